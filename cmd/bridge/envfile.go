@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+)
+
+// envFile is the dotenv file loaded at startup, if it exists
+const envFile = ".env"
+
+// envKeys records every variable the bridge reads, for telling settings
+// from typos in the env file
+var envKeys = make(map[string]bool)
+
+// envPrefixes are variables named after other settings, such as the
+// per-upstream ERSSI_PASSWORD_<NAME>
+var envPrefixes = []string{"ERSSI_PASSWORD_"}
+
+// envFileLines maps the keys set in the env file to their line. Keys
+// already in the process environment are left out: they win over the file.
+var envFileLines = make(map[string]int)
+
+// envErrors collects invalid values, reported together at startup
+var envErrors []string
+
+// envKeyPattern matches the key of an env file line ("KEY=", "export
+// KEY=", "KEY: ")
+var envKeyPattern = regexp.MustCompile(`^(?:export\s+)?([A-Za-z_][A-Za-z0-9_.]*)\s*[=:]\s*(.*)$`)
+
+// loadEnvFile loads the env file into the environment and records where
+// each of its keys is set
+func loadEnvFile() {
+	lines, err := scanEnvFile(envFile)
+	if err != nil {
+		return
+	}
+	for key, line := range lines {
+		if _, set := os.LookupEnv(key); !set {
+			envFileLines[key] = line
+		}
+	}
+	_ = godotenv.Load(envFile)
+}
+
+// scanEnvFile returns the line of every key of an env file. Quoted values
+// may span several lines; those are skipped.
+func scanEnvFile(path string) (map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	quote := byte(0) // Quote of a value continuing on the next line
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if quote != 0 {
+			if strings.IndexByte(line, quote) >= 0 {
+				quote = 0
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m := envKeyPattern.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		lines[m[1]] = n
+		if value := m[2]; value != "" && (value[0] == '"' || value[0] == '\'') && strings.IndexByte(value[1:], value[0]) < 0 {
+			quote = value[0]
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// envOrigin describes where a variable is set, for error messages
+func envOrigin(key string) string {
+	if line, ok := envFileLines[key]; ok {
+		return fmt.Sprintf("%s:%d", envFile, line)
+	}
+	return "environment"
+}
+
+// getEnv gets an environment variable with a fallback default value
+func getEnv(key, fallback string) string {
+	envKeys[key] = true
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getSecret gets a variable that is only read from the environment
+func getSecret(key string) string {
+	return getEnv(key, "")
+}
+
+// invalidEnv records a value that doesn't parse
+func invalidEnv(key, value, want string) {
+	envErrors = append(envErrors, fmt.Sprintf("%s: %s=%q is not a valid %s", envOrigin(key), key, value, want))
+}
+
+// getEnvBool gets a boolean variable ("true", "false", "1", "0", ...)
+func getEnvBool(key string, fallback bool) bool {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		invalidEnv(key, value, "boolean")
+		return fallback
+	}
+	return b
+}
+
+// getEnvInt gets an integer variable
+func getEnvInt(key string, fallback int) int {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		invalidEnv(key, value, "integer")
+		return fallback
+	}
+	return n
+}
+
+// getEnvFloat gets a decimal variable
+func getEnvFloat(key string, fallback float64) float64 {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		invalidEnv(key, value, "number")
+		return fallback
+	}
+	return f
+}
+
+// getEnvDuration gets a duration variable ("30s", "1h")
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := getEnv(key, "")
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		invalidEnv(key, value, "duration")
+		return fallback
+	}
+	return d
+}
+
+// unknownEnvKeys describes the keys of the env file the bridge doesn't
+// read, with the setting they were probably meant to be
+func unknownEnvKeys() []string {
+	var keys []string
+	for key := range envFileLines {
+		if !envKeys[key] && !hasEnvPrefix(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return envFileLines[keys[i]] < envFileLines[keys[j]] })
+
+	unknown := make([]string, len(keys))
+	for i, key := range keys {
+		unknown[i] = fmt.Sprintf("%s:%d: unknown key %s", envFile, envFileLines[key], key)
+		if guess := closestEnvKey(key); guess != "" {
+			unknown[i] += fmt.Sprintf(" (did you mean %s?)", guess)
+		}
+	}
+	return unknown
+}
+
+func hasEnvPrefix(key string) bool {
+	for _, prefix := range envPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// closestEnvKey returns the known key within two edits of key, "" if none
+func closestEnvKey(key string) string {
+	best, bestDist := "", 3
+	for known := range envKeys {
+		if d := editDistance(strings.ToUpper(key), known); d < bestDist || d == bestDist && known < best {
+			best, bestDist = known, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance of two strings
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// checkEnv logs the invalid values and unknown env file keys, and exits
+// if there are any. With allowUnknown, unknown keys are only warned about.
+func checkEnv(logger *logrus.Logger, allowUnknown bool) {
+	errs := envErrors
+	for _, msg := range unknownEnvKeys() {
+		if allowUnknown {
+			logger.Warn(msg)
+		} else {
+			errs = append(errs, msg+" (ALLOW_UNKNOWN_KEYS=true ignores it)")
+		}
+	}
+	if len(errs) == 0 {
+		return
+	}
+
+	for _, msg := range errs {
+		logger.Error(msg)
+	}
+	logger.Fatalf("Invalid configuration, refusing to start")
+}
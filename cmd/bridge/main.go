@@ -4,37 +4,314 @@ import (
 	"flag"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	"erssi-lith-bridge/internal/bridge"
+	"erssi-lith-bridge/internal/chaos"
+	"erssi-lith-bridge/internal/history"
+	"erssi-lith-bridge/internal/tailnet"
+	"erssi-lith-bridge/internal/translator"
+	"erssi-lith-bridge/internal/weechat"
 
-	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
 )
 
 var (
 	erssiURL      *string
 	erssiPassword *string
+	upstreams     upstreamFlags
+	erssiCA       *string
+	erssiCert     *string
+	erssiKey      *string
+	erssiSNI      *string
+	erssiInsecure *bool
+	compression   *bool
+	plaintext     *string
 	listenAddr    *string
+	listenWS      *string
+	socketMode    *string
+	wsPath        *string
+	wsOrigins     *string
+	basicAuth     *string
+	bearerToken   *string
+	acmeDomains   *string
+	acmeCache     *string
+	acmeEmail     *string
+	acmeHTTP      *string
+	relayMode     *string
+	unknownBuffer *string
+	bandwidth     *string
+	compressMin   *int
+	writeQueue    *int
+	writeTimeout  *time.Duration
+	idleTimeout   *time.Duration
+	pingInterval  *time.Duration
+	requireHS     *bool
+	relayAuth     *string
+	hashIters     *int
+	nonceTTL      *time.Duration
+	relayACL      *string
+	maxClients    *int
+	relayAllow    *string
+	relayDeny     *string
+	lockoutFails  *int
+	lockoutWindow *time.Duration
+	lockoutBan    *time.Duration
+	waitForErssi  *bool
+	dumpTimeout   *time.Duration
+	noNicklist    *bool
+	nicksInfo     *bool
+	awayReply     *bool
+	ownPrefix     *string
+	ownColor      *string
+	outColors     *string
+	charsets      *string
+	wallops       *string
+	aliasesFile   *string
+	transforms    *string
+	allowExec     *bool
+	execAllowlist *string
+	subscribe     *string
+	autoSort      *time.Duration
+	historyDir    *string
+	historyLines  *int
+	historyMaxAge *string
+	historyRules  *string
+	chaosErssi    *string
+	chaosRelay    *string
+	digestSMTP    *string
+	digestFrom    *string
+	digestTo      *string
+	digestEvery   *time.Duration
+	reconnect     *bool
+	maxRetries    *int
+	keepalive     *time.Duration
+	lagCheck      *time.Duration
+	lagWarn       *time.Duration
+	resyncSilence *time.Duration
+	sendQueue     *int
+	queueOverflow *string
+	requestRate   *float64
+	requestBurst  *int
+	nickDebounce  *time.Duration
+	throttle      *string
+	validate      *bool
+	journalSize   *int
+	syncBacklog   *int
+	listenStatus  *string
+	injectPipe    *string
+	tsHostname    *string
+	tsStateDir    *string
+	tsControlURL  *string
 	verbose       *bool
+	allowUnknown  *bool
 	version       = "0.1.0"
 )
 
 func main() {
+	// Subcommands
+	if len(os.Args) > 1 && os.Args[1] == "selftest" {
+		os.Exit(runSelftest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		os.Exit(runSoak(os.Args[2:]))
+	}
+
 	// Load .env file if it exists (ignore error if not found)
-	_ = godotenv.Load()
+	loadEnvFile()
 
 	// Get defaults from environment variables or use hardcoded defaults
 	defaultErssiURL := getEnv("ERSSI_URL", "ws://localhost:9001")
 	defaultPassword := getEnv("ERSSI_PASSWORD", "")
+	defaultErssiCA := getEnv("ERSSI_CA_FILE", "")
+	defaultErssiCert := getEnv("ERSSI_CERT_FILE", "")
+	defaultErssiKey := getEnv("ERSSI_KEY_FILE", "")
+	defaultErssiSNI := getEnv("ERSSI_SERVER_NAME", "")
+	defaultErssiInsecure := getEnvBool("ERSSI_INSECURE", false)
+	defaultCompression := getEnvBool("ERSSI_COMPRESSION", true)
+	defaultPlaintext := getEnv("ERSSI_PLAINTEXT", "warn")
 	defaultListen := getEnv("LISTEN_ADDR", ":9000")
-	defaultVerbose := getEnv("VERBOSE", "false") == "true"
+	defaultListenWS := getEnv("LISTEN_WS_ADDR", "")
+	defaultListenStatus := getEnv("LISTEN_STATUS_ADDR", "")
+	defaultInjectPipe := getEnv("INJECT_PIPE", "")
+	defaultSocketMode := getEnv("LISTEN_SOCKET_MODE", "0660")
+	defaultWSPath := getEnv("LISTEN_WS_PATH", "/weechat")
+	defaultWSOrigins := getEnv("LISTEN_WS_ORIGINS", "")
+	defaultBasicAuth := getEnv("RELAY_BASIC_AUTH", "")
+	defaultBearerToken := getEnv("RELAY_BEARER_TOKEN", "")
+	defaultACMEDomains := getEnv("ACME_DOMAINS", "")
+	defaultACMECache := getEnv("ACME_CACHE_DIR", "")
+	defaultACMEEmail := getEnv("ACME_EMAIL", "")
+	defaultACMEHTTP := getEnv("ACME_HTTP_ADDR", "")
+	defaultRelayMode := getEnv("RELAY_MODE", "lenient")
+	defaultUnknownBuffer := getEnv("RELAY_UNKNOWN_BUFFER", "refresh")
+	defaultBandwidth := getEnv("RELAY_CLIENT_BANDWIDTH", "")
+	defaultCompressMin := getEnvInt("RELAY_COMPRESSION_MIN_SIZE", 256)
+	defaultWriteQueue := getEnvInt("RELAY_WRITE_QUEUE_SIZE", weechat.DefaultWriteQueueSize)
+	defaultWriteTimeout := getEnvDuration("RELAY_WRITE_TIMEOUT", weechat.DefaultWriteTimeout)
+	defaultIdleTimeout := getEnvDuration("RELAY_IDLE_TIMEOUT", 0)
+	defaultPingInterval := getEnvDuration("RELAY_PING_INTERVAL", weechat.DefaultPingInterval)
+	defaultVerbose := getEnvBool("VERBOSE", false)
+	defaultRequireHS := getEnvBool("RELAY_REQUIRE_HANDSHAKE", false)
+	defaultRelayAuth := getEnv("RELAY_AUTH", "")
+	defaultRelayACL := getEnv("RELAY_ACL", "")
+	defaultMaxClients := getEnvInt("RELAY_MAX_CLIENTS", 0)
+	defaultRelayAllow := getEnv("RELAY_ALLOW", "")
+	defaultRelayDeny := getEnv("RELAY_DENY", "")
+	defaultLockoutFails := getEnvInt("RELAY_LOCKOUT_FAILURES", 10)
+	defaultLockoutWindow := getEnvDuration("RELAY_LOCKOUT_WINDOW", weechat.DefaultLockoutWindow)
+	defaultLockoutBan := getEnvDuration("RELAY_LOCKOUT_BAN_TIME", weechat.DefaultLockoutBanTime)
+	defaultHashIters := getEnvInt("RELAY_PASSWORD_HASH_ITERATIONS", 100000)
+	defaultNonceTTL := getEnvDuration("RELAY_NONCE_TTL", weechat.DefaultNonceTTL)
+	defaultWaitForErssi := getEnvBool("WAIT_FOR_ERSSI", false)
+	defaultReconnect := getEnvBool("ERSSI_RECONNECT", true)
+	defaultMaxRetries := getEnvInt("ERSSI_RECONNECT_MAX_RETRIES", 0)
+	defaultDigestSMTP := getEnv("DIGEST_SMTP_ADDR", "")
+	defaultDigestFrom := getEnv("DIGEST_FROM", "")
+	defaultDigestTo := getEnv("DIGEST_TO", "")
+	defaultDigestEvery := getEnvDuration("DIGEST_INTERVAL", time.Hour)
+	defaultAwayReply := getEnvBool("AWAY_AUTO_REPLY", false)
+	defaultOwnPrefix := getEnv("OWN_PREFIX", "")
+	defaultOwnColor := getEnv("OWN_COLOR", "")
+	defaultOutColors := getEnv("OUTGOING_COLORS", "convert")
+	defaultWallops := getEnv("WALLOPS_BUFFER", "")
+	defaultCharsets := getEnv("SERVER_CHARSETS", "")
+	defaultAliasesFile := getEnv("ALIASES_FILE", "")
+	defaultTransforms := getEnv("INPUT_TRANSFORMS_FILE", "")
+	defaultAllowExec := getEnvBool("BRIDGE_ALLOW_EXEC", false)
+	defaultExecAllowlist := getEnv("BRIDGE_EXEC_ALLOWLIST", "")
+	defaultSubscribe := getEnv("SUBSCRIBE", "")
+	defaultAutoSort := getEnvDuration("SORT_BY_ACTIVITY", 0)
+	defaultHistoryDir := getEnv("HISTORY_DIR", "")
+	defaultHistoryLines := getEnvInt("HISTORY_MAX_LINES", 500)
+	defaultHistoryMaxAge := getEnv("HISTORY_MAX_AGE", "0")
+	defaultHistoryRules := getEnv("HISTORY_RETENTION", "")
+	defaultChaosErssi := getEnv("CHAOS_ERSSI", "")
+	defaultChaosRelay := getEnv("CHAOS_RELAY", "")
+	defaultNoNicklist := getEnvBool("DISABLE_NICKLIST", false)
+	defaultNicksInfo := getEnvBool("NICKS_INFO", false)
+	defaultKeepalive := getEnvDuration("ERSSI_KEEPALIVE", 15*time.Second)
+	defaultLagCheck := getEnvDuration("ERSSI_LAG_CHECK", 30*time.Second)
+	defaultLagWarn := getEnvDuration("ERSSI_LAG_WARN", 10*time.Second)
+	defaultResyncSilence := getEnvDuration("RESYNC_SILENCE", 0)
+	defaultSendQueue := getEnvInt("ERSSI_SEND_QUEUE", 100)
+	defaultQueueOverflow := getEnv("ERSSI_SEND_QUEUE_OVERFLOW", "drop-oldest")
+	defaultRequestRate := getEnvFloat("ERSSI_REQUEST_RATE", 10)
+	defaultRequestBurst := getEnvInt("ERSSI_REQUEST_BURST", 20)
+	defaultDebounce := getEnvDuration("NICKLIST_DEBOUNCE", 500*time.Millisecond)
+	defaultThrottle := getEnv("ERSSI_THROTTLE", "")
+	defaultValidate := getEnvBool("ERSSI_VALIDATE", false)
+	defaultJournalSize := getEnvInt("JOURNAL_SIZE", 1000)
+	defaultSyncBacklog := getEnvInt("SYNC_BACKLOG", 0)
+	defaultTSHostname := getEnv("TS_HOSTNAME", "")
+	defaultTSStateDir := getEnv("TS_STATE_DIR", "")
+	defaultTSControlURL := getEnv("TS_CONTROL_URL", "")
+	defaultDumpTimeout := getEnvDuration("STATE_DUMP_TIMEOUT", 15*time.Second)
+	defaultAllowUnknown := getEnvBool("ALLOW_UNKNOWN_KEYS", false)
+
+	// Secrets are only read from the environment, never from flags
+	tsAuthKey := getSecret("TS_AUTHKEY")
+	relayPassword := getSecret("RELAY_PASSWORD")
+	relayTOTPSecret := getSecret("RELAY_TOTP_SECRET")
+	nickservCreds := getSecret("NICKSERV_CREDENTIALS")
+	digestUser := getSecret("DIGEST_SMTP_USER")
+	digestPassword := getSecret("DIGEST_SMTP_PASSWORD")
+	historyPassphrase := getSecret("HISTORY_PASSPHRASE")
 
 	// Define flags (these override environment variables)
-	erssiURL = flag.String("erssi", defaultErssiURL, "erssi WebSocket URL (env: ERSSI_URL)")
+	erssiURL = flag.String("erssi", defaultErssiURL, "erssi WebSocket URL, or a comma-separated failover list (env: ERSSI_URL)")
 	erssiPassword = flag.String("password", defaultPassword, "erssi WebSocket password (env: ERSSI_PASSWORD)")
-	listenAddr = flag.String("listen", defaultListen, "WeeChat protocol listen address (env: LISTEN_ADDR)")
+	erssiCA = flag.String("erssi-ca", defaultErssiCA, "PEM CA bundle to verify the erssi certificate (env: ERSSI_CA_FILE)")
+	erssiCert = flag.String("erssi-cert", defaultErssiCert, "Client certificate for erssi (env: ERSSI_CERT_FILE)")
+	erssiKey = flag.String("erssi-key", defaultErssiKey, "Client certificate key for erssi (env: ERSSI_KEY_FILE)")
+	erssiSNI = flag.String("erssi-server-name", defaultErssiSNI, "Expected erssi certificate name (env: ERSSI_SERVER_NAME)")
+	erssiInsecure = flag.Bool("erssi-insecure", defaultErssiInsecure, "Skip erssi certificate verification (env: ERSSI_INSECURE)")
+	compression = flag.Bool("erssi-compression", defaultCompression, "Negotiate permessage-deflate compression with erssi (env: ERSSI_COMPRESSION)")
+	plaintext = flag.String("erssi-plaintext", defaultPlaintext, "Unencrypted erssi frames while a password is set: warn or reject (env: ERSSI_PLAINTEXT)")
+	listenAddr = flag.String("listen", defaultListen, "WeeChat protocol listen addresses, comma-separated, optionally prefixed with tcp4: or tcp6:, or unix:///path/to.sock (env: LISTEN_ADDR)")
+	socketMode = flag.String("listen-socket-mode", defaultSocketMode, "Octal permissions of unix socket listeners (env: LISTEN_SOCKET_MODE)")
+	if err := upstreams.parseList(getEnv("ERSSI_UPSTREAMS", "")); err != nil {
+		logrus.Fatalf("Invalid ERSSI_UPSTREAMS: %v", err)
+	}
+	flag.Var(&upstreams, "upstream", "erssi instance to aggregate as name=URL[|standby...], repeatable, replaces -erssi; server tags become name/tag (env: ERSSI_UPSTREAMS, comma-separated)")
+	listenWS = flag.String("listen-ws", defaultListenWS, "WebSocket relay listen addresses, same format as -listen, empty = disabled (env: LISTEN_WS_ADDR)")
+	listenStatus = flag.String("listen-status", defaultListenStatus, "Address answering uptime monitors with ok or degraded over plain HTTP, empty = disabled (env: LISTEN_STATUS_ADDR)")
+	injectPipe = flag.String("inject-pipe", defaultInjectPipe, "Named pipe, created if missing, or - for stdin, whose \"<server> <target> <text>\" lines are sent as messages, empty = disabled (env: INJECT_PIPE)")
+	wsPath = flag.String("ws-path", defaultWSPath, "WebSocket relay URL path (env: LISTEN_WS_PATH)")
+	wsOrigins = flag.String("ws-origins", defaultWSOrigins, "Comma-separated origins browser clients may use the WebSocket relay from, * = any, empty = same origin (env: LISTEN_WS_ORIGINS)")
+	basicAuth = flag.String("relay-basic-auth", defaultBasicAuth, "Require HTTP basic auth user:password on the WebSocket relay (env: RELAY_BASIC_AUTH)")
+	bearerToken = flag.String("relay-bearer-token", defaultBearerToken, "Require an HTTP bearer token on the WebSocket relay (env: RELAY_BEARER_TOKEN)")
+	acmeDomains = flag.String("acme-domains", defaultACMEDomains, "Serve the relay over TLS with Let's Encrypt certificates for these comma-separated domains, empty = plain TCP (env: ACME_DOMAINS)")
+	acmeCache = flag.String("acme-cache", defaultACMECache, "Directory for ACME certificates and account key, required with -acme-domains (env: ACME_CACHE_DIR)")
+	acmeEmail = flag.String("acme-email", defaultACMEEmail, "Contact email for the ACME account (env: ACME_EMAIL)")
+	acmeHTTP = flag.String("acme-http", defaultACMEHTTP, "Address serving ACME HTTP-01 challenges, e.g. :80, empty = TLS-ALPN-01 only (env: ACME_HTTP_ADDR)")
+	relayMode = flag.String("relay-mode", defaultRelayMode, "Malformed command handling: strict or lenient (env: RELAY_MODE)")
+	unknownBuffer = flag.String("relay-unknown-buffer", defaultUnknownBuffer, "Input for a buffer the bridge doesn't know: refresh (notice and resend the buffer list), notice or ignore (env: RELAY_UNKNOWN_BUFFER)")
+	bandwidth = flag.String("client-bandwidth", defaultBandwidth, "Cap on what each relay client is sent, bytes per second with optional k/m suffix, empty = unlimited (env: RELAY_CLIENT_BANDWIDTH)")
+	compressMin = flag.Int("relay-compression-min-size", defaultCompressMin, "Smallest message in bytes compressed for relay clients that negotiated compression, 0 = all (env: RELAY_COMPRESSION_MIN_SIZE)")
+	writeQueue = flag.Int("relay-write-queue-size", defaultWriteQueue, "Messages a relay client may fall behind before it is disconnected (env: RELAY_WRITE_QUEUE_SIZE)")
+	writeTimeout = flag.Duration("relay-write-timeout", defaultWriteTimeout, "How long a write to a relay client may take before it is disconnected (env: RELAY_WRITE_TIMEOUT)")
+	idleTimeout = flag.Duration("relay-idle-timeout", defaultIdleTimeout, "Disconnect relay clients that send nothing for this long, 0 = never (env: RELAY_IDLE_TIMEOUT)")
+	pingInterval = flag.Duration("relay-ping-interval", defaultPingInterval, "Check that relay clients are still there at this interval, 0 = off (env: RELAY_PING_INTERVAL)")
+	relayAuth = flag.String("relay-auth", defaultRelayAuth, "Relay client authentication: password, htpasswd:<file>, pam[:<service>] or http:<url>, empty = RELAY_PASSWORD if set (env: RELAY_AUTH)")
+	hashIters = flag.Int("relay-hash-iterations", defaultHashIters, "PBKDF2 iterations relay clients hash RELAY_PASSWORD with (env: RELAY_PASSWORD_HASH_ITERATIONS)")
+	nonceTTL = flag.Duration("relay-nonce-ttl", defaultNonceTTL, "How long a handshake nonce can salt an init password hash (env: RELAY_NONCE_TTL)")
+	relayACL = flag.String("relay-acl", defaultRelayACL, "Buffers of restricted relay accounts, comma-separated account=server/channel|..., * = unlisted accounts, empty = all see everything (env: RELAY_ACL)")
+	maxClients = flag.Int("relay-max-clients", defaultMaxClients, "Most relay clients connected at once, 0 = unlimited (env: RELAY_MAX_CLIENTS)")
+	relayAllow = flag.String("relay-allow", defaultRelayAllow, "Comma-separated CIDRs or addresses relay clients may connect from, empty = any (env: RELAY_ALLOW)")
+	relayDeny = flag.String("relay-deny", defaultRelayDeny, "Comma-separated CIDRs or addresses relay clients may not connect from (env: RELAY_DENY)")
+	lockoutFails = flag.Int("relay-lockout-failures", defaultLockoutFails, "Failed relay logins from one address within the lockout window that ban it, 0 = never ban (env: RELAY_LOCKOUT_FAILURES)")
+	lockoutWindow = flag.Duration("relay-lockout-window", defaultLockoutWindow, "How long failed relay logins count towards a ban (env: RELAY_LOCKOUT_WINDOW)")
+	lockoutBan = flag.Duration("relay-lockout-ban-time", defaultLockoutBan, "How long an address that failed too many relay logins is banned (env: RELAY_LOCKOUT_BAN_TIME)")
+	requireHS = flag.Bool("require-handshake", defaultRequireHS, "Reject relay clients that send init without handshake (env: RELAY_REQUIRE_HANDSHAKE)")
+	reconnect = flag.Bool("reconnect", defaultReconnect, "Reconnect to erssi with exponential backoff when the connection drops (env: ERSSI_RECONNECT)")
+	maxRetries = flag.Int("reconnect-max-retries", defaultMaxRetries, "Reconnect attempts before giving up, 0 = forever (env: ERSSI_RECONNECT_MAX_RETRIES)")
+	keepalive = flag.Duration("keepalive", defaultKeepalive, "Ping erssi at this interval to detect dead connections, 0 = off (env: ERSSI_KEEPALIVE)")
+	lagCheck = flag.Duration("lag-check", defaultLagCheck, "Measure the round trip through erssi with ping messages at this interval, 0 = off (env: ERSSI_LAG_CHECK)")
+	lagWarn = flag.Duration("lag-warn", defaultLagWarn, "Warn in the core buffer when erssi or the bridge lags this much (env: ERSSI_LAG_WARN)")
+	resyncSilence = flag.Duration("resync-silence", defaultResyncSilence, "Request a fresh state dump from an erssi that sent nothing for this long, 0 = off (env: RESYNC_SILENCE)")
+	sendQueue = flag.Int("send-queue", defaultSendQueue, "Messages buffered for erssi while reconnecting, 0 = off (env: ERSSI_SEND_QUEUE)")
+	queueOverflow = flag.String("send-queue-overflow", defaultQueueOverflow, "What to drop when the send queue is full: drop-oldest or drop-newest (env: ERSSI_SEND_QUEUE_OVERFLOW)")
+	requestRate = flag.Float64("request-rate", defaultRequestRate, "Nicklist and state requests per second sent to erssi, 0 = unlimited (env: ERSSI_REQUEST_RATE)")
+	requestBurst = flag.Int("request-burst", defaultRequestBurst, "Requests sent to erssi back to back before the rate limit applies (env: ERSSI_REQUEST_BURST)")
+	throttle = flag.String("throttle", defaultThrottle, "Comma-separated per-network flood limits for messages sent to IRC, tag=rate[/burst] or tag=off, * = other networks, off = none; Libera, OFTC and EFnet have defaults (env: ERSSI_THROTTLE)")
+	nickDebounce = flag.Duration("nicklist-debounce", defaultDebounce, "Merge nicklist refreshes of a channel within this window, 0 = off (env: NICKLIST_DEBOUNCE)")
+	validate = flag.Bool("validate-erssi", defaultValidate, "Check erssi messages against the expected fields and report violations in the core buffer (env: ERSSI_VALIDATE)")
+	journalSize = flag.Int("journal-size", defaultJournalSize, "Events from erssi to the relay clients kept for /bridge journal, 0 = off (env: JOURNAL_SIZE)")
+	syncBacklog = flag.Int("sync-backlog", defaultSyncBacklog, "Lines of each synced buffer pushed to a client that didn't request them, 0 = off (env: SYNC_BACKLOG)")
+	waitForErssi = flag.Bool("wait-erssi", defaultWaitForErssi, "Open the relay listener only after erssi state is loaded (env: WAIT_FOR_ERSSI)")
+	dumpTimeout = flag.Duration("dump-timeout", defaultDumpTimeout, "How long buffer list requests wait for a running state dump (env: STATE_DUMP_TIMEOUT)")
+	digestSMTP = flag.String("digest-smtp", defaultDigestSMTP, "SMTP server host:port for highlight digests, empty = disabled (env: DIGEST_SMTP_ADDR)")
+	digestFrom = flag.String("digest-from", defaultDigestFrom, "Sender address of digest mails (env: DIGEST_FROM)")
+	digestTo = flag.String("digest-to", defaultDigestTo, "Comma-separated digest recipients (env: DIGEST_TO)")
+	digestEvery = flag.Duration("digest-interval", defaultDigestEvery, "Time between digest mails (env: DIGEST_INTERVAL)")
+	awayReply = flag.Bool("away-reply", defaultAwayReply, "Answer private messages with a notice while /away is set (env: AWAY_AUTO_REPLY)")
+	ownPrefix = flag.String("own-prefix", defaultOwnPrefix, "Prefix shown on your own messages, {nick} = your nick (env: OWN_PREFIX)")
+	ownColor = flag.String("own-color", defaultOwnColor, "Color of your own message prefix: WeeChat color name or 0-255 (env: OWN_COLOR)")
+	outColors = flag.String("outgoing-colors", defaultOutColors, "WeeChat color codes in sent text: convert (to mIRC colors) or strip (env: OUTGOING_COLORS)")
+	charsets = flag.String("server-charsets", defaultCharsets, "Text encoding of legacy networks, comma-separated server=charset (utf-8, latin-1, cp1252), * = unlisted servers (env: SERVER_CHARSETS)")
+	wallops = flag.String("wallops-buffer", defaultWallops, "Notify level of a per-network buffer for wallops and global notices: none, highlight, message or all, empty = server buffer (env: WALLOPS_BUFFER)")
+	aliasesFile = flag.String("aliases", defaultAliasesFile, "File with command aliases, one \"name = expansion\" per line (env: ALIASES_FILE)")
+	transforms = flag.String("input-transforms", defaultTransforms, "File with per-buffer transforms of outgoing text (env: INPUT_TRANSFORMS_FILE)")
+	allowExec = flag.Bool("allow-exec", defaultAllowExec, "Allow relay clients to run allowlisted host commands with /bridge exec (env: BRIDGE_ALLOW_EXEC)")
+	subscribe = flag.String("subscribe", defaultSubscribe, "Comma-separated server/channel patterns to follow, e.g. libera/#go*,oftc; empty = all (env: SUBSCRIBE)")
+	execAllowlist = flag.String("exec-allowlist", defaultExecAllowlist, "Comma-separated commands /bridge exec may run, each with the argument patterns it may get, e.g. \"uptime,df -h *,journalctl -u **\" (env: BRIDGE_EXEC_ALLOWLIST)")
+	autoSort = flag.Duration("sort-by-activity", defaultAutoSort, "Renumber buffers by recent activity at this interval, 0 = off (env: SORT_BY_ACTIVITY)")
+	historyDir = flag.String("history-dir", defaultHistoryDir, "Directory for persistent buffer history, empty = memory only (env: HISTORY_DIR)")
+	historyLines = flag.Int("history-lines", defaultHistoryLines, "History lines kept per buffer (env: HISTORY_MAX_LINES)")
+	historyMaxAge = flag.String("history-max-age", defaultHistoryMaxAge, "Prune history older than this, e.g. 30d, 0 = keep (env: HISTORY_MAX_AGE)")
+	historyRules = flag.String("history-retention", defaultHistoryRules, "Per-buffer retention overrides, e.g. libera.#busy=200,*.#log=1000/30d (env: HISTORY_RETENTION)")
+	chaosErssi = flag.String("chaos-erssi", defaultChaosErssi, "Testing only: inject faults into messages from erssi, e.g. latency=200ms,jitter=100ms,drop=0.01,reorder=0.05 (env: CHAOS_ERSSI)")
+	chaosRelay = flag.String("chaos-relay", defaultChaosRelay, "Testing only: inject faults into commands from relay clients, same format as -chaos-erssi (env: CHAOS_RELAY)")
+	noNicklist = flag.Bool("no-nicklist", defaultNoNicklist, "Disable nicklist support to save memory and bandwidth (env: DISABLE_NICKLIST)")
+	nicksInfo = flag.Bool("nicks-info", defaultNicksInfo, "Answer info bridge_nicks with the nicks of a buffer, for clients completing nicks on their own (env: NICKS_INFO)")
+	tsHostname = flag.String("tailscale", defaultTSHostname, "Listen on an embedded tailnet node with this hostname instead of the host network (env: TS_HOSTNAME)")
+	tsStateDir = flag.String("tailscale-state-dir", defaultTSStateDir, "Directory for the tailnet node state (env: TS_STATE_DIR)")
+	tsControlURL = flag.String("tailscale-control-url", defaultTSControlURL, "Coordination server URL, e.g. Headscale (env: TS_CONTROL_URL)")
 	verbose = flag.Bool("v", defaultVerbose, "Verbose logging (env: VERBOSE)")
+	allowUnknown = flag.Bool("allow-unknown-keys", defaultAllowUnknown, "Start even though .env sets keys the bridge doesn't know, e.g. typos (env: ALLOW_UNKNOWN_KEYS)")
 
 	flag.Parse()
 
@@ -51,15 +328,177 @@ func main() {
 	}
 
 	logger.Infof("erssi-Lith Bridge v%s", version)
-	logger.Infof("erssi URL: %s", *erssiURL)
+	checkEnv(logger, *allowUnknown)
+	if len(upstreams.entries) > 0 {
+		for _, u := range upstreams.entries {
+			logger.Infof("erssi upstream %s: %s", u.Name, u.URL)
+		}
+	} else {
+		logger.Infof("erssi URL: %s", *erssiURL)
+	}
 	logger.Infof("Listening on: %s", *listenAddr)
 
+	// Optionally move the relay onto a tailnet
+	var listen weechat.ListenFunc
+	if *tsHostname != "" {
+		node, err := tailnet.Start(tailnet.Config{
+			Hostname:   *tsHostname,
+			StateDir:   *tsStateDir,
+			AuthKey:    tsAuthKey,
+			ControlURL: *tsControlURL,
+			Logger:     logger,
+		})
+		if err != nil {
+			logger.Fatalf("Failed to start tailnet node: %v", err)
+		}
+		defer node.Close()
+		listen = node.Listen
+	}
+
+	var aliases map[string]string
+	if *aliasesFile != "" {
+		var err error
+		if aliases, err = bridge.LoadAliases(*aliasesFile); err != nil {
+			logger.Fatalf("Failed to load aliases: %v", err)
+		}
+		logger.Infof("Loaded %d aliases from %s", len(aliases), *aliasesFile)
+	}
+
+	var inputTransforms []translator.InputTransform
+	if *transforms != "" {
+		var err error
+		if inputTransforms, err = translator.LoadInputTransforms(*transforms); err != nil {
+			logger.Fatalf("Failed to load input transforms: %v", err)
+		}
+		logger.Infof("Loaded %d input transforms from %s", len(inputTransforms), *transforms)
+	}
+
+	historyAge, err := history.ParseAge(*historyMaxAge)
+	if err != nil {
+		logger.Fatalf("Invalid history max age: %v", err)
+	}
+	historyRetention, err := history.ParseRetentionRules(*historyRules)
+	if err != nil {
+		logger.Fatalf("Invalid history retention: %v", err)
+	}
+
+	chaosErssiCfg, err := chaos.Parse(*chaosErssi)
+	if err != nil {
+		logger.Fatalf("Invalid -chaos-erssi: %v", err)
+	}
+	chaosRelayCfg, err := chaos.Parse(*chaosRelay)
+	if err != nil {
+		logger.Fatalf("Invalid -chaos-relay: %v", err)
+	}
+
 	// Create bridge
 	b, err := bridge.New(bridge.Config{
 		ErssiURL:      *erssiURL,
 		ErssiPassword: *erssiPassword,
-		ListenAddr:    *listenAddr,
-		Logger:        logger,
+		Upstreams:     upstreams.configs(*erssiPassword),
+
+		ErssiCAFile:     *erssiCA,
+		ErssiCertFile:   *erssiCert,
+		ErssiKeyFile:    *erssiKey,
+		ErssiServerName: *erssiSNI,
+		ErssiInsecure:   *erssiInsecure,
+
+		ErssiCompression: *compression,
+		ErssiPlaintext:   *plaintext,
+
+		ListenAddr:       *listenAddr,
+		Listen:           listen,
+		ListenSocketMode: *socketMode,
+		ListenWSAddr:     *listenWS,
+		ListenWSPath:     *wsPath,
+		ListenWSOrigins:  splitList(*wsOrigins),
+		ListenStatusAddr: *listenStatus,
+		InjectPipe:       *injectPipe,
+		RelayMode:        *relayMode,
+		WaitForErssi:     *waitForErssi,
+
+		RelayBasicAuth:          *basicAuth,
+		RelayBearerToken:        *bearerToken,
+		RelayClientBandwidth:    *bandwidth,
+		RelayCompressionMinSize: *compressMin,
+		RelayWriteQueueSize:     *writeQueue,
+		RelayWriteTimeout:       *writeTimeout,
+		RelayIdleTimeout:        *idleTimeout,
+		RelayPingInterval:       *pingInterval,
+		RelayUnknownBuffer:      *unknownBuffer,
+
+		RelayACMEDomains:  splitList(*acmeDomains),
+		RelayACMECacheDir: *acmeCache,
+		RelayACMEEmail:    *acmeEmail,
+		RelayACMEHTTPAddr: *acmeHTTP,
+
+		RequireHandshake: *requireHS,
+		RelayAuth:        *relayAuth,
+		RelayPassword:    relayPassword,
+		RelayACL:         splitList(*relayACL),
+		RelayMaxClients:  *maxClients,
+		RelayAllow:       splitList(*relayAllow),
+		RelayDeny:        splitList(*relayDeny),
+		RelayLockout: weechat.LockoutConfig{
+			MaxFailures: *lockoutFails,
+			Window:      *lockoutWindow,
+			BanTime:     *lockoutBan,
+		},
+		StateDumpTimeout: *dumpTimeout,
+		DisableNicklist:  *noNicklist,
+		NicksInfo:        *nicksInfo,
+		AwayAutoReply:    *awayReply,
+		Aliases:          aliases,
+		InputTransforms:  inputTransforms,
+		OwnPrefix:        *ownPrefix,
+		OwnColor:         *ownColor,
+		OutgoingColors:   *outColors,
+		Charsets:         splitList(*charsets),
+		WallopsNotify:    *wallops,
+		AllowExec:        *allowExec,
+		ExecAllowlist:    splitList(*execAllowlist),
+		Subscribe:        splitList(*subscribe),
+		AutoSortInterval: *autoSort,
+
+		RelayPasswordHashIterations: *hashIters,
+		RelayNonceTTL:               *nonceTTL,
+		RelayTOTPSecret:             relayTOTPSecret,
+
+		NickServCredentials: splitList(nickservCreds),
+
+		DigestSMTPAddr: *digestSMTP,
+		DigestUsername: digestUser,
+		DigestPassword: digestPassword,
+		DigestFrom:     *digestFrom,
+		DigestTo:       splitList(*digestTo),
+		DigestInterval: *digestEvery,
+
+		HistoryDir:        *historyDir,
+		HistoryPassphrase: historyPassphrase,
+		HistoryMaxLines:   *historyLines,
+		HistoryMaxAge:     historyAge,
+		HistoryRetention:  historyRetention,
+
+		Reconnect:           *reconnect,
+		ReconnectMaxRetries: *maxRetries,
+		KeepaliveInterval:   *keepalive,
+		LagCheckInterval:    *lagCheck,
+		LagWarn:             *lagWarn,
+		ResyncSilence:       *resyncSilence,
+		SendQueueSize:       *sendQueue,
+		SendQueueOverflow:   *queueOverflow,
+		RequestRate:         *requestRate,
+		RequestBurst:        *requestBurst,
+		NicklistDebounce:    *nickDebounce,
+		Throttle:            splitList(*throttle),
+		ValidateErssi:       *validate,
+		JournalSize:         *journalSize,
+		SyncBacklog:         *syncBacklog,
+
+		ChaosErssi: chaosErssiCfg,
+		ChaosRelay: chaosRelayCfg,
+
+		Logger: logger,
 	})
 	if err != nil {
 		logger.Fatalf("Failed to create bridge: %v", err)
@@ -101,10 +540,13 @@ func waitForDone(b *bridge.Bridge) <-chan struct{} {
 	return done
 }
 
-// getEnv gets an environment variable with a fallback default value
-func getEnv(key, fallback string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// splitList splits a comma-separated list, dropping empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
 	}
-	return fallback
+	return items
 }
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"erssi-lith-bridge/internal/selftest"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runSelftest implements "bridge selftest": it starts the bridge in-process
+// against a fake erssi and checks the relay protocol flows.
+// Returns the process exit code.
+func runSelftest(args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout for each step")
+	verbose := fs.Bool("v", false, "Show bridge logs while testing")
+	fs.Parse(args)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	if *verbose {
+		logger.SetOutput(os.Stderr)
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	report := selftest.Run(selftest.Config{
+		Timeout: *timeout,
+		Logger:  logger,
+	})
+	report.Print(os.Stdout)
+
+	if !report.Passed() {
+		return 1
+	}
+	return 0
+}
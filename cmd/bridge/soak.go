@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"time"
+
+	"erssi-lith-bridge/internal/selftest"
+
+	"github.com/sirupsen/logrus"
+)
+
+// runSoak implements "bridge soak": it starts the bridge in-process against
+// a fake erssi, pushes synthetic channel traffic through it to relay clients
+// and reports throughput, latency and memory. Returns the process exit code
+// (non-zero if setup failed or a client missed messages).
+func runSoak(args []string) int {
+	fs := flag.NewFlagSet("soak", flag.ExitOnError)
+	channels := fs.Int("channels", 50, "Channels in the fake erssi state")
+	rate := fs.Float64("rate", 1, "Messages per second per channel")
+	clients := fs.Int("clients", 1, "Relay clients connected and synced")
+	duration := fs.Duration("duration", 30*time.Second, "How long to generate messages")
+	timeout := fs.Duration("timeout", 10*time.Second, "Setup timeout, and how long to wait for clients to catch up")
+	verbose := fs.Bool("v", false, "Show bridge logs while testing")
+	fs.Parse(args)
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	if *verbose {
+		logger.SetOutput(os.Stderr)
+		logger.SetLevel(logrus.DebugLevel)
+	}
+
+	report := selftest.Soak(selftest.SoakConfig{
+		Channels: *channels,
+		Rate:     *rate,
+		Clients:  *clients,
+		Duration: *duration,
+		Timeout:  *timeout,
+		Logger:   logger,
+	})
+	report.Print(os.Stdout)
+
+	if !report.Lossless() {
+		return 1
+	}
+	return 0
+}
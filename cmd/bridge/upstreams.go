@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"erssi-lith-bridge/internal/bridge"
+)
+
+// upstreamFlags collects -upstream name=URL flags. Values from the
+// environment are replaced by the first flag on the command line.
+type upstreamFlags struct {
+	entries  []bridge.UpstreamConfig
+	fromFlag bool
+}
+
+func (f *upstreamFlags) String() string {
+	parts := make([]string, len(f.entries))
+	for i, e := range f.entries {
+		parts[i] = e.Name + "=" + e.URL
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set implements flag.Value
+func (f *upstreamFlags) Set(value string) error {
+	if !f.fromFlag {
+		f.entries = nil
+		f.fromFlag = true
+	}
+	return f.add(value)
+}
+
+// parseList adds a comma-separated list of name=URL entries
+func (f *upstreamFlags) parseList(list string) error {
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		if err := f.add(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *upstreamFlags) add(value string) error {
+	name, urls, ok := strings.Cut(value, "=")
+	if !ok || name == "" || urls == "" {
+		return fmt.Errorf("upstream %q must be name=URL", value)
+	}
+
+	// Standby URLs follow the primary, separated by |
+	list := strings.Split(urls, "|")
+	f.entries = append(f.entries, bridge.UpstreamConfig{Name: name, URL: list[0], Failover: list[1:]})
+	return nil
+}
+
+// configs returns the upstream configs. Each password is read from
+// ERSSI_PASSWORD_<NAME>, falling back to the shared password.
+func (f *upstreamFlags) configs(defaultPassword string) []bridge.UpstreamConfig {
+	configs := make([]bridge.UpstreamConfig, len(f.entries))
+	for i, e := range f.entries {
+		envName := "ERSSI_PASSWORD_" + strings.ToUpper(strings.ReplaceAll(e.Name, "-", "_"))
+		e.Password = getEnv(envName, defaultPassword)
+		configs[i] = e
+	}
+	return configs
+}
@@ -0,0 +1,150 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"erssi-lith-bridge/internal/translator"
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// aclRule is one "server/channel" pattern of an account's visible buffers
+type aclRule struct {
+	server  *regexp.Regexp
+	channel *regexp.Regexp
+
+	// The pattern covers every target of the server ("libera" or
+	// "libera/*"), which makes its server buffer visible too
+	wholeServer bool
+}
+
+// bufferACL limits the buffers relay accounts see in multi-user setups.
+// Accounts without an entry see everything, unless a "*" entry sets the
+// buffers of every unlisted account. A nil *bufferACL restricts nobody.
+type bufferACL struct {
+	accounts map[string][]aclRule
+}
+
+// parseBufferACL parses "account=pattern|pattern" entries, with patterns
+// in the format of Config.Subscribe ("ops=libera/#ops|oftc/#ops")
+func parseBufferACL(entries []string) (*bufferACL, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	acl := &bufferACL{accounts: make(map[string][]aclRule)}
+	for _, entry := range entries {
+		account, patterns, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		account = strings.TrimSpace(account)
+		if !ok || account == "" || strings.TrimSpace(patterns) == "" {
+			return nil, fmt.Errorf("invalid relay ACL %q (want account=server/channel|...)", entry)
+		}
+		if _, dup := acl.accounts[account]; dup {
+			return nil, fmt.Errorf("duplicate relay ACL for account %s", account)
+		}
+
+		var rules []aclRule
+		for _, pattern := range strings.Split(patterns, "|") {
+			pattern = strings.TrimSpace(pattern)
+			server, channel := splitSubscription(pattern)
+			if server == "" || channel == "" {
+				return nil, fmt.Errorf("invalid relay ACL pattern %q for account %s (want server or server/channel)", pattern, account)
+			}
+			rules = append(rules, aclRule{
+				server:      globPattern(server),
+				channel:     globPattern(channel),
+				wholeServer: channel == "*",
+			})
+		}
+		acl.accounts[account] = rules
+	}
+	return acl, nil
+}
+
+// rules returns the rules of an account, false if it is unrestricted
+func (a *bufferACL) rules(account string) ([]aclRule, bool) {
+	if a == nil {
+		return nil, false
+	}
+	if rules, ok := a.accounts[account]; ok {
+		return rules, true
+	}
+	rules, ok := a.accounts["*"]
+	return rules, ok
+}
+
+// view returns the buffers an account sees, nil for all of them
+func (a *bufferACL) view(account string) translator.BufferView {
+	rules, restricted := a.rules(account)
+	if !restricted {
+		return nil
+	}
+
+	return func(serverTag, target string) bool {
+		for _, r := range rules {
+			if !r.server.MatchString(serverTag) {
+				continue
+			}
+			if target == "" && r.wholeServer || target != "" && r.channel.MatchString(target) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// clientView returns the buffers a relay client may see, nil for all
+func (b *Bridge) clientView(client *weechat.Client) translator.BufferView {
+	return b.acl.view(client.Account())
+}
+
+// broadcastToBuffer sends an update of a buffer (empty target = the server
+// buffer) to the clients allowed to see it
+func (b *Bridge) broadcastToBuffer(serverTag, target string, msg *weechatproto.Message) {
+	if b.acl == nil {
+		b.weechatServer.BroadcastMessage(msg)
+		return
+	}
+
+	b.weechatServer.BroadcastMessageIf(msg, func(client *weechat.Client) bool {
+		view := b.clientView(client)
+		return view == nil || view(serverTag, target)
+	})
+}
+
+// broadcastUnrestricted sends an event spanning several buffers (buffer
+// moves, redactions, closes after a resync) to the clients that see every
+// buffer. Restricted clients catch up when they reconnect.
+func (b *Bridge) broadcastUnrestricted(msg *weechatproto.Message) {
+	if b.acl == nil {
+		b.weechatServer.BroadcastMessage(msg)
+		return
+	}
+
+	b.weechatServer.BroadcastMessageIf(msg, func(client *weechat.Client) bool {
+		return b.clientView(client) == nil
+	})
+}
+
+// allowInput reports whether a restricted client may send text to a
+// buffer. Restricted accounts only talk in buffers they see, and only
+// plain text and /me: any other command could reach other channels or the
+// bridge itself.
+func (b *Bridge) allowInput(client *weechat.Client, bufferPtr, text string) bool {
+	view := b.clientView(client)
+	if view == nil {
+		return true
+	}
+
+	if !b.translator.BufferVisible(bufferPtr, view) {
+		b.log.Warnf("Account %q sent input to a buffer outside its ACL", client.Account())
+		return false
+	}
+	if cmd, _ := splitCommand(text); cmd != "" && cmd != "me" {
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("/%s is not available to account %s", cmd, client.Account()))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package bridge
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// writeHtpasswd writes {SHA} entries for "user:password" pairs
+func writeHtpasswd(t *testing.T, accounts ...string) string {
+	t.Helper()
+
+	var lines []string
+	for _, account := range accounts {
+		user, password, _ := strings.Cut(account, ":")
+		sum := sha1.Sum([]byte(password))
+		lines = append(lines, user+":{SHA}"+base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStatusLinesFollowACL(t *testing.T) {
+	tb := startTestBridge(t, Config{
+		RelayAuth: "htpasswd:" + writeHtpasswd(t, "ops:ops-pw", "admin:admin-pw"),
+		RelayACL:  []string{"ops=libera/#go"},
+	})
+
+	ops := tb.dialRelay(t)
+	opsPointers := ops.login("ops:ops-pw", "core.weechat", "libera.#go")
+	if opsPointers["libera"] != "" {
+		t.Fatalf("restricted account sees the server buffer")
+	}
+	ops.send("sync")
+	admin := tb.dialRelay(t)
+	admin.login("admin:admin-pw", "libera", "libera.#go")
+	admin.send("sync")
+	time.Sleep(200 * time.Millisecond)
+
+	reports := []*erssiproto.WebMessage{
+		{Type: erssiproto.Error, ServerTag: "libera", Target: "#go", Text: "visible error"},
+		{Type: erssiproto.Error, ServerTag: "libera", Target: "#secret", Text: "channel error"},
+		{Type: erssiproto.Error, ServerTag: "libera", Text: "server error"},
+		{Type: erssiproto.Error, Text: "upstream error"},
+	}
+	for _, msg := range reports {
+		if err := tb.erssi.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seen := func(msgs []*weechatproto.Message) map[string]bool {
+		found := make(map[string]bool)
+		for _, m := range msgs {
+			for _, msg := range reports {
+				if hasLineContaining(m, msg.Text) {
+					found[msg.Text] = true
+				}
+			}
+		}
+		return found
+	}
+	check := func(what string, found map[string]bool) {
+		t.Helper()
+		if !found["visible error"] {
+			t.Errorf("%s: restricted account missed the error of its buffer", what)
+		}
+		for _, text := range []string{"channel error", "server error", "upstream error"} {
+			if found[text] {
+				t.Errorf("%s: restricted account got %q", what, text)
+			}
+		}
+	}
+
+	// The unrestricted account gets every line, in any order: servers are
+	// handled on their own workers
+	got := make(map[string]bool)
+	for len(got) < len(reports) {
+		for text := range seen([]*weechatproto.Message{admin.next(func(*weechatproto.Message) bool { return true })}) {
+			got[text] = true
+		}
+	}
+
+	check("broadcast", seen(ops.collect(300*time.Millisecond)))
+
+	ops.send("(corelines) hdata buffer:%s/own_lines/last_line(-50)/data message", opsPointers["core.weechat"])
+	check("core buffer lines", seen([]*weechatproto.Message{ops.next(withID("corelines"))}))
+}
@@ -0,0 +1,146 @@
+package bridge
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"erssi-lith-bridge/internal/weechat"
+)
+
+// LoadAliases reads an alias file. Each line is "name = expansion"; blank
+// lines and lines starting with # are ignored.
+//
+//	op = /mode +o $0
+//	np = /me is listening to $*
+//	hi = /msg $0 hello; /whois $0
+func LoadAliases(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	aliases := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name, expansion, ok := strings.Cut(line, "=")
+		name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "/"))
+		expansion = strings.TrimSpace(expansion)
+		if !ok || name == "" || expansion == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("%s:%d: expected \"name = expansion\"", path, lineNo)
+		}
+		aliases[name] = expansion
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return aliases, nil
+}
+
+// expandAlias runs a user-defined alias. Every command of the expansion
+// goes through the local commands and then to erssi; aliases are not
+// expanded again, so they can't loop. Returns false if cmd is no alias.
+func (b *Bridge) expandAlias(client *weechat.Client, bufferPtr, cmd, args string) bool {
+	expansion, ok := b.aliases[cmd]
+	if !ok {
+		return false
+	}
+
+	serverTag, target := b.translator.GetBufferInfo(bufferPtr)
+	for _, text := range splitAliasCommands(expansion) {
+		text = substituteAliasArgs(text, strings.Fields(args), serverTag, target)
+		if text == "" || b.handleLocalCommand(client, bufferPtr, text) {
+			continue
+		}
+		if err := b.sendToBuffer(bufferPtr, text); err != nil {
+			b.log.Errorf("Alias /%s: %v", cmd, err)
+			return true
+		}
+	}
+
+	return true
+}
+
+// splitAliasCommands splits an expansion on ';' (irssi style, "\;" is a
+// literal semicolon)
+func splitAliasCommands(expansion string) []string {
+	var cmds []string
+	var cur strings.Builder
+
+	for i := 0; i < len(expansion); i++ {
+		switch {
+		case expansion[i] == '\\' && i+1 < len(expansion) && expansion[i+1] == ';':
+			cur.WriteByte(';')
+			i++
+		case expansion[i] == ';':
+			cmds = append(cmds, strings.TrimSpace(cur.String()))
+			cur.Reset()
+		default:
+			cur.WriteByte(expansion[i])
+		}
+	}
+
+	return append(cmds, strings.TrimSpace(cur.String()))
+}
+
+// substituteAliasArgs replaces alias variables in one command:
+//
+//	$0..$9  single argument        $1-  arguments from the second on
+//	$*      all arguments          $C   channel/query of the buffer
+//	$S      server tag             $$   a literal $
+//
+// Like irssi, the arguments are appended when the text uses none of them.
+func substituteAliasArgs(text string, args []string, serverTag, target string) string {
+	var out strings.Builder
+	usedArgs := false
+
+	for i := 0; i < len(text); i++ {
+		if text[i] != '$' || i+1 == len(text) {
+			out.WriteByte(text[i])
+			continue
+		}
+
+		next := text[i+1]
+		switch {
+		case next == '$':
+			out.WriteByte('$')
+		case next == '*':
+			out.WriteString(strings.Join(args, " "))
+			usedArgs = true
+		case next == 'C':
+			out.WriteString(target)
+		case next == 'S':
+			out.WriteString(serverTag)
+		case next >= '0' && next <= '9':
+			n, _ := strconv.Atoi(string(next))
+			if i+2 < len(text) && text[i+2] == '-' {
+				if n < len(args) {
+					out.WriteString(strings.Join(args[n:], " "))
+				}
+				i++
+			} else if n < len(args) {
+				out.WriteString(args[n])
+			}
+			usedArgs = true
+		default:
+			out.WriteByte('$')
+			continue
+		}
+		i++
+	}
+
+	result := out.String()
+	if !usedArgs && len(args) > 0 {
+		result += " " + strings.Join(args, " ")
+	}
+	return strings.TrimSpace(result)
+}
@@ -0,0 +1,50 @@
+package bridge
+
+import "time"
+
+// startAutoSort periodically renumbers buffers by activity and broadcasts
+// the moves. Caller must hold b.mu.
+func (b *Bridge) startAutoSort() {
+	if b.autoSortInterval <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	b.autoSortStop = stop
+	go b.autoSortLoop(stop)
+}
+
+// stopAutoSort stops the auto-sort loop. Caller must hold b.mu.
+func (b *Bridge) stopAutoSort() {
+	if b.autoSortStop != nil {
+		close(b.autoSortStop)
+		b.autoSortStop = nil
+	}
+}
+
+func (b *Bridge) autoSortLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(b.autoSortInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.sortBuffers()
+		}
+	}
+}
+
+// sortBuffers renumbers buffers by activity, unless a state dump is still
+// filling the buffer list
+func (b *Bridge) sortBuffers() {
+	if b.dumps.Active() {
+		return
+	}
+
+	if moved := b.translator.SortByActivity(); moved != nil {
+		b.log.Debug("Buffers reordered by activity")
+		b.broadcastUnrestricted(moved)
+	}
+}
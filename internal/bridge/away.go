@@ -0,0 +1,147 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// defaultAwayReplyInterval is how often the same sender gets an auto-reply
+const defaultAwayReplyInterval = 30 * time.Minute
+
+// awayState tracks the away status set through the bridge and rate limits
+// auto-replies per sender
+type awayState struct {
+	mu      sync.Mutex
+	away    bool
+	message string
+	since   time.Time
+
+	autoReply     bool
+	replyInterval time.Duration
+	replied       map[string]time.Time // "serverTag/nick" -> last auto-reply
+}
+
+func newAwayState(autoReply bool, replyInterval time.Duration) *awayState {
+	if replyInterval <= 0 {
+		replyInterval = defaultAwayReplyInterval
+	}
+	return &awayState{
+		autoReply:     autoReply,
+		replyInterval: replyInterval,
+		replied:       make(map[string]time.Time),
+	}
+}
+
+// set marks the user away with a message
+func (a *awayState) set(message string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.away = true
+	a.message = message
+	a.since = time.Now()
+	a.replied = make(map[string]time.Time)
+}
+
+// clear marks the user back, returning how long they were away
+func (a *awayState) clear() (time.Duration, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.away {
+		return 0, false
+	}
+	a.away = false
+	return time.Since(a.since), true
+}
+
+// shouldReply reports whether sender should get an auto-reply now, and
+// records it if so
+func (a *awayState) shouldReply(serverTag, nick string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.away || !a.autoReply {
+		return "", false
+	}
+
+	key := serverTag + "/" + strings.ToLower(nick)
+	if last, ok := a.replied[key]; ok && time.Since(last) < a.replyInterval {
+		return "", false
+	}
+	a.replied[key] = time.Now()
+
+	return a.message, true
+}
+
+// handleAwayCommand handles /away [message]. Like irssi, /away without a
+// message means back.
+func (b *Bridge) handleAwayCommand(client *weechat.Client, bufferPtr, message string) {
+	if message == "" {
+		b.handleBackCommand(client, bufferPtr)
+		return
+	}
+
+	if err := b.sendToBuffer(bufferPtr, "/away -all "+message); err != nil {
+		b.log.Errorf("Failed to set away: %v", err)
+		b.sendLocalNotice(client, bufferPtr, "away: "+err.Error())
+		return
+	}
+
+	b.away.set(message)
+	b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("You are now away: %s", message))
+}
+
+// handleBackCommand handles /back
+func (b *Bridge) handleBackCommand(client *weechat.Client, bufferPtr string) {
+	if err := b.sendToBuffer(bufferPtr, "/away -all"); err != nil {
+		b.log.Errorf("Failed to unset away: %v", err)
+		b.sendLocalNotice(client, bufferPtr, "back: "+err.Error())
+		return
+	}
+
+	if d, ok := b.away.clear(); ok {
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("You are no longer away (away for %s)", d.Round(time.Minute)))
+	} else {
+		b.sendLocalNotice(client, bufferPtr, "You are no longer away")
+	}
+}
+
+// maybeAutoReply answers a private message while away
+func (b *Bridge) maybeAutoReply(msg *erssiproto.WebMessage) {
+	if !isPrivateMessage(msg) {
+		return
+	}
+
+	message, ok := b.away.shouldReply(msg.ServerTag, msg.Nick)
+	if !ok {
+		return
+	}
+
+	// NOTICE, so other bots never answer the auto-reply
+	text := fmt.Sprintf("I'm away (%s), I'll see your message later", message)
+	reply := &erssiproto.WebMessage{
+		Type:      erssiproto.Message,
+		ServerTag: msg.ServerTag,
+		Target:    msg.Nick,
+		Text:      fmt.Sprintf("/notice %s %s", msg.Nick, text),
+	}
+	if err := b.sendToErssi(reply); err != nil {
+		b.log.Errorf("Failed to send away auto-reply to %s: %v", msg.Nick, err)
+		return
+	}
+	b.log.Debugf("Sent away auto-reply to %s on %s", msg.Nick, msg.ServerTag)
+}
+
+// isPrivateMessage reports whether msg is a query message from someone else
+func isPrivateMessage(msg *erssiproto.WebMessage) bool {
+	if msg.IsOwn || msg.Nick == "" || msg.Nick == "--" || msg.Target == "" {
+		return false
+	}
+	return !strings.ContainsAny(msg.Target[:1], "#&!+")
+}
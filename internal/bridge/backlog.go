@@ -0,0 +1,92 @@
+package bridge
+
+import (
+	"sync"
+
+	"erssi-lith-bridge/internal/translator"
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// syncBacklogs remembers the buffers each relay client already has lines
+// of, so that sync pushes their backlog once, and not at all to clients
+// that asked for lines themselves. A nil syncBacklogs pushes nothing.
+type syncBacklogs struct {
+	lines int // Lines pushed per buffer
+
+	mu   sync.Mutex
+	sent map[*weechat.Client]map[string]bool // Buffer pointers, "*" = all
+}
+
+func newSyncBacklogs(lines int) *syncBacklogs {
+	if lines <= 0 {
+		return nil
+	}
+	return &syncBacklogs{lines: lines, sent: make(map[*weechat.Client]map[string]bool)}
+}
+
+// mark records that a client has the lines of a buffer ("*" = all)
+func (s *syncBacklogs) mark(client *weechat.Client, bufferPtr string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sent[client] == nil {
+		s.sent[client] = make(map[string]bool)
+	}
+	s.sent[client][bufferPtr] = true
+}
+
+// take returns the backlog the client doesn't have yet, marking it sent
+func (s *syncBacklogs) take(client *weechat.Client, backlog []translator.BufferBacklog) []translator.BufferBacklog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sent := s.sent[client]
+	if sent == nil {
+		sent = make(map[string]bool)
+		s.sent[client] = sent
+	}
+	if sent["*"] {
+		return nil
+	}
+
+	var missing []translator.BufferBacklog
+	for _, buf := range backlog {
+		if !sent[buf.Pointer] {
+			sent[buf.Pointer] = true
+			missing = append(missing, buf)
+		}
+	}
+	return missing
+}
+
+// forget drops a disconnected client
+func (s *syncBacklogs) forget(client *weechat.Client) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.sent, client)
+}
+
+// pushSyncBacklog sends the last lines of the buffers a sync command names
+// to the client that sent it, as _buffer_line_added events
+func (b *Bridge) pushSyncBacklog(client *weechat.Client, names []string) {
+	b.waitForStateDump()
+
+	backlog := b.translator.SyncBacklog(names, b.backlogs.lines, b.clientView(client))
+	for _, buf := range b.backlogs.take(client, backlog) {
+		msg := weechatproto.CreateLinesHDataWithID(buf.Lines, "_buffer_line_added")
+		if err := client.SendMessage(msg); err != nil {
+			b.log.Errorf("Failed to send backlog: %v", err)
+			return
+		}
+	}
+}
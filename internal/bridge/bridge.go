@@ -1,35 +1,133 @@
 package bridge
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"os"
 	"regexp"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
+	"erssi-lith-bridge/internal/chaos"
+	"erssi-lith-bridge/internal/digest"
 	"erssi-lith-bridge/internal/erssi"
+	"erssi-lith-bridge/internal/history"
+	"erssi-lith-bridge/internal/relayauth"
 	"erssi-lith-bridge/internal/translator"
 	"erssi-lith-bridge/internal/weechat"
 	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
 
 	"github.com/sirupsen/logrus"
 )
 
+// nicklistCallTimeout bounds how long a relay nicklist request waits for erssi
+const nicklistCallTimeout = 5 * time.Second
+
 // Bridge connects erssi WebSocket to WeeChat protocol clients
 type Bridge struct {
-	erssiClient   *erssi.Client
+	upstreams     []*upstream
 	weechatServer *weechat.Server
 	translator    *translator.Translator
 
 	log *logrus.Entry
 
 	// Synchronization
-	mu                  sync.RWMutex
-	running             bool
-	inStateDump         bool   // Track if we're processing state_dump sequence
-	stateDumpServer     string
-	stateDumpRequested  bool   // Track if we already requested state dump from erssi
+	mu                 sync.RWMutex
+	running            bool
+	started            time.Time // When Start was called, for info uptime
+	stateDumpRequested bool      // Track if we already requested state dump from erssi
+
+	// Per-server state_dump progress
+	dumps *stateDumpTracker
+
+	// Plaintext "ok"/"degraded" listener for uptime monitors, see status.go
+	statusAddr     string
+	statusListener net.Listener
+	statusServer   *http.Server
+
+	// Lines to send as messages from local scripts, see inject.go
+	injectPath string
+	injectFile *os.File
+
+	// Backlog pushed to clients on sync (nil = off)
+	backlogs *syncBacklogs
+
+	// Last events from erssi to the relay clients (nil = off)
+	journal *eventJournal
+
+	// Running /bridge diff (nil = none)
+	diffMu sync.Mutex
+	diff   *bufferDiff
+
+	waitForErssi        bool
+	waitForErssiTimeout time.Duration
+	stateDumpTimeout    time.Duration
+
+	// Recent outgoing messages per buffer (for /edit and /delete)
+	sentHistory *sentHistory
+
+	nicklistDisabled bool
+	nicksInfoEnabled bool
+
+	// Away status set with /away, for auto-replies
+	away *awayState
+
+	// User-defined command aliases (name -> expansion)
+	aliases map[string]string
+
+	// Runs allowlisted host commands for /bridge exec (nil = disabled)
+	exec *execRunner
+
+	// Persistent line history (nil = memory only)
+	history *history.Store
+
+	// Ends buffer mutes set with /bridge mute
+	mutes *muteTimers
+
+	// Renumbers buffers by activity every autoSortInterval (0 = off)
+	autoSortInterval time.Duration
+	autoSortStop     chan struct{}
+
+	// Servers and channels followed (nil = all), see Config.Subscribe
+	subs *subscriptions
+
+	// Identifies to NickServ on connect (nil = no credentials)
+	nickserv *nickServ
+
+	// Buffers of restricted relay accounts (nil = no restrictions)
+	acl *bufferACL
+
+	// Distinct erssi schema violations already reported
+	violations *violationReporter
+
+	// Automatic state dump requests when erssi looks out of sync, see
+	// resync.go (resyncSilence 0 = no silence watch)
+	resyncs       *resyncLimiter
+	resyncSilence time.Duration
+	silenceStop   chan struct{}
+
+	// _upgrade sent to relay clients while state reloads, see upgrade.go
+	upgrade relayUpgrade
+
+	// Lag beyond which the core buffer shows a warning, see lag.go
+	lagWarn time.Duration
+
+	// Relay clients waiting for a nicklist from erssi
+	nicklistReqs *nicklistRequests
+
+	// Input for unknown buffer pointers, see stale.go
+	unknownBuffer unknownBufferPolicy
+	staleClients  *staleClients
+
+	// Mails highlights received while no client is connected (optional)
+	digest *digest.Notifier
 }
 
 // Config holds bridge configuration
@@ -38,8 +136,274 @@ type Config struct {
 	ErssiURL      string
 	ErssiPassword string
 
+	// Upstreams aggregates several erssi instances into one buffer list,
+	// namespacing their server tags ("name/tag"). Overrides ErssiURL and
+	// ErssiPassword when set.
+	Upstreams []UpstreamConfig
+
+	// TLS verification for wss:// erssi URLs
+	ErssiCAFile     string // Trust these CAs instead of the system pool
+	ErssiCertFile   string // Client certificate
+	ErssiKeyFile    string // Client certificate key
+	ErssiServerName string // Expected certificate name (default: URL host)
+	ErssiInsecure   bool   // Skip certificate verification
+
+	// ErssiCompression offers permessage-deflate to erssi
+	ErssiCompression bool
+
+	// ErssiPlaintext is what to do with unencrypted frames from erssi
+	// while a password is set: "warn" (default) or "reject"
+	ErssiPlaintext string
+
+	// Subscribe limits the bridge to some servers and channels: patterns
+	// "server/channel" or just "server", with * and ? wildcards (e.g.
+	// "libera/#go*", "oftc"). Messages for anything else are dropped
+	// before translation, so no buffers are created for them. Empty =
+	// everything.
+	Subscribe []string
+
+	// NickServCredentials identify the user to NickServ whenever erssi
+	// reports a server connected: "server=account:password" or
+	// "server=password" per server tag
+	NickServCredentials []string
+
+	// Reconnection to erssi after the connection drops
+	Reconnect           bool
+	ReconnectMaxRetries int // 0 = retry forever
+
+	// ResyncSilence requests a fresh state dump from an erssi that sent
+	// nothing for this long (0 = disabled). Sequence gaps and messages for
+	// unknown channels always trigger a resync.
+	ResyncSilence time.Duration
+
+	// Ping erssi every KeepaliveInterval and drop the connection when it
+	// stays silent for another interval (0 = disabled)
+	KeepaliveInterval time.Duration
+
+	// Ping erssi with fe-web ping messages every LagCheckInterval
+	// (0 = disabled) and warn in the core buffer when the round trip
+	// through erssi, or the time the answer waits in the bridge, exceeds
+	// LagWarn (default 10s)
+	LagCheckInterval time.Duration
+	LagWarn          time.Duration
+
+	// Messages from relay clients buffered while erssi is reconnecting
+	// (0 = reject input while disconnected)
+	SendQueueSize     int
+	SendQueueOverflow string // "drop-oldest" (default) or "drop-newest"
+
+	// ValidateErssi checks incoming erssi messages against the fields the
+	// bridge expects and reports each distinct violation in the core buffer
+	ValidateErssi bool
+
+	// Nicklist requests and other state requests to erssi are limited to
+	// RequestRate per second with bursts of RequestBurst (0 = unlimited);
+	// nicklist refreshes of a channel within NicklistDebounce are merged
+	RequestRate      float64
+	RequestBurst     int
+	NicklistDebounce time.Duration
+
+	// Throttle paces the messages sent to each IRC network below its
+	// flood limit: "tag=rate[/burst]" entries over the defaults of
+	// well-known networks, see erssi.ParseThrottleProfiles
+	Throttle []string
+
 	// WeeChat server
 	ListenAddr string
+	RelayMode  string // "strict" or "lenient" handling of malformed commands
+
+	// RelayUnknownBuffer is what happens to input for a buffer pointer the
+	// bridge doesn't know: "refresh" (default) tells the client in that
+	// buffer and sends it the buffer list again, and resyncs clients that
+	// keep using unknown pointers; "notice" only tells it, "ignore" only
+	// logs
+	RelayUnknownBuffer string
+
+	// RelayClientBandwidth caps what each relay client is sent, in bytes
+	// per second with an optional k/m suffix ("256k"), empty = unlimited
+	RelayClientBandwidth string
+
+	// RelayCompressionMinSize is the smallest message, in bytes, compressed
+	// for relay clients that negotiated compression (0 = all)
+	RelayCompressionMinSize int
+
+	// Relay clients falling RelayWriteQueueSize messages behind, or whose
+	// write takes longer than RelayWriteTimeout, are disconnected so they
+	// can't hold back the others (0 = weechat defaults)
+	RelayWriteQueueSize int
+	RelayWriteTimeout   time.Duration
+
+	// RelayIdleTimeout disconnects relay clients that send nothing for
+	// this long (0 = never); RelayPingInterval is how often the bridge
+	// checks that clients are still there (0 = off)
+	RelayIdleTimeout  time.Duration
+	RelayPingInterval time.Duration
+
+	// Listen opens the relay listeners (default net.Listen), e.g. on an
+	// embedded tailnet node
+	Listen weechat.ListenFunc
+
+	// ListenSocketMode is the octal permission of unix socket listeners
+	// ("unix:///run/bridge.sock" in ListenAddr), default "0660"
+	ListenSocketMode string
+
+	// Websocket relay listener (disabled when ListenWSAddr is empty)
+	ListenWSAddr     string
+	ListenWSPath     string   // URL path, default "/weechat"
+	ListenWSOrigins  []string // Origins browser clients may connect from, see weechat.Config
+	RelayBasicAuth   string   // "user:password" required in an Authorization: Basic header
+	RelayBearerToken string   // Token required in an Authorization: Bearer header
+
+	// ListenStatusAddr answers uptime monitors with "ok" or "degraded" and
+	// nothing else, on the host network and without TLS (empty = off)
+	ListenStatusAddr string
+
+	// InjectPipe is a named pipe (created if missing), or "-" for stdin,
+	// whose "<server> <target> <text>" lines are sent as messages, for cron
+	// jobs and local scripts (empty = off)
+	InjectPipe string
+
+	// Let's Encrypt certificates for the relay listeners, which then speak
+	// TLS (disabled when RelayACMEDomains is empty), see weechat.ACMEConfig
+	RelayACMEDomains  []string
+	RelayACMECacheDir string
+	RelayACMEEmail    string
+	RelayACMEHTTPAddr string
+
+	// RequireHandshake rejects relay clients that send init without a
+	// prior handshake (pre-2.9 clients)
+	RequireHandshake bool
+
+	// RelayAuth selects how relay client passwords are verified:
+	// "password", "htpasswd:<file>", "pam[:<service>]" or "http:<url>",
+	// see relayauth.Config. Empty = RelayPassword if set, else no check.
+	RelayAuth     string
+	RelayPassword string
+
+	// RelayPasswordHashIterations is the PBKDF2 iteration count offered to
+	// relay clients hashing their password (0 = WeeChat's default)
+	RelayPasswordHashIterations int
+
+	// RelayNonceTTL is how long a handshake nonce can salt an init password
+	// hash (0 = weechat.DefaultNonceTTL)
+	RelayNonceTTL time.Duration
+
+	// RelayTOTPSecret is a base32 TOTP secret relay clients must send the
+	// current code of in init, as second factor (empty = password only)
+	RelayTOTPSecret string
+
+	// RelayACL limits the buffers of relay accounts in multi-user setups:
+	// "account=server/channel|..." entries, "*" for unlisted accounts
+	// (see parseBufferACL). Empty = every client sees every buffer.
+	RelayACL []string
+
+	// RelayMaxClients limits the connected relay clients (0 = unlimited).
+	// RelayAllow and RelayDeny are CIDRs or addresses relay clients may and
+	// may not connect from (empty = no restriction), see
+	// weechat.AccessList.
+	RelayMaxClients int
+	RelayAllow      []string
+	RelayDeny       []string
+
+	// RelayLockout bans addresses that keep failing relay init (zero
+	// MaxFailures = off)
+	RelayLockout weechat.LockoutConfig
+
+	// WaitForErssi delays opening the relay listener until erssi is
+	// connected and its first state dump is complete, so early clients
+	// don't see an empty buffer list
+	WaitForErssi        bool
+	WaitForErssiTimeout time.Duration // Give up waiting for the dump (default 30s)
+
+	// StateDumpTimeout is how long a buffer list request waits for a
+	// running state dump to finish before answering with what's loaded
+	// (default 15s)
+	StateDumpTimeout time.Duration
+
+	// DisableNicklist turns off nicklist handling: buffers advertise
+	// nicklist=0, nicklists from erssi are dropped and nicklist requests
+	// get an empty reply. Saves memory and bandwidth on large networks.
+	DisableNicklist bool
+
+	// NicksInfo answers info bridge_nicks with the nicks of a buffer.
+	// Off by default, as it lists nicks to clients without a nicklist
+	// request.
+	NicksInfo bool
+
+	// AwayAutoReply answers private messages with a notice while /away is
+	// set, at most once per AwayReplyInterval (default 30m) per sender
+	AwayAutoReply     bool
+	AwayReplyInterval time.Duration
+
+	// Aliases maps command names to expansions, see LoadAliases
+	Aliases map[string]string
+
+	// InputTransforms rewrite outgoing text per buffer, see
+	// translator.LoadInputTransforms
+	InputTransforms []translator.InputTransform
+
+	// OwnPrefix replaces the nick shown on the user's own messages
+	// ("{nick}" stands for the nick) and OwnColor colors it (WeeChat color
+	// name or 256-color number); both empty = erssi's nick, default color
+	OwnPrefix string
+	OwnColor  string
+
+	// OutgoingColors is what happens to WeeChat color codes in text sent
+	// to erssi: "convert" (default) to mIRC formatting or "strip"
+	OutgoingColors string
+
+	// Charsets are the text encodings of legacy networks whose bytes irssi
+	// passes through unrecoded: "server=charset" entries (utf-8, latin-1 or
+	// cp1252), "*" for unlisted servers. Empty = UTF-8 everywhere.
+	Charsets []string
+
+	// WallopsNotify moves wallops and global notices into a buffer per
+	// network with this notify level ("none", "highlight", "message" or
+	// "all"). Empty = they stay in the server buffer.
+	WallopsNotify string
+
+	// AllowExec permits /bridge exec for the commands in ExecAllowlist,
+	// each with the arguments its entries permit (see newExecRunner).
+	// Anyone with relay access can then run them on the bridge host.
+	AllowExec     bool
+	ExecAllowlist []string
+
+	// AutoSortInterval renumbers buffers by recent activity at this
+	// interval and broadcasts _buffer_moved (0 = keep join order)
+	AutoSortInterval time.Duration
+
+	// Digest mails highlights and private messages received while no
+	// client is connected (disabled when DigestSMTPAddr is empty)
+	DigestSMTPAddr string
+	DigestUsername string
+	DigestPassword string
+	DigestFrom     string
+	DigestTo       []string
+	DigestInterval time.Duration // default 1h
+
+	// HistoryDir keeps buffer lines across restarts (empty = memory only).
+	// With HistoryPassphrase the store is encrypted at rest.
+	HistoryDir        string
+	HistoryPassphrase string
+
+	// History retention: lines per buffer (default 500), maximum age
+	// (0 = unlimited) and per-buffer overrides
+	HistoryMaxLines  int
+	HistoryMaxAge    time.Duration
+	HistoryRetention []history.RetentionRule
+
+	// SyncBacklog is how many lines of each synced buffer are pushed to a
+	// client that didn't ask for them (0 = off)
+	SyncBacklog int
+
+	// JournalSize is how many events /bridge journal keeps (0 = off)
+	JournalSize int
+
+	// ChaosErssi and ChaosRelay inject latency, drops and reordering into
+	// messages from erssi and commands from relay clients, to exercise
+	// queues, resync and dedup against a bad link. Testing only.
+	ChaosErssi chaos.Config
+	ChaosRelay chaos.Config
 
 	// Logging
 	Logger *logrus.Logger
@@ -53,27 +417,220 @@ func New(cfg Config) (*Bridge, error) {
 		logger.SetLevel(logrus.DebugLevel)
 	}
 
-	// Create erssi client
-	erssiClient := erssi.NewClient(erssi.Config{
-		URL:      cfg.ErssiURL,
-		Password: cfg.ErssiPassword,
+	warnChaos(logger, "erssi messages", cfg.ChaosErssi)
+	warnChaos(logger, "relay commands", cfg.ChaosRelay)
+
+	// Create erssi clients
+	upstreams, err := newUpstreams(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+
+	relayMode, err := weechat.ParseProtocolMode(cfg.RelayMode)
+	if err != nil {
+		return nil, err
+	}
+	unknownBuffer, err := parseUnknownBufferPolicy(cfg.RelayUnknownBuffer)
+	if err != nil {
+		return nil, err
+	}
+	clientBandwidth, err := weechat.ParseBandwidth(cfg.RelayClientBandwidth)
+	if err != nil {
+		return nil, err
+	}
+	totpSecret, err := weechat.ParseTOTPSecret(cfg.RelayTOTPSecret)
+	if err != nil {
+		return nil, err
+	}
+	access, err := weechat.ParseAccessList(cfg.RelayAllow, cfg.RelayDeny)
+	if err != nil {
+		return nil, err
+	}
+	socketMode, err := weechat.ParseSocketMode(cfg.ListenSocketMode)
+	if err != nil {
+		return nil, err
+	}
+	relayAuth, err := relayauth.New(relayauth.Config{
+		Provider: cfg.RelayAuth,
+		Password: cfg.RelayPassword,
 		Logger:   logger,
 	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid relay authentication: %w", err)
+	}
+	if relayAuth == nil {
+		logger.WithField("component", "bridge").Warn("Relay authentication is disabled: every client reaching the relay gets in (set RELAY_PASSWORD or -relay-auth)")
+	}
+
+	var wsAuth weechat.HTTPAuth
+	if cfg.RelayBasicAuth != "" {
+		user, password, ok := strings.Cut(cfg.RelayBasicAuth, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("relay basic auth must be user:password")
+		}
+		wsAuth.BasicUser, wsAuth.BasicPassword = user, password
+	}
+	wsAuth.BearerToken = cfg.RelayBearerToken
+
+	execRunner, err := newExecRunner(cfg.AllowExec, cfg.ExecAllowlist)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create WeeChat server
 	weechatServer := weechat.NewServer(weechat.Config{
 		Address: cfg.ListenAddr,
 		Logger:  logger,
+		Mode:    relayMode,
+		Listen:  cfg.Listen,
+
+		SocketMode:             socketMode,
+		Auth:                   relayAuth,
+		PasswordHashIterations: cfg.RelayPasswordHashIterations,
+		NonceTTL:               cfg.RelayNonceTTL,
+		TOTPSecret:             totpSecret,
+		RequireHandshake:       cfg.RequireHandshake,
+		ClientBandwidth:        clientBandwidth,
+		CompressionMinSize:     cfg.RelayCompressionMinSize,
+		WriteQueueSize:         cfg.RelayWriteQueueSize,
+		WriteTimeout:           cfg.RelayWriteTimeout,
+		IdleTimeout:            cfg.RelayIdleTimeout,
+		PingInterval:           cfg.RelayPingInterval,
+		MaxClients:             cfg.RelayMaxClients,
+		Access:                 access,
+		Lockout:                cfg.RelayLockout,
+
+		WebSocketAddr:    cfg.ListenWSAddr,
+		WebSocketPath:    cfg.ListenWSPath,
+		WebSocketAuth:    wsAuth,
+		WebSocketOrigins: cfg.ListenWSOrigins,
+
+		ACME: weechat.ACMEConfig{
+			Domains:  cfg.RelayACMEDomains,
+			CacheDir: cfg.RelayACMECacheDir,
+			Email:    cfg.RelayACMEEmail,
+			HTTPAddr: cfg.RelayACMEHTTPAddr,
+		},
+
+		Chaos: cfg.ChaosRelay,
 	})
 
+	subs, err := parseSubscriptions(cfg.Subscribe)
+	if err != nil {
+		return nil, err
+	}
+	nickserv, err := parseNickServCredentials(cfg.NickServCredentials)
+	if err != nil {
+		return nil, err
+	}
+	acl, err := parseBufferACL(cfg.RelayACL)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create translator
 	trans := translator.NewTranslator(logger)
+	if subs != nil {
+		trans.SetBufferFilter(subs.allows)
+	}
+	if cfg.DisableNicklist {
+		trans.DisableNicklist()
+	}
+	trans.SetInputTransforms(cfg.InputTransforms)
+	if err := trans.SetOwnStyle(cfg.OwnPrefix, cfg.OwnColor); err != nil {
+		return nil, fmt.Errorf("invalid own message color: %w", err)
+	}
+	outgoingColors, err := translator.ParseOutgoingColors(cfg.OutgoingColors)
+	if err != nil {
+		return nil, err
+	}
+	trans.SetOutgoingColors(outgoingColors)
+	charsets, err := translator.ParseCharsets(cfg.Charsets)
+	if err != nil {
+		return nil, err
+	}
+	trans.SetCharsets(charsets)
+	wallopsNotify, err := translator.ParseNotifyLevel(cfg.WallopsNotify)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallops buffer: %w", err)
+	}
+	trans.SetWallopsBuffer(wallopsNotify)
+
+	var store *history.Store
+	if cfg.HistoryDir != "" {
+		store, err = history.Open(history.Config{
+			Dir:        cfg.HistoryDir,
+			Passphrase: cfg.HistoryPassphrase,
+			MaxLines:   cfg.HistoryMaxLines,
+			MaxAge:     cfg.HistoryMaxAge,
+			Overrides:  cfg.HistoryRetention,
+			Logger:     logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to open history: %w", err)
+		}
+		trans.SetHistory(store)
+	}
 
 	b := &Bridge{
-		erssiClient:   erssiClient,
+		upstreams:     upstreams,
 		weechatServer: weechatServer,
 		translator:    trans,
 		log:           logger.WithField("component", "bridge"),
+		sentHistory:   newSentHistory(),
+
+		waitForErssi:        cfg.WaitForErssi,
+		waitForErssiTimeout: cfg.WaitForErssiTimeout,
+		stateDumpTimeout:    cfg.StateDumpTimeout,
+		nicklistDisabled:    cfg.DisableNicklist,
+		nicksInfoEnabled:    cfg.NicksInfo,
+		away:                newAwayState(cfg.AwayAutoReply, cfg.AwayReplyInterval),
+		aliases:             cfg.Aliases,
+		exec:                execRunner,
+		history:             store,
+		mutes:               newMuteTimers(),
+		autoSortInterval:    cfg.AutoSortInterval,
+		violations:          newViolationReporter(),
+		subs:                subs,
+		nickserv:            nickserv,
+		acl:                 acl,
+		nicklistReqs:        newNicklistRequests(),
+		unknownBuffer:       unknownBuffer,
+		staleClients:        newStaleClients(),
+		resyncs:             newResyncLimiter(),
+		resyncSilence:       cfg.ResyncSilence,
+		lagWarn:             cfg.LagWarn,
+		journal:             newEventJournal(cfg.JournalSize),
+		backlogs:            newSyncBacklogs(cfg.SyncBacklog),
+		statusAddr:          cfg.ListenStatusAddr,
+		injectPath:          cfg.InjectPipe,
+	}
+	if b.waitForErssiTimeout == 0 {
+		b.waitForErssiTimeout = 30 * time.Second
+	}
+	if b.stateDumpTimeout == 0 {
+		b.stateDumpTimeout = 15 * time.Second
+	}
+	if b.lagWarn == 0 {
+		b.lagWarn = defaultLagWarn
+	}
+	b.dumps = newStateDumpTracker(b.log, defaultDumpQuietPeriod)
+	b.dumps.onComplete = b.handleDumpComplete
+	b.loadMutes()
+
+	if cfg.DigestSMTPAddr != "" {
+		if cfg.DigestFrom == "" || len(cfg.DigestTo) == 0 {
+			return nil, fmt.Errorf("digest needs a sender and at least one recipient")
+		}
+		b.digest = digest.NewNotifier(digest.Config{
+			SMTPAddr: cfg.DigestSMTPAddr,
+			Username: cfg.DigestUsername,
+			Password: cfg.DigestPassword,
+			From:     cfg.DigestFrom,
+			To:       cfg.DigestTo,
+			Interval: cfg.DigestInterval,
+			Logger:   logger,
+		})
 	}
 
 	// Setup handlers
@@ -85,14 +642,27 @@ func New(cfg Config) (*Bridge, error) {
 // setupHandlers configures event handlers
 func (b *Bridge) setupHandlers() {
 	// erssi client handlers
-	b.erssiClient.OnMessage(b.handleErssiMessage)
-	b.erssiClient.OnConnected(b.handleErssiConnected)
-	b.erssiClient.OnDisconnect(b.handleErssiDisconnect)
+	for _, u := range b.upstreams {
+		u := u
+		u.client.OnMessage(func(msg *erssiproto.WebMessage) {
+			u.namespace(msg)
+			b.handleSubscribedMessage(msg)
+		})
+		u.client.OnConnected(func() { b.handleErssiConnected(u) })
+		u.client.OnDisconnect(func(err error) { b.handleErssiDisconnect(u, err) })
+		u.client.OnReconnected(func() { b.handleErssiReconnected(u) })
+		u.client.OnViolation(func(v erssiproto.Violation) { b.handleSchemaViolation(u, v) })
+		u.client.OnGap(func(missed uint64) { b.handleErssiGap(u, missed) })
+		u.client.OnLag(func(lag erssi.Lag) { b.handleErssiLag(u, lag) })
+	}
 
 	// WeeChat server handlers
 	b.weechatServer.OnCommand(b.handleWeeChatCommand)
 	b.weechatServer.OnClientConnected(b.handleWeeChatClientConnected)
 	b.weechatServer.OnClientDisconnected(b.handleWeeChatClientDisconnected)
+	if b.journal != nil {
+		b.weechatServer.OnBroadcast(b.journalBroadcast)
+	}
 }
 
 // Start starts the bridge
@@ -105,24 +675,88 @@ func (b *Bridge) Start() error {
 	}
 
 	b.log.Info("Starting bridge...")
+	b.started = time.Now()
+
+	// Monitors see "degraded" while erssi state loads
+	if err := b.startStatusProbe(); err != nil {
+		return err
+	}
+	if err := b.openInjectPipe(); err != nil {
+		b.stopStatusProbe()
+		return err
+	}
+
+	if b.waitForErssi {
+		if err := b.startUpstreamFirst(); err != nil {
+			b.stopInject()
+			b.stopStatusProbe()
+			return err
+		}
+		b.startDigest()
+		b.startInject()
+		b.startAutoSort()
+		b.startSilenceWatch()
+		b.running = true
+		b.log.Info("Bridge started successfully")
+		return nil
+	}
 
 	// Start WeeChat server
 	if err := b.weechatServer.Start(); err != nil {
+		b.stopInject()
+		b.stopStatusProbe()
 		return fmt.Errorf("failed to start WeeChat server: %w", err)
 	}
 
 	// Connect to erssi
-	if err := b.erssiClient.Connect(); err != nil {
+	if err := b.connectUpstreams(); err != nil {
 		b.weechatServer.Close()
-		return fmt.Errorf("failed to connect to erssi: %w", err)
+		b.stopInject()
+		b.stopStatusProbe()
+		return err
 	}
 
+	b.startDigest()
+	b.startInject()
+	b.startAutoSort()
+	b.startSilenceWatch()
+
 	b.running = true
 	b.log.Info("Bridge started successfully")
 
 	return nil
 }
 
+// startUpstreamFirst connects to erssi, fetches the full state and only then
+// opens the relay listener. Caller must hold b.mu.
+func (b *Bridge) startUpstreamFirst() error {
+	if err := b.connectUpstreams(); err != nil {
+		return err
+	}
+
+	// Fetch state eagerly instead of on the first client init
+	b.stateDumpRequested = true
+	b.log.Info("Waiting for erssi state dump before accepting relay clients...")
+	b.postStatus("Loading state from erssi...")
+	if err := b.requestStateDumps(); err != nil {
+		b.closeUpstreams()
+		return fmt.Errorf("failed to request state dump: %w", err)
+	}
+
+	if b.dumps.WaitSettled(b.waitForErssiTimeout) {
+		b.log.Infof("erssi state loaded (%d buffers)", len(b.translator.GetBufferList()))
+	} else {
+		b.log.Warnf("erssi state dump not complete after %s, accepting clients anyway", b.waitForErssiTimeout)
+	}
+
+	if err := b.weechatServer.Start(); err != nil {
+		b.closeUpstreams()
+		return fmt.Errorf("failed to start WeeChat server: %w", err)
+	}
+
+	return nil
+}
+
 // Stop stops the bridge
 func (b *Bridge) Stop() error {
 	b.mu.Lock()
@@ -134,15 +768,34 @@ func (b *Bridge) Stop() error {
 
 	b.log.Info("Stopping bridge...")
 
-	// Close erssi connection
-	if err := b.erssiClient.Close(); err != nil {
-		b.log.Errorf("Error closing erssi client: %v", err)
+	// Like WeeChat's /upgrade: clients take the disconnect as a restart
+	// and reconnect
+	b.weechatServer.BroadcastMessage(weechatproto.CreateEventMessage("_upgrade"))
+
+	b.stopAutoSort()
+	b.stopSilenceWatch()
+	b.stopInject()
+
+	// Close erssi connections
+	b.closeUpstreams()
+
+	// Send what the digest collected so far
+	if b.digest != nil {
+		if err := b.digest.Close(); err != nil {
+			b.log.Errorf("Failed to send final digest: %v", err)
+		}
 	}
 
 	// Close WeeChat server
 	if err := b.weechatServer.Close(); err != nil {
 		b.log.Errorf("Error closing WeeChat server: %v", err)
 	}
+	b.stopStatusProbe()
+
+	b.mutes.stop()
+	if b.history != nil {
+		b.history.Close()
+	}
 
 	b.running = false
 	b.log.Info("Bridge stopped")
@@ -150,35 +803,85 @@ func (b *Bridge) Stop() error {
 	return nil
 }
 
-// Wait blocks until erssi connection is closed
+// RelayAddr returns the address the WeeChat relay is listening on
+func (b *Bridge) RelayAddr() string {
+	if addr := b.weechatServer.Addr(); addr != nil {
+		return addr.String()
+	}
+	return ""
+}
+
+// Wait blocks until every erssi connection is closed
 func (b *Bridge) Wait() {
-	b.erssiClient.Wait()
+	for _, u := range b.upstreams {
+		u.client.Wait()
+	}
 }
 
 // erssi event handlers
 
+// handleSubscribedMessage handles an erssi message unless it concerns a
+// server or channel outside the subscriptions
+func (b *Bridge) handleSubscribedMessage(msg *erssiproto.WebMessage) {
+	if !b.subs.allowsMessage(msg) {
+		return
+	}
+	b.handleErssiMessage(msg)
+}
+
 func (b *Bridge) handleErssiMessage(msg *erssiproto.WebMessage) {
 	b.log.Debugf("erssi message: type=%s from=%s target=%s", msg.Type, msg.Nick, msg.Target)
+	in := b.journalErssi(msg)
+
+	// Dump burst messages (channel_join/nicklist/topic/activity_update)
+	// extend the dump of their server; live chat traffic does not
+	if isDumpBurstMessage(msg.Type) {
+		b.dumps.Touch(msg.ServerTag, dumpChannel(msg))
+		b.recordDiffChannel(msg.ServerTag, dumpChannel(msg))
+	}
+
+	// A message for a channel the bridge never heard of means a missed join
+	b.checkForGap(msg)
 
 	// Translate message type
 	switch msg.Type {
 	case erssiproto.Message:
-		// Convert IRC message to WeeChat line
+		// Wallops may go to a buffer of their own, opened by the first one
+		target := b.translator.LineTarget(msg)
+		opened := target != msg.Target && !b.translator.HasBuffer(msg.ServerTag, target)
+
+		// Convert IRC message to WeeChat line (nil for msgid duplicates)
 		weechatMsg := b.translator.ErssiMessageToLine(msg)
-		b.weechatServer.BroadcastMessage(weechatMsg)
+		b.journalLine(in, msg.ServerTag, target, weechatMsg)
+		if weechatMsg != nil {
+			if opened {
+				b.broadcastToBuffer(msg.ServerTag, target, b.translator.GetBufferOpenedEvent(msg.ServerTag, target))
+			}
+			b.broadcastToBuffer(msg.ServerTag, target, weechatMsg)
+			b.maybeAutoReply(msg)
+			b.collectForDigest(msg)
+		}
 
 	case erssiproto.StateDump:
 		// state_dump marks the start of a server's state - create server buffer
-		b.mu.Lock()
-		b.inStateDump = true
-		b.stateDumpServer = msg.ServerTag
-		b.mu.Unlock()
+		b.dumps.Begin(msg.ServerTag)
 
 		b.log.Infof("State dump started for server: %s", msg.ServerTag)
+		b.postServerStatus(msg.ServerTag, "", fmt.Sprintf("Loading %s...", msg.ServerTag))
 
 		// Create server buffer (network buffer)
 		b.translator.EnsureServerBuffer(msg.ServerTag)
 		b.log.Debugf("Created server buffer for: %s", msg.ServerTag)
+		b.recordDiffQueries(msg)
+
+		// Newer fe-web versions embed channel objects (topic, mode, user_count,
+		// nicks) in the dump itself - use them instead of a nicklist round trip
+		if channels := b.translator.ApplyStateDump(msg); len(channels) > 0 {
+			b.log.Debugf("State dump for %s carried %d channels", msg.ServerTag, len(channels))
+			for _, c := range channels {
+				b.dumps.Touch(c.ServerTag, strings.ToLower(c.Name))
+			}
+		}
 
 		// Following channel_join messages will create channel buffers
 
@@ -206,24 +909,91 @@ func (b *Bridge) handleErssiMessage(msg *erssiproto.WebMessage) {
 		// Handle activity update
 		b.handleActivityUpdate(msg)
 
+	case erssiproto.NickChange:
+		// Only the own nick is tracked; other nicks come with nicklists
+		if msg.IsOwn {
+			b.translator.SetOwnNick(msg.ServerTag, msg.Text)
+		}
+
+	case erssiproto.ServerStatus:
+		b.handleServerStatus(msg)
+
+	case erssiproto.Error:
+		b.handleErssiError(msg)
+
 	default:
 		b.log.Debugf("Unhandled erssi message type: %s", msg.Type)
 	}
 }
 
-func (b *Bridge) handleErssiConnected() {
-	b.log.Info("Connected to erssi, waiting for Lith clients...")
+// isDumpBurstMessage reports whether fe-web sends this type as part of a
+// state dump burst
+func isDumpBurstMessage(t erssiproto.MessageType) bool {
+	switch t {
+	case erssiproto.ChannelJoin, erssiproto.Nicklist, erssiproto.Topic, erssiproto.ActivityUpdate:
+		return true
+	}
+	return false
+}
+
+// dumpChannel returns the channel a message contributes to a state dump
+func dumpChannel(msg *erssiproto.WebMessage) string {
+	if msg.Type == erssiproto.ActivityUpdate {
+		return ""
+	}
+	return strings.ToLower(msg.Target)
+}
+
+func (b *Bridge) handleErssiConnected(u *upstream) {
+	u.log.Infof("Connected to %s at %s, waiting for Lith clients...", u.describe(), u.client.ActiveURL())
 	// DON'T request state_dump here - wait until Lith connects and asks for buffers
 }
 
-func (b *Bridge) handleErssiDisconnect(err error) {
-	b.log.Errorf("Disconnected from erssi: %v", err)
-	// TODO: Implement reconnection logic
+func (b *Bridge) handleErssiDisconnect(u *upstream, err error) {
+	if errors.Is(err, erssi.ErrTimeout) {
+		u.log.Errorf("%s connection timed out, assuming it is dead: %v", u.describe(), err)
+	} else {
+		u.log.Errorf("Disconnected from %s: %v", u.describe(), err)
+	}
+
+	// A dump interrupted by the disconnect will never complete
+	b.dumps.Reset(u.prefix)
+
+	// Servers may reconnect unseen while erssi is unreachable
+	if b.nickserv != nil {
+		b.nickserv.reset(u.prefix)
+	}
+}
+
+func (b *Bridge) handleErssiReconnected(u *upstream) {
+	b.mu.RLock()
+	hadState := b.stateDumpRequested
+	b.mu.RUnlock()
+
+	// Anything may have happened while we were away (joins, parts, topics);
+	// fetch the state again if clients were already using it
+	if !hadState {
+		u.log.Infof("Reconnected to %s, state will be fetched on first client", u.describe())
+		return
+	}
+
+	u.log.Infof("Reconnected to %s at %s, re-syncing state...", u.describe(), u.client.ActiveURL())
+	b.postUpstreamStatus(fmt.Sprintf("Reconnected to %s at %s, re-syncing state...", u.describe(), u.client.ActiveURL()))
+	b.beginRelayUpgrade()
+	ctx, cancel := sendContext()
+	defer cancel()
+	if err := u.client.RequestStateDump(ctx); err != nil {
+		u.log.Errorf("Failed to request state dump after reconnect: %v", err)
+	}
 }
 
 // Specific message type handlers
 
 func (b *Bridge) handleNicklist(msg *erssiproto.WebMessage) {
+	if b.nicklistDisabled {
+		return
+	}
+
 	// Parse nicklist from msg.Text (JSON array)
 	if msg.Text == "" {
 		b.log.Warn("Nicklist message has empty text")
@@ -238,16 +1008,19 @@ func (b *Bridge) handleNicklist(msg *erssiproto.WebMessage) {
 
 	b.log.Debugf("Received nicklist for %s.%s with %d users", msg.ServerTag, msg.Target, len(nicks))
 
-	// Convert to WeeChat format and broadcast
+	// Convert to WeeChat format; answer the clients that asked for it, or
+	// broadcast an unsolicited update
 	weechatMsg := b.translator.ErssiNicklistToWeeChat(msg, nicks)
-	b.weechatServer.BroadcastMessage(weechatMsg)
+	if waiters := b.nicklistReqs.take(nicklistKey(msg.ServerTag, msg.Target)); len(waiters) > 0 {
+		for _, w := range waiters {
+			b.sendNicklist(w.client, w.msgID, weechatMsg)
+		}
+	} else {
+		b.broadcastToBuffer(msg.ServerTag, msg.Target, weechatMsg)
+	}
 
 	// Check if we're in state dump - nicklist is the last message per channel
-	b.mu.RLock()
-	inStateDump := b.inStateDump
-	b.mu.RUnlock()
-
-	if inStateDump {
+	if b.dumps.InProgress(msg.ServerTag) {
 		// During state dump, buffers are sent via handleBufferInitialization response
 		// No need to broadcast _buffer_opened here
 		b.log.Debug("Nicklist received during state dump")
@@ -255,15 +1028,20 @@ func (b *Bridge) handleNicklist(msg *erssiproto.WebMessage) {
 }
 
 func (b *Bridge) handleChannelJoin(msg *erssiproto.WebMessage) {
-	b.mu.RLock()
-	inStateDump := b.inStateDump
-	b.mu.RUnlock()
-
-	if inStateDump {
+	if b.dumps.InProgress(msg.ServerTag) {
 		// During state dump - just ensure buffer exists (will be created by translator)
 		b.log.Debugf("State dump: channel %s on %s", msg.Target, msg.ServerTag)
 		// Create buffer via translator (it's idempotent)
 		b.translator.EnsureBuffer(msg.ServerTag, msg.Target)
+
+		// Channel metadata may be inlined in extra_data
+		if len(msg.ExtraData) > 0 {
+			info := translator.ParseChannelInfo(msg.ExtraData)
+			info.Name = msg.Target
+			if b.translator.ApplyChannelInfo(msg.ServerTag, info) {
+				b.log.Debugf("State dump: %d nicks for %s from channel info", len(info.Nicks), msg.Target)
+			}
+		}
 		return
 	}
 
@@ -281,10 +1059,18 @@ func (b *Bridge) handleChannelJoin(msg *erssiproto.WebMessage) {
 	}
 
 	weechatMsg := b.translator.ErssiMessageToLine(joinMsg)
-	b.weechatServer.BroadcastMessage(weechatMsg)
+	b.broadcastToBuffer(msg.ServerTag, msg.Target, weechatMsg)
+
+	b.refreshNicklist(msg.ServerTag, msg.Target)
+}
+
+// refreshNicklist asks erssi for the current nicklist of a channel
+func (b *Bridge) refreshNicklist(serverTag, target string) {
+	if b.nicklistDisabled {
+		return
+	}
 
-	// Request updated nicklist for this channel
-	if err := b.erssiClient.RequestNicklist(msg.ServerTag, msg.Target); err != nil {
+	if err := b.requestNicklist(serverTag, target); err != nil {
 		b.log.Errorf("Failed to request nicklist: %v", err)
 	}
 }
@@ -308,12 +1094,9 @@ func (b *Bridge) handleChannelPart(msg *erssiproto.WebMessage) {
 	}
 
 	weechatMsg := b.translator.ErssiMessageToLine(partMsg)
-	b.weechatServer.BroadcastMessage(weechatMsg)
+	b.broadcastToBuffer(msg.ServerTag, msg.Target, weechatMsg)
 
-	// Request updated nicklist for this channel
-	if err := b.erssiClient.RequestNicklist(msg.ServerTag, msg.Target); err != nil {
-		b.log.Errorf("Failed to request nicklist: %v", err)
-	}
+	b.refreshNicklist(msg.ServerTag, msg.Target)
 }
 
 func (b *Bridge) handleUserQuit(msg *erssiproto.WebMessage) {
@@ -337,7 +1120,7 @@ func (b *Bridge) handleUserQuit(msg *erssiproto.WebMessage) {
 		}
 
 		weechatMsg := b.translator.ErssiMessageToLine(quitMsg)
-		b.weechatServer.BroadcastMessage(weechatMsg)
+		b.broadcastToBuffer(msg.ServerTag, msg.Target, weechatMsg)
 	}
 }
 
@@ -346,8 +1129,11 @@ func (b *Bridge) handleTopic(msg *erssiproto.WebMessage) {
 
 	// Create a system message line for the topic change
 	topicText := fmt.Sprintf("%s has changed topic to: %s", msg.Nick, msg.Text)
+	level := erssiproto.LevelTopics
 	if msg.Nick == "" {
+		// The current topic (state dump, join) is no activity
 		topicText = fmt.Sprintf("Topic: %s", msg.Text)
+		level |= erssiproto.LevelNoActivity
 	}
 
 	topicMsg := &erssiproto.WebMessage{
@@ -356,22 +1142,26 @@ func (b *Bridge) handleTopic(msg *erssiproto.WebMessage) {
 		Target:    msg.Target,
 		Nick:      "--",
 		Text:      topicText,
+		Level:     level,
 		Timestamp: msg.Timestamp,
 	}
 
 	weechatMsg := b.translator.ErssiMessageToLine(topicMsg)
-	b.weechatServer.BroadcastMessage(weechatMsg)
+	b.broadcastToBuffer(msg.ServerTag, msg.Target, weechatMsg)
 
 	// Also broadcast buffer update to refresh topic for this specific buffer
 	bufferUpdate := b.translator.GetBufferOpenedEvent(msg.ServerTag, msg.Target)
-	b.weechatServer.BroadcastMessage(bufferUpdate)
+	b.broadcastToBuffer(msg.ServerTag, msg.Target, bufferUpdate)
 }
 
+// handleActivityUpdate follows the read state of erssi: unread lines are
+// counted as they arrive, and a window erssi reports without activity
+// (level 0) was read, so its buffer leaves the hotlist
 func (b *Bridge) handleActivityUpdate(msg *erssiproto.WebMessage) {
-	b.log.Debugf("Activity update for %s.%s", msg.ServerTag, msg.Target)
-	// Activity updates are handled implicitly when messages arrive
-	// WeeChat clients will show unread indicators based on new lines
-	// Nothing specific to do here
+	b.log.Debugf("Activity update for %s.%s (level %d)", msg.ServerTag, msg.Target, msg.Level)
+	if msg.Level == 0 {
+		b.translator.ClearHotlist(msg.ServerTag, msg.Target)
+	}
 }
 
 // WeeChat event handlers
@@ -393,16 +1183,32 @@ func (b *Bridge) handleWeeChatCommand(client *weechat.Client, msgID string, cmd
 	case "sync":
 		b.handleWeeChatSync(client, msgID, args)
 
+	case "desync":
+		b.handleWeeChatDesync(client, msgID, args)
+
 	case "nicklist":
 		b.handleWeeChatNicklist(client, msgID, args)
 
+	case "info":
+		b.handleWeeChatInfo(client, msgID, args)
+
+	case "infolist":
+		b.handleWeeChatInfoList(client, msgID, args)
+
+	case "completion":
+		b.handleWeeChatCompletion(client, msgID, args)
+
 	default:
 		b.log.Warnf("Unhandled WeeChat command: %s", cmd)
 	}
 }
 
 func (b *Bridge) handleWeeChatInit(client *weechat.Client, msgID string, args []string) {
-	b.log.Info("WeeChat client initialized")
+	if options := client.InitOptions(); len(options) > 0 {
+		b.log.Infof("WeeChat client initialized (options: %v)", options)
+	} else {
+		b.log.Info("WeeChat client initialized")
+	}
 
 	b.mu.Lock()
 	needsStateDump := !b.stateDumpRequested
@@ -414,7 +1220,8 @@ func (b *Bridge) handleWeeChatInit(client *weechat.Client, msgID string, args []
 	// Request state dump from erssi ONLY on first Lith connection
 	if needsStateDump {
 		b.log.Info("First client connection - requesting state from erssi...")
-		if err := b.erssiClient.RequestStateDump(); err != nil {
+		b.postStatus("Loading state from erssi...")
+		if err := b.requestStateDumps(); err != nil {
 			b.log.Errorf("Failed to request state dump: %v", err)
 		}
 	} else {
@@ -436,8 +1243,12 @@ func (b *Bridge) handleWeeChatHData(client *weechat.Client, msgID string, args [
 
 	// Handle different hdata requests
 	if path == "buffer:gui_buffers(*)" || path == "buffer:gui_buffers" {
-		// Buffer list request
-		msg := b.translator.GetAllBuffers(msgID)
+		// Buffer list request - the first client usually asks right after
+		// init, before the dump it triggered has arrived; hold the answer
+		// until the dump settles so it doesn't get an empty list
+		b.waitForStateDump()
+
+		msg := b.translator.GetAllBuffers(msgID, b.clientView(client))
 		b.log.Debugf("Sending buffer list response with ID '%s' (count: %d buffers)", msgID, len(b.translator.GetBufferList()))
 		if err := client.SendMessage(msg); err != nil {
 			b.log.Errorf("Failed to send buffers: %v", err)
@@ -448,9 +1259,11 @@ func (b *Bridge) handleWeeChatHData(client *weechat.Client, msgID string, args [
 		// Line history request - format: buffer:0x123/lines/last_line(-50)
 		b.handleLineRequest(client, msgID, path, params)
 	} else if path == "hotlist:gui_hotlist(*)" {
-		// Hotlist request - send empty hotlist
-		msg := b.translator.GetEmptyHotlist(msgID)
-		b.log.Debugf("Sending empty hotlist response with ID '%s'", msgID)
+		// Hotlist request - the buffers with unread lines, once the state
+		// dump has settled
+		b.waitForStateDump()
+		msg := b.translator.GetHotlist(msgID, b.clientView(client))
+		b.log.Debugf("Sending hotlist response with ID '%s'", msgID)
 		if err := client.SendMessage(msg); err != nil {
 			b.log.Errorf("Failed to send hotlist: %v", err)
 		} else {
@@ -461,6 +1274,105 @@ func (b *Bridge) handleWeeChatHData(client *weechat.Client, msgID string, args [
 	}
 }
 
+// waitForStateDump blocks until the requested state dump is complete, or
+// stateDumpTimeout expires
+func (b *Bridge) waitForStateDump() {
+	b.mu.RLock()
+	requested := b.stateDumpRequested
+	b.mu.RUnlock()
+
+	if !requested {
+		return
+	}
+
+	started := time.Now()
+	if !b.dumps.WaitSettled(b.stateDumpTimeout) {
+		b.log.Warnf("State dump not complete after %s, sending partial buffer list", b.stateDumpTimeout)
+		return
+	}
+	if waited := time.Since(started); waited > time.Millisecond {
+		b.log.Debugf("Buffer list held %s for state dump", waited.Round(time.Millisecond))
+	}
+}
+
+// handleDumpComplete reports a finished server dump in the core buffer and
+// drops the buffers of channels it no longer lists (during /bridge diff,
+// the diff does)
+func (b *Bridge) handleDumpComplete(serverTag string, channels map[string]struct{}) {
+	if !b.completeDiff(serverTag, channels) {
+		b.reconcileBuffers(serverTag, channels)
+	}
+	b.postServerStatus(serverTag, "", fmt.Sprintf("%s loaded (%d channels)", serverTag, len(channels)))
+
+	if !b.dumps.Active() {
+		b.endRelayUpgrade()
+	}
+}
+
+// startDigest starts the digest notifier if configured
+func (b *Bridge) startDigest() {
+	if b.digest != nil {
+		b.digest.Start()
+	}
+}
+
+// collectForDigest queues highlights and private messages for the digest
+// while no client is around to see them
+func (b *Bridge) collectForDigest(msg *erssiproto.WebMessage) {
+	if b.digest == nil || msg.IsOwn {
+		return
+	}
+
+	private := isPrivateMessage(msg)
+	if !msg.IsHighlight && !private {
+		return
+	}
+	if b.weechatServer.AuthenticatedClients() > 0 {
+		return
+	}
+
+	date := time.Now()
+	if msg.Timestamp > 0 {
+		date = time.Unix(msg.Timestamp, 0)
+	}
+	b.digest.Add(digest.Entry{
+		Time:    date,
+		Buffer:  msg.ServerTag + "." + msg.Target,
+		Nick:    msg.Nick,
+		Text:    msg.Text,
+		Private: private,
+	})
+}
+
+// postStatus shows a bridge status line in the core buffer of every client
+func (b *Bridge) postStatus(text string) {
+	b.weechatServer.BroadcastMessage(b.translator.CoreLine(text))
+}
+
+// postServerStatus shows a status line about a server (empty target) or a
+// buffer in the core buffer of the clients allowed to see it
+func (b *Bridge) postServerStatus(serverTag, target, text string) {
+	b.broadcastToBuffer(serverTag, target, b.translator.ScopedCoreLine(serverTag, target, text))
+}
+
+// postUpstreamStatus shows a status line about an upstream (its address,
+// lag or errors) in the core buffer of the clients that see every buffer
+func (b *Bridge) postUpstreamStatus(text string) {
+	b.broadcastUnrestricted(b.translator.UnrestrictedCoreLine(text))
+}
+
+// handleErssiError shows an error reported by erssi in the core buffer
+func (b *Bridge) handleErssiError(msg *erssiproto.WebMessage) {
+	where := strings.TrimSpace(msg.ServerTag + " " + msg.Target)
+	b.log.Warnf("erssi error (%s): %s", where, msg.Text)
+
+	if msg.ServerTag != "" {
+		b.postServerStatus(msg.ServerTag, msg.Target, fmt.Sprintf("erssi error (%s): %s", where, msg.Text))
+		return
+	}
+	b.postUpstreamStatus("erssi error: " + msg.Text)
+}
+
 func (b *Bridge) handleWeeChatInput(client *weechat.Client, msgID string, args []string) {
 	bufferPtr, text, err := b.translator.ParseInputCommand(args)
 	if err != nil {
@@ -468,49 +1380,195 @@ func (b *Bridge) handleWeeChatInput(client *weechat.Client, msgID string, args [
 		return
 	}
 
+	// Clients with escape_commands may send several lines at once; each
+	// goes on its own, like a line typed alone, so none can smuggle a raw
+	// IRC command
+	if strings.Contains(text, "\n") {
+		for _, line := range strings.Split(text, "\n") {
+			if line = strings.TrimRight(line, "\r"); strings.TrimSpace(line) != "" {
+				b.handleInputText(client, bufferPtr, line)
+			}
+		}
+		return
+	}
+	b.handleInputText(client, bufferPtr, text)
+}
+
+// handleInputText handles one line of input
+func (b *Bridge) handleInputText(client *weechat.Client, bufferPtr, text string) {
 	b.log.Debugf("Input: buffer=%s text=%s", bufferPtr, text)
 
-	// Convert to erssi command
-	erssiMsg, err := b.translator.InputToErssiCommand(bufferPtr, text)
-	if err != nil {
-		b.log.Errorf("Failed to convert input: %v", err)
+	if !b.allowInput(client, bufferPtr, text) {
+		return
+	}
+
+	// Context buffers only exist on the client, nothing goes to erssi
+	if b.handleContextInput(client, bufferPtr, text) {
+		return
+	}
+
+	// Bridge-side commands (/edit, /delete) never reach erssi
+	if b.handleLocalCommand(client, bufferPtr, text) {
+		return
+	}
+
+	// User-defined aliases expand to one or more commands
+	if cmd, args := splitCommand(text); cmd != "" && b.expandAlias(client, bufferPtr, cmd, args) {
 		return
 	}
 
 	// Send to erssi
-	if err := b.erssiClient.SendMessage(erssiMsg); err != nil {
-		b.log.Errorf("Failed to send message to erssi: %v", err)
+	if err := b.sendToBuffer(bufferPtr, text); err != nil {
+		if errors.Is(err, translator.ErrUnknownBuffer) {
+			b.handleUnknownBufferInput(client, bufferPtr)
+			return
+		}
+		if errors.Is(err, translator.ErrNoInput) {
+			// Without the "failed to convert input" of sendToBuffer
+			b.sendLocalNotice(client, bufferPtr, "Message not sent, "+errors.Unwrap(err).Error())
+			return
+		}
+		b.log.Errorf("%v", err)
+		return
+	}
+
+	// Remember plain messages for /edit and /delete
+	if cmd, _ := splitCommand(text); cmd == "" {
+		b.sentHistory.record(bufferPtr, text)
 	}
 }
 
 func (b *Bridge) handleWeeChatSync(client *weechat.Client, msgID string, args []string) {
-	b.log.Debug("Sync request - client wants updates")
-	// Sync is automatic in our bridge - erssi pushes updates
-	// Nothing to do here
+	names, flags := weechat.ParseSyncArgs(args)
+	b.log.Debugf("Sync request for %v (flags %04b)", names, flags)
+
+	// erssi pushes updates anyway; the relay server only sends each client
+	// the buffers it synced
+	for _, buffer := range b.syncTargets(names) {
+		client.Sync(buffer, flags)
+	}
+
+	// Clients may get the backlog of the buffers they sync, see syncBacklogs
+	if b.backlogs != nil && flags&weechat.SyncBuffer != 0 {
+		b.pushSyncBacklog(client, names)
+	}
+}
+
+func (b *Bridge) handleWeeChatDesync(client *weechat.Client, msgID string, args []string) {
+	names, flags := weechat.ParseSyncArgs(args)
+	b.log.Debugf("Desync request for %v (flags %04b)", names, flags)
+
+	for _, buffer := range b.syncTargets(names) {
+		client.Desync(buffer, flags)
+	}
+}
+
+// syncTargets returns the pointers of the buffers named by sync or desync,
+// "*" staying as is. Unknown buffers are skipped.
+func (b *Bridge) syncTargets(names []string) []string {
+	var targets, buffers []string
+	for _, name := range names {
+		if name == "*" {
+			targets = append(targets, name)
+		} else if name != "" {
+			buffers = append(buffers, name)
+		}
+	}
+	if len(buffers) == 0 {
+		return targets
+	}
+
+	// Buffers are named after the state dump's channels
+	b.waitForStateDump()
+	return append(targets, b.translator.BufferPointers(buffers)...)
 }
 
 func (b *Bridge) handleWeeChatNicklist(client *weechat.Client, msgID string, args []string) {
 	b.log.Debugf("Nicklist request: args=%v", args)
 
-	// Parse nicklist request - format varies, but typically includes buffer pointer
+	// Without a buffer: every cached nicklist, once the state is loaded
 	if len(args) == 0 {
-		b.log.Warn("Nicklist request with no args")
+		b.waitForStateDump()
+		if err := client.SendMessage(b.translator.GetAllNicklists(msgID, b.clientView(client))); err != nil {
+			b.log.Errorf("Failed to send nicklists: %v", err)
+		}
 		return
 	}
 
 	// Extract buffer pointer and request nicklist from erssi
 	bufferPtr := args[0]
-	serverTag, target := b.translator.GetBufferInfo(bufferPtr)
 
-	if serverTag != "" && target != "" {
-		b.log.Debugf("Requesting nicklist for %s.%s", serverTag, target)
-		if err := b.erssiClient.RequestNicklist(serverTag, target); err != nil {
-			b.log.Errorf("Failed to request nicklist: %v", err)
+	// Buffers outside the client's ACL look like unknown ones
+	if !b.translator.BufferVisible(bufferPtr, b.clientView(client)) {
+		if err := client.SendMessage(weechatproto.CreateNicklistHDataWithID([]weechatproto.NickData{}, msgID)); err != nil {
+			b.log.Errorf("Failed to send nicklist: %v", err)
+		}
+		if !b.translator.BufferKnown(bufferPtr) {
+			b.handleUnknownBufferRequest(client, bufferPtr)
 		}
+		return
+	}
+	serverTag, target := b.translator.GetBufferInfo(bufferPtr)
+
+	// Nicklist support is off, or nothing to ask erssi about (server
+	// buffers, unknown pointers): answer from the cache right away
+	if b.nicklistDisabled || serverTag == "" || target == "" {
+		b.sendCachedNicklist(client, msgID, bufferPtr)
+		return
+	}
+
+	// Serve from the cache when the state dump already delivered the nicks
+	if b.translator.HasNicklist(bufferPtr) {
+		b.log.Debugf("Sending cached nicklist for %s.%s", serverTag, target)
+		b.sendCachedNicklist(client, msgID, bufferPtr)
+		return
+	}
+
+	b.log.Debugf("Requesting nicklist for %s.%s", serverTag, target)
+
+	// The response is delivered to this client by handleNicklist, also when
+	// an fe-web without response IDs sends it as a plain nicklist message
+	key := nicklistKey(serverTag, target)
+	waiter := b.nicklistReqs.add(key, client, msgID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), nicklistCallTimeout)
+	defer cancel()
+
+	resp, err := b.callErssi(ctx, &erssiproto.WebMessage{
+		Type:      erssiproto.Nicklist,
+		ServerTag: serverTag,
+		Target:    target,
+	})
+	if err != nil {
+		// A late response still updates the cache through the normal path
+		b.log.Errorf("Nicklist request for %s.%s failed: %v", serverTag, target, err)
+	} else {
+		b.handleSubscribedMessage(resp)
+	}
+
+	// Not answered by a response: reply with whatever is cached now
+	if b.nicklistReqs.remove(key, waiter) {
+		b.sendCachedNicklist(client, msgID, bufferPtr)
 	}
 }
 
 func (b *Bridge) handleLineRequest(client *weechat.Client, msgID string, path, params string) {
+	count := lineCount(path, params)
+
+	// Lines of every buffer, as Lith asks on startup:
+	// buffer:gui_buffers(*)/own_lines/last_line(-50)/data. Like the buffer
+	// list it waits for the state dump.
+	if strings.HasPrefix(path, "buffer:gui_buffers") {
+		b.waitForStateDump()
+
+		b.log.Debugf("Line request for all buffers, count=%d, msgID=%s", count, msgID)
+		b.backlogs.mark(client, "*")
+		if err := client.SendMessage(b.translator.GetAllBufferLines(count, msgID, b.clientView(client))); err != nil {
+			b.log.Errorf("Failed to send lines: %v", err)
+		}
+		return
+	}
+
 	// Parse buffer pointer from path
 	// Format: buffer:0x123/lines/last_line(-50)
 	re := regexp.MustCompile(`buffer:(0x[0-9a-f]+)`)
@@ -523,35 +1581,56 @@ func (b *Bridge) handleLineRequest(client *weechat.Client, msgID string, path, p
 
 	bufferPtr := matches[1]
 
-	// Parse line count from params (e.g., "(-50)")
-	count := 50 // default
-	if params != "" {
-		re2 := regexp.MustCompile(`\((-?\d+)\)`)
-		matches2 := re2.FindStringSubmatch(params)
-		if len(matches2) >= 2 {
-			if n, err := strconv.Atoi(matches2[1]); err == nil {
-				if n < 0 {
-					count = -n
-				} else {
-					count = n
-				}
-			}
+	b.log.Debugf("Line request for buffer %s, count=%d, msgID=%s", bufferPtr, count, msgID)
+
+	// Buffers outside the client's ACL look like unknown ones
+	if !b.translator.BufferVisible(bufferPtr, b.clientView(client)) {
+		if err := client.SendMessage(weechatproto.CreateLinesHDataWithID([]weechatproto.LineData{}, msgID)); err != nil {
+			b.log.Errorf("Failed to send lines: %v", err)
 		}
+		if !b.translator.BufferKnown(bufferPtr) {
+			b.handleUnknownBufferRequest(client, bufferPtr)
+		}
+		return
 	}
 
-	b.log.Debugf("Line request for buffer %s, count=%d, msgID=%s", bufferPtr, count, msgID)
-
 	// Get lines from translator
-	msg := b.translator.GetBufferLines(bufferPtr, count, msgID)
+	b.backlogs.mark(client, bufferPtr)
+	msg := b.translator.GetBufferLines(bufferPtr, count, msgID, b.clientView(client))
 	if err := client.SendMessage(msg); err != nil {
 		b.log.Errorf("Failed to send lines: %v", err)
 	}
 }
 
+// lineCount parses the number of lines requested by a line hdata: the
+// last_line(-N) count in the path, or a "(-N)" in the parameters of older
+// clients (default 50)
+func lineCount(path, params string) int {
+	re := regexp.MustCompile(`\((-?\d+)\)`)
+	matches := re.FindStringSubmatch(path)
+	if matches == nil {
+		matches = re.FindStringSubmatch(params)
+	}
+	if matches == nil {
+		return 50
+	}
+
+	n, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 50
+	}
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
 func (b *Bridge) handleWeeChatClientConnected(client *weechat.Client) {
 	b.log.Info("New WeeChat client connected")
 }
 
 func (b *Bridge) handleWeeChatClientDisconnected(client *weechat.Client) {
 	b.log.Info("WeeChat client disconnected")
+	b.backlogs.forget(client)
+	b.staleClients.forget(client)
 }
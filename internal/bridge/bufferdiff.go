@@ -0,0 +1,293 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/erssi"
+	"erssi-lith-bridge/internal/translator"
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// bufferDiff compares the buffers of the translator with a fresh state
+// dump, for /bridge diff
+type bufferDiff struct {
+	client    *weechat.Client
+	bufferPtr string
+	repair    bool
+
+	// Channel and query buffers when the dump was requested, by server tag
+	// and lowercase target
+	before map[string]map[string]string
+
+	mu       sync.Mutex
+	channels map[string]map[string]struct{} // Channels of each server's dump
+	queries  map[string]map[string]struct{} // Queries listed by each dump (none = not listed)
+	dumped   map[string]bool                // Servers whose dump completed
+	pending  map[string]bool                // Servers whose dump is awaited
+	done     chan struct{}                  // Closed once nothing is pending
+}
+
+// handleDiffCommand requests a fresh state dump and reports the channels
+// and queries it disagrees with the buffer list on. The dump restores
+// missing buffers; stale ones are only closed with -repair.
+//
+//	/bridge diff
+//	/bridge diff -repair
+func (b *Bridge) handleDiffCommand(client *weechat.Client, bufferPtr, args string) {
+	repair := false
+	switch args {
+	case "":
+	case "-repair":
+		repair = true
+	default:
+		b.sendLocalNotice(client, bufferPtr, "diff: usage: /bridge diff [-repair]")
+		return
+	}
+
+	b.mu.RLock()
+	loaded := b.stateDumpRequested
+	b.mu.RUnlock()
+	if !loaded {
+		b.sendLocalNotice(client, bufferPtr, "diff: no state loaded from erssi yet")
+		return
+	}
+	if b.dumps.Active() {
+		b.sendLocalNotice(client, bufferPtr, "diff: a state dump is running, try again when it's done")
+		return
+	}
+
+	d := &bufferDiff{
+		client:    client,
+		bufferPtr: bufferPtr,
+		repair:    repair,
+		before:    b.translator.BufferTargets(),
+		channels:  make(map[string]map[string]struct{}),
+		queries:   make(map[string]map[string]struct{}),
+		dumped:    make(map[string]bool),
+		pending:   make(map[string]bool),
+		done:      make(chan struct{}),
+	}
+	for serverTag := range d.before {
+		d.pending[serverTag] = true
+	}
+
+	b.diffMu.Lock()
+	if b.diff != nil {
+		b.diffMu.Unlock()
+		b.sendLocalNotice(client, bufferPtr, "diff: already running")
+		return
+	}
+	b.diff = d
+	b.diffMu.Unlock()
+
+	ctx, cancel := sendContext()
+	defer cancel()
+
+	requested := 0
+	for _, u := range b.upstreams {
+		if u.client.State() != erssi.StateAuthenticated {
+			continue
+		}
+		if err := u.client.RequestStateDump(ctx); err != nil {
+			u.log.Errorf("Failed to request state dump: %v", err)
+			continue
+		}
+		requested++
+	}
+	if requested == 0 {
+		b.endDiff()
+		b.sendLocalNotice(client, bufferPtr, "diff: no erssi instance is connected")
+		return
+	}
+
+	b.log.Infof("Comparing buffers with a fresh state dump (repair: %v)", repair)
+	b.sendLocalNotice(client, bufferPtr, "diff: requesting a fresh state dump...")
+	b.beginRelayUpgrade()
+	go b.finishDiff(d)
+}
+
+// activeDiff returns the running diff, nil if none
+func (b *Bridge) activeDiff() *bufferDiff {
+	b.diffMu.Lock()
+	defer b.diffMu.Unlock()
+
+	return b.diff
+}
+
+// endDiff forgets the running diff
+func (b *Bridge) endDiff() {
+	b.diffMu.Lock()
+	defer b.diffMu.Unlock()
+
+	b.diff = nil
+}
+
+// finishDiff waits for the dumps of a diff and reports it. The channels
+// of a dump may still trickle in after the next server's dump started
+// (buffers are handled concurrently), so servers are only compared once
+// every dump is in.
+func (b *Bridge) finishDiff(d *bufferDiff) {
+	select {
+	case <-d.done:
+	case <-time.After(b.stateDumpTimeout):
+	}
+	b.endDiff()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var tags []string
+	for serverTag := range d.dumped {
+		tags = append(tags, serverTag)
+	}
+	for serverTag := range d.pending {
+		tags = append(tags, serverTag)
+	}
+	sort.Strings(tags)
+
+	for _, serverTag := range tags {
+		report := "no state dump received (server disconnected?)"
+		if d.dumped[serverTag] {
+			report = b.compareDump(d, serverTag)
+		}
+		b.sendLocalNotice(d.client, d.bufferPtr, fmt.Sprintf("diff: %s: %s", serverTag, report))
+	}
+}
+
+// recordDiffChannel adds a channel of a dump burst to the running diff
+func (b *Bridge) recordDiffChannel(serverTag, channel string) {
+	d := b.activeDiff()
+	if d == nil || channel == "" {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.channels[serverTag] == nil {
+		d.channels[serverTag] = make(map[string]struct{})
+	}
+	d.channels[serverTag][channel] = struct{}{}
+}
+
+// recordDiffQueries remembers the queries a state dump lists, which the
+// dump tracker doesn't follow
+func (b *Bridge) recordDiffQueries(msg *erssiproto.WebMessage) {
+	d := b.activeDiff()
+	if d == nil {
+		return
+	}
+	state, err := erssiproto.ParseStateDump(msg)
+	if err != nil || state == nil {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, server := range state.Servers {
+		// Dumps without a queries list don't tell anything about them
+		if server.Tag == "" || server.Queries == nil {
+			continue
+		}
+		queries := make(map[string]struct{})
+		for _, query := range server.Queries {
+			if query.Nick != "" && b.subs.allows(server.Tag, query.Nick) {
+				queries[strings.ToLower(query.Nick)] = struct{}{}
+			}
+		}
+		d.queries[server.Tag] = queries
+	}
+}
+
+// completeDiff records a completed server dump in the running diff. It
+// reports whether a diff is running, which leaves stale buffers to it.
+func (b *Bridge) completeDiff(serverTag string, channels map[string]struct{}) bool {
+	d := b.activeDiff()
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.channels[serverTag] == nil {
+		d.channels[serverTag] = make(map[string]struct{})
+	}
+	for channel := range channels {
+		d.channels[serverTag][channel] = struct{}{}
+	}
+	d.dumped[serverTag] = true
+
+	delete(d.pending, serverTag)
+	if len(d.pending) == 0 {
+		select {
+		case <-d.done:
+		default:
+			close(d.done)
+		}
+	}
+	return true
+}
+
+// compareDump describes how the dump of a server differs from the buffers
+// it had, closing stale buffers with -repair (d.mu held)
+func (b *Bridge) compareDump(d *bufferDiff, serverTag string) string {
+	before := d.before[serverTag]
+	channels := d.channels[serverTag]
+	queries, listsQueries := d.queries[serverTag]
+
+	var missing, stale []string
+	for channel := range channels {
+		if _, ok := before[channel]; !ok {
+			missing = append(missing, channel)
+		}
+	}
+	for query := range queries {
+		if _, ok := before[query]; !ok {
+			missing = append(missing, query)
+		}
+	}
+	for key, target := range before {
+		if translator.IsChannel(target) {
+			// Like reconcileBuffers, a dump without any channel is not trusted
+			if _, ok := channels[key]; !ok && len(channels) > 0 {
+				stale = append(stale, target)
+			}
+		} else if _, ok := queries[key]; !ok && listsQueries {
+			stale = append(stale, target)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+
+	if d.repair {
+		b.reconcileBuffers(serverTag, channels)
+		if listsQueries {
+			for _, event := range b.translator.CloseStaleQueries(serverTag, queries) {
+				b.broadcastUnrestricted(event)
+			}
+		}
+	}
+
+	var report []string
+	if len(missing) > 0 {
+		report = append(report, fmt.Sprintf("%d missing (%s), restored", len(missing), strings.Join(missing, ", ")))
+	}
+	if len(stale) > 0 {
+		action := "kept, /bridge diff -repair closes them"
+		if d.repair {
+			action = "closed"
+		}
+		report = append(report, fmt.Sprintf("%d stale (%s), %s", len(stale), strings.Join(stale, ", "), action))
+	}
+	if len(report) == 0 {
+		return fmt.Sprintf("in sync (%d channels and queries)", len(before))
+	}
+	return strings.Join(report, "; ")
+}
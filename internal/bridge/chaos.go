@@ -0,0 +1,17 @@
+package bridge
+
+import (
+	"erssi-lith-bridge/internal/chaos"
+
+	"github.com/sirupsen/logrus"
+)
+
+// warnChaos logs loudly when chaos mode is on for a stream, so it's not
+// left enabled in production by accident
+func warnChaos(logger *logrus.Logger, stream string, cfg chaos.Config) {
+	if !cfg.Enabled() {
+		return
+	}
+	logger.WithField("component", "bridge").
+		Warnf("CHAOS MODE: injecting faults into %s (%s)", stream, cfg)
+}
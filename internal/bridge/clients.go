@@ -0,0 +1,72 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// handleClientsCommand lists the connected relay clients with their traffic
+func (b *Bridge) handleClientsCommand(client *weechat.Client, bufferPtr string) {
+	clients := b.weechatServer.ClientStats()
+	sort.Slice(clients, func(i, j int) bool { return clients[i].Connected.Before(clients[j].Connected) })
+
+	total := b.weechatServer.Stats()
+	b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("clients: %d connected, %s sent, %s received in total",
+		len(clients), formatBytes(total.BytesSent), formatBytes(total.BytesReceived)))
+
+	for _, c := range clients {
+		transport := "tcp"
+		if c.WebSocket {
+			transport = "websocket"
+		}
+		line := fmt.Sprintf("clients: %s (%s) for %s, sent %s in %d messages, received %s",
+			c.Remote, transport, time.Since(c.Connected).Round(time.Second),
+			formatBytes(c.BytesSent), c.MessagesSent, formatBytes(c.BytesReceived))
+		if !c.Authenticated {
+			line += ", not authenticated"
+		}
+		if c.Compression != weechatproto.CompressionOff {
+			line += fmt.Sprintf(", %s: %s", c.Compression,
+				compressionSummary(c.MessagesCompressed, c.MessagesSent, c.MessageBytes, c.MessageBytesSent))
+		}
+		if c.Throttled > 0 {
+			line += fmt.Sprintf(", throttled %s", c.Throttled.Round(time.Millisecond))
+		}
+		if c.Queued > 0 {
+			line += fmt.Sprintf(", %d queued", c.Queued)
+		}
+		if c.Dropped > 0 {
+			line += fmt.Sprintf(", %d dropped", c.Dropped)
+		}
+		b.sendLocalNotice(client, bufferPtr, line)
+	}
+}
+
+// compressionSummary compares the size of messages before and after
+// compression
+func compressionSummary(compressed, messages, size, sent int64) string {
+	saved := 0.0
+	if size > 0 {
+		saved = 100 * float64(size-sent) / float64(size)
+	}
+	return fmt.Sprintf("%s of messages sent as %s (%.0f%% saved, %d of %d compressed)",
+		formatBytes(size), formatBytes(sent), saved, compressed, messages)
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
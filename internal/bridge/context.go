@@ -0,0 +1,201 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+const (
+	defaultContextLines = 5  // Lines before and after the anchor
+	maxContextLines     = 50 // Upper bound for -n
+)
+
+// contextRequest is a parsed /context command
+type contextRequest struct {
+	lines  int
+	buffer string // "server.target", "" = the current buffer
+	anchor contextAnchor
+}
+
+// contextAnchor selects the line to show the context of
+type contextAnchor struct {
+	desc  string                // For the buffer title
+	match func(date int64) bool // nil = any line, highlights only
+}
+
+// handleContextCommand opens a temporary buffer with the lines around a
+// highlight, taken from the history store (or the in-memory lines when
+// history is disabled). Among the lines matching the time, the newest
+// highlight wins, otherwise the newest line.
+//
+//	/context                         around the last highlight here
+//	/context 14:05                   around 14:05 today or earlier
+//	/context -n 10 libera.#go 1718000000
+func (b *Bridge) handleContextCommand(client *weechat.Client, bufferPtr, args string) {
+	req, err := parseContextArgs(args)
+	if err != nil {
+		b.sendLocalNotice(client, bufferPtr, "context: "+err.Error())
+		b.sendLocalNotice(client, bufferPtr,
+			"context: usage: /context [-n <lines>] [<server.target>] [<HH:MM[:SS]>|<unix time>]")
+		return
+	}
+
+	serverTag, target := b.translator.GetBufferInfo(bufferPtr)
+	if req.buffer != "" {
+		serverTag, target, _ = strings.Cut(req.buffer, ".")
+		if !b.translator.HasBuffer(serverTag, target) {
+			b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("context: no buffer %s", req.buffer))
+			return
+		}
+	}
+	if serverTag == "" || target == "" {
+		b.sendLocalNotice(client, bufferPtr, "context: not a channel or query, name one: /context <server.target> ...")
+		return
+	}
+	name := serverTag + "." + target
+
+	var lines []weechatproto.LineData
+	if b.history != nil {
+		lines = b.history.Load(serverTag, target)
+	}
+	if len(lines) == 0 {
+		lines = b.translator.BufferLines(serverTag, target)
+	}
+
+	anchor := findAnchor(lines, req.anchor.match)
+	if anchor < 0 {
+		if req.anchor.match == nil {
+			b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("context: no highlight in %s", name))
+		} else {
+			b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("context: no line at %s in %s", req.anchor.desc, name))
+		}
+		return
+	}
+
+	window := lines[max(0, anchor-req.lines):min(len(lines), anchor+req.lines+1)]
+	at := time.Unix(lines[anchor].Date, 0).Format("2006-01-02 15:04:05")
+	title := fmt.Sprintf("Context of %s around %s (/close to close)", name, at)
+
+	contextPtr, events := b.translator.OpenTempBuffer("context."+name, "ctx:"+target, title, window)
+	for _, event := range events {
+		if err := client.SendMessage(event); err != nil {
+			b.log.Errorf("Failed to send context buffer: %v", err)
+			b.translator.CloseTempBuffer(contextPtr)
+			return
+		}
+	}
+	b.log.Debugf("Opened context of %s around %s (%d lines)", name, at, len(window))
+}
+
+// handleContextInput handles input typed in a context buffer: /close (or
+// /buffer close) closes it, anything else is refused. Returns false for
+// other buffers.
+func (b *Bridge) handleContextInput(client *weechat.Client, bufferPtr, text string) bool {
+	if !b.translator.IsTempBuffer(bufferPtr) {
+		return false
+	}
+
+	cmd, args := splitCommand(text)
+	if cmd == "close" || (cmd == "buffer" && args == "close") {
+		if event := b.translator.CloseTempBuffer(bufferPtr); event != nil {
+			if err := client.SendMessage(event); err != nil {
+				b.log.Errorf("Failed to close context buffer: %v", err)
+			}
+		}
+		return true
+	}
+
+	b.sendLocalNotice(client, bufferPtr, "This context buffer is read-only, /close closes it")
+	return true
+}
+
+// parseContextArgs parses the arguments of /context
+func parseContextArgs(args string) (contextRequest, error) {
+	req := contextRequest{lines: defaultContextLines}
+
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+
+		if field == "-n" {
+			if i+1 == len(fields) {
+				return req, fmt.Errorf("-n needs a number of lines")
+			}
+			i++
+			n, err := strconv.Atoi(fields[i])
+			if err != nil || n < 0 || n > maxContextLines {
+				return req, fmt.Errorf("-n wants 0 to %d lines", maxContextLines)
+			}
+			req.lines = n
+			continue
+		}
+
+		if anchor, ok := parseContextAnchor(field); ok {
+			if req.anchor.match != nil {
+				return req, fmt.Errorf("more than one time given")
+			}
+			req.anchor = anchor
+			continue
+		}
+
+		if req.buffer != "" || !strings.Contains(field, ".") {
+			return req, fmt.Errorf("unexpected %q", field)
+		}
+		req.buffer = field
+	}
+
+	return req, nil
+}
+
+// parseContextAnchor parses a time of day (HH:MM or HH:MM:SS, local time)
+// or a unix timestamp
+func parseContextAnchor(s string) (contextAnchor, bool) {
+	if ts, err := strconv.ParseInt(s, 10, 64); err == nil && ts > 0 {
+		return contextAnchor{
+			desc:  time.Unix(ts, 0).Format("2006-01-02 15:04:05"),
+			match: func(date int64) bool { return date == ts },
+		}, true
+	}
+
+	for _, layout := range []string{"15:04:05", "15:04"} {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		withSeconds := layout == "15:04:05"
+		return contextAnchor{
+			desc: s,
+			match: func(date int64) bool {
+				lt := time.Unix(date, 0)
+				return lt.Hour() == t.Hour() && lt.Minute() == t.Minute() &&
+					(!withSeconds || lt.Second() == t.Second())
+			},
+		}, true
+	}
+
+	return contextAnchor{}, false
+}
+
+// findAnchor returns the index of the newest highlight among the lines
+// matching match, or the newest matching line if none is a highlight.
+// Without match only highlights count. Returns -1 if nothing matches.
+func findAnchor(lines []weechatproto.LineData, match func(date int64) bool) int {
+	newest := -1
+	for i := len(lines) - 1; i >= 0; i-- {
+		if match != nil && !match(lines[i].Date) {
+			continue
+		}
+		if lines[i].Highlight {
+			return i
+		}
+		if newest < 0 && match != nil {
+			newest = i
+		}
+	}
+	return newest
+}
@@ -0,0 +1,176 @@
+package bridge
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"erssi-lith-bridge/internal/weechat"
+)
+
+const (
+	// execTimeout bounds how long a /bridge exec command may run
+	execTimeout = 10 * time.Second
+	// execMaxLines bounds the output lines posted into the buffer
+	execMaxLines = 30
+	// execMaxOutput bounds the captured output in bytes
+	execMaxOutput = 16 << 10
+)
+
+// execRunner runs allowlisted host commands for /bridge exec. Commands are
+// started directly (no shell), with a minimal environment, a timeout and
+// truncated output.
+type execRunner struct {
+	allowed map[string]string     // command name -> resolved path
+	args    map[string][][]string // command name -> permitted argument patterns
+}
+
+// newExecRunner resolves the allowlisted commands. An entry is a command
+// name followed by the arguments it may be run with, as glob patterns
+// matched word by word ("*" doesn't match "/"); a final "**" matches any
+// remaining arguments. A bare name runs without arguments. Returns nil when
+// exec is not permitted or nothing is allowlisted.
+func newExecRunner(permitted bool, allowlist []string) (*execRunner, error) {
+	if !permitted || len(allowlist) == 0 {
+		return nil, nil
+	}
+
+	r := &execRunner{allowed: make(map[string]string), args: make(map[string][][]string)}
+	for _, entry := range allowlist {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		name, patterns := fields[0], fields[1:]
+		if strings.Contains(name, "/") {
+			return nil, fmt.Errorf("exec allowlist entry %q must start with a bare command name", entry)
+		}
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("exec allowlist entry %q: invalid pattern %q", entry, pattern)
+			}
+		}
+
+		if _, ok := r.allowed[name]; !ok {
+			resolved, err := exec.LookPath(name)
+			if err != nil {
+				return nil, fmt.Errorf("exec allowlist entry %q: %w", entry, err)
+			}
+			r.allowed[name] = resolved
+		}
+		r.args[name] = append(r.args[name], patterns)
+	}
+	return r, nil
+}
+
+// permits reports whether an allowlist entry of the command matches args
+func (r *execRunner) permits(name string, args []string) bool {
+	for _, patterns := range r.args[name] {
+		if matchArgs(patterns, args) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchArgs matches arguments word by word against glob patterns, a final
+// "**" matching any remaining arguments
+func matchArgs(patterns, args []string) bool {
+	for i, pattern := range patterns {
+		if pattern == "**" && i == len(patterns)-1 {
+			return true
+		}
+		if i >= len(args) {
+			return false
+		}
+		if ok, _ := path.Match(pattern, args[i]); !ok {
+			return false
+		}
+	}
+	return len(args) == len(patterns)
+}
+
+// run executes an allowlisted command and returns its combined output lines
+func (r *execRunner) run(name string, args []string) ([]string, error) {
+	cmdPath, ok := r.allowed[name]
+	if !ok {
+		return nil, fmt.Errorf("%q is not in the exec allowlist", name)
+	}
+	if !r.permits(name, args) {
+		return nil, fmt.Errorf("the exec allowlist doesn't permit these arguments for %q", name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), execTimeout)
+	defer cancel()
+
+	var out limitedBuffer
+	cmd := exec.CommandContext(ctx, cmdPath, args...)
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "LANG=C.UTF-8"}
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	err := cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", execTimeout)
+	}
+
+	lines := strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+	if len(lines) == 1 && lines[0] == "" {
+		lines = nil
+	}
+	if len(lines) > execMaxLines {
+		lines = append(lines[:execMaxLines], fmt.Sprintf("... (%d more lines)", len(lines)-execMaxLines))
+	}
+	if out.truncated {
+		lines = append(lines, "... (output truncated)")
+	}
+
+	return lines, err
+}
+
+// limitedBuffer keeps the first execMaxOutput bytes written to it
+type limitedBuffer struct {
+	bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := execMaxOutput - b.Len(); len(p) > room {
+		b.Buffer.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// handleExecCommand runs an allowlisted host command and posts its output
+// into the buffer (visible to this client only)
+func (b *Bridge) handleExecCommand(client *weechat.Client, bufferPtr, args string) {
+	if b.exec == nil {
+		b.sendLocalNotice(client, bufferPtr, "exec: disabled (start the bridge with -allow-exec and -exec-allowlist)")
+		return
+	}
+
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		b.sendLocalNotice(client, bufferPtr, "exec: usage: /bridge exec <command> [args]")
+		return
+	}
+
+	b.log.Infof("Running /bridge exec %s", strings.Join(fields, " "))
+
+	go func() {
+		lines, err := b.exec.run(fields[0], fields[1:])
+		for _, line := range lines {
+			b.sendLocalNotice(client, bufferPtr, line)
+		}
+		if err != nil {
+			b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("exec: %s: %v", fields[0], err))
+		}
+	}()
+}
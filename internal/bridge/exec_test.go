@@ -0,0 +1,57 @@
+package bridge
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExecAllowlistArguments(t *testing.T) {
+	r, err := newExecRunner(true, []string{"true", "echo -n *", "echo hello", "ls -l /tmp/*", "printf **"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		cmd  string
+		want bool
+	}{
+		{"true", true},
+		{"true --version", false},
+		{"echo hello", true},
+		{"echo -n world", true},
+		{"echo -n", false},
+		{"echo -n a b", false},
+		{"echo world", false},
+		{"echo", false},
+		{"ls -l /tmp/x", true},
+		{"ls -l /tmp/x/y", false},
+		{"ls -l /etc", false},
+		{"printf", true},
+		{"printf %s a b", true},
+		{"cat /etc/passwd", false},
+	}
+	for _, tt := range tests {
+		fields := strings.Fields(tt.cmd)
+		if got := r.permits(fields[0], fields[1:]); got != tt.want {
+			t.Errorf("%q: got %v, want %v", tt.cmd, got, tt.want)
+		}
+	}
+
+	if lines, err := r.run("echo", []string{"-n", "hi"}); err != nil || len(lines) != 1 || lines[0] != "hi" {
+		t.Errorf("permitted run: got %q, %v", lines, err)
+	}
+	if _, err := r.run("echo", []string{"-e", "hi"}); err == nil {
+		t.Errorf("run with arguments outside the allowlist succeeded")
+	}
+}
+
+func TestExecAllowlistInvalid(t *testing.T) {
+	for _, entry := range []string{"/bin/echo", "echo [", "no-such-command-here"} {
+		if _, err := newExecRunner(true, []string{entry}); err == nil {
+			t.Errorf("%q accepted", entry)
+		}
+	}
+	if r, err := newExecRunner(false, []string{"echo **"}); r != nil || err != nil {
+		t.Errorf("exec not permitted: got %v, %v", r, err)
+	}
+}
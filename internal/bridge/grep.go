@@ -0,0 +1,88 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+const (
+	maxGrepResults = 500              // Newest matches shown
+	grepAutoClose  = 10 * time.Minute // Results buffers close by themselves after this
+)
+
+// handleGrepCommand searches the lines of the current buffer, from the
+// history store (or memory when history is disabled), and opens a
+// temporary buffer with the matches, each prefixed with its date. The
+// pattern is a case-insensitive regular expression matched against the
+// message text. The results buffer closes by itself after grepAutoClose.
+//
+//	/bridge grep deploy
+//	/bridge grep ^bob.*(ci|build) failed
+func (b *Bridge) handleGrepCommand(client *weechat.Client, bufferPtr, pattern string) {
+	if pattern == "" {
+		b.sendLocalNotice(client, bufferPtr, "grep: usage: /bridge grep <pattern>")
+		return
+	}
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("grep: invalid pattern: %v", err))
+		return
+	}
+
+	serverTag, target := b.translator.GetBufferInfo(bufferPtr)
+	if serverTag == "" || target == "" {
+		b.sendLocalNotice(client, bufferPtr, "grep: not a channel or query")
+		return
+	}
+	name := serverTag + "." + target
+
+	var lines []weechatproto.LineData
+	if b.history != nil {
+		lines = b.history.Load(serverTag, target)
+	}
+	if len(lines) == 0 {
+		lines = b.translator.BufferLines(serverTag, target)
+	}
+
+	var matches []weechatproto.LineData
+	for _, line := range lines {
+		if re.MatchString(line.Message) {
+			line.Message = time.Unix(line.Date, 0).Format("2006-01-02 15:04:05") + " " + line.Message
+			matches = append(matches, line)
+		}
+	}
+	if len(matches) == 0 {
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("grep: no match for %q in %s", pattern, name))
+		return
+	}
+
+	title := fmt.Sprintf("%d lines of %s matching %q (/close to close)", len(matches), name, pattern)
+	if len(matches) > maxGrepResults {
+		title = fmt.Sprintf("Last %d of %d lines of %s matching %q (/close to close)",
+			maxGrepResults, len(matches), name, pattern)
+		matches = matches[len(matches)-maxGrepResults:]
+	}
+
+	grepPtr, events := b.translator.OpenTempBuffer("grep."+name, "grep:"+target, title, matches)
+	for _, event := range events {
+		if err := client.SendMessage(event); err != nil {
+			b.log.Errorf("Failed to send grep results: %v", err)
+			b.translator.CloseTempBuffer(grepPtr)
+			return
+		}
+	}
+	b.log.Debugf("Opened grep results of %s for %q (%d lines)", name, pattern, len(matches))
+
+	time.AfterFunc(grepAutoClose, func() {
+		if event := b.translator.CloseTempBuffer(grepPtr); event != nil {
+			if err := client.SendMessage(event); err != nil && !errors.Is(err, weechat.ErrClientClosed) {
+				b.log.Errorf("Failed to close grep results: %v", err)
+			}
+		}
+	})
+}
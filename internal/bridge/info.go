@@ -0,0 +1,147 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"erssi-lith-bridge/internal/erssi"
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// handleWeeChatInfo answers info requests about the bridge's health:
+//
+//	info relay_client_count    relay clients past init
+//	info uptime [days|seconds] bridge uptime, "days:hh:mm:ss" like WeeChat
+//	info erssi_connected [name] "1" if erssi (every upstream, or the named
+//	                           one) is connected and authenticated, else "0"
+//	info bridge_nicks <buffer> nicks of a buffer (pointer or name), comma
+//	                           separated, recent speakers first (with
+//	                           Config.NicksInfo, empty otherwise)
+//
+// Other names get an empty value, like unknown infos in WeeChat.
+func (b *Bridge) handleWeeChatInfo(client *weechat.Client, msgID string, args []string) {
+	name := args[0]
+	arguments := strings.Join(args[1:], " ")
+
+	var value string
+	switch name {
+	case "relay_client_count":
+		value = strconv.Itoa(b.weechatServer.AuthenticatedClients())
+	case "uptime":
+		value = b.uptimeInfo(arguments)
+	case "erssi_connected":
+		value = b.erssiConnectedInfo(arguments)
+	case "bridge_nicks":
+		value = b.nicksInfo(client, arguments)
+	default:
+		b.log.Debugf("Unknown info %q requested", name)
+	}
+
+	if err := client.SendMessage(weechatproto.CreateInfoWithID(name, value, msgID)); err != nil {
+		b.log.Errorf("Failed to send info %s: %v", name, err)
+	}
+}
+
+// handleWeeChatInfoList answers infolist requests from the buffer state,
+// for clients that fall back to infolist buffer and friends
+func (b *Bridge) handleWeeChatInfoList(client *weechat.Client, msgID string, args []string) {
+	name, pointer := args[0], ""
+	if len(args) > 1 {
+		pointer = args[1]
+	}
+
+	// Like the buffer list hdata, wait for the state dump
+	b.waitForStateDump()
+	if name == "buffer_lines" {
+		b.backlogs.mark(client, pointer)
+	}
+
+	msg := b.translator.InfoList(name, pointer, msgID, b.clientView(client))
+	if err := client.SendMessage(msg); err != nil {
+		b.log.Errorf("Failed to send infolist %s: %v", name, err)
+	}
+}
+
+// handleWeeChatCompletion completes a word of input from the nicklist and
+// buffer state
+//
+//	completion 0x123 -1 hello al
+//	completion irc.libera.#go 3 /jo
+func (b *Bridge) handleWeeChatCompletion(client *weechat.Client, msgID string, args []string) {
+	bufferPtr := args[0]
+	if !strings.HasPrefix(bufferPtr, "0x") {
+		bufferPtr = ""
+		if pointers := b.translator.BufferPointers(args[:1]); len(pointers) > 0 {
+			bufferPtr = pointers[0]
+		}
+	}
+
+	position, data := -1, ""
+	if len(args) > 1 {
+		if n, err := strconv.Atoi(args[1]); err == nil {
+			position = n
+		}
+	}
+	if len(args) > 2 {
+		data = args[2]
+	}
+
+	msg := b.translator.Complete(bufferPtr, data, position, msgID, b.clientView(client))
+	if err := client.SendMessage(msg); err != nil {
+		b.log.Errorf("Failed to send completion: %v", err)
+	}
+}
+
+// nicksInfo lists the nicks of one buffer for clients without the
+// completion command, so their scripts can tab-complete against the bridge.
+// IRC nicks can't contain commas.
+func (b *Bridge) nicksInfo(client *weechat.Client, buffer string) string {
+	if !b.nicksInfoEnabled || buffer == "" || buffer == "*" {
+		return ""
+	}
+	pointers := b.translator.BufferPointers([]string{buffer})
+	if len(pointers) != 1 {
+		return ""
+	}
+	return strings.Join(b.translator.CompletionNicks(pointers[0], b.clientView(client)), ",")
+}
+
+// uptimeInfo formats the bridge uptime like WeeChat's info uptime
+func (b *Bridge) uptimeInfo(format string) string {
+	b.mu.RLock()
+	started := b.started
+	b.mu.RUnlock()
+
+	uptime := time.Since(started)
+	seconds := int64(uptime / time.Second)
+
+	switch format {
+	case "days":
+		return strconv.FormatInt(seconds/86400, 10)
+	case "seconds":
+		return strconv.FormatInt(seconds, 10)
+	}
+	return fmt.Sprintf("%d:%02d:%02d:%02d", seconds/86400, seconds/3600%24, seconds/60%60, seconds%60)
+}
+
+// erssiConnectedInfo reports whether every upstream, or the named one, is
+// connected
+func (b *Bridge) erssiConnectedInfo(name string) string {
+	found := false
+	for _, u := range b.upstreams {
+		if name != "" && u.name != name {
+			continue
+		}
+		found = true
+		if u.client.State() != erssi.StateAuthenticated {
+			return "0"
+		}
+	}
+	if !found {
+		return "0"
+	}
+	return "1"
+}
@@ -0,0 +1,40 @@
+package bridge
+
+import (
+	"testing"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// infoValue returns the value of the info in a message
+func infoValue(t *testing.T, msg *weechatproto.Message) string {
+	t.Helper()
+	for _, obj := range msg.Data {
+		if info, ok := obj.(weechatproto.Info); ok {
+			return info.Value
+		}
+	}
+	t.Fatalf("no info in %+v", msg)
+	return ""
+}
+
+func TestNicksInfo(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		tb := startTestBridge(t, Config{NicksInfo: enabled})
+		c := tb.dialRelay(t)
+		pointers := c.init("libera.#go")
+
+		for _, buffer := range []string{"libera.#go", pointers["libera.#go"]} {
+			c.send("(nicks) info bridge_nicks %s", buffer)
+			got := infoValue(t, c.next(withID("nicks")))
+
+			want := ""
+			if enabled {
+				want = "alice,tester"
+			}
+			if got != want {
+				t.Errorf("enabled %v, %s: got %q, want %q", enabled, buffer, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package bridge
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// openInjectPipe opens the inject pipe, if configured: the named pipe at
+// injectPath, created if missing, or stdin for "-". Caller must hold b.mu.
+func (b *Bridge) openInjectPipe() error {
+	switch b.injectPath {
+	case "":
+		return nil
+	case "-":
+		b.injectFile = os.Stdin
+		return nil
+	}
+
+	info, err := os.Stat(b.injectPath)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := mkfifo(b.injectPath); err != nil {
+			return fmt.Errorf("failed to create inject pipe: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to open inject pipe: %w", err)
+	} else if info.Mode()&os.ModeNamedPipe == 0 {
+		return fmt.Errorf("inject pipe %s exists and is not a named pipe", b.injectPath)
+	}
+
+	// Opened for writing too, so the pipe doesn't hit EOF whenever a
+	// writer goes away and opening doesn't wait for the first one
+	file, err := os.OpenFile(b.injectPath, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open inject pipe: %w", err)
+	}
+	b.injectFile = file
+	return nil
+}
+
+// startInject starts sending the lines written to the inject pipe
+func (b *Bridge) startInject() {
+	if b.injectFile == nil {
+		return
+	}
+	b.log.Infof("Reading messages to send from %s", b.injectFile.Name())
+	go b.readInjected(b.injectFile)
+}
+
+// stopInject closes the inject pipe. Stdin is left open; its reader ends
+// at EOF.
+func (b *Bridge) stopInject() {
+	if b.injectFile == nil {
+		return
+	}
+	if b.injectFile != os.Stdin {
+		b.injectFile.Close()
+	}
+	b.injectFile = nil
+}
+
+// readInjected sends every "<server> <target> <text>" line of r as a
+// message, for cron jobs and scripts. Empty lines and "#" comments (server
+// tags never start with "#") are skipped.
+func (b *Bridge) readInjected(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if trimmed := strings.TrimSpace(line); trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		serverTag, target, text, ok := parseInjectLine(line)
+		if !ok {
+			b.log.Warnf("Ignoring injected line %q (want: <server> <target> <text>)", line)
+			continue
+		}
+		if err := b.sendToErssi(b.translator.MessageToErssi(serverTag, target, text)); err != nil {
+			b.log.Errorf("Failed to send injected message to %s/%s: %v", serverTag, target, err)
+			continue
+		}
+		b.log.Debugf("Sent injected message to %s/%s", serverTag, target)
+	}
+
+	if err := scanner.Err(); err != nil && !errors.Is(err, os.ErrClosed) {
+		b.log.Errorf("Inject pipe failed: %v", err)
+	}
+}
+
+// parseInjectLine splits an injected line into server tag, target and text.
+// The text keeps its spacing.
+func parseInjectLine(line string) (serverTag, target, text string, ok bool) {
+	serverTag, rest, _ := strings.Cut(strings.TrimLeft(line, " \t"), " ")
+	target, text, _ = strings.Cut(strings.TrimLeft(rest, " \t"), " ")
+	if serverTag == "" || target == "" || text == "" {
+		return "", "", "", false
+	}
+	return serverTag, target, text, true
+}
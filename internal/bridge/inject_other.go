@@ -0,0 +1,10 @@
+//go:build !unix
+
+package bridge
+
+import "errors"
+
+// mkfifo is unavailable without named pipes; stdin still works
+func mkfifo(path string) error {
+	return errors.New("named pipes are not supported on this platform")
+}
@@ -0,0 +1,11 @@
+//go:build unix
+
+package bridge
+
+import "syscall"
+
+// mkfifo creates the inject pipe, readable and writable by the bridge user
+// only
+func mkfifo(path string) error {
+	return syscall.Mkfifo(path, 0o600)
+}
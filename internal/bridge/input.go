@@ -0,0 +1,353 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"erssi-lith-bridge/internal/history"
+	"erssi-lith-bridge/internal/weechat"
+)
+
+// maxSentHistory is the number of outgoing messages remembered per buffer
+const maxSentHistory = 20
+
+// sentHistory remembers the user's recent outgoing messages per buffer so
+// /edit and /delete know what they refer to
+type sentHistory struct {
+	mu       sync.Mutex
+	byBuffer map[string][]string // buffer pointer -> oldest..newest
+}
+
+func newSentHistory() *sentHistory {
+	return &sentHistory{byBuffer: make(map[string][]string)}
+}
+
+// record appends an outgoing message
+func (h *sentHistory) record(bufferPtr, text string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msgs := append(h.byBuffer[bufferPtr], text)
+	if len(msgs) > maxSentHistory {
+		msgs = msgs[len(msgs)-maxSentHistory:]
+	}
+	h.byBuffer[bufferPtr] = msgs
+}
+
+// last returns the most recent outgoing message
+func (h *sentHistory) last(bufferPtr string) (string, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msgs := h.byBuffer[bufferPtr]
+	if len(msgs) == 0 {
+		return "", false
+	}
+	return msgs[len(msgs)-1], true
+}
+
+// replaceLast replaces the most recent outgoing message (after /edit)
+func (h *sentHistory) replaceLast(bufferPtr, text string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if msgs := h.byBuffer[bufferPtr]; len(msgs) > 0 {
+		msgs[len(msgs)-1] = text
+	}
+}
+
+// dropLast forgets the most recent outgoing message (after /delete)
+func (h *sentHistory) dropLast(bufferPtr string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if msgs := h.byBuffer[bufferPtr]; len(msgs) > 0 {
+		h.byBuffer[bufferPtr] = msgs[:len(msgs)-1]
+	}
+}
+
+// splitCommand splits "/cmd args" into lowercase command and raw args
+func splitCommand(text string) (cmd, args string) {
+	if !strings.HasPrefix(text, "/") || strings.HasPrefix(text, "//") {
+		return "", ""
+	}
+	cmd, args, _ = strings.Cut(text[1:], " ")
+	return strings.ToLower(cmd), strings.TrimSpace(args)
+}
+
+// handleLocalCommand handles commands implemented by the bridge itself.
+// Returns true if the input was consumed and must not be forwarded to erssi.
+func (b *Bridge) handleLocalCommand(client *weechat.Client, bufferPtr, text string) bool {
+	cmd, args := splitCommand(text)
+
+	switch cmd {
+	case "edit":
+		b.handleEditCommand(client, bufferPtr, args)
+	case "delete":
+		b.handleDeleteCommand(client, bufferPtr)
+	case "away":
+		b.handleAwayCommand(client, bufferPtr, args)
+	case "back":
+		b.handleBackCommand(client, bufferPtr)
+	case "context":
+		b.handleContextCommand(client, bufferPtr, args)
+	case "bridge":
+		b.handleBridgeCommand(client, bufferPtr, args)
+	case "buffer":
+		// WeeChat clients mark a buffer read with /buffer set hotlist -1;
+		// other /buffer commands are erssi's
+		if strings.Join(strings.Fields(args), " ") != "set hotlist -1" {
+			return false
+		}
+		b.translator.ClearHotlistByPointer(bufferPtr)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// handleBridgeCommand dispatches /bridge subcommands
+func (b *Bridge) handleBridgeCommand(client *weechat.Client, bufferPtr, args string) {
+	sub, rest, _ := strings.Cut(args, " ")
+
+	switch strings.ToLower(sub) {
+	case "clients":
+		b.handleClientsCommand(client, bufferPtr)
+	case "diff":
+		b.handleDiffCommand(client, bufferPtr, strings.TrimSpace(rest))
+	case "exec":
+		b.handleExecCommand(client, bufferPtr, strings.TrimSpace(rest))
+	case "grep":
+		b.handleGrepCommand(client, bufferPtr, strings.TrimSpace(rest))
+	case "journal":
+		b.handleJournalCommand(client, bufferPtr, strings.TrimSpace(rest))
+	case "lag":
+		b.handleLagCommand(client, bufferPtr)
+	case "mute":
+		b.handleMuteCommand(client, bufferPtr, strings.TrimSpace(rest))
+	case "unmute":
+		b.handleMuteCommand(client, bufferPtr, "off")
+	case "preview":
+		b.handlePreviewCommand(client, bufferPtr, rest)
+	case "prune":
+		b.handlePruneCommand(client, bufferPtr)
+	case "purge":
+		b.handlePurgeCommand(client, bufferPtr, strings.TrimSpace(rest))
+	case "stats":
+		b.handleStatsCommand(client, bufferPtr)
+	default:
+		b.sendLocalNotice(client, bufferPtr,
+			"bridge: usage: /bridge clients | /bridge diff [-repair] | /bridge exec <command> [args] | /bridge grep <pattern> | /bridge journal [<count>] [<filter>] | /bridge lag | /bridge mute [<duration>|off] | /bridge preview <text> | /bridge prune | /bridge purge [-redact] <nick|nick!user@host> | /bridge stats")
+	}
+}
+
+// handlePreviewCommand shows what formatted text turns into on IRC, for
+// trying out -outgoing-colors. The text is kept verbatim, codes included.
+func (b *Bridge) handlePreviewCommand(client *weechat.Client, bufferPtr, text string) {
+	if strings.TrimSpace(text) == "" {
+		b.sendLocalNotice(client, bufferPtr, "preview: usage: /bridge preview <text>")
+		return
+	}
+
+	sent, shown := b.translator.PreviewOutgoing(text)
+	b.sendLocalNotice(client, bufferPtr, "preview: sent as: "+sent)
+	// Reset after the text so its colors don't run into anything appended
+	b.sendLocalNotice(client, bufferPtr, "preview: seen as: "+shown+"\x1c")
+}
+
+// handlePruneCommand applies the history retention policy right away
+func (b *Bridge) handlePruneCommand(client *weechat.Client, bufferPtr string) {
+	if b.history == nil {
+		b.sendLocalNotice(client, bufferPtr, "prune: persistent history is disabled")
+		return
+	}
+
+	result, err := b.history.Prune()
+	if err != nil {
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("prune: %v", err))
+		return
+	}
+	b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("prune: removed %d lines from %d buffers (%d emptied)",
+		result.LinesRemoved, result.Buffers, result.FilesRemoved))
+}
+
+// handlePurgeCommand deletes every stored line sent by a nick or hostmask.
+// With -redact the lines are also removed from the in-memory buffers and
+// connected clients redraw the affected buffers.
+//
+//	/bridge purge alice
+//	/bridge purge -redact *!*@spam.example
+func (b *Bridge) handlePurgeCommand(client *weechat.Client, bufferPtr, args string) {
+	redact := false
+	if flag, rest, _ := strings.Cut(args, " "); flag == "-redact" {
+		redact = true
+		args = strings.TrimSpace(rest)
+	}
+
+	mask, err := history.ParseMask(args)
+	if err != nil {
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("purge: %v", err))
+		return
+	}
+
+	var notice string
+	if b.history != nil {
+		result, err := b.history.Purge(mask)
+		if err != nil {
+			b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("purge: %v", err))
+			return
+		}
+		notice = fmt.Sprintf("purge: removed %d stored lines from %s in %d buffers",
+			result.LinesRemoved, mask.Pattern, result.Buffers)
+	} else if !redact {
+		b.sendLocalNotice(client, bufferPtr, "purge: persistent history is disabled, use -redact to remove lines from memory")
+		return
+	}
+
+	if redact {
+		removed, events := b.translator.RedactLines(mask.MatchTags)
+		for _, event := range events {
+			b.broadcastUnrestricted(event)
+		}
+		if notice != "" {
+			notice += ", "
+		} else {
+			notice = "purge: "
+		}
+		notice += fmt.Sprintf("redacted %d lines from connected clients", removed)
+	}
+
+	b.log.Infof("Purged lines from %s (redact: %v)", mask.Pattern, redact)
+	b.sendLocalNotice(client, bufferPtr, notice)
+}
+
+// handleEditCommand corrects the last outgoing message in a buffer.
+// IRC has no message editing, so the correction is sent as a sed-style
+// "s/old/new/" when the change is a single replacement, or as a corrected
+// repost ("* new text") otherwise.
+//
+//	/edit s/teh/the/      explicit substitution
+//	/edit the whole text  replacement text
+func (b *Bridge) handleEditCommand(client *weechat.Client, bufferPtr, args string) {
+	last, ok := b.sentHistory.last(bufferPtr)
+	if !ok {
+		b.sendLocalNotice(client, bufferPtr, "edit: no recent message to edit in this buffer")
+		return
+	}
+	if args == "" {
+		b.sendLocalNotice(client, bufferPtr, "edit: usage: /edit <new text> or /edit s/old/new/")
+		return
+	}
+
+	var edited, correction string
+	if old, repl, ok := parseSubstitution(args); ok {
+		if !strings.Contains(last, old) {
+			b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("edit: %q not found in last message", old))
+			return
+		}
+		edited = strings.Replace(last, old, repl, 1)
+		correction = args
+	} else {
+		edited = args
+		correction = editCorrection(last, edited)
+	}
+
+	if edited == last {
+		return
+	}
+
+	if err := b.sendToBuffer(bufferPtr, correction); err != nil {
+		b.log.Errorf("Failed to send edit: %v", err)
+		return
+	}
+	b.sentHistory.replaceLast(bufferPtr, edited)
+}
+
+// handleDeleteCommand retracts the last outgoing message in a buffer.
+// IRC can't unsend, so a short retraction notice is posted instead.
+func (b *Bridge) handleDeleteCommand(client *weechat.Client, bufferPtr string) {
+	last, ok := b.sentHistory.last(bufferPtr)
+	if !ok {
+		b.sendLocalNotice(client, bufferPtr, "delete: no recent message to delete in this buffer")
+		return
+	}
+
+	notice := fmt.Sprintf("(please disregard: %q)", truncateText(last, 40))
+	if err := b.sendToBuffer(bufferPtr, notice); err != nil {
+		b.log.Errorf("Failed to send retraction: %v", err)
+		return
+	}
+	b.sentHistory.dropLast(bufferPtr)
+}
+
+// parseSubstitution parses "s/old/new/" (trailing slash optional)
+func parseSubstitution(s string) (old, repl string, ok bool) {
+	if !strings.HasPrefix(s, "s/") {
+		return "", "", false
+	}
+	parts := strings.SplitN(strings.TrimSuffix(s[2:], "/"), "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// editCorrection builds the text to send for an edit of last into edited:
+// "s/old/new/" if exactly one contiguous span of words changed and the old
+// span is unambiguous, a "* edited" repost otherwise
+func editCorrection(last, edited string) string {
+	oldWords := strings.Fields(last)
+	newWords := strings.Fields(edited)
+
+	prefix := 0
+	for prefix < len(oldWords) && prefix < len(newWords) && oldWords[prefix] == newWords[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(oldWords)-prefix && suffix < len(newWords)-prefix &&
+		oldWords[len(oldWords)-1-suffix] == newWords[len(newWords)-1-suffix] {
+		suffix++
+	}
+
+	oldSpan := strings.Join(oldWords[prefix:len(oldWords)-suffix], " ")
+	newSpan := strings.Join(newWords[prefix:len(newWords)-suffix], " ")
+
+	if oldSpan != "" && !strings.Contains(oldSpan, "/") && !strings.Contains(newSpan, "/") &&
+		strings.Count(last, oldSpan) == 1 {
+		return fmt.Sprintf("s/%s/%s/", oldSpan, newSpan)
+	}
+
+	return "* " + edited
+}
+
+// truncateText shortens text to at most n runes
+func truncateText(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return string(runes[:n]) + "…"
+}
+
+// sendToBuffer forwards text typed in a buffer to erssi
+func (b *Bridge) sendToBuffer(bufferPtr, text string) error {
+	erssiMsg, err := b.translator.InputToErssiCommand(bufferPtr, text)
+	if err != nil {
+		return fmt.Errorf("failed to convert input: %w", err)
+	}
+
+	if err := b.sendToErssi(erssiMsg); err != nil {
+		return fmt.Errorf("failed to send message to erssi: %w", err)
+	}
+
+	return nil
+}
+
+// sendLocalNotice shows a bridge-generated line in a buffer, to one client only
+func (b *Bridge) sendLocalNotice(client *weechat.Client, bufferPtr, text string) {
+	if err := client.SendMessage(b.translator.LocalNotice(bufferPtr, text)); err != nil {
+		b.log.Errorf("Failed to send notice: %v", err)
+	}
+}
@@ -0,0 +1,50 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+func TestInputToServerBufferReachesServer(t *testing.T) {
+	tb := startTestBridge(t, Config{})
+	client := tb.dialRelay(t)
+	pointers := client.init("libera", "libera.#go")
+
+	client.send("input %s /join #rust", pointers["libera"])
+	msg := tb.waitErssi(t, func(msg *erssiproto.WebMessage) bool {
+		return msg.Type == erssiproto.Message && msg.Text == "/join #rust"
+	})
+	if msg.ServerTag != "libera" || msg.Target != "" {
+		t.Errorf("server buffer input sent to %q/%q, want libera with no target", msg.ServerTag, msg.Target)
+	}
+
+	client.send("input %s hello", pointers["libera.#go"])
+	msg = tb.waitErssi(t, func(msg *erssiproto.WebMessage) bool {
+		return msg.Type == erssiproto.Message && msg.Text == "hello"
+	})
+	if msg.ServerTag != "libera" || msg.Target != "#go" {
+		t.Errorf("channel input sent to %q/%q, want libera/#go", msg.ServerTag, msg.Target)
+	}
+}
+
+func TestInputToCoreBufferIsNotUnknown(t *testing.T) {
+	tb := startTestBridge(t, Config{})
+	client := tb.dialRelay(t)
+	pointers := client.init("core.weechat")
+	client.send("sync")
+
+	client.send("input %s hello", pointers["core.weechat"])
+	for _, msg := range client.collect(500 * time.Millisecond) {
+		switch {
+		case msg.ID == "_buffer_opened":
+			t.Errorf("core buffer input resent the buffer list")
+		case hasLineContaining(msg, "doesn't know this buffer"):
+			t.Errorf("core buffer input reported as unknown buffer")
+		case hasLineContaining(msg, "Message not sent, buffer takes no input: the core buffer only takes /bridge commands"):
+			return
+		}
+	}
+	t.Errorf("no notice for core buffer input")
+}
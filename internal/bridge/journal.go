@@ -0,0 +1,193 @@
+package bridge
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// Kinds of journal events
+const (
+	journalIn        = "in"        // Message from erssi
+	journalTranslate = "translate" // Line made from an erssi message
+	journalOut       = "out"       // Broadcast to relay clients
+)
+
+// journalShow is how many events /bridge journal shows by default
+const journalShow = 50
+
+// journalEvent is one entry of the event journal
+type journalEvent struct {
+	Seq    uint64
+	At     time.Time
+	Kind   string
+	Detail string
+}
+
+// eventJournal keeps the last events passing through the bridge, numbered
+// in the order they were recorded, for telling where a line reported
+// missing or out of order got lost. A nil journal records nothing.
+type eventJournal struct {
+	mu     sync.Mutex
+	events []journalEvent // Ring, oldest at next once full
+	next   int
+	seq    uint64
+}
+
+func newEventJournal(size int) *eventJournal {
+	if size <= 0 {
+		return nil
+	}
+	return &eventJournal{events: make([]journalEvent, 0, size)}
+}
+
+// record adds an event and returns its sequence number (0 when disabled)
+func (j *eventJournal) record(kind, detail string) uint64 {
+	if j == nil {
+		return 0
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.seq++
+	event := journalEvent{Seq: j.seq, At: time.Now(), Kind: kind, Detail: detail}
+	if len(j.events) < cap(j.events) {
+		j.events = append(j.events, event)
+	} else {
+		j.events[j.next] = event
+		j.next = (j.next + 1) % len(j.events)
+	}
+	return j.seq
+}
+
+// last returns up to n events, oldest first, whose kind or detail contains
+// filter (case-insensitive, "" = all)
+func (j *eventJournal) last(n int, filter string) []journalEvent {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	filter = strings.ToLower(filter)
+	var events []journalEvent
+	for i := len(j.events) - 1; i >= 0 && len(events) < n; i-- {
+		event := j.events[(j.next+i)%len(j.events)]
+		if filter == "" || strings.Contains(strings.ToLower(event.Kind+" "+event.Detail), filter) {
+			events = append(events, event)
+		}
+	}
+	for i, k := 0, len(events)-1; i < k; i, k = i+1, k-1 {
+		events[i], events[k] = events[k], events[i]
+	}
+	return events
+}
+
+// journalErssi records a message from erssi and returns its sequence number
+func (b *Bridge) journalErssi(msg *erssiproto.WebMessage) uint64 {
+	if b.journal == nil {
+		return 0
+	}
+
+	detail := string(msg.Type)
+	if msg.ServerTag != "" {
+		detail += " " + msg.ServerTag
+		if msg.Target != "" {
+			detail += "." + msg.Target
+		}
+	}
+	if msg.Nick != "" {
+		detail += " from " + msg.Nick
+	}
+	if msg.Seq != 0 {
+		detail += fmt.Sprintf(" seq=%d", msg.Seq)
+	}
+	if msgID := msg.MsgID(); msgID != "" {
+		detail += " msgid=" + msgID
+	}
+	return b.journal.record(journalIn, detail)
+}
+
+// journalLine records the line made from the erssi message journaled as
+// in, nil for a dropped duplicate
+func (b *Bridge) journalLine(in uint64, serverTag, target string, line *weechatproto.Message) {
+	if b.journal == nil {
+		return
+	}
+
+	if line == nil {
+		b.journal.record(journalTranslate, fmt.Sprintf("#%d %s.%s dropped (duplicate)", in, serverTag, target))
+		return
+	}
+	b.journal.record(journalTranslate, fmt.Sprintf("#%d %s.%s line", in, serverTag, target))
+}
+
+// journalBroadcast records a message broadcast to relay clients
+func (b *Bridge) journalBroadcast(msg *weechatproto.Message, clients int) {
+	if b.journal == nil {
+		return
+	}
+
+	detail := msg.ID
+	if hdata, ok := broadcastHData(msg); detail == "" && ok {
+		detail = hdata.Path // Lines are sent without an event name
+	}
+	if ptr := weechat.EventBuffer(msg); ptr != "" {
+		if serverTag, target := b.translator.GetBufferInfo(ptr); serverTag != "" {
+			detail += " " + serverTag
+			if target != "" {
+				detail += "." + target
+			}
+		} else {
+			detail += " " + ptr
+		}
+	}
+	b.journal.record(journalOut, fmt.Sprintf("%s to %d clients", detail, clients))
+}
+
+// broadcastHData returns the first non-empty hdata of a message
+func broadcastHData(msg *weechatproto.Message) (weechatproto.HData, bool) {
+	for _, obj := range msg.Data {
+		if hdata, ok := obj.(weechatproto.HData); ok && len(hdata.Items) > 0 {
+			return hdata, true
+		}
+	}
+	return weechatproto.HData{}, false
+}
+
+// handleJournalCommand shows the latest journal events, optionally only
+// those matching a filter (e.g. a buffer name)
+//
+//	/bridge journal
+//	/bridge journal 200 libera.#go
+func (b *Bridge) handleJournalCommand(client *weechat.Client, bufferPtr, args string) {
+	if b.journal == nil {
+		b.sendLocalNotice(client, bufferPtr, "journal: disabled (JOURNAL_SIZE=0)")
+		return
+	}
+
+	n := journalShow
+	if first, rest, _ := strings.Cut(args, " "); first != "" {
+		if count, err := strconv.Atoi(first); err == nil {
+			if count <= 0 {
+				b.sendLocalNotice(client, bufferPtr, "journal: usage: /bridge journal [<count>] [<filter>]")
+				return
+			}
+			n, args = count, strings.TrimSpace(rest)
+		}
+	}
+
+	events := b.journal.last(n, args)
+	if len(events) == 0 {
+		b.sendLocalNotice(client, bufferPtr, "journal: no matching events")
+		return
+	}
+	for _, event := range events {
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("journal: #%d %s %s %s",
+			event.Seq, event.At.Format("15:04:05.000"), event.Kind, event.Detail))
+	}
+}
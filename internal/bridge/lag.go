@@ -0,0 +1,65 @@
+package bridge
+
+import (
+	"fmt"
+	"time"
+
+	"erssi-lith-bridge/internal/erssi"
+	"erssi-lith-bridge/internal/weechat"
+)
+
+// defaultLagWarn is the lag beyond which the core buffer shows a warning
+const defaultLagWarn = 10 * time.Second
+
+// handleErssiLag warns in the core buffer when an upstream starts or stops
+// lagging, telling erssi lag (irssi busy or the link slow) apart from
+// bridge lag (messages waiting in the bridge)
+func (b *Bridge) handleErssiLag(u *upstream, lag erssi.Lag) {
+	side := ""
+	switch {
+	case lag.Erssi >= b.lagWarn:
+		side = "erssi"
+	case lag.Bridge >= b.lagWarn:
+		side = "bridge"
+	}
+
+	u.lagMu.Lock()
+	was := u.lagging
+	u.lagging = side
+	u.lagMu.Unlock()
+
+	if side == was {
+		return
+	}
+
+	switch {
+	case side == "erssi":
+		b.postUpstreamStatus(fmt.Sprintf("%s is lagging: %s", u.describe(), formatLag(lag.Erssi)))
+	case side == "bridge":
+		b.postUpstreamStatus(fmt.Sprintf("bridge is lagging behind %s: %s", u.describe(), formatLag(lag.Bridge)))
+	default:
+		b.postUpstreamStatus(fmt.Sprintf("%s lag back to %s (bridge %s)",
+			u.describe(), formatLag(lag.Erssi), formatLag(lag.Bridge)))
+	}
+}
+
+// handleLagCommand shows the last lag measurement of every upstream
+func (b *Bridge) handleLagCommand(client *weechat.Client, bufferPtr string) {
+	for _, u := range b.upstreams {
+		lag := u.client.Lag()
+		if lag.At.IsZero() {
+			b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("lag: %s not measured (lag checks off or not connected)", u.describe()))
+			continue
+		}
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("lag: %s %s, bridge %s (%s ago)",
+			u.describe(), formatLag(lag.Erssi), formatLag(lag.Bridge), time.Since(lag.At).Round(time.Second)))
+	}
+}
+
+// formatLag rounds a lag for display
+func formatLag(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(100 * time.Millisecond).String()
+}
@@ -0,0 +1,196 @@
+package bridge
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/history"
+	"erssi-lith-bridge/internal/weechat"
+)
+
+// mutesState names the history state document holding the buffer mutes
+// (buffer key -> unix time the mute ends)
+const mutesState = "mutes"
+
+// muteTimers ends buffer mutes when they expire
+type muteTimers struct {
+	mu     sync.Mutex
+	timers map[string]*time.Timer // buffer key -> expiry
+}
+
+func newMuteTimers() *muteTimers {
+	return &muteTimers{timers: make(map[string]*time.Timer)}
+}
+
+// schedule runs fn at until, replacing the previous timer of the buffer.
+// A zero until only cancels it.
+func (m *muteTimers) schedule(key string, until time.Time, fn func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if timer, ok := m.timers[key]; ok {
+		timer.Stop()
+		delete(m.timers, key)
+	}
+	if until.IsZero() {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(until), func() {
+		m.mu.Lock()
+		current := m.timers[key] == timer
+		if current {
+			delete(m.timers, key)
+		}
+		m.mu.Unlock()
+
+		if current {
+			fn()
+		}
+	})
+	m.timers[key] = timer
+}
+
+// stop cancels every timer
+func (m *muteTimers) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, timer := range m.timers {
+		timer.Stop()
+		delete(m.timers, key)
+	}
+}
+
+// handleMuteCommand silences the hotlist and push notifications of the
+// current buffer for a while. Its lines still arrive. Mutes survive
+// restarts when persistent history is enabled.
+//
+//	/bridge mute 2h     mute the current buffer for two hours
+//	/bridge mute off    unmute it
+//	/bridge mute        list the muted buffers
+func (b *Bridge) handleMuteCommand(client *weechat.Client, bufferPtr, args string) {
+	if args == "" {
+		b.listMutes(client, bufferPtr)
+		return
+	}
+
+	serverTag, target := b.translator.GetBufferInfo(bufferPtr)
+	if serverTag == "" || target == "" || serverTag == target {
+		b.sendLocalNotice(client, bufferPtr, "mute: only channels and queries can be muted")
+		return
+	}
+	name := serverTag + "." + target
+
+	if strings.EqualFold(args, "off") {
+		b.setMute(serverTag, target, time.Time{})
+		b.log.Infof("Unmuted %s", name)
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("mute: %s unmuted", name))
+		return
+	}
+
+	d, err := history.ParseAge(args)
+	if err != nil || d == 0 {
+		b.sendLocalNotice(client, bufferPtr, "mute: usage: /bridge mute <duration (e.g. 30m, 2h, 1d)> | /bridge mute off | /bridge mute")
+		return
+	}
+
+	until := time.Now().Add(d)
+	b.setMute(serverTag, target, until)
+	b.log.Infof("Muted %s until %s", name, until.Format(time.RFC3339))
+	b.sendLocalNotice(client, bufferPtr,
+		fmt.Sprintf("mute: %s muted until %s, its lines still arrive", name, formatMuteEnd(until)))
+}
+
+// listMutes shows the muted buffers
+func (b *Bridge) listMutes(client *weechat.Client, bufferPtr string) {
+	mutes := b.translator.Mutes()
+	if len(mutes) == 0 {
+		b.sendLocalNotice(client, bufferPtr, "mute: no muted buffers")
+		return
+	}
+
+	keys := make([]string, 0, len(mutes))
+	for key := range mutes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		until := mutes[key]
+		b.sendLocalNotice(client, bufferPtr, fmt.Sprintf("mute: %s until %s (%s left)",
+			key, formatMuteEnd(until), time.Until(until).Round(time.Minute)))
+	}
+}
+
+// formatMuteEnd formats the end of a mute, with the date if not today
+func formatMuteEnd(until time.Time) string {
+	if until.Format(time.DateOnly) == time.Now().Format(time.DateOnly) {
+		return until.Format("15:04")
+	}
+	return until.Format("2006-01-02 15:04")
+}
+
+// setMute mutes a buffer until the given time (zero = unmute), tells the
+// clients and persists the mutes
+func (b *Bridge) setMute(serverTag, target string, until time.Time) {
+	b.applyMute(serverTag, target, until)
+	b.saveMutes()
+}
+
+// applyMute mutes a buffer and schedules the end of the mute
+func (b *Bridge) applyMute(serverTag, target string, until time.Time) {
+	if event := b.translator.MuteBuffer(serverTag, target, until); event != nil {
+		b.broadcastToBuffer(serverTag, target, event)
+	}
+
+	key := serverTag + "." + strings.ToLower(target)
+	b.mutes.schedule(key, until, func() {
+		b.log.Infof("Mute of %s.%s expired", serverTag, target)
+		b.setMute(serverTag, target, time.Time{})
+	})
+}
+
+// saveMutes persists the active mutes in the history store
+func (b *Bridge) saveMutes() {
+	if b.history == nil {
+		return
+	}
+
+	saved := make(map[string]int64)
+	for key, until := range b.translator.Mutes() {
+		saved[key] = until.Unix()
+	}
+	if err := b.history.SaveState(mutesState, saved); err != nil {
+		b.log.Errorf("Failed to save buffer mutes: %v", err)
+	}
+}
+
+// loadMutes restores the mutes saved before a restart
+func (b *Bridge) loadMutes() {
+	if b.history == nil {
+		return
+	}
+
+	var saved map[string]int64
+	if err := b.history.LoadState(mutesState, &saved); err != nil {
+		b.log.Errorf("Failed to load buffer mutes: %v", err)
+		return
+	}
+
+	for key, unix := range saved {
+		until := time.Unix(unix, 0)
+		serverTag, target, ok := strings.Cut(key, ".")
+		if !ok || !until.After(time.Now()) {
+			continue
+		}
+		b.applyMute(serverTag, target, until)
+	}
+	if len(saved) > 0 {
+		b.log.Infof("Restored %d buffer mutes", len(b.translator.Mutes()))
+	}
+}
@@ -0,0 +1,91 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// nicklistWaiter is a relay client waiting for a channel's nicklist
+type nicklistWaiter struct {
+	client *weechat.Client
+	msgID  string
+}
+
+// nicklistRequests tracks which relay clients asked for which channel's
+// nicklist, so the answer from erssi goes to them (with their message IDs)
+// rather than to everyone
+type nicklistRequests struct {
+	mu      sync.Mutex
+	waiting map[string][]*nicklistWaiter // nicklistKey -> waiters
+}
+
+func newNicklistRequests() *nicklistRequests {
+	return &nicklistRequests{waiting: make(map[string][]*nicklistWaiter)}
+}
+
+// nicklistKey identifies a channel in nicklistRequests
+func nicklistKey(serverTag, target string) string {
+	return serverTag + "\x00" + strings.ToLower(target)
+}
+
+// add registers a client waiting for a channel's nicklist
+func (r *nicklistRequests) add(key string, client *weechat.Client, msgID string) *nicklistWaiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w := &nicklistWaiter{client: client, msgID: msgID}
+	r.waiting[key] = append(r.waiting[key], w)
+	return w
+}
+
+// take removes and returns every client waiting for a channel
+func (r *nicklistRequests) take(key string) []*nicklistWaiter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	waiters := r.waiting[key]
+	delete(r.waiting, key)
+	return waiters
+}
+
+// remove unregisters a waiter. Returns false if it was already answered.
+func (r *nicklistRequests) remove(key string, w *nicklistWaiter) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	waiters := r.waiting[key]
+	for i, other := range waiters {
+		if other != w {
+			continue
+		}
+		waiters = append(waiters[:i], waiters[i+1:]...)
+		if len(waiters) == 0 {
+			delete(r.waiting, key)
+		} else {
+			r.waiting[key] = waiters
+		}
+		return true
+	}
+	return false
+}
+
+// sendCachedNicklist answers a nicklist request from the cache (empty for
+// unknown buffers)
+func (b *Bridge) sendCachedNicklist(client *weechat.Client, msgID, bufferPtr string) {
+	if err := client.SendMessage(b.translator.GetBufferNicklist(bufferPtr, msgID)); err != nil {
+		b.log.Errorf("Failed to send nicklist: %v", err)
+	}
+}
+
+// sendNicklist answers a nicklist request with a nicklist shared by several
+// clients, setting the client's message ID on a copy
+func (b *Bridge) sendNicklist(client *weechat.Client, msgID string, nicklist *weechatproto.Message) {
+	reply := *nicklist
+	reply.ID = msgID
+	if err := client.SendMessage(&reply); err != nil {
+		b.log.Errorf("Failed to send nicklist: %v", err)
+	}
+}
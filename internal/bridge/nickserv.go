@@ -0,0 +1,123 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// nickServCredential is an account to identify with on one server
+type nickServCredential struct {
+	account  string // "" = the current nick
+	password string
+}
+
+// nickServ identifies to NickServ when erssi reports a server connected.
+// fe-web has no way to hand SASL credentials to irssi, so this is the
+// fallback that works on any services package.
+type nickServ struct {
+	creds map[string]nickServCredential // lowercase server tag -> account
+
+	mu         sync.Mutex
+	identified map[string]bool // Servers identified since they connected
+}
+
+// parseNickServCredentials parses "server=account:password" or
+// "server=password" entries (nil if there are none)
+func parseNickServCredentials(entries []string) (*nickServ, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	ns := &nickServ{
+		creds:      make(map[string]nickServCredential),
+		identified: make(map[string]bool),
+	}
+	for i, entry := range entries {
+		// Never quote the entry, it holds the password
+		server, secret, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok || server == "" || secret == "" {
+			return nil, fmt.Errorf("invalid NickServ credentials entry %d (want server=account:password)", i+1)
+		}
+		var cred nickServCredential
+		if account, password, ok := strings.Cut(secret, ":"); ok {
+			cred = nickServCredential{account: account, password: password}
+		} else {
+			cred = nickServCredential{password: secret}
+		}
+		if cred.password == "" {
+			return nil, fmt.Errorf("invalid NickServ credentials for %q: empty password", server)
+		}
+		ns.creds[strings.ToLower(server)] = cred
+	}
+	return ns, nil
+}
+
+// connected records a server_status and returns the credentials to
+// identify with, if the server just connected and has some
+func (ns *nickServ) connected(serverTag string, connected bool) (nickServCredential, bool) {
+	key := strings.ToLower(serverTag)
+	cred, ok := ns.creds[key]
+	if !ok {
+		return nickServCredential{}, false
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	if !connected {
+		delete(ns.identified, key)
+		return nickServCredential{}, false
+	}
+	if ns.identified[key] {
+		return nickServCredential{}, false
+	}
+	ns.identified[key] = true
+	return cred, true
+}
+
+// reset forgets which servers of an upstream were identified, after the
+// connection to erssi dropped and their state is unknown
+func (ns *nickServ) reset(prefix string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	prefix = strings.ToLower(prefix)
+	for key := range ns.identified {
+		if strings.HasPrefix(key, prefix) {
+			delete(ns.identified, key)
+		}
+	}
+}
+
+// handleServerStatus identifies to NickServ when a server with configured
+// credentials connects
+func (b *Bridge) handleServerStatus(msg *erssiproto.WebMessage) {
+	connected, ok := msg.ServerConnected()
+	if !ok || b.nickserv == nil {
+		return
+	}
+
+	cred, ok := b.nickserv.connected(msg.ServerTag, connected)
+	if !ok {
+		return
+	}
+
+	// Sent raw so irssi doesn't echo the password into a NickServ query
+	command := "IDENTIFY " + cred.password
+	if cred.account != "" {
+		command = fmt.Sprintf("IDENTIFY %s %s", cred.account, cred.password)
+	}
+	identify := &erssiproto.WebMessage{
+		Type:      erssiproto.Message,
+		ServerTag: msg.ServerTag,
+		Text:      "/quote PRIVMSG NickServ :" + command,
+	}
+	if err := b.sendToErssi(identify); err != nil {
+		b.log.Errorf("Failed to identify to NickServ on %s: %v", msg.ServerTag, err)
+		return
+	}
+	b.log.Infof("Sent NickServ IDENTIFY on %s", msg.ServerTag)
+}
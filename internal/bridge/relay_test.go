@@ -0,0 +1,246 @@
+package bridge
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"erssi-lith-bridge/internal/erssi/erssitest"
+	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testTimeout bounds every wait of the bridge tests
+const testTimeout = 5 * time.Second
+
+// testNetworks is the state of the fake erssi of the bridge tests
+var testNetworks = []erssitest.Network{{
+	Tag:  "libera",
+	Nick: "tester",
+	Channels: []erssitest.Channel{{
+		Name:  "#go",
+		Topic: "Go programming",
+		Nicks: []erssiproto.NickInfo{{Nick: "tester", Prefix: "@"}, {Nick: "alice"}},
+		Backlog: []erssiproto.WebMessage{
+			{Nick: "alice", Text: "anyone here?"},
+			{Nick: "alice", Text: "tester: ping", IsHighlight: true},
+		},
+	}},
+}}
+
+// testBridge is a bridge connected to a fake erssi
+type testBridge struct {
+	*Bridge
+	erssi *erssitest.Server
+}
+
+// startTestBridge starts a bridge on a fake erssi with testNetworks, and
+// stops both when the test ends
+func startTestBridge(t *testing.T, cfg Config) *testBridge {
+	t.Helper()
+
+	fake, err := erssitest.NewServer(testNetworks)
+	if err != nil {
+		t.Fatalf("fake erssi: %v", err)
+	}
+	t.Cleanup(func() { fake.Close() })
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	cfg.ErssiURL = fake.URL()
+	cfg.ListenAddr = "127.0.0.1:0"
+	cfg.Logger = logger
+
+	b, err := New(cfg)
+	if err != nil {
+		t.Fatalf("bridge: %v", err)
+	}
+	if err := b.Start(); err != nil {
+		t.Fatalf("bridge: %v", err)
+	}
+	t.Cleanup(func() { b.Stop() })
+
+	return &testBridge{Bridge: b, erssi: fake}
+}
+
+// waitErssi waits until the fake erssi receives a message accepted by
+// match, failing the test otherwise
+func (tb *testBridge) waitErssi(t *testing.T, match func(*erssiproto.WebMessage) bool) *erssiproto.WebMessage {
+	t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case msg := <-tb.erssi.Received():
+			if match(msg) {
+				return msg
+			}
+		case <-deadline:
+			t.Fatalf("erssi received no matching message within %s", testTimeout)
+			return nil
+		}
+	}
+}
+
+// testRelayClient is a relay client decoding what the bridge sends
+type testRelayClient struct {
+	t        *testing.T
+	conn     net.Conn
+	messages chan *weechatproto.Message
+}
+
+// dialRelay connects a relay client to a test bridge
+func (tb *testBridge) dialRelay(t *testing.T) *testRelayClient {
+	t.Helper()
+
+	conn, err := net.DialTimeout("tcp", tb.RelayAddr(), testTimeout)
+	if err != nil {
+		t.Fatalf("relay: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c := &testRelayClient{t: t, conn: conn, messages: make(chan *weechatproto.Message, 1024)}
+	go func() {
+		defer close(c.messages)
+		decoder := weechatproto.NewDecoder(conn)
+		for {
+			msg, err := decoder.DecodeMessage()
+			if err != nil {
+				return
+			}
+			c.messages <- msg
+		}
+	}()
+	return c
+}
+
+// send writes one command line
+func (c *testRelayClient) send(format string, args ...interface{}) {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, format+"\n", args...); err != nil {
+		c.t.Fatalf("relay send: %v", err)
+	}
+}
+
+// next returns the next message accepted by match, discarding others
+func (c *testRelayClient) next(match func(*weechatproto.Message) bool) *weechatproto.Message {
+	c.t.Helper()
+
+	deadline := time.After(testTimeout)
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.t.Fatalf("relay connection closed")
+			}
+			if match(msg) {
+				return msg
+			}
+		case <-deadline:
+			c.t.Fatalf("no matching relay message within %s", testTimeout)
+			return nil
+		}
+	}
+}
+
+// collect returns the messages received until none came for quiet
+func (c *testRelayClient) collect(quiet time.Duration) []*weechatproto.Message {
+	var msgs []*weechatproto.Message
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				return msgs
+			}
+			msgs = append(msgs, msg)
+		case <-time.After(quiet):
+			return msgs
+		}
+	}
+}
+
+// init authenticates the client and waits for the buffer list to contain
+// every buffer named in want, returning their pointers by name
+func (c *testRelayClient) init(want ...string) map[string]string {
+	c.t.Helper()
+	return c.login("", want...)
+}
+
+// login is init with a password
+func (c *testRelayClient) login(password string, want ...string) map[string]string {
+	c.t.Helper()
+
+	c.send("init password=%s,compression=off", password)
+	deadline := time.Now().Add(testTimeout)
+	for {
+		c.send("(buffers) hdata buffer:gui_buffers(*) number,name")
+		pointers := bufferPointers(c.next(withID("buffers")))
+		missing := false
+		for _, name := range want {
+			if pointers[name] == "" {
+				missing = true
+			}
+		}
+		if !missing {
+			return pointers
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("buffers %v not listed, got %v", want, pointers)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// withID matches messages carrying the given ID
+func withID(id string) func(*weechatproto.Message) bool {
+	return func(msg *weechatproto.Message) bool { return msg.ID == id }
+}
+
+// hdataOf returns the first hdata object of a message
+func hdataOf(msg *weechatproto.Message) (weechatproto.HData, bool) {
+	for _, obj := range msg.Data {
+		if h, ok := obj.(weechatproto.HData); ok {
+			return h, true
+		}
+	}
+	return weechatproto.HData{}, false
+}
+
+// bufferPointers returns the pointers of a buffer hdata by buffer name
+func bufferPointers(msg *weechatproto.Message) map[string]string {
+	pointers := make(map[string]string)
+	h, _ := hdataOf(msg)
+	for _, item := range h.Items {
+		if len(item.Pointers) > 0 {
+			pointers[weechatproto.ObjectString(item.Objects["name"])] = item.Pointers[0]
+		}
+	}
+	return pointers
+}
+
+// lineMessages returns the message of every line in a line hdata
+func lineMessages(msg *weechatproto.Message) []string {
+	var texts []string
+	h, _ := hdataOf(msg)
+	for _, item := range h.Items {
+		if text, ok := item.Objects["message"]; ok {
+			texts = append(texts, weechatproto.ObjectString(text))
+		}
+	}
+	return texts
+}
+
+// hasLineContaining reports whether a message carries a line containing text
+func hasLineContaining(msg *weechatproto.Message, text string) bool {
+	for _, line := range lineMessages(msg) {
+		if strings.Contains(line, text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/erssi"
+	"erssi-lith-bridge/internal/translator"
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// minResyncInterval is the minimum time between two automatic resyncs of
+// the same server, so a server that keeps looking out of sync can't flood
+// erssi with state dump requests
+const minResyncInterval = 30 * time.Second
+
+// resyncLimiter remembers when each server (or upstream, "prefix*") was last
+// resynced
+type resyncLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+func newResyncLimiter() *resyncLimiter {
+	return &resyncLimiter{last: make(map[string]time.Time)}
+}
+
+// allow reports whether key may be resynced now, and records it if so
+func (r *resyncLimiter) allow(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if last, ok := r.last[key]; ok && now.Sub(last) < minResyncInterval {
+		return false
+	}
+	r.last[key] = now
+	return true
+}
+
+// impliesBuffer reports whether erssi only sends this message type for a
+// channel the user is in, so a missing buffer means a join was missed
+func impliesBuffer(t erssiproto.MessageType) bool {
+	switch t {
+	case erssiproto.Message, erssiproto.ChannelPart, erssiproto.ChannelKick,
+		erssiproto.Topic, erssiproto.ChannelMode, erssiproto.NicklistUpdate:
+		return true
+	}
+	return false
+}
+
+// checkForGap resyncs a server when a message refers to a channel the
+// bridge doesn't know although the server's state was loaded
+func (b *Bridge) checkForGap(msg *erssiproto.WebMessage) {
+	if !impliesBuffer(msg.Type) || !translator.IsChannel(msg.Target) {
+		return
+	}
+	if b.dumps.Phase(msg.ServerTag) != dumpComplete || b.translator.HasBuffer(msg.ServerTag, msg.Target) {
+		return
+	}
+
+	b.resyncServer(msg.ServerTag, fmt.Sprintf("%s for unknown channel %s", msg.Type, msg.Target))
+}
+
+// resyncServer requests a fresh state dump of one server
+func (b *Bridge) resyncServer(serverTag, reason string) {
+	if b.dumps.InProgress(serverTag) || !b.resyncs.allow(serverTag) {
+		return
+	}
+
+	u, err := b.upstreamFor(serverTag)
+	if err != nil {
+		b.log.Warnf("Can't resync %s: %v", serverTag, err)
+		return
+	}
+
+	b.log.Warnf("%s looks out of sync (%s), requesting a fresh state dump", serverTag, reason)
+	b.postServerStatus(serverTag, "", fmt.Sprintf("%s out of sync (%s), re-syncing...", serverTag, reason))
+	b.beginRelayUpgrade()
+	ctx, cancel := sendContext()
+	defer cancel()
+	if err := u.client.RequestServerStateDump(ctx, u.localTag(serverTag)); err != nil {
+		u.log.Errorf("Failed to request state dump for %s: %v", serverTag, err)
+	}
+}
+
+// resyncUpstream requests a fresh state dump of every server of an upstream
+func (b *Bridge) resyncUpstream(u *upstream, reason string) {
+	b.mu.RLock()
+	hadState := b.stateDumpRequested
+	b.mu.RUnlock()
+
+	// Nothing to repair before the first dump
+	if !hadState || b.dumps.Active() || !b.resyncs.allow(u.prefix+"*") {
+		return
+	}
+
+	u.log.Warnf("%s looks out of sync (%s), requesting a fresh state dump", u.describe(), reason)
+	b.postUpstreamStatus(fmt.Sprintf("%s out of sync (%s), re-syncing...", u.describe(), reason))
+	b.beginRelayUpgrade()
+	ctx, cancel := sendContext()
+	defer cancel()
+	if err := u.client.RequestStateDump(ctx); err != nil {
+		u.log.Errorf("Failed to request state dump: %v", err)
+	}
+}
+
+// handleErssiGap resyncs an upstream whose sequence numbers skipped ahead
+func (b *Bridge) handleErssiGap(u *upstream, missed uint64) {
+	b.resyncUpstream(u, fmt.Sprintf("%d messages missed", missed))
+}
+
+// reconcileBuffers closes the channel buffers of a server that its latest
+// state dump no longer lists. A dump without any channel is not trusted
+// (fe-web versions that send channels differently would lose every buffer).
+func (b *Bridge) reconcileBuffers(serverTag string, channels map[string]struct{}) {
+	if len(channels) == 0 {
+		return
+	}
+
+	for _, event := range b.translator.CloseStaleChannels(serverTag, channels) {
+		b.broadcastUnrestricted(event)
+	}
+}
+
+// startSilenceWatch resyncs upstreams that stay silent for longer than
+// resyncSilence. Caller must hold b.mu.
+func (b *Bridge) startSilenceWatch() {
+	if b.resyncSilence <= 0 {
+		return
+	}
+
+	stop := make(chan struct{})
+	b.silenceStop = stop
+	go b.silenceWatchLoop(stop)
+}
+
+// stopSilenceWatch stops the silence watch. Caller must hold b.mu.
+func (b *Bridge) stopSilenceWatch() {
+	if b.silenceStop != nil {
+		close(b.silenceStop)
+		b.silenceStop = nil
+	}
+}
+
+func (b *Bridge) silenceWatchLoop(stop <-chan struct{}) {
+	ticker := time.NewTicker(b.resyncSilence / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			b.checkSilence()
+		}
+	}
+}
+
+// checkSilence resyncs every connected upstream that sent nothing for
+// resyncSilence
+func (b *Bridge) checkSilence() {
+	for _, u := range b.upstreams {
+		if u.client.State() != erssi.StateAuthenticated {
+			continue
+		}
+		last := u.client.Stats().LastMessage
+		if last.IsZero() || time.Since(last) < b.resyncSilence {
+			continue
+		}
+		b.resyncUpstream(u, fmt.Sprintf("silent for %s", time.Since(last).Round(time.Second)))
+	}
+}
@@ -0,0 +1,47 @@
+package bridge
+
+import (
+	"fmt"
+	"sync"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// maxReportedViolations bounds the distinct schema violations remembered
+// for de-duplication
+const maxReportedViolations = 1000
+
+// violationReporter posts each distinct erssi schema violation to the core
+// buffer once, so a changed fe-web field doesn't flood it
+type violationReporter struct {
+	mu   sync.Mutex
+	seen map[string]struct{}
+}
+
+func newViolationReporter() *violationReporter {
+	return &violationReporter{seen: make(map[string]struct{})}
+}
+
+// first records a violation and reports whether it is new
+func (r *violationReporter) first(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.seen[key]; ok || len(r.seen) >= maxReportedViolations {
+		return false
+	}
+	r.seen[key] = struct{}{}
+	return true
+}
+
+// handleSchemaViolation reports a schema violation found in a message from
+// an upstream
+func (b *Bridge) handleSchemaViolation(u *upstream, v erssiproto.Violation) {
+	key := u.prefix + v.Error()
+	if !b.violations.first(key) {
+		return
+	}
+
+	u.log.Warnf("erssi schema violation: %v", v)
+	b.postUpstreamStatus(fmt.Sprintf("erssi schema violation (%s): %v", u.describe(), v))
+}
@@ -0,0 +1,196 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/weechat"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+const (
+	// minBufferRefreshInterval spaces the buffer lists sent to one client
+	// that keeps typing into buffers the bridge doesn't know
+	minBufferRefreshInterval = 30 * time.Second
+
+	// staleResyncMisses unknown buffer pointers from one client within
+	// staleWindow mean it holds the buffers of a previous bridge run, and
+	// is resynced
+	staleResyncMisses = 3
+	staleWindow       = time.Minute
+)
+
+// unknownBufferPolicy is what happens to input for a buffer pointer the
+// bridge doesn't know, e.g. one a client kept across a bridge restart
+type unknownBufferPolicy string
+
+const (
+	// unknownBufferIgnore only logs the input
+	unknownBufferIgnore unknownBufferPolicy = "ignore"
+	// unknownBufferNotice tells the client in that buffer that nothing was sent
+	unknownBufferNotice unknownBufferPolicy = "notice"
+	// unknownBufferRefresh also sends the client the buffer list again, as
+	// _buffer_opened, so it can map its buffers to the current pointers
+	unknownBufferRefresh unknownBufferPolicy = "refresh"
+)
+
+// parseUnknownBufferPolicy parses an unknown buffer policy name (empty means
+// refresh)
+func parseUnknownBufferPolicy(s string) (unknownBufferPolicy, error) {
+	switch unknownBufferPolicy(strings.ToLower(s)) {
+	case "", unknownBufferRefresh:
+		return unknownBufferRefresh, nil
+	case unknownBufferNotice:
+		return unknownBufferNotice, nil
+	case unknownBufferIgnore:
+		return unknownBufferIgnore, nil
+	default:
+		return "", fmt.Errorf("unknown buffer policy %q (want refresh, notice or ignore)", s)
+	}
+}
+
+// staleClient is what a relay client did with unknown buffer pointers
+type staleClient struct {
+	misses    []time.Time // Unknown pointers within staleWindow
+	refreshed time.Time   // Last buffer list sent for one
+}
+
+// staleClients tracks the relay clients referring to buffer pointers the
+// bridge doesn't know
+type staleClients struct {
+	mu      sync.Mutex
+	clients map[*weechat.Client]*staleClient
+}
+
+func newStaleClients() *staleClients {
+	return &staleClients{clients: make(map[*weechat.Client]*staleClient)}
+}
+
+// get returns the record of a client, creating it. Caller must hold mu.
+func (s *staleClients) get(client *weechat.Client) *staleClient {
+	c := s.clients[client]
+	if c == nil {
+		c = &staleClient{}
+		s.clients[client] = c
+	}
+	return c
+}
+
+// miss records an unknown pointer from a client and reports whether it made
+// staleResyncMisses of them within staleWindow; the count then starts over
+func (s *staleClients) miss(client *weechat.Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.get(client)
+	now := time.Now()
+	recent := c.misses[:0]
+	for _, t := range c.misses {
+		if now.Sub(t) < staleWindow {
+			recent = append(recent, t)
+		}
+	}
+	c.misses = append(recent, now)
+
+	if len(c.misses) < staleResyncMisses {
+		return false
+	}
+	c.misses = nil
+	c.refreshed = now
+	return true
+}
+
+// allowRefresh reports whether a client may be sent the buffer list now,
+// and records it if so
+func (s *staleClients) allowRefresh(client *weechat.Client) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.get(client)
+	now := time.Now()
+	if now.Sub(c.refreshed) < minBufferRefreshInterval {
+		return false
+	}
+	c.refreshed = now
+	return true
+}
+
+// forget drops a disconnected client
+func (s *staleClients) forget(client *weechat.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, client)
+}
+
+// handleUnknownBufferInput reacts to input for a buffer pointer the bridge
+// doesn't know according to the configured policy. The notice goes to the
+// stale pointer, which the client still shows as a buffer.
+func (b *Bridge) handleUnknownBufferInput(client *weechat.Client, bufferPtr string) {
+	b.log.Warnf("Input for unknown buffer %s dropped", bufferPtr)
+	if b.unknownBuffer == unknownBufferIgnore {
+		return
+	}
+
+	b.sendLocalNotice(client, bufferPtr, "Message not sent: the bridge doesn't know this buffer (restarted?). Reopen it from the buffer list.")
+	if b.unknownBuffer != unknownBufferRefresh {
+		return
+	}
+
+	if b.staleMiss(client, bufferPtr) {
+		b.resyncStaleClient(client)
+	} else if b.staleClients.allowRefresh(client) {
+		b.log.Infof("Sending the buffer list again to a client with stale buffer %s", bufferPtr)
+		b.sendBufferList(client)
+	}
+}
+
+// handleUnknownBufferRequest counts a request for the lines or nicklist of
+// a buffer pointer the bridge doesn't know. One can be a buffer closed meanwhile;
+// repeated ones resync the client.
+func (b *Bridge) handleUnknownBufferRequest(client *weechat.Client, bufferPtr string) {
+	b.log.Debugf("Request for unknown buffer %s", bufferPtr)
+	if b.unknownBuffer == unknownBufferRefresh && b.staleMiss(client, bufferPtr) {
+		b.resyncStaleClient(client)
+	}
+}
+
+// staleMiss counts a buffer pointer of a client towards a resync, see
+// staleClients.miss. Pointers of buffers the bridge has, such as server and
+// core buffers, are never counted.
+func (b *Bridge) staleMiss(client *weechat.Client, bufferPtr string) bool {
+	if b.translator.BufferKnown(bufferPtr) {
+		return false
+	}
+	return b.staleClients.miss(client)
+}
+
+// resyncStaleClient brings a client that keeps using unknown buffer
+// pointers back in line: clients that synced upgrades get _upgrade and
+// _upgrade_ended, as if the bridge had reloaded, and drop their buffers to
+// fetch them again; others get the buffer list as _buffer_opened.
+func (b *Bridge) resyncStaleClient(client *weechat.Client) {
+	if !client.Synced("*", weechat.SyncUpgrade) {
+		b.log.Warnf("Client keeps using unknown buffers, sending it the buffer list again")
+		b.sendBufferList(client)
+		return
+	}
+
+	b.log.Warnf("Client keeps using unknown buffers, resyncing it with _upgrade")
+	for _, id := range []string{"_upgrade", "_upgrade_ended"} {
+		if err := client.SendMessage(weechatproto.CreateEventMessage(id)); err != nil {
+			b.log.Errorf("Failed to send %s: %v", id, err)
+			return
+		}
+	}
+}
+
+// sendBufferList sends a client its buffers as _buffer_opened
+func (b *Bridge) sendBufferList(client *weechat.Client) {
+	msg := b.translator.GetAllBuffers("_buffer_opened", b.clientView(client))
+	if err := client.SendMessage(msg); err != nil {
+		b.log.Errorf("Failed to send buffer list: %v", err)
+	}
+}
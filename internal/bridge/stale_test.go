@@ -0,0 +1,42 @@
+package bridge
+
+import (
+	"testing"
+	"time"
+)
+
+func TestServerAndCoreBuffersNeverResync(t *testing.T) {
+	tb := startTestBridge(t, Config{})
+	client := tb.dialRelay(t)
+	pointers := client.init("core.weechat", "libera")
+	client.send("sync")
+
+	for i := 0; i < 2*staleResyncMisses; i++ {
+		client.send("input %s /away", pointers["libera"])
+		client.send("input %s hello", pointers["core.weechat"])
+		client.send("(lines) hdata buffer:%s/own_lines/last_line(-10)/data message", pointers["libera"])
+		client.send("(nicks) nicklist %s", pointers["core.weechat"])
+	}
+
+	for _, msg := range client.collect(500 * time.Millisecond) {
+		switch msg.ID {
+		case "_upgrade", "_upgrade_ended":
+			t.Fatalf("known buffers resynced the client with %s", msg.ID)
+		case "_buffer_opened":
+			t.Fatalf("known buffers resent the buffer list")
+		}
+	}
+}
+
+func TestUnknownBuffersResync(t *testing.T) {
+	tb := startTestBridge(t, Config{})
+	client := tb.dialRelay(t)
+	client.init()
+	client.send("sync")
+
+	for i := 0; i < staleResyncMisses; i++ {
+		client.send("input 0xdeadbeef hello")
+	}
+	client.next(withID("_upgrade"))
+	client.next(withID("_upgrade_ended"))
+}
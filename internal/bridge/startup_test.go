@@ -0,0 +1,104 @@
+package bridge
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// lithStartup is the sequence Lith sends right after init, with its IDs
+var lithStartup = []struct {
+	id      string
+	command string
+	path    string // hdata path of the reply
+}{
+	{"listbuffers", "hdata buffer:gui_buffers(*) number,name,hidden,title,local_variables", "buffer"},
+	{"listlines", "hdata buffer:gui_buffers(*)/own_lines/last_line(-50)/data date,displayed,prefix,message,highlight,notify,tags_array", "line_data"},
+	{"nicklist", "nicklist", "buffer/nicklist_item"},
+	{"hotlist", "hdata hotlist:gui_hotlist(*) buffer,count", "hotlist"},
+}
+
+// TestLithStartupSequence sends Lith's startup requests right after init,
+// while the state dump is still arriving, and checks they are answered in
+// order, with their IDs, from the complete state
+func TestLithStartupSequence(t *testing.T) {
+	tb := startTestBridge(t, Config{})
+	client := tb.dialRelay(t)
+
+	client.send("(handshake) handshake password_hash_algo=plain,compression=off")
+	client.next(withID("handshake"))
+	client.send("init password=,compression=off")
+	for _, req := range lithStartup {
+		client.send("(%s) %s", req.id, req.command)
+	}
+	client.send("sync")
+
+	replies := make(map[string]*weechatproto.Message)
+	for _, req := range lithStartup {
+		// Broadcasts (core buffer lines, _buffer_* events) may come in
+		// between, replies to later requests may not
+		msg := client.next(func(msg *weechatproto.Message) bool {
+			return msg.ID != "" && !strings.HasPrefix(msg.ID, "_")
+		})
+		if msg.ID != req.id {
+			t.Fatalf("got reply %q while expecting %q", msg.ID, req.id)
+		}
+		h, ok := hdataOf(msg)
+		if !ok || h.Path != req.path {
+			t.Fatalf("%s reply has hdata path %q, want %q", req.id, h.Path, req.path)
+		}
+		replies[req.id] = msg
+	}
+
+	pointers := bufferPointers(replies["listbuffers"])
+	for _, name := range []string{"core.weechat", "libera", "libera.#go"} {
+		if pointers[name] == "" {
+			t.Errorf("buffer list lacks %s: %v", name, pointers)
+		}
+	}
+
+	nicks := make(map[string]bool)
+	h, _ := hdataOf(replies["nicklist"])
+	for _, item := range h.Items {
+		if len(item.Pointers) == 2 && item.Pointers[0] == pointers["libera.#go"] {
+			if group, _ := item.Objects["group"].(weechatproto.Char); group.Value == 0 {
+				nicks[weechatproto.ObjectString(item.Objects["name"])] = true
+			}
+		}
+	}
+	if !nicks["tester"] || !nicks["alice"] {
+		t.Errorf("nicklist of libera.#go has %v, want tester and alice", nicks)
+	}
+
+	// The backlog of the fake erssi leaves a message and a highlight unread
+	h, _ = hdataOf(replies["hotlist"])
+	if len(h.Items) != 1 {
+		t.Fatalf("hotlist has %d entries, want libera.#go only", len(h.Items))
+	}
+	entry := h.Items[0]
+	if buffer := weechatproto.ObjectString(entry.Objects["buffer"]); buffer != pointers["libera.#go"] {
+		t.Errorf("hotlist entry for buffer %s, want libera.#go (%s)", buffer, pointers["libera.#go"])
+	}
+	if priority, _ := entry.Objects["priority"].(weechatproto.Integer); priority.Value != 3 {
+		t.Errorf("hotlist priority %d, want 3 (highlight)", priority.Value)
+	}
+	var counts []int32
+	if count, ok := entry.Objects["count"].(weechatproto.Array); ok {
+		for _, v := range count.Values {
+			n, _ := v.(weechatproto.Integer)
+			counts = append(counts, n.Value)
+		}
+	}
+	if fmt.Sprint(counts) != "[0 1 0 1]" {
+		t.Errorf("hotlist counts %v, want [0 1 0 1] (a message and a highlight)", counts)
+	}
+
+	// Clients mark a buffer read with /buffer set hotlist -1
+	client.send("input %s /buffer set hotlist -1", pointers["libera.#go"])
+	client.send("(hotlist) hdata hotlist:gui_hotlist(*) buffer,count")
+	if h, _ := hdataOf(client.next(withID("hotlist"))); len(h.Items) != 0 {
+		t.Errorf("hotlist has %d entries after marking libera.#go read", len(h.Items))
+	}
+}
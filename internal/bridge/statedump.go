@@ -0,0 +1,276 @@
+package bridge
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// defaultDumpQuietPeriod is how long a server may stay silent before its
+// state dump is considered complete. fe-web has no end-of-dump marker: it
+// sends state_dump followed by channel_join/nicklist/topic bursts.
+const defaultDumpQuietPeriod = 2 * time.Second
+
+// dumpPhase is the state of a server's state dump
+type dumpPhase int
+
+const (
+	dumpIdle       dumpPhase = iota // No dump received (or cancelled)
+	dumpInProgress                  // state_dump received, burst still arriving
+	dumpComplete                    // Burst finished
+)
+
+func (p dumpPhase) String() string {
+	switch p {
+	case dumpInProgress:
+		return "in-progress"
+	case dumpComplete:
+		return "complete"
+	default:
+		return "idle"
+	}
+}
+
+// serverDump tracks the dump of a single server tag
+type serverDump struct {
+	phase      dumpPhase
+	generation int // Incremented on every (re)start, guards stale timers
+	started    time.Time
+	channels   map[string]struct{}
+	timer      *time.Timer
+}
+
+// stateDumpTracker models state dump processing as a state machine per
+// server tag: idle -> in-progress -> complete. A dump ends when another
+// server's dump starts or when the server stays quiet for quietPeriod.
+// A second state_dump for a server restarts its dump from scratch.
+type stateDumpTracker struct {
+	mu          sync.Mutex
+	servers     map[string]*serverDump
+	quietPeriod time.Duration
+	log         *logrus.Entry
+
+	// onComplete is called (without the lock held) when a dump finishes,
+	// with the channels it listed (lowercase)
+	onComplete func(serverTag string, channels map[string]struct{})
+
+	// Closed once every started dump has completed
+	settledWaiters []chan struct{}
+}
+
+func newStateDumpTracker(log *logrus.Entry, quietPeriod time.Duration) *stateDumpTracker {
+	if quietPeriod <= 0 {
+		quietPeriod = defaultDumpQuietPeriod
+	}
+	return &stateDumpTracker{
+		servers:     make(map[string]*serverDump),
+		quietPeriod: quietPeriod,
+		log:         log,
+	}
+}
+
+// Begin starts (or restarts) the dump of a server. Any other server still in
+// progress is completed, since fe-web dumps servers one after another.
+func (t *stateDumpTracker) Begin(serverTag string) {
+	var finished []finishedDump
+
+	t.mu.Lock()
+	for tag, d := range t.servers {
+		if tag != serverTag && d.phase == dumpInProgress {
+			finished = append(finished, t.completeLocked(tag, d))
+		}
+	}
+
+	d, ok := t.servers[serverTag]
+	if !ok {
+		d = &serverDump{}
+		t.servers[serverTag] = d
+	}
+
+	switch d.phase {
+	case dumpInProgress:
+		t.log.Warnf("State dump for %s restarted before completing (%d channels so far), discarding progress",
+			serverTag, len(d.channels))
+	case dumpComplete:
+		t.log.Infof("State dump for %s received again, resynchronizing", serverTag)
+	}
+
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.phase = dumpInProgress
+	d.generation++
+	d.started = time.Now()
+	d.channels = make(map[string]struct{})
+	t.armLocked(serverTag, d)
+	t.mu.Unlock()
+
+	t.notify(finished)
+}
+
+// InProgress reports whether a dump is running for the server
+func (t *stateDumpTracker) InProgress(serverTag string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.servers[serverTag]
+	return ok && d.phase == dumpInProgress
+}
+
+// Phase returns the current dump phase of a server
+func (t *stateDumpTracker) Phase(serverTag string) dumpPhase {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if d, ok := t.servers[serverTag]; ok {
+		return d.phase
+	}
+	return dumpIdle
+}
+
+// Touch records dump activity for a server, extending its quiet period.
+// channel may be empty for activity that doesn't name a channel.
+func (t *stateDumpTracker) Touch(serverTag, channel string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, ok := t.servers[serverTag]
+	if !ok || d.phase != dumpInProgress {
+		return
+	}
+
+	if channel != "" {
+		d.channels[channel] = struct{}{}
+	}
+	t.armLocked(serverTag, d)
+}
+
+// Reset cancels the dumps of every server whose tag starts with prefix
+// (e.g. when an erssi connection drops; "" matches all servers)
+func (t *stateDumpTracker) Reset(prefix string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for tag, d := range t.servers {
+		if !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		if d.timer != nil {
+			d.timer.Stop()
+		}
+		if d.phase == dumpInProgress {
+			t.log.Warnf("State dump for %s cancelled", tag)
+		}
+		d.phase = dumpIdle
+		d.generation++
+	}
+}
+
+// armLocked (re)starts the quiet-period timer of a dump
+func (t *stateDumpTracker) armLocked(serverTag string, d *serverDump) {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+
+	generation := d.generation
+	d.timer = time.AfterFunc(t.quietPeriod, func() {
+		t.mu.Lock()
+		if d.phase != dumpInProgress || d.generation != generation {
+			t.mu.Unlock()
+			return
+		}
+		done := t.completeLocked(serverTag, d)
+		t.mu.Unlock()
+
+		t.notify([]finishedDump{done})
+	})
+}
+
+type finishedDump struct {
+	serverTag string
+	channels  map[string]struct{}
+}
+
+// completeLocked marks a dump complete
+func (t *stateDumpTracker) completeLocked(serverTag string, d *serverDump) finishedDump {
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	d.phase = dumpComplete
+
+	t.log.Infof("State dump for %s complete: %d channels in %s",
+		serverTag, len(d.channels), time.Since(d.started).Round(time.Millisecond))
+
+	return finishedDump{serverTag: serverTag, channels: d.channels}
+}
+
+// settledLocked reports whether at least one dump completed and none is running
+func (t *stateDumpTracker) settledLocked() bool {
+	complete := false
+	for _, d := range t.servers {
+		switch d.phase {
+		case dumpInProgress:
+			return false
+		case dumpComplete:
+			complete = true
+		}
+	}
+	return complete
+}
+
+// Active reports whether any state dump is in progress
+func (t *stateDumpTracker) Active() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range t.servers {
+		if d.phase == dumpInProgress {
+			return true
+		}
+	}
+	return false
+}
+
+// WaitSettled blocks until every started dump has completed (and at least one
+// did), or the timeout expires. Returns false on timeout.
+func (t *stateDumpTracker) WaitSettled(timeout time.Duration) bool {
+	t.mu.Lock()
+	if t.settledLocked() {
+		t.mu.Unlock()
+		return true
+	}
+	ch := make(chan struct{})
+	t.settledWaiters = append(t.settledWaiters, ch)
+	t.mu.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (t *stateDumpTracker) notify(finished []finishedDump) {
+	if len(finished) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	if t.settledLocked() {
+		for _, ch := range t.settledWaiters {
+			close(ch)
+		}
+		t.settledWaiters = nil
+	}
+	t.mu.Unlock()
+
+	if t.onComplete == nil {
+		return
+	}
+	for _, f := range finished {
+		t.onComplete(f.serverTag, f.channels)
+	}
+}
@@ -0,0 +1,87 @@
+package bridge
+
+import (
+	"fmt"
+	"time"
+
+	"erssi-lith-bridge/internal/erssi"
+	"erssi-lith-bridge/internal/weechat"
+)
+
+// UpstreamHealth is a snapshot of one erssi connection, for telling which
+// irssi instance misbehaves when several are aggregated
+type UpstreamHealth struct {
+	Name  string // Upstream name, "" for a lone erssi
+	URL   string // URL in use, a standby one during failover
+	State erssi.State
+	Lag   erssi.Lag // Zero At if never measured
+	Stats erssi.Stats
+}
+
+// UpstreamHealth returns the health of every erssi upstream, in
+// configuration order
+func (b *Bridge) UpstreamHealth() []UpstreamHealth {
+	health := make([]UpstreamHealth, 0, len(b.upstreams))
+	for _, u := range b.upstreams {
+		health = append(health, UpstreamHealth{
+			Name:  u.name,
+			URL:   u.client.ActiveURL(),
+			State: u.client.State(),
+			Lag:   u.client.Lag(),
+			Stats: u.client.Stats(),
+		})
+	}
+	return health
+}
+
+// handleStatsCommand shows the health of every upstream and the relay
+// totals
+func (b *Bridge) handleStatsCommand(client *weechat.Client, bufferPtr string) {
+	for i, h := range b.UpstreamHealth() {
+		line := fmt.Sprintf("stats: %s %s (%s)", b.upstreams[i].describe(), h.State, h.URL)
+
+		if h.Lag.At.IsZero() {
+			line += ", lag not measured"
+		} else {
+			line += fmt.Sprintf(", lag %s", formatLag(h.Lag.Erssi))
+		}
+
+		if h.Stats.LastMessage.IsZero() {
+			line += ", no message yet"
+		} else {
+			line += fmt.Sprintf(", last message %s ago", time.Since(h.Stats.LastMessage).Round(time.Second))
+		}
+
+		line += fmt.Sprintf(", %d reconnects, %d messages in, %d out", h.Stats.Reconnects, h.Stats.MessagesIn, h.Stats.MessagesOut)
+		if h.Stats.QueueLength > 0 {
+			line += fmt.Sprintf(", %d queued", h.Stats.QueueLength)
+		}
+		if h.Stats.Paced > 0 {
+			line += fmt.Sprintf(", %d messages paced", h.Stats.Paced)
+		}
+		if h.Stats.Gaps > 0 {
+			line += fmt.Sprintf(", %d gaps", h.Stats.Gaps)
+		}
+		b.sendLocalNotice(client, bufferPtr, line)
+	}
+
+	relay := b.weechatServer.Stats()
+	line := fmt.Sprintf("stats: relay %d clients, %s sent, %s received",
+		b.weechatServer.AuthenticatedClients(), formatBytes(relay.BytesSent), formatBytes(relay.BytesReceived))
+	if relay.MessagesCompressed > 0 {
+		line += ", " + compressionSummary(relay.MessagesCompressed, relay.MessagesSent, relay.MessageBytes, relay.MessageBytesSent)
+	}
+	if relay.SlowClientsClosed > 0 {
+		line += fmt.Sprintf(", %d slow clients disconnected (%d messages dropped)", relay.SlowClientsClosed, relay.MessagesDropped)
+	}
+	if relay.ClientsTimedOut > 0 {
+		line += fmt.Sprintf(", %d clients timed out", relay.ClientsTimedOut)
+	}
+	if relay.AuthFailures > 0 {
+		line += fmt.Sprintf(", %d failed logins (%d addresses banned)", relay.AuthFailures, relay.AddressesBanned)
+	}
+	if relay.ConnectionsRejected > 0 {
+		line += fmt.Sprintf(", %d connections rejected", relay.ConnectionsRejected)
+	}
+	b.sendLocalNotice(client, bufferPtr, line)
+}
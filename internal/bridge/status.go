@@ -0,0 +1,81 @@
+package bridge
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"erssi-lith-bridge/internal/erssi"
+)
+
+// startStatusProbe opens the status listener, if configured. It answers
+// any request with "ok" while every erssi upstream is connected and
+// "degraded" otherwise, for uptime monitors: it tells nothing else, so it
+// needs no authentication and stays apart from the relay.
+func (b *Bridge) startStatusProbe() error {
+	if b.statusAddr == "" {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", b.statusAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for status probes: %w", err)
+	}
+
+	b.statusListener = listener
+	b.statusServer = &http.Server{
+		Handler:           http.HandlerFunc(b.handleStatusProbe),
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      5 * time.Second,
+	}
+
+	b.log.Infof("Status probe listening on %s", listener.Addr())
+
+	go func() {
+		if err := b.statusServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			b.log.Errorf("Status probe error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// stopStatusProbe closes the status listener
+func (b *Bridge) stopStatusProbe() {
+	if b.statusServer == nil {
+		return
+	}
+	if err := b.statusServer.Close(); err != nil {
+		b.log.Errorf("Error closing status probe: %v", err)
+	}
+	b.statusServer = nil
+}
+
+// StatusAddr returns the address of the status listener, "" if disabled
+func (b *Bridge) StatusAddr() string {
+	if b.statusListener == nil {
+		return ""
+	}
+	return b.statusListener.Addr().String()
+}
+
+// handleStatusProbe answers a status probe
+func (b *Bridge) handleStatusProbe(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	status, code := "ok", http.StatusOK
+	for _, u := range b.upstreams {
+		if u.client.State() != erssi.StateAuthenticated {
+			status, code = "degraded", http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.WriteHeader(code)
+	if r.Method != http.MethodHead {
+		fmt.Fprintln(w, status)
+	}
+}
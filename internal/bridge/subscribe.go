@@ -0,0 +1,94 @@
+package bridge
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// subscription is one "server/channel" pattern of Config.Subscribe
+type subscription struct {
+	server  *regexp.Regexp
+	channel *regexp.Regexp
+}
+
+// subscriptions selects the servers and channels the bridge follows.
+// A nil *subscriptions follows everything.
+type subscriptions struct {
+	patterns []subscription
+}
+
+// parseSubscriptions parses "server/channel" patterns with * and ?
+// wildcards, case-insensitive. The channel part starts at the first "/"
+// followed by a channel prefix or wildcard, so namespaced tags work
+// ("home/libera/#go*"); without one the whole server is followed
+// ("liberachat" = "liberachat/*"). No patterns = everything.
+func parseSubscriptions(patterns []string) (*subscriptions, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+
+	subs := &subscriptions{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		server, channel := splitSubscription(pattern)
+		if server == "" || channel == "" {
+			return nil, fmt.Errorf("invalid subscription %q (want server or server/channel)", pattern)
+		}
+		subs.patterns = append(subs.patterns, subscription{
+			server:  globPattern(server),
+			channel: globPattern(channel),
+		})
+	}
+	return subs, nil
+}
+
+// splitSubscription splits a pattern into its server and channel globs
+func splitSubscription(pattern string) (server, channel string) {
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '/' || i+1 >= len(pattern) {
+			continue
+		}
+		if strings.ContainsRune("#&!+*?", rune(pattern[i+1])) {
+			return pattern[:i], pattern[i+1:]
+		}
+	}
+	return pattern, "*"
+}
+
+// globPattern compiles a * and ? wildcard pattern
+func globPattern(glob string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+	pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+	return regexp.MustCompile(`(?i)^` + pattern + `$`)
+}
+
+// allows reports whether the bridge follows a server's target. An empty
+// target stands for the server itself, followed when any of its channels is.
+func (s *subscriptions) allows(serverTag, target string) bool {
+	if s == nil {
+		return true
+	}
+
+	for _, p := range s.patterns {
+		if !p.server.MatchString(serverTag) {
+			continue
+		}
+		if target == "" || p.channel.MatchString(target) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsMessage reports whether an incoming erssi message concerns a
+// followed server or channel
+func (s *subscriptions) allowsMessage(msg *erssiproto.WebMessage) bool {
+	if s == nil || msg.ServerTag == "" {
+		return true
+	}
+	return s.allows(msg.ServerTag, msg.Target)
+}
@@ -0,0 +1,49 @@
+package bridge
+
+import (
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// relayUpgrade tracks an _upgrade sent to relay clients while the bridge
+// reloads erssi state. WeeChat sends _upgrade before /upgrade and
+// _upgrade_ended after it; clients then drop their buffers and fetch them
+// again, instead of showing stale ones until the user refreshes.
+type relayUpgrade struct {
+	mu     sync.Mutex
+	active bool
+	timer  *time.Timer // Ends an upgrade whose state dump never settles
+}
+
+// beginRelayUpgrade tells relay clients that the state is being reloaded,
+// unless an upgrade is already running
+func (b *Bridge) beginRelayUpgrade() {
+	b.upgrade.mu.Lock()
+	if b.upgrade.active {
+		b.upgrade.mu.Unlock()
+		return
+	}
+	b.upgrade.active = true
+	b.upgrade.timer = time.AfterFunc(b.stateDumpTimeout, b.endRelayUpgrade)
+	b.upgrade.mu.Unlock()
+
+	b.log.Debug("Sending _upgrade to relay clients")
+	b.weechatServer.BroadcastMessage(weechatproto.CreateEventMessage("_upgrade"))
+}
+
+// endRelayUpgrade tells relay clients to re-sync after beginRelayUpgrade
+func (b *Bridge) endRelayUpgrade() {
+	b.upgrade.mu.Lock()
+	if !b.upgrade.active {
+		b.upgrade.mu.Unlock()
+		return
+	}
+	b.upgrade.active = false
+	b.upgrade.timer.Stop()
+	b.upgrade.mu.Unlock()
+
+	b.log.Debug("Sending _upgrade_ended to relay clients")
+	b.weechatServer.BroadcastMessage(weechatproto.CreateEventMessage("_upgrade_ended"))
+}
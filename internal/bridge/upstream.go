@@ -0,0 +1,340 @@
+package bridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/erssi"
+	"erssi-lith-bridge/pkg/erssiproto"
+
+	"github.com/sirupsen/logrus"
+)
+
+// upstreamCloseTimeout bounds how long shutdown waits for erssi writes
+const upstreamCloseTimeout = 5 * time.Second
+
+// upstreamSeparator joins an upstream name and an erssi server tag
+// ("home/libera") when several erssi instances are aggregated
+const upstreamSeparator = "/"
+
+// UpstreamConfig describes one erssi instance when aggregating several
+type UpstreamConfig struct {
+	Name     string   // Namespace for the instance's server tags
+	URL      string   // Primary erssi URL
+	Failover []string // Standby URLs tried in order when the primary is down
+	Password string
+}
+
+// upstream is one erssi connection. With several upstreams every server tag
+// is namespaced with the upstream name so buffers from different irssi
+// instances never collide; with a single one tags are left untouched.
+type upstream struct {
+	name   string
+	prefix string // "name/" or "" for a lone upstream
+	client *erssi.Client
+	log    *logrus.Entry
+
+	// Which side lags beyond Config.LagWarn ("" = none), see lag.go
+	lagMu   sync.Mutex
+	lagging string
+}
+
+// newUpstreams creates the erssi clients described by cfg
+func newUpstreams(cfg Config, logger *logrus.Logger) ([]*upstream, error) {
+	upstreamCfgs := cfg.Upstreams
+	if len(upstreamCfgs) == 0 {
+		urls := splitURLs(cfg.ErssiURL)
+		if len(urls) == 0 {
+			return nil, fmt.Errorf("no erssi URL configured")
+		}
+		upstreamCfgs = []UpstreamConfig{{URL: urls[0], Failover: urls[1:], Password: cfg.ErssiPassword}}
+	}
+
+	overflow, err := erssi.ParseOverflowPolicy(cfg.SendQueueOverflow)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := erssi.ParsePlaintextPolicy(cfg.ErssiPlaintext)
+	if err != nil {
+		return nil, err
+	}
+	throttles, err := erssi.ParseThrottleProfiles(cfg.Throttle)
+	if err != nil {
+		return nil, err
+	}
+
+	multi := len(upstreamCfgs) > 1
+	seen := make(map[string]bool)
+	upstreams := make([]*upstream, 0, len(upstreamCfgs))
+
+	for _, uc := range upstreamCfgs {
+		if multi {
+			if uc.Name == "" {
+				return nil, fmt.Errorf("upstream %s needs a name", uc.URL)
+			}
+			if strings.ContainsAny(uc.Name, "./ ") {
+				return nil, fmt.Errorf("upstream name %q must not contain '.', '/' or spaces", uc.Name)
+			}
+			if seen[uc.Name] {
+				return nil, fmt.Errorf("duplicate upstream name %q", uc.Name)
+			}
+			seen[uc.Name] = true
+		}
+
+		u := &upstream{
+			name: uc.Name,
+			client: erssi.NewClient(erssi.Config{
+				Name:     uc.Name,
+				URL:      uc.URL,
+				Failover: uc.Failover,
+				Password: uc.Password,
+				Logger:   logger,
+				Reconnect: erssi.ReconnectPolicy{
+					Enabled:    cfg.Reconnect,
+					MaxRetries: cfg.ReconnectMaxRetries,
+				},
+				Keepalive: erssi.KeepalivePolicy{
+					Interval: cfg.KeepaliveInterval,
+				},
+				LagInterval: cfg.LagCheckInterval,
+				TLS: erssi.TLSConfig{
+					CAFile:     cfg.ErssiCAFile,
+					CertFile:   cfg.ErssiCertFile,
+					KeyFile:    cfg.ErssiKeyFile,
+					ServerName: cfg.ErssiServerName,
+					Insecure:   cfg.ErssiInsecure,
+				},
+				Queue: erssi.QueueConfig{
+					Size:     cfg.SendQueueSize,
+					Overflow: overflow,
+				},
+				Validate:    cfg.ValidateErssi,
+				Compression: cfg.ErssiCompression,
+				Plaintext:   plaintext,
+				Chaos:       cfg.ChaosErssi,
+				RateLimit: erssi.RateLimitConfig{
+					Rate:     cfg.RequestRate,
+					Burst:    cfg.RequestBurst,
+					Debounce: cfg.NicklistDebounce,
+				},
+				Throttles: throttles,
+			}),
+			log: logger.WithField("component", "bridge"),
+		}
+		if multi {
+			u.prefix = uc.Name + upstreamSeparator
+			u.log = u.log.WithField("upstream", uc.Name)
+		}
+
+		upstreams = append(upstreams, u)
+	}
+
+	return upstreams, nil
+}
+
+// splitURLs splits a comma-separated URL list (primary first)
+func splitURLs(list string) []string {
+	var urls []string
+	for _, url := range strings.Split(list, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// owns reports whether a (namespaced) server tag belongs to this upstream
+func (u *upstream) owns(serverTag string) bool {
+	return u.prefix == "" || strings.HasPrefix(serverTag, u.prefix)
+}
+
+// localTag strips the upstream namespace from a server tag
+func (u *upstream) localTag(serverTag string) string {
+	return strings.TrimPrefix(serverTag, u.prefix)
+}
+
+// namespace rewrites the server tags of an incoming message
+func (u *upstream) namespace(msg *erssiproto.WebMessage) {
+	if u.prefix == "" {
+		return
+	}
+
+	if msg.ServerTag != "" {
+		msg.ServerTag = u.prefix + msg.ServerTag
+	}
+	if msg.Server != "" && msg.Server != "*" {
+		msg.Server = u.prefix + msg.Server
+	}
+
+	if msg.Type == erssiproto.StateDump {
+		u.namespaceStateDump(msg)
+	}
+}
+
+// namespaceStateDump rewrites the server tags embedded in a state dump
+// payload. A JSON payload in Text is moved to ExtraData on the way.
+func (u *upstream) namespaceStateDump(msg *erssiproto.WebMessage) {
+	if len(msg.ExtraData) == 0 && msg.Text != "" {
+		var data map[string]interface{}
+		if err := json.Unmarshal([]byte(msg.Text), &data); err == nil {
+			msg.ExtraData = data
+			msg.Text = ""
+		}
+	}
+
+	prefixTag := func(server map[string]interface{}) {
+		if tag, ok := server["tag"].(string); ok && tag != "" {
+			server["tag"] = u.prefix + tag
+		}
+	}
+
+	prefixTag(msg.ExtraData)
+	if servers, ok := msg.ExtraData["servers"].([]interface{}); ok {
+		for _, item := range servers {
+			if server, ok := item.(map[string]interface{}); ok {
+				prefixTag(server)
+			}
+		}
+	}
+}
+
+// upstreamFor returns the upstream owning a server tag
+func (b *Bridge) upstreamFor(serverTag string) (*upstream, error) {
+	for _, u := range b.upstreams {
+		if u.owns(serverTag) {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("no erssi upstream for server %q", serverTag)
+}
+
+// erssiSendTimeout bounds a send to erssi, so a hung upstream can't hold
+// up the relay commands waiting on it
+const erssiSendTimeout = 10 * time.Second
+
+// sendContext returns the context of a send to erssi
+func sendContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), erssiSendTimeout)
+}
+
+// sendToErssi routes a message to the upstream owning its server tag
+func (b *Bridge) sendToErssi(msg *erssiproto.WebMessage) error {
+	u, err := b.upstreamFor(msg.ServerTag)
+	if err != nil {
+		return err
+	}
+
+	out := *msg
+	out.ServerTag = u.localTag(msg.ServerTag)
+
+	ctx, cancel := sendContext()
+	defer cancel()
+	return u.client.SendContext(ctx, &out)
+}
+
+// callErssi sends a request to the upstream owning its server tag and
+// returns the correlated response with server tags namespaced
+func (b *Bridge) callErssi(ctx context.Context, msg *erssiproto.WebMessage) (*erssiproto.WebMessage, error) {
+	u, err := b.upstreamFor(msg.ServerTag)
+	if err != nil {
+		return nil, err
+	}
+
+	out := *msg
+	out.ServerTag = u.localTag(msg.ServerTag)
+	resp, err := u.client.Call(ctx, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	u.namespace(resp)
+	return resp, nil
+}
+
+// requestNicklist asks the owning upstream for a channel's nicklist
+func (b *Bridge) requestNicklist(serverTag, target string) error {
+	u, err := b.upstreamFor(serverTag)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := sendContext()
+	defer cancel()
+	return u.client.RequestNicklist(ctx, u.localTag(serverTag), target)
+}
+
+// requestStateDumps asks every upstream for its state
+func (b *Bridge) requestStateDumps() error {
+	ctx, cancel := sendContext()
+	defer cancel()
+
+	var errs []error
+	for _, u := range b.upstreams {
+		if err := u.client.RequestStateDump(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", u.describe(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// connectUpstreams connects every upstream, closing them all on failure
+func (b *Bridge) connectUpstreams() error {
+	for _, u := range b.upstreams {
+		if err := u.client.Connect(context.Background()); err != nil {
+			b.closeUpstreams()
+			return fmt.Errorf("failed to connect to %s: %w", u.describe(), err)
+		}
+	}
+	return nil
+}
+
+// closeUpstreams closes every upstream connection, giving pending writes
+// upstreamCloseTimeout to finish
+func (b *Bridge) closeUpstreams() {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamCloseTimeout)
+	defer cancel()
+
+	for _, u := range b.upstreams {
+		if err := u.client.Close(ctx); err != nil {
+			u.log.Errorf("Error closing erssi client: %v", err)
+		}
+	}
+}
+
+// UpstreamStatus describes the link to one erssi instance
+type UpstreamStatus struct {
+	Name     string // Empty for a lone upstream
+	URL      string // URL of the current (or last) connection
+	State    erssi.State
+	Stats    erssi.Stats
+	Protocol erssi.Protocol // Negotiated with fe-web
+	Lag      erssi.Lag      // Last lag measurement (zero if lag checks are off)
+}
+
+// UpstreamStatus reports the link state of every erssi upstream
+func (b *Bridge) UpstreamStatus() []UpstreamStatus {
+	status := make([]UpstreamStatus, len(b.upstreams))
+	for i, u := range b.upstreams {
+		status[i] = UpstreamStatus{
+			Name:     u.name,
+			URL:      u.client.ActiveURL(),
+			State:    u.client.State(),
+			Stats:    u.client.Stats(),
+			Protocol: u.client.Protocol(),
+			Lag:      u.client.Lag(),
+		}
+	}
+	return status
+}
+
+// describe names the upstream for log and error messages
+func (u *upstream) describe() string {
+	if u.name == "" {
+		return "erssi"
+	}
+	return "erssi upstream " + u.name
+}
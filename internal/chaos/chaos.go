@@ -0,0 +1,280 @@
+// Package chaos injects artificial latency, drops and reordering into a
+// message stream, so resilience features (send queues, resync, dedup) can
+// be exercised against a realistically bad link. It is a test and ops tool;
+// nothing is injected unless configured.
+package chaos
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config describes the faults injected into one stream
+type Config struct {
+	Latency time.Duration // Added to every message
+	Jitter  time.Duration // Random extra delay, 0..Jitter
+	Drop    float64       // Probability a message is lost
+	Reorder float64       // Probability a message is held back and overtaken
+	Seed    uint64        // Random seed (0 = random), for reproducible runs
+}
+
+// Enabled reports whether the config injects anything
+func (c Config) Enabled() bool {
+	return c.Latency > 0 || c.Jitter > 0 || c.Drop > 0 || c.Reorder > 0
+}
+
+func (c Config) String() string {
+	return fmt.Sprintf("latency=%s jitter=%s drop=%g reorder=%g", c.Latency, c.Jitter, c.Drop, c.Reorder)
+}
+
+// Parse parses a spec like "latency=200ms,jitter=50ms,drop=0.01,reorder=0.05"
+// (any subset; seed=N makes the run reproducible). Empty = nothing injected.
+func Parse(spec string) (Config, error) {
+	var cfg Config
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return cfg, fmt.Errorf("invalid chaos setting %q (want key=value)", item)
+		}
+
+		var err error
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "latency":
+			cfg.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			cfg.Jitter, err = time.ParseDuration(value)
+		case "drop":
+			cfg.Drop, err = parseProbability(value)
+		case "reorder":
+			cfg.Reorder, err = parseProbability(value)
+		case "seed":
+			cfg.Seed, err = strconv.ParseUint(value, 10, 64)
+		default:
+			return cfg, fmt.Errorf("unknown chaos setting %q (want latency, jitter, drop, reorder or seed)", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("invalid chaos %s: %w", key, err)
+		}
+	}
+
+	if cfg.Latency < 0 || cfg.Jitter < 0 {
+		return cfg, fmt.Errorf("chaos latency and jitter must not be negative")
+	}
+	return cfg, nil
+}
+
+// parseProbability parses a probability between 0 and 1
+func parseProbability(value string) (float64, error) {
+	p, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("%g is not between 0 and 1", p)
+	}
+	return p, nil
+}
+
+// Stats counts what an Injector did
+type Stats struct {
+	Delivered int64
+	Dropped   int64
+	Reordered int64
+}
+
+// Injector delays, drops and reorders deliveries of one ordered stream.
+// Messages that are neither dropped nor reordered keep their order, like on
+// a slow TCP connection; a reordered message is held back by an extra
+// Latency+Jitter and the ones after it overtake it.
+type Injector struct {
+	cfg Config
+
+	mu      sync.Mutex
+	rng     *rand.Rand
+	pending deliveryHeap
+	seq     uint64
+	lastDue time.Time // Due time of the last in-order message
+	running bool
+	closed  bool
+	wake    chan struct{}
+	idle    sync.WaitGroup
+
+	delivered atomic.Int64
+	dropped   atomic.Int64
+	reordered atomic.Int64
+}
+
+// New returns an injector for cfg, or nil when cfg injects nothing. A nil
+// *Injector delivers synchronously.
+func New(cfg Config) *Injector {
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return &Injector{
+		cfg:  cfg,
+		rng:  rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15)),
+		wake: make(chan struct{}, 1),
+	}
+}
+
+// Apply schedules deliver according to the injected faults: it runs later
+// on the injector's goroutine, or never if the message is dropped
+func (inj *Injector) Apply(deliver func()) {
+	if inj == nil {
+		deliver()
+		return
+	}
+
+	inj.mu.Lock()
+	defer inj.mu.Unlock()
+
+	if inj.closed {
+		return
+	}
+	if inj.cfg.Drop > 0 && inj.rng.Float64() < inj.cfg.Drop {
+		inj.dropped.Add(1)
+		return
+	}
+
+	due := time.Now().Add(inj.delayLocked())
+	if inj.cfg.Reorder > 0 && inj.rng.Float64() < inj.cfg.Reorder {
+		due = due.Add(inj.cfg.Latency + inj.delayLocked() + time.Millisecond)
+		inj.reordered.Add(1)
+	} else {
+		if due.Before(inj.lastDue) {
+			due = inj.lastDue
+		}
+		inj.lastDue = due
+	}
+
+	inj.seq++
+	heap.Push(&inj.pending, &delivery{due: due, seq: inj.seq, fn: deliver})
+
+	if !inj.running {
+		inj.running = true
+		inj.idle.Add(1)
+		go inj.run()
+	}
+	select {
+	case inj.wake <- struct{}{}:
+	default:
+	}
+}
+
+// delayLocked returns Latency plus random jitter
+func (inj *Injector) delayLocked() time.Duration {
+	delay := inj.cfg.Latency
+	if inj.cfg.Jitter > 0 {
+		delay += time.Duration(inj.rng.Int64N(int64(inj.cfg.Jitter) + 1))
+	}
+	return delay
+}
+
+// run delivers pending messages when they are due and exits when none is
+// left
+func (inj *Injector) run() {
+	defer inj.idle.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		inj.mu.Lock()
+		if inj.closed || len(inj.pending) == 0 {
+			inj.running = false
+			inj.mu.Unlock()
+			return
+		}
+		next := inj.pending[0]
+		wait := time.Until(next.due)
+		if wait <= 0 {
+			heap.Pop(&inj.pending)
+			inj.mu.Unlock()
+
+			next.fn()
+			inj.delivered.Add(1)
+			continue
+		}
+		inj.mu.Unlock()
+
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-inj.wake:
+			if !timer.Stop() {
+				<-timer.C
+			}
+		}
+	}
+}
+
+// Close discards pending deliveries and waits for one in progress
+func (inj *Injector) Close() {
+	if inj == nil {
+		return
+	}
+
+	inj.mu.Lock()
+	inj.closed = true
+	inj.pending = nil
+	inj.mu.Unlock()
+
+	select {
+	case inj.wake <- struct{}{}:
+	default:
+	}
+	inj.idle.Wait()
+}
+
+// Stats returns what the injector did so far
+func (inj *Injector) Stats() Stats {
+	if inj == nil {
+		return Stats{}
+	}
+	return Stats{
+		Delivered: inj.delivered.Load(),
+		Dropped:   inj.dropped.Load(),
+		Reordered: inj.reordered.Load(),
+	}
+}
+
+// delivery is a scheduled message
+type delivery struct {
+	due time.Time
+	seq uint64
+	fn  func()
+}
+
+// deliveryHeap orders deliveries by due time, then arrival
+type deliveryHeap []*delivery
+
+func (h deliveryHeap) Len() int { return len(h) }
+func (h deliveryHeap) Less(i, j int) bool {
+	if h[i].due.Equal(h[j].due) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].due.Before(h[j].due)
+}
+func (h deliveryHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *deliveryHeap) Push(x interface{}) { *h = append(*h, x.(*delivery)) }
+func (h *deliveryHeap) Pop() interface{} {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
@@ -0,0 +1,99 @@
+// Package clock abstracts the current time and ID generation so the bridge
+// components can run deterministically in tests and replays
+package clock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the time
+type Clock interface {
+	Now() time.Time
+}
+
+// System is the wall clock
+var System Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// Manual is a clock that only moves when told to
+type Manual struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManual returns a manual clock set to now
+func NewManual(now time.Time) *Manual {
+	return &Manual{now: now}
+}
+
+// Now returns the clock's time
+func (m *Manual) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.now
+}
+
+// Set moves the clock to t
+func (m *Manual) Set(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now = t
+}
+
+// Advance moves the clock forward by d
+func (m *Manual) Advance(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now = m.now.Add(d)
+}
+
+// IDGenerator hands out unique IDs
+type IDGenerator interface {
+	NewID() string
+}
+
+// Pointers generates WeeChat-style hex pointers ("0x1a2b") from a counter
+type Pointers struct {
+	next atomic.Uint64
+}
+
+// NewPointers returns a pointer generator starting at start. Use a fixed
+// start for reproducible pointers.
+func NewPointers(start uint64) *Pointers {
+	p := &Pointers{}
+	p.next.Store(start)
+	return p
+}
+
+// NewID returns the next pointer
+func (p *Pointers) NewID() string {
+	return fmt.Sprintf("0x%x", p.next.Add(1)-1)
+}
+
+// Random generates hex IDs from crypto/rand, for values that must not be
+// guessable (e.g. handshake nonces)
+type Random struct {
+	Bytes int // Random bytes per ID (default 16)
+}
+
+// NewID returns a random hex ID
+func (r Random) NewID() string {
+	n := r.Bytes
+	if n <= 0 {
+		n = 16
+	}
+	b := make([]byte, n)
+	rand.Read(b) // Never fails, see crypto/rand.Read
+	return hex.EncodeToString(b)
+}
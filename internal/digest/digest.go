@@ -0,0 +1,195 @@
+// Package digest batches highlights and private messages received while no
+// relay client is connected and mails them out periodically over SMTP.
+package digest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Config holds digest notifier configuration
+type Config struct {
+	SMTPAddr string // Mail server host:port (STARTTLS is used when offered)
+	Username string // SMTP auth user (optional)
+	Password string
+	From     string
+	To       []string
+
+	// Interval between digests (default 1h). Nothing is sent when no
+	// entries were collected.
+	Interval time.Duration
+	// MaxEntries bounds the entries kept per digest (default 200)
+	MaxEntries int
+
+	Logger *logrus.Logger
+}
+
+// Entry is one collected message
+type Entry struct {
+	Time    time.Time
+	Buffer  string // e.g. "libera.#go" or "libera.alice"
+	Nick    string
+	Text    string
+	Private bool
+}
+
+// Notifier collects entries and mails them as a digest
+type Notifier struct {
+	cfg Config
+	log *logrus.Entry
+
+	mu      sync.Mutex
+	entries []Entry
+	dropped int
+
+	started bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewNotifier creates a digest notifier
+func NewNotifier(cfg Config) *Notifier {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logrus.New()
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 200
+	}
+
+	return &Notifier{
+		cfg:  cfg,
+		log:  logger.WithField("component", "digest"),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+}
+
+// Add queues an entry for the next digest
+func (n *Notifier) Add(e Entry) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.entries) >= n.cfg.MaxEntries {
+		n.dropped++
+		return
+	}
+	n.entries = append(n.entries, e)
+}
+
+// Start sends a digest every interval until Close
+func (n *Notifier) Start() {
+	n.started = true
+	n.log.Infof("Mailing highlight digests to %s every %s", strings.Join(n.cfg.To, ", "), n.cfg.Interval)
+
+	go func() {
+		defer close(n.done)
+
+		ticker := time.NewTicker(n.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-n.stop:
+				return
+			case <-ticker.C:
+				if err := n.Flush(); err != nil {
+					n.log.Errorf("Failed to send digest: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the notifier and sends what was collected
+func (n *Notifier) Close() error {
+	if n.started {
+		close(n.stop)
+		<-n.done
+		n.started = false
+	}
+	return n.Flush()
+}
+
+// Flush sends the collected entries now. On failure they are kept for the
+// next attempt.
+func (n *Notifier) Flush() error {
+	n.mu.Lock()
+	entries, dropped := n.entries, n.dropped
+	n.entries, n.dropped = nil, 0
+	n.mu.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := n.send(entries, dropped); err != nil {
+		n.mu.Lock()
+		n.entries = append(entries, n.entries...)
+		if len(n.entries) > n.cfg.MaxEntries {
+			n.dropped += len(n.entries) - n.cfg.MaxEntries
+			n.entries = n.entries[:n.cfg.MaxEntries]
+		}
+		n.dropped += dropped
+		n.mu.Unlock()
+		return err
+	}
+
+	n.log.Infof("Sent digest with %d messages", len(entries))
+	return nil
+}
+
+// send mails one digest
+func (n *Notifier) send(entries []Entry, dropped int) error {
+	var auth smtp.Auth
+	if n.cfg.Username != "" {
+		host, _, err := net.SplitHostPort(n.cfg.SMTPAddr)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP address: %w", err)
+		}
+		auth = smtp.PlainAuth("", n.cfg.Username, n.cfg.Password, host)
+	}
+
+	return smtp.SendMail(n.cfg.SMTPAddr, auth, n.cfg.From, n.cfg.To, n.compose(entries, dropped))
+}
+
+// compose builds the digest mail
+func (n *Notifier) compose(entries []Entry, dropped int) []byte {
+	highlights, private := 0, 0
+	for _, e := range entries {
+		if e.Private {
+			private++
+		} else {
+			highlights++
+		}
+	}
+
+	var body bytes.Buffer
+	fmt.Fprintf(&body, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&body, "To: %s\r\n", strings.Join(n.cfg.To, ", "))
+	fmt.Fprintf(&body, "Subject: IRC digest: %d highlights, %d private messages\r\n", highlights, private)
+	fmt.Fprintf(&body, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	body.WriteString("\r\n")
+
+	body.WriteString("You were mentioned while no client was connected:\r\n\r\n")
+	for _, e := range entries {
+		fmt.Fprintf(&body, "[%s] %s <%s> %s\r\n", e.Time.Format("2006-01-02 15:04"), e.Buffer, e.Nick, e.Text)
+	}
+	if dropped > 0 {
+		fmt.Fprintf(&body, "\r\n... and %d more\r\n", dropped)
+	}
+
+	return body.Bytes()
+}
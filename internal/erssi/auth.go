@@ -0,0 +1,90 @@
+package erssi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrAuthFailed is returned by Connect when erssi rejects the password
+var ErrAuthFailed = errors.New("erssi authentication failed")
+
+// authTimeout is how long a new connection waits for auth_ok. fe-web
+// versions that don't send it are assumed to have accepted the password.
+const authTimeout = 3 * time.Second
+
+// frame is one message read from the socket
+type frame struct {
+	messageType int
+	data        []byte
+	err         error
+
+	msg      *erssiproto.WebMessage // Already decoded, for a replayed frame
+	received time.Time
+}
+
+// awaitAuth waits for the first message of a new connection and checks it
+// for auth_ok or an error. The read keeps running in the background if
+// nothing arrives within authTimeout; the returned channel then delivers
+// that frame to the read loop. A first message other than auth_ok is
+// also handed on.
+func (c *Client) awaitAuth(conn *websocket.Conn) (<-chan frame, error) {
+	first := make(chan frame, 1)
+	go func() {
+		messageType, data, err := conn.ReadMessage()
+		first <- frame{messageType: messageType, data: data, err: err}
+	}()
+
+	var f frame
+	select {
+	case f = <-first:
+	case <-time.After(authTimeout):
+		c.log.Debugf("No auth_ok from erssi within %s, assuming legacy fe-web", authTimeout)
+		return first, nil
+	}
+
+	if f.err != nil {
+		if closeErr, ok := f.err.(*websocket.CloseError); ok && closeErr.Code == websocket.ClosePolicyViolation {
+			return nil, fmt.Errorf("%w: %s", ErrAuthFailed, closeErr.Text)
+		}
+		return nil, fmt.Errorf("connection closed during authentication: %w", f.err)
+	}
+
+	msg, err := c.decode(f.messageType, f.data)
+	if err != nil {
+		if c.password != "" {
+			// Encrypted with a key derived from another password
+			return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
+		}
+		return nil, err
+	}
+
+	switch msg.Type {
+	case erssiproto.AuthOK:
+		c.log.Debug("erssi accepted authentication")
+		c.negotiate(msg)
+		return nil, nil
+	case erssiproto.Error:
+		return nil, fmt.Errorf("%w: %s", ErrAuthFailed, msg.Text)
+	default:
+		// Not an auth reply: replay it through the read loop
+		f.msg = msg
+		replay := make(chan frame, 1)
+		replay <- f
+		return replay, nil
+	}
+}
+
+// handshakeError maps a failed websocket handshake to ErrAuthFailed when
+// erssi answered with 401/403
+func handshakeError(resp *http.Response, err error) error {
+	if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		return fmt.Errorf("%w: %s", ErrAuthFailed, resp.Status)
+	}
+	return fmt.Errorf("failed to connect: %w", err)
+}
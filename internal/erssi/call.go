@@ -0,0 +1,96 @@
+package erssi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// ErrDisconnected is returned by Call when the connection drops before the
+// response arrives
+var ErrDisconnected = errors.New("erssi disconnected")
+
+// ResponseError is an error response from erssi to a Call
+type ResponseError struct {
+	Request erssiproto.MessageType
+	Text    string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("erssi rejected %s: %s", e.Request, e.Text)
+}
+
+// Call sends a request with a fresh ID and waits for the message whose
+// ResponseTo matches it. The response is returned to the caller only, it
+// does not reach the OnMessage handler. An error response is returned as
+// *ResponseError. The wait ends with ctx, Close or a disconnect.
+func (c *Client) Call(ctx context.Context, msg *erssiproto.WebMessage) (*erssiproto.WebMessage, error) {
+	req := *msg
+	req.ID = "bridge-" + strconv.FormatUint(c.nextCallID.Add(1), 10)
+
+	ch := make(chan *erssiproto.WebMessage, 1)
+	c.callsMu.Lock()
+	c.calls[req.ID] = ch
+	c.callsMu.Unlock()
+
+	defer func() {
+		c.callsMu.Lock()
+		delete(c.calls, req.ID)
+		c.callsMu.Unlock()
+	}()
+
+	if err := c.throttle(ctx); err != nil {
+		return nil, err
+	}
+	if err := c.sendNow(ctx, &req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp, ok := <-ch:
+		if !ok {
+			return nil, ErrDisconnected
+		}
+		if resp.Type == erssiproto.Error {
+			return nil, &ResponseError{Request: req.Type, Text: resp.Text}
+		}
+		return resp, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("%s request %s: %w", req.Type, req.ID, ctx.Err())
+	case <-c.stop:
+		return nil, ErrClosed
+	}
+}
+
+// deliverResponse hands a response to its waiting Call. Returns false if
+// no call is waiting for it.
+func (c *Client) deliverResponse(msg *erssiproto.WebMessage) bool {
+	if msg.ResponseTo == "" {
+		return false
+	}
+
+	c.callsMu.Lock()
+	defer c.callsMu.Unlock()
+
+	ch, ok := c.calls[msg.ResponseTo]
+	if !ok {
+		return false
+	}
+	delete(c.calls, msg.ResponseTo)
+	ch <- msg
+	return true
+}
+
+// failCalls aborts every pending Call after the connection dropped
+func (c *Client) failCalls() {
+	c.callsMu.Lock()
+	defer c.callsMu.Unlock()
+
+	for id, ch := range c.calls {
+		close(ch)
+		delete(c.calls, id)
+	}
+}
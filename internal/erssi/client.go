@@ -1,13 +1,18 @@
 package erssi
 
 import (
-	"crypto/tls"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"erssi-lith-bridge/internal/chaos"
+	"erssi-lith-bridge/internal/clock"
 	"erssi-lith-bridge/pkg/erssiproto"
 
 	"github.com/gorilla/websocket"
@@ -23,28 +28,142 @@ func min(a, b int) int {
 
 // Client represents a connection to erssi fe-web WebSocket server
 type Client struct {
-	url      string
+	urls     []string // Primary first, then standbys
+	active   int      // Index of the URL of the current connection
 	password string
 	conn     *websocket.Conn
 	mu       sync.RWMutex
 
 	// Message handlers
-	onMessage    func(*erssiproto.WebMessage)
-	onConnected  func()
-	onDisconnect func(error)
+	onMessage      func(*erssiproto.WebMessage)
+	onConnected    func()
+	onDisconnect   func(error)
+	onReconnecting func(attempt int, delay time.Duration)
+	onReconnected  func()
+	onViolation    func(erssiproto.Violation)
+	onGap          func(missed uint64)
+	onLag          func(Lag)
 
 	// Internal state
 	authenticated bool
-	encryptionKey []byte // AES-256-GCM key
+	encryptionKey []byte // AES-256-GCM key for the current protocol
+	v1Key         []byte // Key with the v1 PBKDF2 parameters
+	protocol      Protocol
 	log           *logrus.Entry
 	done          chan struct{}
+
+	// Reconnection
+	reconnect ReconnectPolicy
+	keepalive KeepalivePolicy
+	tls       TLSConfig
+	closing   bool          // Set by Close, suppresses reconnects
+	running   bool          // A read or reconnect loop owns the link and will release Wait
+	stop      chan struct{} // Closed by Close, aborts backoff sleeps and dials
+	stopOnce  sync.Once
+	doneOnce  sync.Once
+
+	// Current socket, readable without c.mu so Close can tear down a
+	// connection whose writer is stuck
+	current atomic.Pointer[websocket.Conn]
+
+	// Outgoing messages buffered while disconnected
+	queueCfg QueueConfig
+	queue    []queuedMessage
+
+	stats       clientStats
+	lastSeq     atomic.Uint64 // Sequence number of the last message, 0 = none yet
+	clock       clock.Clock
+	validate    bool
+	compression bool
+	chaos       chaos.Config
+
+	// Unencrypted frames despite a password, see PlaintextPolicy
+	plaintext       PlaintextPolicy
+	plaintextWarned atomic.Bool // Warned on the current connection
+
+	// OnMessage workers, see pipeline.go
+	handlers []chan *erssiproto.WebMessage
+
+	// Request throttling, see RateLimitConfig
+	rateLimit  RateLimitConfig
+	limiter    *tokenBucket // nil = unlimited
+	debounced  map[string]*time.Timer
+	debounceMu sync.Mutex
+
+	// Per-network flood pacing, see ThrottleProfile
+	throttles       map[string]ThrottleProfile
+	serverBuckets   map[string]*tokenBucket // By lower-case tag, nil = unlimited
+	serverBucketsMu sync.Mutex
+
+	// Link state, see State
+	state         State
+	onStateChange func(from, to State)
+	stateMu       sync.Mutex
+
+	// Pending Call requests by ID
+	calls      map[string]chan *erssiproto.WebMessage
+	callsMu    sync.Mutex
+	nextCallID atomic.Uint64
+
+	// Application-level lag pings, see lag.go
+	lagInterval time.Duration
+	lag         lagState
+	nextPingID  atomic.Uint64
 }
 
+// ErrClosed is returned when connecting a client that was closed
+var ErrClosed = errors.New("client closed")
+
 // Config holds configuration for erssi client
 type Config struct {
+	Name     string // Identifies the connection in logs when there are several
 	URL      string
+	Failover []string // Standby URLs tried in order when URL is unreachable
 	Password string
 	Logger   *logrus.Logger
+
+	// Reconnect controls automatic reconnection after the connection drops
+	Reconnect ReconnectPolicy
+
+	// Keepalive controls ping/pong detection of dead connections
+	Keepalive KeepalivePolicy
+
+	// LagInterval sends fe-web ping messages at this interval to measure
+	// the round trip through erssi, see Lag (0 = disabled)
+	LagInterval time.Duration
+
+	// TLS controls certificate verification for wss:// URLs
+	TLS TLSConfig
+
+	// Queue buffers outgoing messages while reconnecting
+	Queue QueueConfig
+
+	// RateLimit throttles nicklist requests and Calls
+	RateLimit RateLimitConfig
+
+	// Throttles paces chat messages by lower-case server tag, "*" for
+	// the others, see ParseThrottleProfiles (nil = unlimited)
+	Throttles map[string]ThrottleProfile
+
+	// Clock ages queued messages and refills the rate limit (default
+	// clock.System). Socket deadlines always use the wall clock.
+	Clock clock.Clock
+
+	// Validate checks every incoming message against the fields expected
+	// for its type and reports violations through OnViolation
+	Validate bool
+
+	// Plaintext decides what happens to unencrypted frames when a
+	// password is set (default PlaintextWarn)
+	Plaintext PlaintextPolicy
+
+	// Compression offers permessage-deflate in the handshake. erssi may
+	// decline it; the connection is then uncompressed.
+	Compression bool
+
+	// Chaos injects latency, drops and reordering into incoming messages
+	// (testing only)
+	Chaos chaos.Config
 }
 
 // NewClient creates a new erssi WebSocket client
@@ -54,23 +173,59 @@ func NewClient(cfg Config) *Client {
 		logger = logrus.New()
 	}
 
+	log := logger.WithField("component", "erssi-client")
+	if cfg.Name != "" {
+		log = log.WithField("upstream", cfg.Name)
+	}
+
+	clk := cfg.Clock
+	if clk == nil {
+		clk = clock.System
+	}
+
 	client := &Client{
-		url:      cfg.URL,
-		password: cfg.Password,
-		log:      logger.WithField("component", "erssi-client"),
-		done:     make(chan struct{}),
+		urls:        append([]string{cfg.URL}, cfg.Failover...),
+		password:    cfg.Password,
+		log:         log,
+		done:        make(chan struct{}),
+		reconnect:   cfg.Reconnect.withDefaults(),
+		keepalive:   cfg.Keepalive.withDefaults(),
+		lagInterval: cfg.LagInterval,
+		tls:         cfg.TLS,
+		queueCfg:    cfg.Queue.withDefaults(),
+		rateLimit:   cfg.RateLimit,
+		limiter:     newTokenBucket(clk, cfg.RateLimit.Rate, cfg.RateLimit.Burst),
+		clock:       clk,
+		validate:    cfg.Validate,
+		compression: cfg.Compression,
+		chaos:       cfg.Chaos,
+		plaintext:   cfg.Plaintext,
+		debounced:   make(map[string]*time.Timer),
+		throttles:   cfg.Throttles,
+		calls:       make(map[string]chan *erssiproto.WebMessage),
+		stop:        make(chan struct{}),
+		protocol:    protocolV1(),
+	}
+
+	if cfg.TLS.Insecure {
+		client.log.Warn("TLS certificate verification disabled for erssi connection")
 	}
 
 	// Derive encryption key from password
 	if cfg.Password != "" {
-		client.encryptionKey = deriveKey(cfg.Password)
+		client.v1Key = deriveKey(cfg.Password)
+		client.encryptionKey = client.v1Key
 		client.log.Debug("Encryption key derived from password")
 	}
 
+	client.startHandlers()
+
 	return client
 }
 
-// OnMessage sets the message handler
+// OnMessage sets the message handler. Messages of the same buffer are
+// handled one at a time in the order erssi sent them; different buffers are
+// handled concurrently.
 func (c *Client) OnMessage(handler func(*erssiproto.WebMessage)) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -91,182 +246,415 @@ func (c *Client) OnDisconnect(handler func(error)) {
 	c.onDisconnect = handler
 }
 
-// Connect establishes connection to erssi WebSocket server
-func (c *Client) Connect() error {
+// OnReconnecting sets the handler called before each reconnection attempt
+func (c *Client) OnReconnecting(handler func(attempt int, delay time.Duration)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnecting = handler
+}
+
+// OnReconnected sets the handler called after a successful reconnection,
+// so the owner can re-sync its state
+func (c *Client) OnReconnected(handler func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnected = handler
+}
+
+// OnViolation sets the handler called for each schema violation found in an
+// incoming message (with Config.Validate). The message is still delivered.
+func (c *Client) OnViolation(handler func(erssiproto.Violation)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onViolation = handler
+}
+
+// Connect establishes connection to erssi WebSocket server. ctx bounds the
+// dial (all failover URLs included); each handshake also times out on its own.
+func (c *Client) Connect(ctx context.Context) error {
+	if err := c.dial(ctx); err != nil {
+		c.setState(StateDisconnected)
+		return err
+	}
+
+	// Call connected handler
+	c.mu.RLock()
+	if c.onConnected != nil {
+		go c.onConnected()
+	}
+	c.mu.RUnlock()
+
+	return nil
+}
+
+// ActiveURL returns the URL of the current (or last) connection
+func (c *Client) ActiveURL() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.urls[c.active]
+}
+
+// dial tries every URL in order (primary first), then starts the read loop
+// on the first connection that succeeds
+func (c *Client) dial(ctx context.Context) error {
+	var errs []error
+	for i, url := range c.urls {
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, err)
+			break
+		}
+
+		c.setState(StateConnecting)
+		conn, err := c.dialURL(ctx, url)
+		if err != nil {
+			if len(c.urls) > 1 {
+				c.log.Warnf("erssi at %s unreachable: %v", url, err)
+			}
+			errs = append(errs, err)
+			continue
+		}
+
+		c.setState(StateConnected)
+		c.setProtocol(protocolV1()) // Until auth_ok says otherwise
+		c.lastSeq.Store(0)          // Sequence numbers restart per connection
+		c.plaintextWarned.Store(false)
+		pending, err := c.awaitAuth(conn)
+		if err != nil {
+			conn.Close()
+			c.log.Errorf("erssi at %s: %v", url, err)
+			errs = append(errs, err)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closing {
+			c.mu.Unlock()
+			conn.Close()
+			return ErrClosed
+		}
+		c.conn = conn
+		c.current.Store(conn)
+		c.running = true
+		c.flushQueueLocked()
+		if i != c.active {
+			c.log.Warnf("Failed over from %s to %s", c.urls[c.active], url)
+		}
+		c.active = i
+		c.authenticated = true
+		c.mu.Unlock()
+
+		c.setState(StateAuthenticated)
+		c.startKeepalive(conn)
+		c.startLagCheck(conn)
+
+		// Start read loop
+		go c.readLoop(pending)
+
+		c.log.Infof("Connected to erssi at %s", url)
+
+		return nil
+	}
+
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// dialURL opens a WebSocket to one erssi URL
+func (c *Client) dialURL(ctx context.Context, url string) (*websocket.Conn, error) {
 	// erssi requires password in query parameter: /?password=xxx
-	urlWithPassword := c.url
+	urlWithPassword := url
 	if c.password != "" {
 		separator := "?"
-		if strings.Contains(c.url, "?") {
+		if strings.Contains(url, "?") {
 			separator = "&"
 		}
-		urlWithPassword = fmt.Sprintf("%s%spassword=%s", c.url, separator, c.password)
+		urlWithPassword = fmt.Sprintf("%s%spassword=%s", url, separator, c.password)
 	}
 
-	c.log.Infof("Connecting to erssi at %s", c.url)
+	c.log.Infof("Connecting to erssi at %s", url)
 	c.log.Debugf("Full WebSocket URL with password: %s", urlWithPassword)
 
+	tlsConfig, err := c.tls.build()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS configuration: %w", err)
+	}
+
 	dialer := websocket.Dialer{
-		HandshakeTimeout: 10 * time.Second,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true, // erssi uses self-signed certs
-		},
+		HandshakeTimeout:  10 * time.Second,
+		TLSClientConfig:   tlsConfig,
+		EnableCompression: c.compression,
 	}
 
-	conn, resp, err := dialer.Dial(urlWithPassword, nil)
+	conn, resp, err := dialer.DialContext(ctx, urlWithPassword, protocolRequestHeader())
+	if err != nil && dialer.EnableCompression && isCompressionError(err) {
+		// erssi answered with deflate parameters gorilla can't handle
+		c.log.Warnf("erssi at %s offered unsupported compression, connecting without it", url)
+		dialer.EnableCompression = false
+		conn, resp, err = dialer.DialContext(ctx, urlWithPassword, protocolRequestHeader())
+	}
 	if err != nil {
 		if resp != nil {
 			c.log.Errorf("HTTP Response Status: %s", resp.Status)
 			c.log.Errorf("HTTP Response Headers: %v", resp.Header)
 		}
-		return fmt.Errorf("failed to connect: %w", err)
+		return nil, handshakeError(resp, err)
 	}
 	if resp != nil {
 		c.log.Debugf("WebSocket handshake successful, status: %s", resp.Status)
 	}
+	if dialer.EnableCompression {
+		if resp != nil && strings.Contains(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+			c.log.Info("erssi accepted permessage-deflate compression")
+		} else {
+			c.log.Info("erssi declined compression, connection is uncompressed")
+		}
+	}
 
-	c.mu.Lock()
-	c.conn = conn
-	c.mu.Unlock()
+	return conn, nil
+}
 
-	// Start read loop
-	go c.readLoop()
+// isCompressionError reports whether a handshake failed on the
+// permessage-deflate negotiation (gorilla doesn't export the error)
+func isCompressionError(err error) bool {
+	return strings.Contains(err.Error(), "invalid compression negotiation")
+}
 
-	// Password is already in URL query param, no separate auth needed
-	c.authenticated = true
-	c.log.Info("Connected to erssi")
+// readLoop continuously reads messages from WebSocket. A frame already read
+// while authenticating is taken from pending first.
+func (c *Client) readLoop(pending <-chan frame) {
+	defer c.log.Info("Read loop stopped")
 
-	// Call connected handler
-	c.mu.RLock()
-	if c.onConnected != nil {
-		go c.onConnected()
-	}
-	c.mu.RUnlock()
+	// Frames are decrypted and parsed off this loop, so a burst of large
+	// frames doesn't stall reading into a websocket timeout
+	p := c.startPipeline()
+	defer p.close()
 
-	return nil
-}
+	for {
+		c.mu.RLock()
+		conn := c.conn
+		c.mu.RUnlock()
 
-// authenticate sends authentication to erssi
-func (c *Client) authenticate() error {
-	c.log.Debug("Authenticating...")
+		if conn == nil {
+			c.finish()
+			return
+		}
 
-	auth := map[string]interface{}{
-		"type":     "auth",
-		"password": c.password,
-	}
+		var f frame
+		if pending != nil {
+			f = <-pending
+			pending = nil
+		} else {
+			f.messageType, f.data, f.err = conn.ReadMessage()
+		}
+		if f.err != nil {
+			c.handleReadError(conn, c.timeoutError(f.err))
+			return
+		}
+		c.extendDeadline(conn)
+		c.stats.received(len(f.data), c.clock.Now())
+		if f.received.IsZero() {
+			f.received = time.Now()
+		}
 
-	data, err := json.Marshal(auth)
-	if err != nil {
-		return err
+		p.push(f)
 	}
+}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// dispatch processes a decoded incoming message, read at received, in read
+// order
+func (c *Client) dispatch(msg *erssiproto.WebMessage, received time.Time) {
+	c.checkSequence(msg)
 
-	if c.conn == nil {
-		return fmt.Errorf("not connected")
+	// Log parsed message structure
+	c.log.Debugf("Parsed message: type=%s, server_tag=%s, target=%s, nick=%s, text=%s, server=%s",
+		msg.Type, msg.ServerTag, msg.Target, msg.Nick, msg.Text, msg.Server)
+
+	c.log.Debugf("Received message type=%s from=%s target=%s", msg.Type, msg.Nick, msg.Target)
+
+	// Answers to lag pings are measured, not handled
+	if c.handleLagReply(msg, received) {
+		return
 	}
 
-	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-		return err
+	// Responses to Call go to the waiting caller only
+	if c.deliverResponse(msg) {
+		return
 	}
 
-	// TODO: Wait for AUTH_OK response
-	c.authenticated = true
+	// A late auth_ok (legacy detection timed out) only carries the
+	// protocol parameters
+	if msg.Type == erssiproto.AuthOK {
+		c.negotiate(msg)
+		return
+	}
 
-	return nil
+	c.handle(msg)
 }
 
-// readLoop continuously reads messages from WebSocket
-func (c *Client) readLoop() {
-	defer func() {
-		c.log.Info("Read loop stopped")
-		close(c.done)
-	}()
-
-	for {
-		c.mu.RLock()
-		conn := c.conn
-		c.mu.RUnlock()
-
-		if conn == nil {
-			return
-		}
+// decode decrypts (for binary frames with encryption on) and parses a frame
+func (c *Client) decode(messageType int, data []byte) (*erssiproto.WebMessage, error) {
+	c.mu.RLock()
+	key, protocol := c.encryptionKey, c.protocol
+	c.mu.RUnlock()
 
-		messageType, data, err := conn.ReadMessage()
+	// erssi sends binary frames for encrypted data
+	if messageType == websocket.BinaryMessage && key != nil {
+		decrypted, err := decryptMessage(data, key)
 		if err != nil {
-			c.log.Errorf("Read error: %v", err)
+			c.stats.decryptErrors.Add(1)
+			return nil, fmt.Errorf("failed to decrypt message: %w", err)
+		}
+		data = decrypted
+	}
 
-			// Call disconnect handler
-			c.mu.RLock()
-			if c.onDisconnect != nil {
-				go c.onDisconnect(err)
-			}
-			c.mu.RUnlock()
+	// Log raw JSON after decryption
+	c.log.Debugf("Raw JSON received: %s", string(data))
 
-			return
+	if protocol.renames() {
+		adapted, err := protocol.adaptIncoming(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse message: %w", err)
 		}
+		data = adapted
+	}
 
-		// erssi sends binary frames for encrypted data
-		if messageType == websocket.BinaryMessage && c.encryptionKey != nil {
-			// Decrypt message
-			decrypted, err := decryptMessage(data, c.encryptionKey)
-			if err != nil {
-				c.log.Errorf("Failed to decrypt message: %v", err)
-				continue
-			}
-			data = decrypted
-		}
+	if c.validate {
+		c.checkSchema(data)
+	}
 
-		// Log raw JSON after decryption
-		c.log.Debugf("Raw JSON received: %s", string(data))
+	var msg erssiproto.WebMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		c.log.Debugf("Raw data (first 100 bytes): %q", string(data[:min(100, len(data))]))
+		return nil, fmt.Errorf("failed to parse message: %w", err)
+	}
 
-		// Parse JSON message
-		var msg erssiproto.WebMessage
-		if err := json.Unmarshal(data, &msg); err != nil {
-			c.log.Errorf("Failed to parse message: %v", err)
-			c.log.Debugf("Raw data (first 100 bytes): %q", string(data[:min(100, len(data))]))
-			continue
-		}
+	return &msg, nil
+}
 
-		// Log parsed message structure
-		c.log.Debugf("Parsed message: type=%s, server_tag=%s, target=%s, nick=%s, text=%s, server=%s",
-			msg.Type, msg.ServerTag, msg.Target, msg.Nick, msg.Text, msg.Server)
+// SendMessage sends a message to erssi. While a reconnect is pending the
+// message is queued (if a queue is configured) and sent after reconnecting.
+// It waits for earlier sends as long as it takes, see SendContext.
+func (c *Client) SendMessage(msg *erssiproto.WebMessage) error {
+	return c.SendContext(context.Background(), msg)
+}
 
-		c.log.Debugf("Received message type=%s from=%s target=%s", msg.Type, msg.Nick, msg.Target)
+// SendContext sends a message to erssi like SendMessage, giving up when
+// ctx is done while waiting for its server's throttle profile, an earlier
+// send or writing. A write cut
+// short drops the connection: the frame can't be taken back, so the
+// stream is unusable until a reconnect.
+func (c *Client) SendContext(ctx context.Context, msg *erssiproto.WebMessage) error {
+	if err := c.pace(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	if err := c.lockContext(ctx); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
+	defer c.mu.Unlock()
 
-		// Call message handler
-		c.mu.RLock()
-		if c.onMessage != nil {
-			// IMPORTANT: Create a copy of the message to avoid race conditions
-			// The msg variable is reused in the loop, so we must copy it before
-			// passing to the goroutine
-			msgCopy := msg
-			go c.onMessage(&msgCopy)
+	if c.closing {
+		return ErrClosed
+	}
+	if c.conn == nil {
+		if c.canQueueLocked() {
+			return c.enqueueLocked(msg)
 		}
-		c.mu.RUnlock()
+		return fmt.Errorf("not connected")
 	}
+
+	err := c.writeLocked(ctx, msg)
+	if err != nil && c.canQueueLocked() {
+		// The connection is going away; retry once reconnected
+		c.log.Warnf("Send failed, queueing for retry: %v", err)
+		return c.enqueueLocked(msg)
+	}
+	return err
 }
 
-// SendMessage sends a message to erssi
-func (c *Client) SendMessage(msg *erssiproto.WebMessage) error {
-	c.mu.Lock()
+// sendNow sends a message without queueing (state requests are re-issued
+// after reconnecting anyway), giving up when ctx is done
+func (c *Client) sendNow(ctx context.Context, msg *erssiproto.WebMessage) error {
+	if err := c.lockContext(ctx); err != nil {
+		return fmt.Errorf("failed to send message: %w", err)
+	}
 	defer c.mu.Unlock()
 
+	if c.closing {
+		return ErrClosed
+	}
 	if c.conn == nil {
 		return fmt.Errorf("not connected")
 	}
 
+	return c.writeLocked(ctx, msg)
+}
+
+// lockContext locks c.mu, unless ctx is done first
+func (c *Client) lockContext(ctx context.Context) error {
+	if c.mu.TryLock() {
+		return nil
+	}
+	if ctx.Done() == nil {
+		c.mu.Lock()
+		return nil
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		c.mu.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return nil
+	case <-ctx.Done():
+		// Hand the lock back once the goroutine gets it
+		go func() {
+			<-locked
+			c.mu.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// writeLocked writes a message to the connection, bounded by ctx. Caller
+// must hold c.mu.
+func (c *Client) writeLocked(ctx context.Context, msg *erssiproto.WebMessage) error {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
+	if c.protocol.renames() {
+		if data, err = c.protocol.adaptOutgoing(data); err != nil {
+			return fmt.Errorf("failed to marshal message: %w", err)
+		}
+	}
 
 	c.log.Debugf("Sending message type=%s", msg.Type)
 
-	if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+	conn := c.conn
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+		defer conn.SetWriteDeadline(time.Time{})
+	}
+	// Cancelling ctx aborts a write stuck on a full socket buffer
+	stop := context.AfterFunc(ctx, func() { conn.NetConn().SetWriteDeadline(time.Now()) })
+	err = conn.WriteMessage(websocket.TextMessage, data)
+	stop()
+
+	if err != nil {
+		var netErr net.Error
+		if ctx.Err() != nil || errors.As(err, &netErr) && netErr.Timeout() {
+			c.log.Warnf("Write to erssi didn't finish in time, dropping connection: %v", err)
+			conn.Close()
+		}
 		return fmt.Errorf("failed to send message: %w", err)
 	}
+	c.stats.sent(len(data))
 
 	return nil
 }
@@ -284,54 +672,140 @@ func (c *Client) SendCommand(serverTag, target, text string) error {
 }
 
 // RequestStateDump requests full state dump from erssi
-func (c *Client) RequestStateDump() error {
+func (c *Client) RequestStateDump(ctx context.Context) error {
+	return c.RequestServerStateDump(ctx, "*") // Request all servers
+}
+
+// RequestServerStateDump requests the state dump of one server
+func (c *Client) RequestServerStateDump(ctx context.Context, serverTag string) error {
 	msg := &erssiproto.WebMessage{
 		Type:   erssiproto.SyncServer,
-		Server: "*", // Request all servers
+		Server: serverTag,
 	}
 
-	return c.SendMessage(msg)
+	return c.sendNow(ctx, msg)
 }
 
-// RequestNicklist requests nicklist for a channel
-func (c *Client) RequestNicklist(serverTag, channel string) error {
-	msg := &erssiproto.WebMessage{
-		Type:      erssiproto.Nicklist,
-		ServerTag: serverTag,
-		Target:    channel,
+// closeHandshakeTimeout bounds how long Close waits for erssi to answer
+// the close frame
+const closeHandshakeTimeout = 2 * time.Second
+
+// Close closes the connection and stops any reconnection attempts. New
+// sends are refused, pending writes finish, and after the close frame
+// erssi gets closeHandshakeTimeout to answer with its own close frame
+// before the socket is torn down, so the last messages (a /quit) aren't
+// lost to a reset. When ctx expires first the socket is dropped instead,
+// so Close never hangs on a stuck write.
+func (c *Client) Close(ctx context.Context) error {
+	c.log.Info("Closing connection")
+
+	c.stopOnce.Do(func() { close(c.stop) })
+	c.stopDebounced()
+	defer c.setState(StateDisconnected)
+
+	locked := make(chan struct{})
+	go func() {
+		c.mu.Lock()
+		close(locked)
+	}()
+
+	var forced error
+	select {
+	case <-locked:
+	case <-ctx.Done():
+		// Closing the socket makes the stuck write fail and release the lock
+		c.log.Warn("Timed out waiting for pending writes, dropping connection")
+		if conn := c.current.Load(); conn != nil {
+			conn.Close()
+		}
+		<-locked
+		forced = ctx.Err()
 	}
 
-	return c.SendMessage(msg)
-}
+	c.closing = true
+	running := c.running
+	conn := c.conn
+	if conn != nil && forced == nil {
+		c.flushQueueLocked()
+	}
+	err := c.sendCloseLocked(ctx, forced)
+	c.mu.Unlock()
 
-// Close closes the connection
-func (c *Client) Close() error {
-	c.log.Info("Closing connection")
+	if conn != nil {
+		if err == nil {
+			c.awaitCloseReply(ctx)
+		}
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn = nil
+		}
+		c.mu.Unlock()
+		conn.Close()
+	}
 
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	// Never connected: no loop is left to release Wait
+	if !running {
+		c.finish()
+	}
+	return err
+}
 
-	if c.conn == nil {
-		return nil
+// sendCloseLocked sends a close frame, unless the close was forced.
+// Caller must hold c.mu.
+func (c *Client) sendCloseLocked(ctx context.Context, forced error) error {
+	if c.conn == nil || forced != nil {
+		return forced
 	}
 
-	// Send close message
-	err := c.conn.WriteMessage(
+	// Send close message, bounded by ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		c.conn.SetWriteDeadline(deadline)
+	}
+	return c.conn.WriteMessage(
 		websocket.CloseMessage,
 		websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
 	)
+}
 
-	// Close the connection
-	if closeErr := c.conn.Close(); closeErr != nil && err == nil {
-		err = closeErr
+// awaitCloseReply waits until erssi answers the close frame, which ends
+// the read loop, or closeHandshakeTimeout (or ctx) expires. Messages erssi
+// sends meanwhile are still handled.
+func (c *Client) awaitCloseReply(ctx context.Context) {
+	timer := time.NewTimer(closeHandshakeTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.done:
+	case <-timer.C:
+		c.log.Warn("erssi didn't answer the close frame, dropping connection")
+	case <-ctx.Done():
 	}
-
-	c.conn = nil
-
-	return err
 }
 
-// Wait blocks until connection is closed
+// Wait blocks until the client is closed for good: by Close, or when the
+// connection drops with reconnecting disabled or out of retries. A drop
+// followed by a successful reconnect doesn't release it.
 func (c *Client) Wait() {
 	<-c.done
 }
+
+// checkSchema validates a decrypted message and reports its violations
+func (c *Client) checkSchema(data []byte) {
+	violations := erssiproto.Validate(data)
+	if len(violations) == 0 {
+		return
+	}
+
+	c.stats.violations.Add(int64(len(violations)))
+
+	c.mu.RLock()
+	onViolation := c.onViolation
+	c.mu.RUnlock()
+
+	for _, v := range violations {
+		c.log.Debugf("Schema violation: %v", v)
+		if onViolation != nil {
+			onViolation(v)
+		}
+	}
+}
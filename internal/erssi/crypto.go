@@ -10,20 +10,25 @@ import (
 )
 
 const (
-	// Encryption constants from fe-web-crypto.h
-	keySize        = 32 // AES-256
-	ivSize         = 12 // GCM IV
-	tagSize        = 16 // GCM tag
+	// Encryption constants from fe-web-crypto.h (protocol v1, see Protocol)
+	keySize          = 32 // AES-256
+	ivSize           = 12 // GCM IV
+	tagSize          = 16 // GCM tag
 	pbkdf2Iterations = 10000
-	pbkdf2Salt     = "irssi-fe-web-v1"
+	pbkdf2Salt       = "irssi-fe-web-v1"
 )
 
 // deriveKey derives AES-256 key from password using PBKDF2
 func deriveKey(password string) []byte {
+	return deriveKeyWith(password, pbkdf2Salt, pbkdf2Iterations)
+}
+
+// deriveKeyWith derives the key with negotiated PBKDF2 parameters
+func deriveKeyWith(password, salt string, iterations int) []byte {
 	return pbkdf2.Key(
 		[]byte(password),
-		[]byte(pbkdf2Salt),
-		pbkdf2Iterations,
+		[]byte(salt),
+		iterations,
 		keySize,
 		sha256.New,
 	)
@@ -0,0 +1,268 @@
+// Package erssitest provides an in-process fake erssi fe-web WebSocket server
+// for self-tests and load generation. It speaks plaintext JSON (no password,
+// no encryption) and answers sync_server and nicklist requests from a
+// canned state.
+package erssitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+
+	"github.com/gorilla/websocket"
+)
+
+// Channel describes a channel in the fake state
+type Channel struct {
+	Name  string
+	Topic string
+	Nicks []erssiproto.NickInfo
+
+	// Backlog is sent as messages to the channel after its topic, e.g.
+	// to leave unread lines and highlights
+	Backlog []erssiproto.WebMessage
+}
+
+// Network describes one IRC server in the fake state
+type Network struct {
+	Tag      string
+	Nick     string
+	Channels []Channel
+}
+
+// Server is a fake erssi fe-web endpoint
+type Server struct {
+	networks []Network
+
+	listener net.Listener
+	http     *http.Server
+	upgrader websocket.Upgrader
+
+	mu     sync.Mutex
+	conns  map[*websocket.Conn]*sync.Mutex
+	notify chan *erssiproto.WebMessage
+}
+
+// NewServer starts a fake erssi server on a random loopback port
+func NewServer(networks []Network) (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %w", err)
+	}
+
+	s := &Server{
+		networks: networks,
+		listener: listener,
+		conns:    make(map[*websocket.Conn]*sync.Mutex),
+		notify:   make(chan *erssiproto.WebMessage, 256),
+	}
+
+	s.http = &http.Server{Handler: http.HandlerFunc(s.handleWS)}
+	go s.http.Serve(listener)
+
+	return s, nil
+}
+
+// URL returns the ws:// URL of the server
+func (s *Server) URL() string {
+	return fmt.Sprintf("ws://%s/", s.listener.Addr())
+}
+
+// Received returns a channel delivering every message sent by clients
+func (s *Server) Received() <-chan *erssiproto.WebMessage {
+	return s.notify
+}
+
+// Send pushes a message to every connected client
+func (s *Server) Send(msg *erssiproto.WebMessage) error {
+	if msg.Timestamp == 0 {
+		msg.Timestamp = time.Now().Unix()
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for conn, wmu := range s.conns {
+		wmu.Lock()
+		err := conn.WriteMessage(websocket.TextMessage, data)
+		wmu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Close shuts the server down
+func (s *Server) Close() error {
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	return s.http.Close()
+}
+
+func (s *Server) handleWS(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	wmu := &sync.Mutex{}
+	s.mu.Lock()
+	s.conns[conn] = wmu
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	wmu.Lock()
+	conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"auth_ok"}`))
+	wmu.Unlock()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg erssiproto.WebMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+
+		select {
+		case s.notify <- &msg:
+		default:
+		}
+
+		switch msg.Type {
+		case erssiproto.SyncServer:
+			s.sendStateDump(conn, wmu, msg.Server)
+		case erssiproto.Nicklist:
+			s.sendNicklist(conn, wmu, &msg)
+		case erssiproto.Ping:
+			s.sendPong(conn, wmu, &msg)
+		}
+	}
+}
+
+// sendNicklist answers a nicklist request, correlated through response_to
+func (s *Server) sendNicklist(conn *websocket.Conn, wmu *sync.Mutex, req *erssiproto.WebMessage) {
+	resp := &erssiproto.WebMessage{
+		Type:       erssiproto.Error,
+		ServerTag:  req.ServerTag,
+		Target:     req.Target,
+		Text:       "no such channel",
+		Timestamp:  time.Now().Unix(),
+		ResponseTo: req.ID,
+	}
+
+	for _, network := range s.networks {
+		for _, channel := range network.Channels {
+			if network.Tag == req.ServerTag && channel.Name == req.Target {
+				nicks, _ := json.Marshal(channel.Nicks)
+				resp.Type = erssiproto.Nicklist
+				resp.Text = string(nicks)
+			}
+		}
+	}
+
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	wmu.Lock()
+	conn.WriteMessage(websocket.TextMessage, data)
+	wmu.Unlock()
+}
+
+// sendPong answers a ping, correlated through response_to
+func (s *Server) sendPong(conn *websocket.Conn, wmu *sync.Mutex, req *erssiproto.WebMessage) {
+	data, err := json.Marshal(&erssiproto.WebMessage{
+		Type:       erssiproto.Pong,
+		Timestamp:  time.Now().Unix(),
+		ResponseTo: req.ID,
+	})
+	if err != nil {
+		return
+	}
+	wmu.Lock()
+	conn.WriteMessage(websocket.TextMessage, data)
+	wmu.Unlock()
+}
+
+// sendStateDump replays the canned state the way fe-web does:
+// state_dump, then channel_join + nicklist + topic for each channel.
+// server selects one network ("*" or empty = all).
+func (s *Server) sendStateDump(conn *websocket.Conn, wmu *sync.Mutex, server string) {
+	now := time.Now().Unix()
+
+	send := func(msg *erssiproto.WebMessage) {
+		msg.Timestamp = now
+		data, err := json.Marshal(msg)
+		if err != nil {
+			return
+		}
+		wmu.Lock()
+		conn.WriteMessage(websocket.TextMessage, data)
+		wmu.Unlock()
+	}
+
+	for _, network := range s.networks {
+		if server != "" && server != "*" && server != network.Tag {
+			continue
+		}
+		send(&erssiproto.WebMessage{Type: erssiproto.StateDump, ServerTag: network.Tag})
+
+		for _, channel := range network.Channels {
+			send(&erssiproto.WebMessage{
+				Type:      erssiproto.ChannelJoin,
+				ServerTag: network.Tag,
+				Target:    channel.Name,
+				Nick:      network.Nick,
+			})
+
+			nicks, _ := json.Marshal(channel.Nicks)
+			send(&erssiproto.WebMessage{
+				Type:      erssiproto.Nicklist,
+				ServerTag: network.Tag,
+				Target:    channel.Name,
+				Text:      string(nicks),
+			})
+
+			if channel.Topic != "" {
+				send(&erssiproto.WebMessage{
+					Type:      erssiproto.Topic,
+					ServerTag: network.Tag,
+					Target:    channel.Name,
+					Text:      channel.Topic,
+				})
+			}
+
+			for _, msg := range channel.Backlog {
+				msg.Type = erssiproto.Message
+				msg.ServerTag = network.Tag
+				msg.Target = channel.Name
+				send(&msg)
+			}
+		}
+	}
+}
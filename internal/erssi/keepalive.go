@@ -0,0 +1,97 @@
+package erssi
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ErrTimeout is reported to the disconnect handler when erssi stopped
+// answering pings (half-open connection, NAT timeout, suspended host)
+var ErrTimeout = errors.New("connection timed out")
+
+// KeepalivePolicy configures ping/pong based dead connection detection
+type KeepalivePolicy struct {
+	Interval time.Duration // Time between pings (0 = keepalive disabled)
+	Timeout  time.Duration // How long to wait for any data after a ping (default Interval)
+}
+
+// withDefaults fills unset fields with defaults
+func (p KeepalivePolicy) withDefaults() KeepalivePolicy {
+	if p.Interval > 0 && p.Timeout <= 0 {
+		p.Timeout = p.Interval
+	}
+	return p
+}
+
+// enabled reports whether pings are sent
+func (p KeepalivePolicy) enabled() bool {
+	return p.Interval > 0
+}
+
+// startKeepalive arms the read deadline of a fresh connection and starts
+// pinging it. Every pong or message pushes the deadline forward, so a silent
+// peer makes ReadMessage fail within Interval+Timeout.
+func (c *Client) startKeepalive(conn *websocket.Conn) {
+	if !c.keepalive.enabled() {
+		return
+	}
+
+	c.extendDeadline(conn)
+	conn.SetPongHandler(func(string) error {
+		c.extendDeadline(conn)
+		return nil
+	})
+
+	go c.pingLoop(conn)
+}
+
+// extendDeadline pushes the read deadline of a connection forward
+func (c *Client) extendDeadline(conn *websocket.Conn) {
+	if !c.keepalive.enabled() {
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(c.keepalive.Interval + c.keepalive.Timeout))
+}
+
+// pingLoop pings conn until it is replaced, closed or fails
+func (c *Client) pingLoop(conn *websocket.Conn) {
+	ticker := time.NewTicker(c.keepalive.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.mu.RLock()
+		current := c.conn == conn
+		c.mu.RUnlock()
+		if !current {
+			return
+		}
+
+		// WriteControl may be called concurrently with WriteMessage
+		deadline := time.Now().Add(c.keepalive.Timeout)
+		if err := conn.WriteControl(websocket.PingMessage, nil, deadline); err != nil {
+			// The read loop notices the broken connection on its own
+			c.log.Debugf("Ping failed: %v", err)
+			return
+		}
+	}
+}
+
+// timeoutError turns a read deadline expiry into ErrTimeout
+func (c *Client) timeoutError(err error) error {
+	var netErr net.Error
+	if c.keepalive.enabled() && errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w: no response from erssi within %s",
+			ErrTimeout, c.keepalive.Interval+c.keepalive.Timeout)
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package erssi
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+
+	"github.com/gorilla/websocket"
+)
+
+// lagPingPrefix marks the IDs of lag pings, so their answers are told apart
+// from Call responses
+const lagPingPrefix = "lag-"
+
+// Lag is an application-level ping measurement. Unlike websocket pings,
+// which the socket layer answers, a ping message is answered by fe-web
+// itself, so a busy irssi shows up as erssi lag while frames waiting in
+// the bridge show up as bridge lag.
+type Lag struct {
+	Erssi  time.Duration // From sending the ping until its pong was read
+	Bridge time.Duration // From reading the pong until it was handled
+	At     time.Time     // When the measurement was taken
+}
+
+// lagState tracks the outstanding lag ping of the current connection
+type lagState struct {
+	mu          sync.Mutex
+	pendingID   string // "" = no ping outstanding
+	sentAt      time.Time
+	last        Lag
+	unsupported bool // erssi rejected the ping
+}
+
+// OnLag sets the handler called with every lag measurement. While a ping
+// stays unanswered it is called on each check with the time waited so far.
+func (c *Client) OnLag(handler func(Lag)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onLag = handler
+}
+
+// Lag returns the last lag measurement; an outstanding ping older than it
+// counts as the erssi lag. The zero Lag means nothing was measured yet.
+func (c *Client) Lag() Lag {
+	c.lag.mu.Lock()
+	defer c.lag.mu.Unlock()
+
+	lag := c.lag.last
+	if c.lag.pendingID != "" {
+		if waited := time.Since(c.lag.sentAt); waited > lag.Erssi {
+			lag.Erssi = waited
+			lag.At = time.Now()
+		}
+	}
+	return lag
+}
+
+// startLagCheck starts pinging a fresh connection every lagInterval
+func (c *Client) startLagCheck(conn *websocket.Conn) {
+	if c.lagInterval <= 0 {
+		return
+	}
+
+	c.lag.mu.Lock()
+	c.lag.pendingID = ""
+	c.lag.unsupported = false
+	c.lag.mu.Unlock()
+
+	go c.lagLoop(conn)
+}
+
+// lagLoop pings conn until it is replaced, closed or erssi rejects pings
+func (c *Client) lagLoop(conn *websocket.Conn) {
+	ticker := time.NewTicker(c.lagInterval)
+	defer ticker.Stop()
+
+	for {
+		c.mu.RLock()
+		current := c.conn == conn
+		c.mu.RUnlock()
+		if !current {
+			return
+		}
+
+		if !c.sendLagPing() {
+			return
+		}
+
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendLagPing sends a ping, or reports the outstanding one as lag if it is
+// still unanswered. Returns false once erssi rejected pings.
+func (c *Client) sendLagPing() bool {
+	c.lag.mu.Lock()
+	if c.lag.unsupported {
+		c.lag.mu.Unlock()
+		return false
+	}
+	if c.lag.pendingID != "" {
+		lag := Lag{Erssi: time.Since(c.lag.sentAt), At: time.Now()}
+		c.lag.mu.Unlock()
+
+		c.log.Warnf("erssi hasn't answered a ping for %s", lag.Erssi.Round(time.Millisecond))
+		c.reportLag(lag)
+		return true
+	}
+
+	id := lagPingPrefix + strconv.FormatUint(c.nextPingID.Add(1), 10)
+	c.lag.pendingID = id
+	c.lag.sentAt = time.Now()
+	c.lag.mu.Unlock()
+
+	if err := c.sendNow(context.Background(), &erssiproto.WebMessage{Type: erssiproto.Ping, ID: id}); err != nil {
+		// The read loop notices the broken connection on its own
+		c.log.Debugf("Lag ping failed: %v", err)
+		c.lag.mu.Lock()
+		c.lag.pendingID = ""
+		c.lag.mu.Unlock()
+	}
+	return true
+}
+
+// handleLagReply consumes the answer to a lag ping, read at received.
+// Returns false for any other message.
+func (c *Client) handleLagReply(msg *erssiproto.WebMessage, received time.Time) bool {
+	id := msg.ResponseTo
+	if msg.Type != erssiproto.Pong && !strings.HasPrefix(id, lagPingPrefix) {
+		return false
+	}
+	if id == "" {
+		// fe-web versions without response IDs echo the ID itself
+		id = msg.ID
+	}
+
+	c.lag.mu.Lock()
+	if msg.Type == erssiproto.Error {
+		c.lag.unsupported = true
+		c.lag.pendingID = ""
+		c.lag.mu.Unlock()
+
+		c.log.Warnf("erssi rejected the lag ping (%s), lag check disabled", msg.Text)
+		return true
+	}
+	if c.lag.pendingID == "" || (id != "" && id != c.lag.pendingID) {
+		// Answer to a ping of an earlier connection
+		c.lag.mu.Unlock()
+		return true
+	}
+
+	now := time.Now()
+	lag := Lag{
+		Erssi:  received.Sub(c.lag.sentAt),
+		Bridge: now.Sub(received),
+		At:     now,
+	}
+	c.lag.pendingID = ""
+	c.lag.last = lag
+	c.lag.mu.Unlock()
+
+	c.log.Debugf("Lag: erssi %s, bridge %s", lag.Erssi, lag.Bridge)
+	c.reportLag(lag)
+	return true
+}
+
+// reportLag passes a measurement to the OnLag handler
+func (c *Client) reportLag(lag Lag) {
+	c.mu.RLock()
+	onLag := c.onLag
+	c.mu.RUnlock()
+
+	if onLag != nil {
+		go onLag(lag)
+	}
+}
@@ -0,0 +1,170 @@
+package erssi
+
+import (
+	"bytes"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"erssi-lith-bridge/internal/chaos"
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// decodeWorkers is how many frames are decrypted and parsed in parallel
+const decodeWorkers = 4
+
+// pipelineQueueSize bounds the frames read but not yet decoded, and the
+// messages decoded but not yet handled per handler worker. Reading pauses
+// when the pipeline is full.
+const pipelineQueueSize = 256
+
+// handlerWorkers is how many OnMessage calls run in parallel. Messages of
+// one server always go to the same worker, so they're handled in order:
+// a state_dump comes before the channel_join and nicklist messages of its
+// burst, and nick changes and quits stay in line with channel traffic.
+const handlerWorkers = 8
+
+// decodeJob is a frame on its way through the pipeline
+type decodeJob struct {
+	f    frame
+	key  []byte // Encryption key when decoding started
+	msg  *erssiproto.WebMessage
+	err  error
+	done chan struct{}
+}
+
+// pipeline decodes the frames of one connection off the read loop: workers
+// decrypt and parse them in parallel and a sequencer passes the results on
+// in the order they were read
+type pipeline struct {
+	c       *Client
+	jobs    chan *decodeJob // To the decode workers
+	ordered chan *decodeJob // To the sequencer, in read order
+	workers sync.WaitGroup
+	drained chan struct{}
+
+	// Chaos mode faults, injected after decoding
+	chaos *chaos.Injector
+}
+
+func (c *Client) startPipeline() *pipeline {
+	p := &pipeline{
+		c:       c,
+		jobs:    make(chan *decodeJob, pipelineQueueSize),
+		ordered: make(chan *decodeJob, pipelineQueueSize),
+		drained: make(chan struct{}),
+		chaos:   chaos.New(c.chaos),
+	}
+
+	p.workers.Add(decodeWorkers)
+	for i := 0; i < decodeWorkers; i++ {
+		go p.decodeWorker()
+	}
+	go p.sequence()
+
+	return p
+}
+
+// push queues a frame, blocking while the pipeline is full
+func (p *pipeline) push(f frame) {
+	job := &decodeJob{f: f, msg: f.msg, done: make(chan struct{})}
+	p.ordered <- job
+	if job.msg != nil {
+		close(job.done)
+		return
+	}
+	p.jobs <- job
+}
+
+// close lets the frames already read through and stops the pipeline
+func (p *pipeline) close() {
+	close(p.jobs)
+	close(p.ordered)
+	p.workers.Wait()
+	<-p.drained
+	p.chaos.Close()
+}
+
+func (p *pipeline) decodeWorker() {
+	defer p.workers.Done()
+
+	for job := range p.jobs {
+		job.key = p.c.currentKey()
+		job.msg, job.err = p.c.decode(job.f.messageType, job.f.data)
+		close(job.done)
+	}
+}
+
+// sequence dispatches decoded messages in read order
+func (p *pipeline) sequence() {
+	defer close(p.drained)
+
+	for job := range p.ordered {
+		<-job.done
+
+		msg, err := job.msg, job.err
+		if err != nil && !bytes.Equal(job.key, p.c.currentKey()) {
+			// A late auth_ok changed the key while the frame was decoded,
+			// try again with the new one
+			msg, err = p.c.decode(job.f.messageType, job.f.data)
+		}
+		if err != nil {
+			p.c.log.Errorf("%v", err)
+			continue
+		}
+		if p.c.checkPlaintext(job.f.messageType, msg) != nil {
+			continue
+		}
+
+		// Chaos mode delays, drops or reorders the message before it is
+		// processed, like a bad link would
+		p.chaos.Apply(func() { p.c.dispatch(msg, job.f.received) })
+	}
+}
+
+// currentKey returns the encryption key in use
+func (c *Client) currentKey() []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.encryptionKey
+}
+
+// startHandlers starts the workers calling the OnMessage handler
+func (c *Client) startHandlers() {
+	c.handlers = make([]chan *erssiproto.WebMessage, handlerWorkers)
+	for i := range c.handlers {
+		c.handlers[i] = make(chan *erssiproto.WebMessage, pipelineQueueSize)
+		go c.handleMessages(c.handlers[i])
+	}
+}
+
+func (c *Client) handleMessages(queue <-chan *erssiproto.WebMessage) {
+	for {
+		select {
+		case <-c.stop:
+			return
+		case msg := <-queue:
+			c.mu.RLock()
+			onMessage := c.onMessage
+			c.mu.RUnlock()
+
+			if onMessage != nil {
+				onMessage(msg)
+			}
+		}
+	}
+}
+
+// handle queues a message for the OnMessage handler on the worker of its
+// server. Buffers of a server aren't spread over workers: server-level
+// messages (state_dump, server_status, nick_change, user_quit) have to be
+// handled in order with the channel messages around them.
+func (c *Client) handle(msg *erssiproto.WebMessage) {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(msg.ServerTag)))
+
+	select {
+	case c.handlers[h.Sum32()%handlerWorkers] <- msg:
+	case <-c.stop:
+	}
+}
@@ -0,0 +1,78 @@
+package erssi
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestHandleKeepsServerOrder(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	c := NewClient(Config{URL: "ws://127.0.0.1:0", Logger: logger})
+	defer c.Close(context.Background())
+
+	var mu sync.Mutex
+	seen := make(map[string][]string)
+	done := make(chan struct{})
+	total := 0
+	const want = 2 * (1 + 3*20 + 2)
+
+	c.OnMessage(func(msg *erssiproto.WebMessage) {
+		// Slow handlers make a reordering across workers likely
+		time.Sleep(time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		seen[msg.ServerTag] = append(seen[msg.ServerTag], fmt.Sprintf("%s %s", msg.Type, msg.Target))
+		if total++; total == want {
+			close(done)
+		}
+	})
+
+	expected := make(map[string][]string)
+	for _, tag := range []string{"libera", "oftc"} {
+		burst := []*erssiproto.WebMessage{{Type: erssiproto.StateDump, ServerTag: tag}}
+		for i := 0; i < 20; i++ {
+			channel := fmt.Sprintf("#c%d", i)
+			burst = append(burst,
+				&erssiproto.WebMessage{Type: erssiproto.ChannelJoin, ServerTag: tag, Target: channel},
+				&erssiproto.WebMessage{Type: erssiproto.Nicklist, ServerTag: tag, Target: channel},
+				&erssiproto.WebMessage{Type: erssiproto.Topic, ServerTag: tag, Target: channel},
+			)
+		}
+		burst = append(burst,
+			&erssiproto.WebMessage{Type: erssiproto.NickChange, ServerTag: tag},
+			&erssiproto.WebMessage{Type: erssiproto.UserQuit, ServerTag: tag},
+		)
+		for _, msg := range burst {
+			expected[tag] = append(expected[tag], fmt.Sprintf("%s %s", msg.Type, msg.Target))
+			c.handle(msg)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if total != want {
+		t.Fatalf("handled %d of %d messages", total, want)
+	}
+	for tag, order := range expected {
+		got := seen[tag]
+		for i := range order {
+			if got[i] != order[i] {
+				t.Fatalf("%s: message %d handled as %q, want %q", tag, i, got[i], order[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package erssi
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+
+	"github.com/gorilla/websocket"
+)
+
+// PlaintextPolicy decides what happens to unencrypted frames from erssi
+// while a password, and so encryption, is configured. A misconfigured
+// fe-web may send them; auth_ok is always accepted in plaintext.
+type PlaintextPolicy string
+
+const (
+	// PlaintextWarn handles unencrypted frames and warns once per connection
+	PlaintextWarn PlaintextPolicy = "warn"
+	// PlaintextReject drops unencrypted frames and warns once per connection
+	PlaintextReject PlaintextPolicy = "reject"
+)
+
+// ParsePlaintextPolicy parses a plaintext policy name (empty means warn)
+func ParsePlaintextPolicy(s string) (PlaintextPolicy, error) {
+	switch PlaintextPolicy(strings.ToLower(s)) {
+	case "", PlaintextWarn:
+		return PlaintextWarn, nil
+	case PlaintextReject:
+		return PlaintextReject, nil
+	default:
+		return "", fmt.Errorf("unknown plaintext policy %q (want warn or reject)", s)
+	}
+}
+
+// errPlaintextRejected is returned for unencrypted frames under
+// PlaintextReject
+var errPlaintextRejected = errors.New("unencrypted frame rejected")
+
+// checkPlaintext applies the plaintext policy to a decoded frame
+func (c *Client) checkPlaintext(messageType int, msg *erssiproto.WebMessage) error {
+	if messageType != websocket.TextMessage || msg.Type == erssiproto.AuthOK || c.currentKey() == nil {
+		return nil
+	}
+
+	c.stats.plaintext.Add(1)
+	reject := c.plaintext == PlaintextReject
+
+	if c.plaintextWarned.CompareAndSwap(false, true) {
+		if reject {
+			c.log.Errorf("erssi sent an unencrypted frame (%s) although a password is set, dropping unencrypted messages (check the fe-web password)", msg.Type)
+		} else {
+			c.log.Warnf("erssi sent an unencrypted frame (%s) although a password is set; handling it anyway (check the fe-web password)", msg.Type)
+		}
+	}
+
+	if reject {
+		return errPlaintextRejected
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package erssi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// protocolHeader carries the highest fe-web protocol version the bridge
+// speaks in the websocket handshake. fe-web versions that know it answer
+// with their own parameters in the auth_ok extra_data; older ones ignore it.
+const protocolHeader = "X-Fe-Web-Protocol"
+
+// supportedVersion is the highest fe-web protocol version the bridge knows
+const supportedVersion = 1
+
+// Protocol describes what the connected fe-web speaks. Without negotiation
+// (legacy fe-web, or an auth_ok without parameters) it is the v1 protocol
+// the constants in crypto.go were written for.
+type Protocol struct {
+	Version      int
+	Capabilities []string
+
+	// PBKDF2 parameters of the encryption key
+	PBKDF2Iterations int
+	PBKDF2Salt       string
+
+	// MessageTypes maps bridge message types to the names fe-web uses
+	MessageTypes map[erssiproto.MessageType]erssiproto.MessageType
+	// Fields maps fe-web field names to the names the bridge uses
+	Fields map[string]string
+}
+
+// protocolV1 is the protocol assumed when fe-web doesn't negotiate
+func protocolV1() Protocol {
+	return Protocol{
+		Version:          1,
+		PBKDF2Iterations: pbkdf2Iterations,
+		PBKDF2Salt:       pbkdf2Salt,
+	}
+}
+
+// Has reports whether fe-web announced a capability
+func (p Protocol) Has(capability string) bool {
+	for _, c := range p.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// renames reports whether messages need adapting to or from fe-web
+func (p Protocol) renames() bool {
+	return len(p.MessageTypes) > 0 || len(p.Fields) > 0
+}
+
+// parseProtocol reads the protocol parameters from auth_ok extra_data:
+// "protocol" (version), "capabilities", "pbkdf2_iterations", "pbkdf2_salt",
+// "message_types" and "fields". Anything missing keeps its v1 value.
+func parseProtocol(extra map[string]interface{}) (Protocol, error) {
+	p := protocolV1()
+	if extra == nil {
+		return p, nil
+	}
+
+	if v, ok := extra["protocol"]; ok {
+		version, err := intValue(v)
+		if err != nil || version < 1 {
+			return p, fmt.Errorf("invalid protocol version %v", v)
+		}
+		p.Version = version
+	}
+
+	if caps, ok := extra["capabilities"].([]interface{}); ok {
+		for _, c := range caps {
+			if s, ok := c.(string); ok && s != "" {
+				p.Capabilities = append(p.Capabilities, s)
+			}
+		}
+	}
+
+	if v, ok := extra["pbkdf2_iterations"]; ok {
+		iterations, err := intValue(v)
+		if err != nil || iterations < 1 {
+			return p, fmt.Errorf("invalid pbkdf2_iterations %v", v)
+		}
+		p.PBKDF2Iterations = iterations
+	}
+
+	if v, ok := extra["pbkdf2_salt"]; ok {
+		salt, ok := v.(string)
+		if !ok || salt == "" {
+			return p, fmt.Errorf("invalid pbkdf2_salt %v", v)
+		}
+		p.PBKDF2Salt = salt
+	}
+
+	if types, ok := extra["message_types"].(map[string]interface{}); ok {
+		p.MessageTypes = make(map[erssiproto.MessageType]erssiproto.MessageType)
+		for ours, theirs := range types {
+			if name, ok := theirs.(string); ok && name != "" && name != ours {
+				p.MessageTypes[erssiproto.MessageType(ours)] = erssiproto.MessageType(name)
+			}
+		}
+	}
+
+	if fields, ok := extra["fields"].(map[string]interface{}); ok {
+		p.Fields = make(map[string]string)
+		for theirs, ours := range fields {
+			if name, ok := ours.(string); ok && name != "" && name != theirs {
+				p.Fields[theirs] = name
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// intValue converts a JSON number (float64 after decoding) or numeric
+// string to an int
+func intValue(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case float64:
+		if n != float64(int(n)) {
+			return 0, fmt.Errorf("not an integer: %v", n)
+		}
+		return int(n), nil
+	case string:
+		return strconv.Atoi(n)
+	default:
+		return 0, fmt.Errorf("not a number: %v", v)
+	}
+}
+
+// protocolRequestHeader advertises the supported protocol version
+func protocolRequestHeader() http.Header {
+	header := http.Header{}
+	header.Set(protocolHeader, strconv.Itoa(supportedVersion))
+	return header
+}
+
+// negotiate applies the protocol announced in auth_ok: the parameters are
+// used for every frame after it, and the encryption key is re-derived when
+// the PBKDF2 parameters differ from v1
+func (c *Client) negotiate(authOK *erssiproto.WebMessage) {
+	p, err := parseProtocol(authOK.ExtraData)
+	if err != nil {
+		c.log.Warnf("Ignoring protocol parameters from erssi: %v", err)
+		p = protocolV1()
+	}
+
+	if p.Version > supportedVersion {
+		c.log.Warnf("erssi speaks fe-web protocol v%d, the bridge knows up to v%d; unknown features are ignored",
+			p.Version, supportedVersion)
+	}
+	if p.Version != 1 || len(p.Capabilities) > 0 {
+		c.log.Infof("Negotiated fe-web protocol v%d (capabilities: %s)", p.Version, strings.Join(p.Capabilities, ", "))
+	}
+
+	c.setProtocol(p)
+}
+
+// setProtocol switches to a protocol, deriving its key if needed
+func (c *Client) setProtocol(p Protocol) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.password != "" {
+		switch {
+		case p.PBKDF2Iterations == pbkdf2Iterations && p.PBKDF2Salt == pbkdf2Salt:
+			c.encryptionKey = c.v1Key
+		case p.PBKDF2Iterations != c.protocol.PBKDF2Iterations || p.PBKDF2Salt != c.protocol.PBKDF2Salt:
+			c.encryptionKey = deriveKeyWith(c.password, p.PBKDF2Salt, p.PBKDF2Iterations)
+			c.log.Debugf("Encryption key derived with %d PBKDF2 iterations", p.PBKDF2Iterations)
+		}
+	}
+	c.protocol = p
+}
+
+// Protocol returns the protocol of the current (or last) connection
+func (c *Client) Protocol() Protocol {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.protocol
+}
+
+// adaptIncoming renames fe-web message types and fields to the bridge's
+func (p Protocol) adaptIncoming(data []byte) ([]byte, error) {
+	types := make(map[erssiproto.MessageType]erssiproto.MessageType, len(p.MessageTypes))
+	for ours, theirs := range p.MessageTypes {
+		types[theirs] = ours
+	}
+	return rename(data, p.Fields, types)
+}
+
+// adaptOutgoing renames bridge message types and fields to fe-web's
+func (p Protocol) adaptOutgoing(data []byte) ([]byte, error) {
+	fields := make(map[string]string, len(p.Fields))
+	for theirs, ours := range p.Fields {
+		fields[ours] = theirs
+	}
+	return rename(data, fields, p.MessageTypes)
+}
+
+// rename rewrites the top-level field names and the type of a JSON message
+func rename(data []byte, fields map[string]string, types map[erssiproto.MessageType]erssiproto.MessageType) ([]byte, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	renamed := make(map[string]json.RawMessage, len(raw))
+	for name, value := range raw {
+		if to, ok := fields[name]; ok {
+			name = to
+		}
+		renamed[name] = value
+	}
+
+	if value, ok := renamed["type"]; ok {
+		var msgType erssiproto.MessageType
+		if json.Unmarshal(value, &msgType) == nil {
+			if to, ok := types[msgType]; ok {
+				renamed["type"], _ = json.Marshal(to)
+			}
+		}
+	}
+
+	return json.Marshal(renamed)
+}
@@ -0,0 +1,129 @@
+package erssi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// ErrQueueFull is returned when a message can't be queued under the
+// drop-newest overflow policy
+var ErrQueueFull = errors.New("send queue full")
+
+// OverflowPolicy decides which message is lost when the send queue is full
+type OverflowPolicy string
+
+const (
+	// OverflowDropOldest discards the oldest queued message
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowDropNewest rejects the message being sent
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+)
+
+// ParseOverflowPolicy parses an overflow policy name (empty means drop-oldest)
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	switch OverflowPolicy(strings.ToLower(s)) {
+	case "", OverflowDropOldest:
+		return OverflowDropOldest, nil
+	case OverflowDropNewest:
+		return OverflowDropNewest, nil
+	default:
+		return "", fmt.Errorf("unknown overflow policy %q (want drop-oldest or drop-newest)", s)
+	}
+}
+
+// QueueConfig configures buffering of outgoing messages while erssi is
+// disconnected. Queued messages are flushed in order after reconnecting.
+type QueueConfig struct {
+	Size     int            // Messages kept while disconnected (0 = no queue)
+	Overflow OverflowPolicy // What to drop when full (default drop-oldest)
+	MaxAge   time.Duration  // Discard messages queued longer than this (default 5m)
+}
+
+// withDefaults fills unset fields with defaults
+func (q QueueConfig) withDefaults() QueueConfig {
+	if q.Overflow == "" {
+		q.Overflow = OverflowDropOldest
+	}
+	if q.MaxAge <= 0 {
+		q.MaxAge = 5 * time.Minute
+	}
+	return q
+}
+
+type queuedMessage struct {
+	msg    *erssiproto.WebMessage
+	queued time.Time
+}
+
+// canQueueLocked reports whether unsendable messages should be queued.
+// Caller must hold c.mu.
+func (c *Client) canQueueLocked() bool {
+	return c.queueCfg.Size > 0 && c.reconnect.Enabled && !c.closing
+}
+
+// enqueueLocked buffers a message for sending after reconnection.
+// Caller must hold c.mu.
+func (c *Client) enqueueLocked(msg *erssiproto.WebMessage) error {
+	if len(c.queue) >= c.queueCfg.Size {
+		if c.queueCfg.Overflow == OverflowDropNewest {
+			c.stats.dropped.Add(1)
+			return ErrQueueFull
+		}
+		c.queue = c.queue[1:]
+		c.stats.dropped.Add(1)
+		c.log.Warn("Send queue full, dropped oldest message")
+	}
+
+	c.queue = append(c.queue, queuedMessage{msg: msg, queued: c.clock.Now()})
+	c.stats.queued.Add(1)
+	c.log.Debugf("erssi disconnected, queued message type=%s (%d queued)", msg.Type, len(c.queue))
+
+	return nil
+}
+
+// flushQueueLocked sends queued messages in order on the new connection,
+// keeping the rest if a write fails. Caller must hold c.mu.
+func (c *Client) flushQueueLocked() {
+	if len(c.queue) == 0 {
+		return
+	}
+
+	sent := 0
+	for len(c.queue) > 0 {
+		item := c.queue[0]
+		if c.clock.Now().Sub(item.queued) > c.queueCfg.MaxAge {
+			c.stats.dropped.Add(1)
+			c.queue = c.queue[1:]
+			continue
+		}
+
+		if err := c.writeLocked(context.Background(), item.msg); err != nil {
+			c.log.Warnf("Flushing send queue failed, %d messages kept: %v", len(c.queue), err)
+			return
+		}
+		c.stats.flushed.Add(1)
+		c.queue = c.queue[1:]
+		sent++
+	}
+
+	c.queue = nil
+	c.log.Infof("Flushed %d queued messages to erssi", sent)
+}
+
+// discardQueue drops every queued message once reconnecting is abandoned
+func (c *Client) discardQueue() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.queue) == 0 {
+		return
+	}
+	c.stats.dropped.Add(int64(len(c.queue)))
+	c.log.Warnf("Discarding %d queued messages", len(c.queue))
+	c.queue = nil
+}
@@ -0,0 +1,152 @@
+package erssi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/clock"
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// RateLimitConfig throttles state requests to erssi (nicklist refreshes and
+// Calls). Chat messages typed by the user are never delayed.
+type RateLimitConfig struct {
+	Rate     float64       // Requests per second (0 = unlimited)
+	Burst    int           // Requests sent back to back before throttling (default: Rate, at least 1)
+	Debounce time.Duration // Nicklist requests for a channel within this window are sent once (0 = off)
+}
+
+// tokenBucket is a token bucket refilled at rate tokens per second
+type tokenBucket struct {
+	clock  clock.Clock
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newTokenBucket returns a full bucket, or nil when rate is unlimited
+func newTokenBucket(clk clock.Clock, rate float64, burst int) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = max(1, int(math.Ceil(rate)))
+	}
+	return &tokenBucket{
+		clock:  clk,
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   clk.Now(),
+	}
+}
+
+// reserve takes a token and returns how long to wait before using it.
+// Tokens may go negative, so concurrent waiters are spaced out in order.
+func (b *tokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.clock.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// throttle waits for the rate limiter, ctx or Close
+func (c *Client) throttle(ctx context.Context) error {
+	if c.limiter == nil {
+		return nil
+	}
+
+	delay := c.limiter.reserve()
+	if delay == 0 {
+		return nil
+	}
+	c.stats.throttled.Add(1)
+	c.log.Debugf("Rate limit reached, delaying request by %s", delay.Round(time.Millisecond))
+
+	return c.sleep(ctx, delay)
+}
+
+// sleep waits for delay, ctx or Close
+func (c *Client) sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.stop:
+		return ErrClosed
+	}
+}
+
+// RequestNicklist requests nicklist for a channel. With a debounce window
+// the request is sent once at the end of the window however many times it
+// was asked for (e.g. a join flood during a netsplit); errors are then
+// logged instead of returned. ctx bounds an immediate request only.
+func (c *Client) RequestNicklist(ctx context.Context, serverTag, channel string) error {
+	if c.rateLimit.Debounce <= 0 {
+		return c.requestNicklist(ctx, serverTag, channel)
+	}
+
+	key := serverTag + "\x00" + strings.ToLower(channel)
+
+	c.debounceMu.Lock()
+	defer c.debounceMu.Unlock()
+
+	if _, ok := c.debounced[key]; ok {
+		c.stats.coalesced.Add(1)
+		return nil
+	}
+	c.debounced[key] = time.AfterFunc(c.rateLimit.Debounce, func() {
+		c.debounceMu.Lock()
+		delete(c.debounced, key)
+		c.debounceMu.Unlock()
+
+		if err := c.requestNicklist(context.Background(), serverTag, channel); err != nil && !errors.Is(err, ErrClosed) {
+			c.log.Warnf("Failed to request nicklist for %s/%s: %v", serverTag, channel, err)
+		}
+	})
+	return nil
+}
+
+// requestNicklist sends a nicklist request once the rate limit allows
+func (c *Client) requestNicklist(ctx context.Context, serverTag, channel string) error {
+	if err := c.throttle(ctx); err != nil {
+		return err
+	}
+
+	msg := &erssiproto.WebMessage{
+		Type:      erssiproto.Nicklist,
+		ServerTag: serverTag,
+		Target:    channel,
+	}
+
+	return c.sendNow(ctx, msg)
+}
+
+// stopDebounced cancels nicklist requests still waiting for their window
+func (c *Client) stopDebounced() {
+	c.debounceMu.Lock()
+	defer c.debounceMu.Unlock()
+
+	for key, timer := range c.debounced {
+		timer.Stop()
+		delete(c.debounced, key)
+	}
+}
@@ -0,0 +1,180 @@
+package erssi
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ReconnectPolicy configures automatic reconnection with exponential backoff
+type ReconnectPolicy struct {
+	Enabled      bool
+	MaxRetries   int           // Attempts per outage before giving up (0 = forever)
+	InitialDelay time.Duration // Delay before the first attempt (default 1s)
+	MaxDelay     time.Duration // Upper bound for the delay (default 60s)
+	Multiplier   float64       // Growth factor per attempt (default 2)
+	Jitter       float64       // Random +/- fraction applied to each delay (default 0.2)
+}
+
+// withDefaults fills unset fields with defaults
+func (p ReconnectPolicy) withDefaults() ReconnectPolicy {
+	if p.InitialDelay <= 0 {
+		p.InitialDelay = time.Second
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 60 * time.Second
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = 2
+	}
+	if p.Jitter <= 0 || p.Jitter >= 1 {
+		p.Jitter = 0.2
+	}
+	return p
+}
+
+// backoff returns the delay before the given attempt (1-based)
+func (p ReconnectPolicy) backoff(attempt int) time.Duration {
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt-1))
+	if delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+
+	// Spread reconnects so several bridges don't hammer erssi in lockstep
+	delay += delay * p.Jitter * (2*rand.Float64() - 1)
+
+	return time.Duration(delay)
+}
+
+// handleReadError reacts to a broken connection: reconnect if enabled,
+// otherwise (or after an explicit Close) finish the client
+func (c *Client) handleReadError(conn *websocket.Conn, err error) {
+	c.mu.Lock()
+	closing := c.closing
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.authenticated = false
+	onDisconnect := c.onDisconnect
+	c.mu.Unlock()
+
+	conn.Close()
+	c.failCalls()
+
+	if closing {
+		c.finish()
+		return
+	}
+
+	c.log.Errorf("Read error: %v", err)
+
+	// Call disconnect handler
+	if onDisconnect != nil {
+		go onDisconnect(err)
+	}
+
+	if !c.reconnect.Enabled {
+		c.finish()
+		return
+	}
+
+	c.setState(StateReconnecting)
+	go c.reconnectLoop()
+}
+
+// reconnectLoop redials with exponential backoff until it succeeds, the
+// retry budget is exhausted or the client is closed
+func (c *Client) reconnectLoop() {
+	for attempt := 1; ; attempt++ {
+		if c.reconnect.MaxRetries > 0 && attempt > c.reconnect.MaxRetries {
+			c.log.Errorf("Giving up reconnecting to erssi after %d attempts", c.reconnect.MaxRetries)
+			c.discardQueue()
+			c.finish()
+			return
+		}
+
+		c.setState(StateReconnecting)
+		delay := c.reconnect.backoff(attempt)
+		c.log.Infof("Reconnecting to erssi in %s (attempt %d)", delay.Round(time.Millisecond), attempt)
+
+		c.mu.RLock()
+		onReconnecting := c.onReconnecting
+		c.mu.RUnlock()
+		if onReconnecting != nil {
+			onReconnecting(attempt, delay)
+		}
+
+		select {
+		case <-c.stop:
+			c.finish()
+			return
+		case <-time.After(delay):
+		}
+
+		ctx, cancel := c.stopContext()
+		err := c.dial(ctx)
+		cancel()
+		if err != nil {
+			if err == ErrClosed || c.stopped() {
+				c.finish()
+				return
+			}
+			if errors.Is(err, ErrAuthFailed) {
+				// Retrying can't fix a wrong password
+				c.log.Errorf("Giving up reconnecting to erssi: %v", err)
+				c.discardQueue()
+				c.finish()
+				return
+			}
+			c.log.Warnf("Reconnect attempt %d failed: %v", attempt, err)
+			continue
+		}
+
+		c.log.Infof("Reconnected to erssi after %d attempt(s)", attempt)
+		c.stats.reconnects.Add(1)
+
+		c.mu.RLock()
+		onReconnected := c.onReconnected
+		c.mu.RUnlock()
+		if onReconnected != nil {
+			go onReconnected()
+		}
+		return
+	}
+}
+
+// stopContext returns a context that is also cancelled when Close is
+// called, so a reconnect dial in flight is aborted
+func (c *Client) stopContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-c.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// stopped reports whether Close was called
+func (c *Client) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish marks the client as terminally closed, releasing Wait
+func (c *Client) finish() {
+	c.doneOnce.Do(func() {
+		c.setState(StateDisconnected)
+		close(c.done)
+	})
+}
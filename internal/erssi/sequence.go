@@ -0,0 +1,44 @@
+package erssi
+
+import "erssi-lith-bridge/pkg/erssiproto"
+
+// OnGap sets the handler called when the sequence numbers of incoming
+// messages skip ahead, i.e. erssi sent messages the bridge never received.
+// Only fe-web versions that number their messages (the "seq" field) can
+// report gaps.
+func (c *Client) OnGap(handler func(missed uint64)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onGap = handler
+}
+
+// checkSequence compares a message's sequence number with the last one.
+// Messages without a number are ignored; the first number of a connection
+// starts the count.
+func (c *Client) checkSequence(msg *erssiproto.WebMessage) {
+	if msg.Seq == 0 {
+		return
+	}
+
+	last := c.lastSeq.Swap(msg.Seq)
+	if last == 0 || msg.Seq == last+1 {
+		return
+	}
+
+	if msg.Seq <= last {
+		c.log.Warnf("erssi sequence went back from %d to %d", last, msg.Seq)
+		return
+	}
+
+	missed := msg.Seq - last - 1
+	c.stats.gaps.Add(1)
+	c.log.Warnf("Missed %d messages from erssi (sequence %d -> %d)", missed, last, msg.Seq)
+
+	c.mu.RLock()
+	onGap := c.onGap
+	c.mu.RUnlock()
+
+	if onGap != nil {
+		go onGap(missed)
+	}
+}
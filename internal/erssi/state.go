@@ -0,0 +1,69 @@
+package erssi
+
+// State is the state of the link to erssi
+type State int
+
+const (
+	// StateDisconnected means no connection and no reconnect pending
+	StateDisconnected State = iota
+	// StateConnecting means a websocket dial is in progress
+	StateConnecting
+	// StateConnected means the websocket is up, authentication pending
+	StateConnected
+	// StateAuthenticated means erssi accepted the connection
+	StateAuthenticated
+	// StateReconnecting means the link dropped and a redial is scheduled
+	StateReconnecting
+)
+
+func (s State) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateAuthenticated:
+		return "authenticated"
+	case StateReconnecting:
+		return "reconnecting"
+	default:
+		return "disconnected"
+	}
+}
+
+// State returns the current link state
+func (c *Client) State() State {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	return c.state
+}
+
+// OnStateChange sets the handler called on every state transition. It runs
+// synchronously on the goroutine making the transition.
+func (c *Client) OnStateChange(handler func(from, to State)) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+
+	c.onStateChange = handler
+}
+
+// setState records a transition and notifies the handler. Must not be
+// called with c.mu held, so the handler may use the client.
+func (c *Client) setState(s State) {
+	c.stateMu.Lock()
+	old := c.state
+	if old == s {
+		c.stateMu.Unlock()
+		return
+	}
+	c.state = s
+	handler := c.onStateChange
+	c.stateMu.Unlock()
+
+	c.log.Debugf("erssi link %s -> %s", old, s)
+
+	if handler != nil {
+		handler(old, s)
+	}
+}
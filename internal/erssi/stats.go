@@ -0,0 +1,121 @@
+package erssi
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// clientStats holds erssi client counters (updated atomically)
+type clientStats struct {
+	queued  atomic.Int64
+	flushed atomic.Int64
+	dropped atomic.Int64
+
+	throttled atomic.Int64
+	coalesced atomic.Int64
+	paced     atomic.Int64
+
+	messagesIn    atomic.Int64
+	messagesOut   atomic.Int64
+	bytesIn       atomic.Int64
+	bytesOut      atomic.Int64
+	decryptErrors atomic.Int64
+	plaintext     atomic.Int64
+	reconnects    atomic.Int64
+	violations    atomic.Int64
+	gaps          atomic.Int64
+	lastMessageAt atomic.Int64 // Unix nanoseconds, 0 = never
+}
+
+// Stats is a point-in-time snapshot of erssi client counters
+type Stats struct {
+	// Queued counts messages buffered while disconnected
+	Queued int64
+	// Flushed counts queued messages sent after reconnecting
+	Flushed int64
+	// Dropped counts queued messages lost to overflow or age
+	Dropped int64
+	// QueueLength is the number of messages waiting right now
+	QueueLength int
+	// Throttled counts requests delayed by the rate limit
+	Throttled int64
+	// Coalesced counts nicklist requests merged into a pending one
+	Coalesced int64
+	// Paced counts chat messages delayed by their network's throttle
+	// profile
+	Paced int64
+
+	// MessagesIn counts frames received from erssi
+	MessagesIn int64
+	// MessagesOut counts frames sent to erssi
+	MessagesOut int64
+	// BytesIn counts received payload bytes (encrypted frames as received)
+	BytesIn int64
+	// BytesOut counts sent payload bytes
+	BytesOut int64
+	// DecryptErrors counts frames that could not be decrypted
+	DecryptErrors int64
+	// PlaintextFrames counts unencrypted frames received although a
+	// password is set, see PlaintextPolicy
+	PlaintextFrames int64
+	// Reconnects counts successful reconnects after a drop
+	Reconnects int64
+	// SchemaViolations counts problems found by Config.Validate
+	SchemaViolations int64
+	// Gaps counts jumps in the message sequence numbers (missed messages)
+	Gaps int64
+	// LastMessage is when the last frame arrived (zero if none yet)
+	LastMessage time.Time
+}
+
+// StatsSource is anything reporting erssi client counters, e.g. for a
+// metrics endpoint
+type StatsSource interface {
+	Stats() Stats
+}
+
+// Stats returns a snapshot of the client counters
+func (c *Client) Stats() Stats {
+	c.mu.RLock()
+	queueLength := len(c.queue)
+	c.mu.RUnlock()
+
+	var lastMessage time.Time
+	if ns := c.stats.lastMessageAt.Load(); ns != 0 {
+		lastMessage = time.Unix(0, ns)
+	}
+
+	return Stats{
+		Queued:      c.stats.queued.Load(),
+		Flushed:     c.stats.flushed.Load(),
+		Dropped:     c.stats.dropped.Load(),
+		QueueLength: queueLength,
+		Throttled:   c.stats.throttled.Load(),
+		Coalesced:   c.stats.coalesced.Load(),
+		Paced:       c.stats.paced.Load(),
+
+		MessagesIn:       c.stats.messagesIn.Load(),
+		MessagesOut:      c.stats.messagesOut.Load(),
+		BytesIn:          c.stats.bytesIn.Load(),
+		BytesOut:         c.stats.bytesOut.Load(),
+		DecryptErrors:    c.stats.decryptErrors.Load(),
+		PlaintextFrames:  c.stats.plaintext.Load(),
+		Reconnects:       c.stats.reconnects.Load(),
+		SchemaViolations: c.stats.violations.Load(),
+		Gaps:             c.stats.gaps.Load(),
+		LastMessage:      lastMessage,
+	}
+}
+
+// received counts an incoming frame
+func (s *clientStats) received(size int, at time.Time) {
+	s.messagesIn.Add(1)
+	s.bytesIn.Add(int64(size))
+	s.lastMessageAt.Store(at.UnixNano())
+}
+
+// sent counts an outgoing frame
+func (s *clientStats) sent(size int) {
+	s.messagesOut.Add(1)
+	s.bytesOut.Add(int64(size))
+}
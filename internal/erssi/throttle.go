@@ -0,0 +1,119 @@
+package erssi
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// ThrottleProfile paces the messages sent to one IRC network below its
+// flood limit, so a long paste doesn't get the user disconnected for
+// excess flood
+type ThrottleProfile struct {
+	Rate  float64 // Messages per second (0 = unlimited)
+	Burst int     // Messages sent back to back before pacing (default: Rate, at least 1)
+}
+
+// DefaultThrottleProfiles are the profiles of networks with well-known
+// flood limits, by lower-case server tag (irssi's chatnet names and the
+// usual short ones)
+var DefaultThrottleProfiles = map[string]ThrottleProfile{
+	"libera":     {Rate: 1, Burst: 5},
+	"liberachat": {Rate: 1, Burst: 5},
+	"oftc":       {Rate: 0.5, Burst: 5},
+	"efnet":      {Rate: 0.5, Burst: 4},
+}
+
+// ParseThrottleProfiles parses "tag=rate[/burst]" entries over
+// DefaultThrottleProfiles. "*" sets the profile of unlisted server tags,
+// "off" as a rate disables pacing for a tag, and a lone "off" entry
+// disables every profile.
+func ParseThrottleProfiles(entries []string) (map[string]ThrottleProfile, error) {
+	if len(entries) == 1 && strings.EqualFold(strings.TrimSpace(entries[0]), "off") {
+		return map[string]ThrottleProfile{}, nil
+	}
+
+	profiles := make(map[string]ThrottleProfile, len(DefaultThrottleProfiles)+len(entries))
+	for tag, profile := range DefaultThrottleProfiles {
+		profiles[tag] = profile
+	}
+
+	for _, entry := range entries {
+		tag, spec, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if !ok || tag == "" {
+			return nil, fmt.Errorf("invalid throttle profile %q (want tag=rate[/burst])", entry)
+		}
+		if strings.EqualFold(strings.TrimSpace(spec), "off") {
+			profiles[tag] = ThrottleProfile{}
+			continue
+		}
+
+		rate, burst, hasBurst := strings.Cut(spec, "/")
+		var profile ThrottleProfile
+		var err error
+		if profile.Rate, err = strconv.ParseFloat(strings.TrimSpace(rate), 64); err != nil || profile.Rate < 0 {
+			return nil, fmt.Errorf("invalid rate in throttle profile %q", entry)
+		}
+		if hasBurst {
+			if profile.Burst, err = strconv.Atoi(strings.TrimSpace(burst)); err != nil || profile.Burst < 1 {
+				return nil, fmt.Errorf("invalid burst in throttle profile %q", entry)
+			}
+		}
+		profiles[tag] = profile
+	}
+
+	return profiles, nil
+}
+
+// serverBucket returns the token bucket pacing messages to a server, nil
+// when its profile is unlimited
+func (c *Client) serverBucket(serverTag string) *tokenBucket {
+	if len(c.throttles) == 0 || serverTag == "" {
+		return nil
+	}
+	tag := strings.ToLower(serverTag)
+
+	c.serverBucketsMu.Lock()
+	defer c.serverBucketsMu.Unlock()
+
+	if bucket, ok := c.serverBuckets[tag]; ok {
+		return bucket
+	}
+	if c.serverBuckets == nil {
+		c.serverBuckets = make(map[string]*tokenBucket)
+	}
+	profile, ok := c.throttles[tag]
+	if !ok {
+		profile = c.throttles["*"]
+	}
+	bucket := newTokenBucket(c.clock, profile.Rate, profile.Burst)
+	c.serverBuckets[tag] = bucket
+	return bucket
+}
+
+// pace waits until the throttle profile of its server lets a message
+// through. Only chat messages and commands, which reach the IRC server,
+// are paced.
+func (c *Client) pace(ctx context.Context, msg *erssiproto.WebMessage) error {
+	if msg.Type != erssiproto.Message {
+		return nil
+	}
+	bucket := c.serverBucket(msg.ServerTag)
+	if bucket == nil {
+		return nil
+	}
+
+	delay := bucket.reserve()
+	if delay == 0 {
+		return nil
+	}
+	c.stats.paced.Add(1)
+	c.log.Debugf("Flood limit of %s reached, delaying message by %s", msg.ServerTag, delay.Round(time.Millisecond))
+
+	return c.sleep(ctx, delay)
+}
@@ -0,0 +1,96 @@
+package history
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	keySize          = 32 // AES-256
+	saltSize         = 16
+	pbkdf2Iterations = 210000
+
+	// checkPlaintext is sealed into the metadata to detect a wrong passphrase
+	checkPlaintext = "erssi-lith-bridge history"
+)
+
+// ErrWrongPassphrase is returned when opening an encrypted store with a
+// passphrase other than the one it was created with
+var ErrWrongPassphrase = errors.New("wrong history passphrase")
+
+// sealer encrypts records with AES-256-GCM under a passphrase-derived key.
+// Every record is bound to the name of the file holding it (its additional
+// data), so records can't be moved between buffers or state documents.
+type sealer struct {
+	aead    cipher.AEAD
+	nameKey []byte // HMAC key for file names, so they don't leak buffer names
+
+	// unbound stores (version 1) sealed records without additional data
+	unbound bool
+}
+
+// newSealer derives the record and file name keys from a passphrase
+func newSealer(passphrase string, salt []byte) (*sealer, error) {
+	key := pbkdf2.Key([]byte(passphrase), salt, pbkdf2Iterations, 2*keySize, sha256.New)
+
+	block, err := aes.NewCipher(key[:keySize])
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return &sealer{aead: aead, nameKey: key[keySize:]}, nil
+}
+
+// newSalt returns a random key derivation salt
+func newSalt() ([]byte, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	return salt, nil
+}
+
+// seal encrypts plaintext stored in the file name as [nonce][ciphertext+tag]
+func (s *sealer) seal(plaintext []byte, name string) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, s.additionalData(name)), nil
+}
+
+// open decrypts data produced by seal for the same file name
+func (s *sealer) open(data []byte, name string) ([]byte, error) {
+	if len(data) < s.aead.NonceSize()+s.aead.Overhead() {
+		return nil, fmt.Errorf("encrypted record too short: %d bytes", len(data))
+	}
+	nonceSize := s.aead.NonceSize()
+	return s.aead.Open(nil, data[:nonceSize], data[nonceSize:], s.additionalData(name))
+}
+
+// additionalData returns the GCM additional data of a record in the file name
+func (s *sealer) additionalData(name string) []byte {
+	if s.unbound {
+		return nil
+	}
+	return []byte(name)
+}
+
+// fileName returns an opaque file name for a buffer
+func (s *sealer) fileName(buffer string) string {
+	mac := hmac.New(sha256.New, s.nameKey)
+	mac.Write([]byte(buffer))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
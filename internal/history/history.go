@@ -0,0 +1,403 @@
+// Package history persists buffer lines across bridge restarts. Every buffer
+// is an append-only file of JSON records; with a passphrase each record is
+// encrypted with AES-256-GCM and file names are keyed hashes.
+package history
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+
+	"github.com/sirupsen/logrus"
+)
+
+// metaFile describes the store (format version, encryption parameters)
+const metaFile = "history.json"
+
+// storeVersion is the format of new stores. Version 1 sealed records without
+// binding them to their file; those stores are still read and written.
+const storeVersion = 2
+
+// Config holds history store configuration
+type Config struct {
+	Dir        string // Directory holding the store (created if missing)
+	Passphrase string // Encrypts the store at rest (empty = plaintext)
+
+	// Retention per buffer: MaxLines (default 500) and MaxAge (0 = no age
+	// limit), with overrides for matching buffers (first match wins)
+	MaxLines  int
+	MaxAge    time.Duration
+	Overrides []RetentionRule
+
+	// PruneInterval is how often old lines are pruned (default 1h)
+	PruneInterval time.Duration
+
+	Logger *logrus.Logger
+}
+
+// meta is the content of metaFile
+type meta struct {
+	Version   int    `json:"version"`
+	Encrypted bool   `json:"encrypted"`
+	Salt      []byte `json:"salt,omitempty"`
+	Check     []byte `json:"check,omitempty"` // checkPlaintext sealed with the key
+}
+
+// record is one persisted line
+type record struct {
+	Buffer    string `json:"buffer,omitempty"` // "server.target", for pruning
+	Date      int64  `json:"date"`
+	Prefix    string `json:"prefix"`
+	Message   string `json:"message"`
+	Tags      string `json:"tags,omitempty"`
+	Highlight bool   `json:"highlight,omitempty"`
+}
+
+// Store is a persistent per-buffer line history
+type Store struct {
+	dir       string
+	retention Retention
+	rules     []RetentionRule
+	sealer    *sealer // nil when the store is not encrypted
+	log       *logrus.Entry
+
+	mu     sync.Mutex
+	counts map[string]int // Lines per file, for compaction
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// Open opens or creates a history store. An existing store must be opened
+// with the passphrase it was created with; switching a store between
+// plaintext and encrypted is refused rather than mixing both.
+func Open(cfg Config) (*Store, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logrus.New()
+	}
+	if cfg.MaxLines <= 0 {
+		cfg.MaxLines = defaultMaxLines
+	}
+	if cfg.PruneInterval <= 0 {
+		cfg.PruneInterval = time.Hour
+	}
+
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	s := &Store{
+		dir:       cfg.Dir,
+		retention: Retention{MaxLines: cfg.MaxLines, MaxAge: cfg.MaxAge},
+		rules:     cfg.Overrides,
+		log:       logger.WithField("component", "history"),
+		counts:    make(map[string]int),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+
+	m, err := s.readMeta()
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if err := s.create(cfg.Passphrase); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	default:
+		if err := s.unlock(m, cfg.Passphrase); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.sealer != nil {
+		s.log.Infof("History store %s opened (encrypted)", cfg.Dir)
+	} else {
+		s.log.Infof("History store %s opened", cfg.Dir)
+	}
+
+	if _, err := s.Prune(); err != nil {
+		s.log.Warnf("History prune failed: %v", err)
+	}
+	s.startPruner(cfg.PruneInterval)
+
+	return s, nil
+}
+
+// Close stops the background pruner
+func (s *Store) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+// create initializes a new store
+func (s *Store) create(passphrase string) error {
+	m := meta{Version: storeVersion}
+
+	if passphrase != "" {
+		salt, err := newSalt()
+		if err != nil {
+			return err
+		}
+		if s.sealer, err = newSealer(passphrase, salt); err != nil {
+			return err
+		}
+		check, err := s.sealer.seal([]byte(checkPlaintext), metaFile)
+		if err != nil {
+			return err
+		}
+		m.Encrypted, m.Salt, m.Check = true, salt, check
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, metaFile), data, 0o600)
+}
+
+// unlock checks the passphrase against an existing store
+func (s *Store) unlock(m meta, passphrase string) error {
+	switch {
+	case m.Encrypted && passphrase == "":
+		return fmt.Errorf("history store %s is encrypted, a passphrase is required", s.dir)
+	case !m.Encrypted && passphrase != "":
+		return fmt.Errorf("history store %s is not encrypted; move it away to start an encrypted one", s.dir)
+	case !m.Encrypted:
+		return nil
+	}
+
+	sl, err := newSealer(passphrase, m.Salt)
+	if err != nil {
+		return err
+	}
+	sl.unbound = m.Version < 2
+	if check, err := sl.open(m.Check, metaFile); err != nil || string(check) != checkPlaintext {
+		return ErrWrongPassphrase
+	}
+	s.sealer = sl
+	return nil
+}
+
+func (s *Store) readMeta() (meta, error) {
+	var m meta
+	data, err := os.ReadFile(filepath.Join(s.dir, metaFile))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("invalid history metadata: %w", err)
+	}
+	if m.Version < 1 || m.Version > storeVersion {
+		return m, fmt.Errorf("unsupported history store version %d", m.Version)
+	}
+	return m, nil
+}
+
+// bufferName returns the name of a buffer as used in retention patterns
+func bufferName(serverTag, target string) string {
+	return serverTag + "." + strings.ToLower(target)
+}
+
+// path returns the file of a buffer
+func (s *Store) path(buffer string) string {
+	if s.sealer != nil {
+		return filepath.Join(s.dir, s.sealer.fileName(buffer)+".log")
+	}
+	return filepath.Join(s.dir, url.PathEscape(buffer)+".log")
+}
+
+// Record appends a line to a buffer's history
+func (s *Store) Record(serverTag, target string, line weechatproto.LineData) {
+	buffer := bufferName(serverTag, target)
+	path := s.path(buffer)
+	data, err := s.encode(path, record{
+		Buffer:    buffer,
+		Date:      line.Date,
+		Prefix:    line.Prefix,
+		Message:   line.Message,
+		Tags:      line.Tags,
+		Highlight: line.Highlight,
+	})
+	if err != nil {
+		s.log.Errorf("Failed to encode history line: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		s.log.Errorf("Failed to open history for %s.%s: %v", serverTag, target, err)
+		return
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.log.Errorf("Failed to write history for %s.%s: %v", serverTag, target, err)
+		return
+	}
+
+	count, ok := s.counts[path]
+	if !ok {
+		count, _ = countLines(path)
+	} else {
+		count++
+	}
+	s.counts[path] = count
+
+	// Let the file grow to twice the limit before rewriting it
+	if count > 2*s.retentionFor(buffer).MaxLines {
+		if err := s.compactLocked(path, buffer); err != nil {
+			s.log.Warnf("Failed to compact history for %s.%s: %v", serverTag, target, err)
+		}
+	}
+}
+
+// Load returns the stored lines of a buffer, oldest first. Pointers are
+// left empty for the caller to assign.
+func (s *Store) Load(serverTag, target string) []weechatproto.LineData {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	buffer := bufferName(serverTag, target)
+	path := s.path(buffer)
+	raw, err := readLines(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.log.Errorf("Failed to read history for %s.%s: %v", serverTag, target, err)
+		}
+		return nil
+	}
+
+	records := make([]record, len(raw))
+	for i, data := range raw {
+		if records[i], err = s.decode(path, data); err != nil {
+			s.log.Warnf("Skipping unreadable history line in %s.%s: %v", serverTag, target, err)
+		}
+	}
+	kept := len(s.applyRetention(buffer, raw, records))
+
+	lines := make([]weechatproto.LineData, 0, kept)
+	for _, rec := range records[len(records)-kept:] {
+		if rec.Date == 0 {
+			continue
+		}
+		lines = append(lines, weechatproto.LineData{
+			Date:        rec.Date,
+			DatePrinted: rec.Date,
+			Displayed:   true,
+			Highlight:   rec.Highlight,
+			Tags:        rec.Tags,
+			Prefix:      rec.Prefix,
+			Message:     rec.Message,
+		})
+	}
+	return lines
+}
+
+// encode serializes a record of the file at path, encrypting it when the
+// store is encrypted
+func (s *Store) encode(path string, rec record) ([]byte, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+	if s.sealer == nil {
+		return data, nil
+	}
+
+	sealed, err := s.sealer.seal(data, filepath.Base(path))
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// decode parses a record stored in the file at path
+func (s *Store) decode(path string, data []byte) (record, error) {
+	var rec record
+	if s.sealer != nil {
+		sealed, err := base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return rec, err
+		}
+		if data, err = s.sealer.open(sealed, filepath.Base(path)); err != nil {
+			return rec, err
+		}
+	}
+	err := json.Unmarshal(data, &rec)
+	return rec, err
+}
+
+// compactLocked rewrites a file keeping the newest lines allowed by the
+// buffer's line limit. Caller must hold s.mu.
+func (s *Store) compactLocked(path, buffer string) error {
+	raw, err := readLines(path)
+	if err != nil {
+		return err
+	}
+	if limit := s.retentionFor(buffer).MaxLines; len(raw) > limit {
+		raw = raw[len(raw)-limit:]
+	}
+	return s.rewriteLocked(path, raw)
+}
+
+// rewriteLocked atomically replaces a file with the given lines.
+// Caller must hold s.mu.
+func (s *Store) rewriteLocked(path string, raw [][]byte) error {
+	tmp := path + ".tmp"
+	data := append(bytes.Join(raw, []byte{'\n'}), '\n')
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	s.counts[path] = len(raw)
+	return nil
+}
+
+// readLines reads the non-empty lines of a file
+func readLines(path string) ([][]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		if line := scanner.Bytes(); len(line) > 0 {
+			lines = append(lines, bytes.Clone(line))
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// countLines counts the lines of a file
+func countLines(path string) (int, error) {
+	lines, err := readLines(path)
+	return len(lines), err
+}
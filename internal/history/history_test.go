@@ -0,0 +1,186 @@
+package history
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+
+	"github.com/sirupsen/logrus"
+)
+
+func openStore(t *testing.T, dir, passphrase string) (*Store, error) {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	return Open(Config{Dir: dir, Passphrase: passphrase, Logger: logger})
+}
+
+func testLine(message string) weechatproto.LineData {
+	return weechatproto.LineData{Date: 1700000000, Prefix: "alice", Message: message, Tags: "irc_privmsg,nick_alice"}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, passphrase := range []string{"", "correct horse"} {
+		dir := t.TempDir()
+		s, err := openStore(t, dir, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Record("libera", "#Go", testLine("hello"))
+		s.Record("libera", "#go", testLine("secret plans"))
+		if err := s.SaveState("settings", map[string]string{"nick": "tester"}); err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+
+		// Reopen, as after a restart
+		s, err = openStore(t, dir, passphrase)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		lines := s.Load("libera", "#GO")
+		if len(lines) != 2 || lines[0].Message != "hello" || lines[1].Message != "secret plans" || lines[1].Prefix != "alice" {
+			t.Errorf("passphrase %q: loaded %+v", passphrase, lines)
+		}
+		var state map[string]string
+		if err := s.LoadState("settings", &state); err != nil || state["nick"] != "tester" {
+			t.Errorf("passphrase %q: state %v, %v", passphrase, state, err)
+		}
+
+		if passphrase == "" {
+			continue
+		}
+		// Nothing readable is left on disk
+		entries, _ := os.ReadDir(dir)
+		for _, e := range entries {
+			data, _ := os.ReadFile(filepath.Join(dir, e.Name()))
+			for _, leak := range []string{"#go", "secret plans", "tester"} {
+				if strings.Contains(e.Name(), leak) || strings.Contains(string(data), leak) {
+					t.Errorf("%s leaks %q", e.Name(), leak)
+				}
+			}
+		}
+	}
+}
+
+func TestWrongPassphrase(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(t, dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Record("libera", "#go", testLine("hello"))
+	s.Close()
+
+	if _, err := openStore(t, dir, "battery staple"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("wrong passphrase: got %v, want %v", err, ErrWrongPassphrase)
+	}
+	if _, err := openStore(t, dir, ""); err == nil {
+		t.Errorf("encrypted store opened without passphrase")
+	}
+}
+
+func TestPlaintextStoreNotEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(t, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Record("libera", "#go", testLine("hello"))
+	s.Close()
+
+	if _, err := openStore(t, dir, "correct horse"); err == nil || errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("plaintext store opened with a passphrase: %v", err)
+	}
+
+	// The refusal left the store as it was
+	s, err = openStore(t, dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if lines := s.Load("libera", "#go"); len(lines) != 1 || lines[0].Message != "hello" {
+		t.Errorf("loaded %+v", lines)
+	}
+}
+
+func TestRecordsBoundToBuffer(t *testing.T) {
+	dir := t.TempDir()
+	s, err := openStore(t, dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Record("libera", "#go", testLine("hello"))
+	s.Record("libera", "#rust", testLine("other buffer"))
+
+	// Splice a record of #go into the file of #rust
+	goData, err := os.ReadFile(s.path(bufferName("libera", "#go")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rustPath := s.path(bufferName("libera", "#rust"))
+	f, err := os.OpenFile(rustPath, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(goData)
+	f.Close()
+	delete(s.counts, rustPath)
+
+	if lines := s.Load("libera", "#rust"); len(lines) != 1 || lines[0].Message != "other buffer" {
+		t.Errorf("moved record accepted: %+v", lines)
+	}
+}
+
+func TestUnboundStore(t *testing.T) {
+	dir := t.TempDir()
+
+	// A version 1 store, sealed without additional data
+	salt, err := newSalt()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl, err := newSealer("correct horse", salt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sl.unbound = true
+	check, err := sl.seal([]byte(checkPlaintext), metaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1 := &Store{dir: dir, sealer: sl}
+	if err := os.WriteFile(filepath.Join(dir, metaFile), []byte(`{"version":1,"encrypted":true,"salt":"`+
+		encodeBase64(salt)+`","check":"`+encodeBase64(check)+`"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data, err := v1.encode(v1.path("libera.#go"), record{Buffer: "libera.#go", Date: 1700000000, Message: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(v1.path("libera.#go"), append(data, '\n'), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openStore(t, dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Record("libera", "#go", testLine("still version 1"))
+	lines := s.Load("libera", "#go")
+	if len(lines) != 2 || lines[0].Message != "hello" || lines[1].Message != "still version 1" {
+		t.Errorf("loaded %+v", lines)
+	}
+}
+
+func encodeBase64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
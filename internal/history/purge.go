@@ -0,0 +1,123 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Mask matches the sender of a line by nick or nick!user@host, with * and ?
+// wildcards. Lines are matched on their nick_ and host_ tags.
+type Mask struct {
+	Pattern string
+
+	nick *regexp.Regexp
+	host *regexp.Regexp // nil when the mask is a bare nick
+}
+
+// ParseMask parses a nick or nick!user@host mask
+func ParseMask(pattern string) (Mask, error) {
+	pattern = strings.TrimSpace(pattern)
+	nick, host, hasHost := strings.Cut(pattern, "!")
+	if nick == "" || (hasHost && host == "") || strings.ContainsAny(pattern, " ,") {
+		return Mask{}, fmt.Errorf("invalid mask %q, want nick or nick!user@host", pattern)
+	}
+
+	m := Mask{Pattern: pattern, nick: wildcard(nick)}
+	if hasHost {
+		m.host = wildcard(host)
+	}
+	return m, nil
+}
+
+// wildcard compiles a case-insensitive glob with * and ?
+func wildcard(glob string) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(glob)
+	pattern = strings.ReplaceAll(pattern, `\*`, `.*`)
+	pattern = strings.ReplaceAll(pattern, `\?`, `.`)
+	return regexp.MustCompile(`(?i)^` + pattern + `$`)
+}
+
+// MatchTags reports whether a line with the given comma-separated tags was
+// sent by a matching user. A host mask never matches a line without a host
+// tag.
+func (m Mask) MatchTags(tags string) bool {
+	var nick, host string
+	for _, tag := range strings.Split(tags, ",") {
+		if v, ok := strings.CutPrefix(tag, "nick_"); ok {
+			nick = v
+		} else if v, ok := strings.CutPrefix(tag, "host_"); ok {
+			host = v
+		}
+	}
+
+	if nick == "" || !m.nick.MatchString(nick) {
+		return false
+	}
+	return m.host == nil || (host != "" && m.host.MatchString(host))
+}
+
+// Purge deletes every stored line sent by a user matching the mask, across
+// all buffers
+func (s *Store) Purge(mask Mask) (PruneResult, error) {
+	var result PruneResult
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.log"))
+	if err != nil {
+		return result, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range paths {
+		removed, deleted, err := s.purgeFileLocked(path, mask)
+		if err != nil {
+			return result, fmt.Errorf("failed to purge %s: %w", filepath.Base(path), err)
+		}
+		if removed == 0 {
+			continue
+		}
+		result.Buffers++
+		result.LinesRemoved += removed
+		if deleted {
+			result.FilesRemoved++
+		}
+	}
+
+	s.log.Infof("Purged %d history lines from %s in %d buffers",
+		result.LinesRemoved, mask.Pattern, result.Buffers)
+	return result, nil
+}
+
+// purgeFileLocked removes the lines of a matching sender from one buffer
+// file, deleting it when nothing is left. Lines that can't be decoded are
+// kept. Caller must hold s.mu.
+func (s *Store) purgeFileLocked(path string, mask Mask) (removed int, deleted bool, err error) {
+	raw, err := readLines(path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	kept := raw[:0:0]
+	for _, data := range raw {
+		rec, err := s.decode(path, data)
+		if err == nil && mask.MatchTags(rec.Tags) {
+			continue
+		}
+		kept = append(kept, data)
+	}
+
+	removed = len(raw) - len(kept)
+	if removed == 0 {
+		return 0, false, nil
+	}
+
+	if len(kept) == 0 {
+		delete(s.counts, path)
+		return removed, true, os.Remove(path)
+	}
+	return removed, false, s.rewriteLocked(path, kept)
+}
@@ -0,0 +1,217 @@
+package history
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultMaxLines is the per-buffer line limit when none is configured
+const defaultMaxLines = 500
+
+// Retention limits how much history a buffer keeps
+type Retention struct {
+	MaxLines int           // Newest lines kept (0 = store default)
+	MaxAge   time.Duration // Older lines are pruned (0 = store default)
+}
+
+// RetentionRule overrides the retention of buffers matching a pattern
+type RetentionRule struct {
+	Buffer string // Buffer pattern "server.target", * matches anything
+	Retention
+
+	match *regexp.Regexp
+}
+
+// NewRetentionRule compiles a retention override
+func NewRetentionRule(buffer string, r Retention) RetentionRule {
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(buffer), `\*`, `.*`)
+	return RetentionRule{
+		Buffer:    buffer,
+		Retention: r,
+		match:     regexp.MustCompile(`(?i)^` + pattern + `$`),
+	}
+}
+
+// ParseRetentionRules parses a comma-separated override list. Each entry is
+// "<buffer>=<limit>", the limit being a line count, an age or both
+// separated by "/":
+//
+//	libera.#busy=200,libera.#ops=7d,*.#log=1000/30d
+func ParseRetentionRules(list string) ([]RetentionRule, error) {
+	var rules []RetentionRule
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		buffer, limits, ok := strings.Cut(item, "=")
+		if !ok || buffer == "" || limits == "" {
+			return nil, fmt.Errorf("retention %q must be <buffer>=<lines>[/<age>]", item)
+		}
+
+		var r Retention
+		for _, limit := range strings.Split(limits, "/") {
+			if n, err := strconv.Atoi(limit); err == nil && n > 0 {
+				r.MaxLines = n
+				continue
+			}
+			age, err := ParseAge(limit)
+			if err != nil {
+				return nil, fmt.Errorf("retention %q: %w", item, err)
+			}
+			r.MaxAge = age
+		}
+
+		rules = append(rules, NewRetentionRule(buffer, r))
+	}
+	return rules, nil
+}
+
+// ParseAge parses a duration, also accepting whole days ("30d")
+func ParseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q", s)
+	}
+	return d, nil
+}
+
+// retentionFor returns the effective retention of a buffer ("" for a file
+// whose buffer is unknown)
+func (s *Store) retentionFor(buffer string) Retention {
+	r := s.retention
+	if buffer == "" {
+		return r
+	}
+	for _, rule := range s.rules {
+		if rule.match.MatchString(buffer) {
+			if rule.MaxLines > 0 {
+				r.MaxLines = rule.MaxLines
+			}
+			if rule.MaxAge > 0 {
+				r.MaxAge = rule.MaxAge
+			}
+			break
+		}
+	}
+	return r
+}
+
+// PruneResult summarizes a prune run
+type PruneResult struct {
+	Buffers      int // Buffer files examined
+	LinesRemoved int
+	FilesRemoved int // Buffers left empty and deleted
+}
+
+// Prune applies the retention policy to every buffer in the store
+func (s *Store) Prune() (PruneResult, error) {
+	var result PruneResult
+
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.log"))
+	if err != nil {
+		return result, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, path := range paths {
+		removed, deleted, err := s.pruneFileLocked(path)
+		if err != nil {
+			return result, fmt.Errorf("failed to prune %s: %w", filepath.Base(path), err)
+		}
+		result.Buffers++
+		result.LinesRemoved += removed
+		if deleted {
+			result.FilesRemoved++
+		}
+	}
+
+	if result.LinesRemoved > 0 {
+		s.log.Infof("Pruned %d history lines from %d buffers (%d emptied)",
+			result.LinesRemoved, result.Buffers, result.FilesRemoved)
+	}
+	return result, nil
+}
+
+// pruneFileLocked applies retention to one buffer file, deleting it when
+// nothing is left. Caller must hold s.mu.
+func (s *Store) pruneFileLocked(path string) (removed int, deleted bool, err error) {
+	raw, err := readLines(path)
+	if err != nil {
+		return 0, false, err
+	}
+
+	records := make([]record, len(raw))
+	buffer := ""
+	for i, data := range raw {
+		// Unreadable lines get date 0 and fall to the age limit
+		records[i], _ = s.decode(path, data)
+		if records[i].Buffer != "" {
+			buffer = records[i].Buffer
+		}
+	}
+
+	kept := s.applyRetention(buffer, raw, records)
+	removed = len(raw) - len(kept)
+	if removed == 0 {
+		return 0, false, nil
+	}
+
+	if len(kept) == 0 {
+		delete(s.counts, path)
+		return removed, true, os.Remove(path)
+	}
+	return removed, false, s.rewriteLocked(path, kept)
+}
+
+// applyRetention returns the raw lines a buffer keeps
+func (s *Store) applyRetention(buffer string, raw [][]byte, records []record) [][]byte {
+	r := s.retentionFor(buffer)
+
+	start := 0
+	if len(raw) > r.MaxLines {
+		start = len(raw) - r.MaxLines
+	}
+	if r.MaxAge > 0 {
+		cutoff := time.Now().Add(-r.MaxAge).Unix()
+		for start < len(raw) && records[start].Date < cutoff {
+			start++
+		}
+	}
+	return raw[start:]
+}
+
+// startPruner runs Prune every interval until Close
+func (s *Store) startPruner(interval time.Duration) {
+	go func() {
+		defer close(s.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				if _, err := s.Prune(); err != nil {
+					s.log.Warnf("History prune failed: %v", err)
+				}
+			}
+		}
+	}()
+}
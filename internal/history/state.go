@@ -0,0 +1,65 @@
+package history
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// statePath returns the file of a named state document
+func (s *Store) statePath(name string) string {
+	return filepath.Join(s.dir, name+".state")
+}
+
+// SaveState stores a small named document (e.g. bridge settings that must
+// survive restarts) in the store, encrypted like the lines when the store
+// is encrypted
+func (s *Store) SaveState(name string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	path := s.statePath(name)
+	if s.sealer != nil {
+		if data, err = s.sealer.seal(data, filepath.Base(path)); err != nil {
+			return err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// LoadState reads a document stored with SaveState into v. A document never
+// saved leaves v untouched.
+func (s *Store) LoadState(name string, v any) error {
+	path := s.statePath(name)
+	s.mu.Lock()
+	data, err := os.ReadFile(path)
+	s.mu.Unlock()
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if s.sealer != nil {
+		if data, err = s.sealer.open(data, filepath.Base(path)); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,131 @@
+package relayauth
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// htpasswd checks "user:password" against an Apache htpasswd file with
+// bcrypt ("htpasswd -B") or {SHA} entries. The file is reloaded when it
+// changes, so accounts can be managed without a restart.
+type htpasswd struct {
+	path string
+	log  *logrus.Entry
+
+	mu      sync.Mutex
+	modTime time.Time
+	users   map[string]string // user -> hash
+
+	// Compared against for unknown users, so they take as long as known ones
+	dummy []byte
+}
+
+func newHtpasswd(path string, log *logrus.Entry) (*htpasswd, error) {
+	dummy, err := bcrypt.GenerateFromPassword([]byte("relayauth"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	h := &htpasswd{path: path, log: log, dummy: dummy}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// reload reads the file again if it changed since the last read
+func (h *htpasswd) reload() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	info, err := os.Stat(h.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(h.modTime) && h.users != nil {
+		return nil
+	}
+
+	users, err := parseHtpasswd(h.path)
+	if err != nil {
+		return err
+	}
+	if h.users != nil {
+		h.log.Infof("Reloaded %s (%d accounts)", h.path, len(users))
+	}
+	h.users = users
+	h.modTime = info.ModTime()
+	return nil
+}
+
+// parseHtpasswd reads user:hash lines, skipping blanks and # comments
+func parseHtpasswd(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok || user == "" {
+			return nil, fmt.Errorf("%s:%d: expected user:hash", path, lineNum)
+		}
+		if !strings.HasPrefix(hash, "$2") && !strings.HasPrefix(hash, "{SHA}") {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for %s (use htpasswd -B)", path, lineNum, user)
+		}
+		users[user] = hash
+	}
+	return users, scanner.Err()
+}
+
+// Authenticate checks "user:password" against the file
+func (h *htpasswd) Authenticate(ctx context.Context, password, remote string) (string, error) {
+	user, secret, err := splitUser(password)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep serving the last good version if an edit broke the file
+	if err := h.reload(); err != nil {
+		h.log.Errorf("Failed to reload %s: %v", h.path, err)
+	}
+
+	h.mu.Lock()
+	hash, ok := h.users[user]
+	h.mu.Unlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(h.dummy, []byte(secret))
+		return "", ErrDenied
+	}
+
+	if sum, ok := strings.CutPrefix(hash, "{SHA}"); ok {
+		digest := sha1.Sum([]byte(secret))
+		if subtle.ConstantTimeCompare([]byte(base64.StdEncoding.EncodeToString(digest[:])), []byte(sum)) != 1 {
+			return "", ErrDenied
+		}
+		return user, nil
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) != nil {
+		return "", ErrDenied
+	}
+	return user, nil
+}
@@ -0,0 +1,111 @@
+package relayauth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// aliceSHA is "alice:secret" in {SHA} form
+const aliceSHA = "alice:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	writeFile(t, path, "# relay accounts\n\n"+aliceSHA+"\nbob:"+string(hash)+"\n")
+
+	h, err := newHtpasswd(path, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		account  string
+		err      error
+	}{
+		{"alice:secret", "alice", nil},
+		{"bob:hunter2", "bob", nil},
+		{"alice:hunter2", "", ErrDenied},
+		{"bob:secret", "", ErrDenied},
+		{"bob:", "", ErrDenied},
+		{"carol:secret", "", ErrDenied},
+		{"secret", "", ErrDenied},
+		{":secret", "", ErrDenied},
+	}
+	for _, tt := range tests {
+		account, err := h.Authenticate(context.Background(), tt.password, "192.0.2.1:4000")
+		if account != tt.account || !errors.Is(err, tt.err) {
+			t.Errorf("%q: got %q, %v, want %q, %v", tt.password, account, err, tt.account, tt.err)
+		}
+	}
+}
+
+func TestHtpasswdReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	writeFile(t, path, aliceSHA+"\n")
+
+	h, err := newHtpasswd(path, logrus.NewEntry(logrus.New()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// carol:secret, with a later mtime so the change is seen on any filesystem
+	writeFile(t, path, "carol:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n")
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if account, err := h.Authenticate(ctx, "carol:secret", ""); err != nil || account != "carol" {
+		t.Errorf("added account: got %q, %v", account, err)
+	}
+	if _, err := h.Authenticate(ctx, "alice:secret", ""); !errors.Is(err, ErrDenied) {
+		t.Errorf("removed account: got %v, want %v", err, ErrDenied)
+	}
+
+	// A broken edit keeps the last good version
+	writeFile(t, path, "carol:plaintext\n")
+	later = later.Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if account, err := h.Authenticate(ctx, "carol:secret", ""); err != nil || account != "carol" {
+		t.Errorf("after broken edit: got %q, %v", account, err)
+	}
+}
+
+func TestHtpasswdRejectsFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"plaintext": "alice:secret\n",
+		"md5":       "alice:$apr1$abc$def\n",
+		"no user":   ":{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=\n",
+		"no hash":   "alice\n",
+	} {
+		path := filepath.Join(dir, "htpasswd")
+		writeFile(t, path, content)
+		if _, err := newHtpasswd(path, logrus.NewEntry(logrus.New())); err == nil {
+			t.Errorf("%s: file accepted", name)
+		}
+	}
+	if _, err := newHtpasswd(filepath.Join(dir, "missing"), logrus.NewEntry(logrus.New())); err == nil {
+		t.Errorf("missing file accepted")
+	}
+}
@@ -0,0 +1,81 @@
+package relayauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// httpVerifier asks an external HTTP service to check credentials. It POSTs
+//
+//	{"user": "alice", "password": "secret", "remote": "192.0.2.1:51234"}
+//
+// and accepts on 200 or 204, optionally answered with {"account": "..."}
+// to override the account; 401 and 403 deny. A password without "user:"
+// is sent with an empty user, for token-style verifiers.
+type httpVerifier struct {
+	url    string
+	client *http.Client
+}
+
+func newHTTPVerifier(rawURL string, timeout time.Duration) (*httpVerifier, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid verifier URL %q", rawURL)
+	}
+	return &httpVerifier{url: rawURL, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// verifyRequest is the body sent to the verifier
+type verifyRequest struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+	Remote   string `json:"remote"`
+}
+
+// Authenticate asks the verifier
+func (v *httpVerifier) Authenticate(ctx context.Context, password, remote string) (string, error) {
+	req := verifyRequest{Password: password, Remote: remote}
+	if strings.Contains(password, ":") {
+		req.User, req.Password, _ = splitUser(password)
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, v.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := v.client.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("verifier request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "", ErrDenied
+	default:
+		return "", fmt.Errorf("verifier answered %s", resp.Status)
+	}
+
+	var answer struct {
+		Account string `json:"account"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if len(bytes.TrimSpace(data)) > 0 && json.Unmarshal(data, &answer) == nil && answer.Account != "" {
+		return answer.Account, nil
+	}
+	return req.User, nil
+}
@@ -0,0 +1,89 @@
+package relayauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPVerifier(t *testing.T) {
+	var got verifyRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		got = verifyRequest{}
+		json.NewDecoder(r.Body).Decode(&got)
+
+		switch got.User + ":" + got.Password {
+		case "alice:secret":
+			w.WriteHeader(http.StatusNoContent)
+		case "bob:secret":
+			w.Write([]byte(`{"account": "robert"}`))
+		case ":token":
+			w.Write([]byte("ok"))
+		case "mallory:secret":
+			w.WriteHeader(http.StatusForbidden)
+		case "broken:secret":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer srv.Close()
+
+	v, err := newHTTPVerifier(srv.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		password string
+		account  string
+		denied   bool
+		failed   bool
+	}{
+		{password: "alice:secret", account: "alice"},
+		{password: "bob:secret", account: "robert"},
+		{password: "token"},
+		{password: "alice:wrong", denied: true},
+		{password: "mallory:secret", denied: true},
+		{password: "broken:secret", failed: true},
+	}
+	for _, tt := range tests {
+		account, err := v.Authenticate(context.Background(), tt.password, "192.0.2.1:4000")
+		switch {
+		case tt.denied:
+			if !errors.Is(err, ErrDenied) {
+				t.Errorf("%q: got %v, want %v", tt.password, err, ErrDenied)
+			}
+		case tt.failed:
+			if err == nil || errors.Is(err, ErrDenied) {
+				t.Errorf("%q: got %v, want a verifier error", tt.password, err)
+			}
+		case err != nil || account != tt.account:
+			t.Errorf("%q: got %q, %v, want %q", tt.password, account, err, tt.account)
+		}
+	}
+	if got.Remote != "192.0.2.1:4000" {
+		t.Errorf("remote sent as %q", got.Remote)
+	}
+}
+
+func TestHTTPVerifierUnreachable(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	v, err := newHTTPVerifier(srv.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Authenticate(context.Background(), "alice:secret", ""); err == nil || errors.Is(err, ErrDenied) {
+		t.Errorf("got %v, want a verifier error", err)
+	}
+}
@@ -0,0 +1,82 @@
+//go:build pam
+
+package relayauth
+
+/*
+#cgo LDFLAGS: -lpam
+#include <security/pam_appl.h>
+#include <stdlib.h>
+#include <string.h>
+
+// conversation answers every password prompt with appdata (the password)
+static int conversation(int n, const struct pam_message **msg, struct pam_response **resp, void *appdata) {
+	struct pam_response *replies = calloc(n, sizeof(struct pam_response));
+	if (replies == NULL) {
+		return PAM_BUF_ERR;
+	}
+	for (int i = 0; i < n; i++) {
+		if (msg[i]->msg_style == PAM_PROMPT_ECHO_OFF) {
+			replies[i].resp = strdup((const char *)appdata);
+		}
+	}
+	*resp = replies;
+	return PAM_SUCCESS;
+}
+
+static int authenticate(const char *service, const char *user, const char *password) {
+	struct pam_conv conv = { conversation, (void *)password };
+	pam_handle_t *handle = NULL;
+
+	int ret = pam_start(service, user, &conv, &handle);
+	if (ret != PAM_SUCCESS) {
+		return ret;
+	}
+	ret = pam_authenticate(handle, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	if (ret == PAM_SUCCESS) {
+		ret = pam_acct_mgmt(handle, PAM_SILENT | PAM_DISALLOW_NULL_AUTHTOK);
+	}
+	pam_end(handle, ret);
+	return ret;
+}
+*/
+import "C"
+
+import (
+	"context"
+	"fmt"
+	"unsafe"
+)
+
+// pamAuth checks "user:password" with a PAM service
+type pamAuth struct {
+	service string
+}
+
+func newPAM(service string) (*pamAuth, error) {
+	return &pamAuth{service: service}, nil
+}
+
+// Authenticate runs the PAM auth and account stacks of the service
+func (p *pamAuth) Authenticate(ctx context.Context, password, remote string) (string, error) {
+	user, secret, err := splitUser(password)
+	if err != nil {
+		return "", err
+	}
+
+	cService := C.CString(p.service)
+	cUser := C.CString(user)
+	cSecret := C.CString(secret)
+	defer C.free(unsafe.Pointer(cService))
+	defer C.free(unsafe.Pointer(cUser))
+	defer C.free(unsafe.Pointer(cSecret))
+
+	switch ret := C.authenticate(cService, cUser, cSecret); ret {
+	case C.PAM_SUCCESS:
+		return user, nil
+	case C.PAM_AUTH_ERR, C.PAM_USER_UNKNOWN, C.PAM_PERM_DENIED, C.PAM_ACCT_EXPIRED,
+		C.PAM_NEW_AUTHTOK_REQD, C.PAM_MAXTRIES, C.PAM_CRED_INSUFFICIENT:
+		return "", ErrDenied
+	default:
+		return "", fmt.Errorf("PAM service %s failed (error %d)", p.service, int(ret))
+	}
+}
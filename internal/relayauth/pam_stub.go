@@ -0,0 +1,8 @@
+//go:build !pam
+
+package relayauth
+
+// newPAM is unavailable without the pam build tag
+func newPAM(service string) (Provider, error) {
+	return nil, ErrPAMNotSupported
+}
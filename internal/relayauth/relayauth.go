@@ -0,0 +1,116 @@
+// Package relayauth verifies the password relay clients send with init
+// against a pluggable provider: a static password, an htpasswd file, PAM or
+// an external HTTP verifier. The relay protocol has no user name, so the
+// providers backed by accounts take the password as "user:password" and the
+// user becomes the client's account. Only the static password can check
+// the hashed passwords of relay protocol 2.9, the other providers need the
+// password in plain. PAM needs cgo and libpam, so it is only compiled in
+// with the pam build tag.
+package relayauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDenied is returned by Authenticate for wrong credentials
+var ErrDenied = errors.New("invalid credentials")
+
+// ErrPAMNotSupported is returned for the pam provider in builds without the
+// pam tag
+var ErrPAMNotSupported = errors.New("built without PAM support (rebuild with -tags pam)")
+
+// Provider verifies relay client credentials
+type Provider interface {
+	// Authenticate checks the password a client sent with init and
+	// returns the account it belongs to ("" for a static password).
+	// Wrong credentials return ErrDenied, failures of the provider itself
+	// other errors.
+	Authenticate(ctx context.Context, password, remote string) (account string, err error)
+}
+
+// Config selects and configures a provider
+type Config struct {
+	// Provider is "password", "htpasswd:<file>", "pam[:<service>]" or
+	// "http:<url>". Empty selects "password" when Password is set and no
+	// authentication otherwise.
+	Provider string
+
+	// Password is the relay password of the password provider
+	Password string
+
+	// HTTPTimeout bounds a request to the HTTP verifier (default 5s)
+	HTTPTimeout time.Duration
+
+	Logger *logrus.Logger
+}
+
+// New creates the configured provider, nil if authentication is disabled
+func New(cfg Config) (Provider, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logrus.New()
+	}
+	log := logger.WithField("component", "relayauth")
+
+	kind, arg, _ := strings.Cut(cfg.Provider, ":")
+	switch strings.ToLower(kind) {
+	case "":
+		if cfg.Password == "" {
+			return nil, nil
+		}
+		return newStaticPassword(cfg.Password), nil
+	case "password":
+		if cfg.Password == "" {
+			return nil, fmt.Errorf("password authentication needs a relay password")
+		}
+		return newStaticPassword(cfg.Password), nil
+	case "htpasswd":
+		if arg == "" {
+			return nil, fmt.Errorf("htpasswd authentication needs a file (htpasswd:<file>)")
+		}
+		h, err := newHtpasswd(arg, log)
+		if err != nil {
+			return nil, err
+		}
+		return h, nil
+	case "pam":
+		if arg == "" {
+			arg = "login"
+		}
+		p, err := newPAM(arg)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case "http":
+		if arg == "" {
+			return nil, fmt.Errorf("http authentication needs a verifier URL (http:<url>)")
+		}
+		timeout := cfg.HTTPTimeout
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+		v, err := newHTTPVerifier(arg, timeout)
+		if err != nil {
+			return nil, err
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("unknown relay auth provider %q (want password, htpasswd, pam or http)", kind)
+	}
+}
+
+// splitUser splits a "user:password" relay password
+func splitUser(password string) (user, secret string, err error) {
+	user, secret, ok := strings.Cut(password, ":")
+	if !ok || user == "" {
+		return "", "", fmt.Errorf("%w: expected user:password", ErrDenied)
+	}
+	return user, secret, nil
+}
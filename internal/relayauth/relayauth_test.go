@@ -0,0 +1,46 @@
+package relayauth
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	writeFile(t, path, aliceSHA+"\n")
+
+	tests := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{}, "<nil>"},
+		{Config{Password: "secret"}, "*relayauth.staticPassword"},
+		{Config{Provider: "password", Password: "secret"}, "*relayauth.staticPassword"},
+		{Config{Provider: "htpasswd:" + path}, "*relayauth.htpasswd"},
+		{Config{Provider: "HTTP:https://auth.example.com/verify"}, "*relayauth.httpVerifier"},
+	}
+	for _, tt := range tests {
+		p, err := New(tt.cfg)
+		if err != nil {
+			t.Errorf("%+v: %v", tt.cfg, err)
+			continue
+		}
+		if got := typeName(p); got != tt.want {
+			t.Errorf("%+v: got %s, want %s", tt.cfg, got, tt.want)
+		}
+	}
+
+	for _, provider := range []string{"password", "htpasswd", "htpasswd:" + path + ".missing", "http", "http:ftp://example.com", "ldap"} {
+		if _, err := New(Config{Provider: provider}); err == nil {
+			t.Errorf("%q accepted", provider)
+		}
+	}
+}
+
+func typeName(p Provider) string {
+	if p == nil {
+		return "<nil>"
+	}
+	return fmt.Sprintf("%T", p)
+}
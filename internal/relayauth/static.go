@@ -0,0 +1,38 @@
+package relayauth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+)
+
+// staticPassword accepts one shared relay password. It is the only
+// provider knowing the plaintext secret, so the only one able to check
+// hashed passwords too.
+type staticPassword struct {
+	password string
+	sum      [sha256.Size]byte
+}
+
+func newStaticPassword(password string) *staticPassword {
+	return &staticPassword{password: password, sum: sha256.Sum256([]byte(password))}
+}
+
+// Authenticate compares in constant time; hashing first hides the length
+// of the password too
+func (p *staticPassword) Authenticate(ctx context.Context, password, remote string) (string, error) {
+	sum := sha256.Sum256([]byte(password))
+	if subtle.ConstantTimeCompare(sum[:], p.sum[:]) != 1 {
+		return "", ErrDenied
+	}
+	return "", nil
+}
+
+// AuthenticateHash checks a hashed password of relay protocol 2.9, see
+// weechat.HashAuthenticator
+func (p *staticPassword) AuthenticateHash(ctx context.Context, verify func(secret string) bool, remote string) (string, error) {
+	if !verify(p.password) {
+		return "", ErrDenied
+	}
+	return "", nil
+}
@@ -0,0 +1,31 @@
+package relayauth
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestStaticPassword(t *testing.T) {
+	p := newStaticPassword("secret")
+	ctx := context.Background()
+
+	if account, err := p.Authenticate(ctx, "secret", "192.0.2.1:4000"); err != nil || account != "" {
+		t.Errorf("right password: got %q, %v", account, err)
+	}
+	for _, wrong := range []string{"", "Secret", "secret ", "secretsecret"} {
+		if _, err := p.Authenticate(ctx, wrong, "192.0.2.1:4000"); !errors.Is(err, ErrDenied) {
+			t.Errorf("%q: got %v, want %v", wrong, err, ErrDenied)
+		}
+	}
+
+	equals := func(want string) func(string) bool {
+		return func(secret string) bool { return secret == want }
+	}
+	if _, err := p.AuthenticateHash(ctx, equals("secret"), "192.0.2.1:4000"); err != nil {
+		t.Errorf("matching hash: %v", err)
+	}
+	if _, err := p.AuthenticateHash(ctx, equals("wrong"), "192.0.2.1:4000"); !errors.Is(err, ErrDenied) {
+		t.Errorf("other hash: got %v, want %v", err, ErrDenied)
+	}
+}
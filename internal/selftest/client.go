@@ -0,0 +1,132 @@
+package selftest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// relayClient is a minimal WeeChat relay client built on weechatproto.Decoder
+type relayClient struct {
+	conn     net.Conn
+	messages chan *weechatproto.Message
+
+	mu  sync.Mutex
+	err error
+}
+
+// dialRelay connects to a relay and starts decoding incoming messages
+func dialRelay(addr string, timeout time.Duration) (*relayClient, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &relayClient{
+		conn:     conn,
+		messages: make(chan *weechatproto.Message, 256),
+	}
+	go c.readLoop()
+
+	return c, nil
+}
+
+func (c *relayClient) readLoop() {
+	defer close(c.messages)
+
+	decoder := weechatproto.NewDecoder(c.conn)
+	for {
+		msg, err := decoder.DecodeMessage()
+		if err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.mu.Unlock()
+			return
+		}
+		c.messages <- msg
+	}
+}
+
+// send writes one command line
+func (c *relayClient) send(format string, args ...interface{}) error {
+	line := fmt.Sprintf(format, args...)
+	_, err := c.conn.Write([]byte(line + "\n"))
+	return err
+}
+
+// expect waits for a message accepted by match, discarding others
+func (c *relayClient) expect(timeout time.Duration, match func(*weechatproto.Message) bool) (*weechatproto.Message, error) {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case msg, ok := <-c.messages:
+			if !ok {
+				c.mu.Lock()
+				err := c.err
+				c.mu.Unlock()
+				return nil, fmt.Errorf("connection closed: %v", err)
+			}
+			if match(msg) {
+				return msg, nil
+			}
+		case <-deadline.C:
+			return nil, fmt.Errorf("timed out after %s", timeout)
+		}
+	}
+}
+
+func (c *relayClient) close() {
+	c.send("quit")
+	c.conn.Close()
+}
+
+// withID matches messages carrying the given ID
+func withID(id string) func(*weechatproto.Message) bool {
+	return func(msg *weechatproto.Message) bool {
+		return msg.ID == id
+	}
+}
+
+// hdataOf returns the first hdata object of a message
+func hdataOf(msg *weechatproto.Message) (weechatproto.HData, bool) {
+	for _, obj := range msg.Data {
+		if h, ok := obj.(weechatproto.HData); ok {
+			return h, true
+		}
+	}
+	return weechatproto.HData{}, false
+}
+
+// findBuffer returns the pointer of the buffer with the given name
+func findBuffer(msg *weechatproto.Message, name string) string {
+	h, ok := hdataOf(msg)
+	if !ok {
+		return ""
+	}
+	for _, item := range h.Items {
+		if weechatproto.ObjectString(item.Objects["name"]) == name && len(item.Pointers) > 0 {
+			return item.Pointers[0]
+		}
+	}
+	return ""
+}
+
+// hasLine reports whether a line hdata contains the given text
+func hasLine(msg *weechatproto.Message, text string) bool {
+	h, ok := hdataOf(msg)
+	if !ok {
+		return false
+	}
+	for _, item := range h.Items {
+		if strings.Contains(weechatproto.ObjectString(item.Objects["message"]), text) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,368 @@
+// Package selftest runs the bridge in-process against a fake erssi server and
+// drives it with a relay client, checking the handshake, init, Lith's
+// startup sequence, hdata, nicklist, sync and input flows end to end. Soak
+// drives the same setup with synthetic load.
+package selftest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"erssi-lith-bridge/internal/bridge"
+	"erssi-lith-bridge/internal/erssi/erssitest"
+	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	testServer  = "selftest"
+	testChannel = "#selftest"
+	testBuffer  = testServer + "." + testChannel
+)
+
+// Config holds self-test configuration
+type Config struct {
+	// Timeout for each step
+	Timeout time.Duration
+	Logger  *logrus.Logger
+}
+
+// StepResult is the outcome of a single step
+type StepResult struct {
+	Name     string
+	Err      error
+	Duration time.Duration
+}
+
+// Report is the outcome of a self-test run
+type Report struct {
+	Steps []StepResult
+}
+
+// Passed reports whether every step succeeded
+func (r *Report) Passed() bool {
+	for _, step := range r.Steps {
+		if step.Err != nil {
+			return false
+		}
+	}
+	return len(r.Steps) > 0
+}
+
+// Print writes a human readable report
+func (r *Report) Print(w io.Writer) {
+	for _, step := range r.Steps {
+		status := "PASS"
+		if step.Err != nil {
+			status = "FAIL"
+		}
+		fmt.Fprintf(w, "%s  %-12s %8s", status, step.Name, step.Duration.Round(time.Millisecond))
+		if step.Err != nil {
+			fmt.Fprintf(w, "  %v", step.Err)
+		}
+		fmt.Fprintln(w)
+	}
+
+	if r.Passed() {
+		fmt.Fprintln(w, "selftest passed")
+	} else {
+		fmt.Fprintln(w, "selftest FAILED")
+	}
+}
+
+// run holds state shared between steps
+type run struct {
+	cfg    Config
+	erssi  *erssitest.Server
+	bridge *bridge.Bridge
+	client *relayClient
+
+	bufferPtr string
+}
+
+// Run executes the self-test and returns its report
+func Run(cfg Config) *Report {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 5 * time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = logrus.New()
+		cfg.Logger.SetOutput(io.Discard)
+	}
+
+	r := &run{cfg: cfg}
+	defer r.cleanup()
+
+	report := &Report{}
+	steps := []struct {
+		name string
+		fn   func() error
+	}{
+		{"start", r.start},
+		{"handshake", r.handshake},
+		{"init", r.init},
+		{"startup", r.startup},
+		{"buffers", r.buffers},
+		{"lines", r.lines},
+		{"nicklist", r.nicklist},
+		{"sync", r.sync},
+		{"input", r.input},
+	}
+
+	for _, step := range steps {
+		started := time.Now()
+		err := step.fn()
+		report.Steps = append(report.Steps, StepResult{
+			Name:     step.name,
+			Err:      err,
+			Duration: time.Since(started),
+		})
+		if err != nil {
+			break
+		}
+	}
+
+	return report
+}
+
+func (r *run) cleanup() {
+	if r.client != nil {
+		r.client.close()
+	}
+	if r.bridge != nil {
+		r.bridge.Stop()
+	}
+	if r.erssi != nil {
+		r.erssi.Close()
+	}
+}
+
+func (r *run) start() error {
+	var err error
+	r.erssi, err = erssitest.NewServer([]erssitest.Network{{
+		Tag:  testServer,
+		Nick: "tester",
+		Channels: []erssitest.Channel{{
+			Name:  testChannel,
+			Topic: "bridge self-test",
+			Nicks: []erssiproto.NickInfo{
+				{Nick: "tester", Prefix: "@"},
+				{Nick: "alice"},
+			},
+		}},
+	}})
+	if err != nil {
+		return fmt.Errorf("fake erssi: %w", err)
+	}
+
+	r.bridge, err = bridge.New(bridge.Config{
+		ErssiURL:   r.erssi.URL(),
+		ListenAddr: "127.0.0.1:0",
+		Logger:     r.cfg.Logger,
+	})
+	if err != nil {
+		return fmt.Errorf("bridge: %w", err)
+	}
+	if err := r.bridge.Start(); err != nil {
+		return fmt.Errorf("bridge: %w", err)
+	}
+
+	r.client, err = dialRelay(r.bridge.RelayAddr(), r.cfg.Timeout)
+	if err != nil {
+		return fmt.Errorf("relay: %w", err)
+	}
+	return nil
+}
+
+func (r *run) handshake() error {
+	if err := r.client.send("(handshake) handshake password_hash_algo=plain,compression=off"); err != nil {
+		return err
+	}
+
+	msg, err := r.client.expect(r.cfg.Timeout, withID("handshake"))
+	if err != nil {
+		return err
+	}
+	for _, obj := range msg.Data {
+		if h, ok := obj.(weechatproto.HashTable); ok {
+			for _, key := range h.Keys {
+				if key == "password_hash_algo" {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("handshake reply lacks password_hash_algo")
+}
+
+func (r *run) init() error {
+	// The relay sends nothing back on init; make sure the erssi side
+	// received the state request it triggers
+	if err := r.client.send("init password=,compression=off"); err != nil {
+		return err
+	}
+	return r.waitErssi(func(msg *erssiproto.WebMessage) bool {
+		return msg.Type == erssiproto.SyncServer
+	})
+}
+
+// startupRequests is the sequence Lith sends right after init: buffer list,
+// lines and hotlist of every buffer, every nicklist, then sync
+var startupRequests = []struct {
+	id      string
+	command string
+}{
+	{"buffers", "hdata buffer:gui_buffers(*) number,name,hidden,title,local_variables"},
+	{"lines", "hdata buffer:gui_buffers(*)/own_lines/last_line(-50)/data date,displayed,prefix,message,highlight,notify,tags_array"},
+	{"hotlist", "hdata hotlist:gui_hotlist(*) buffer,count"},
+	{"nicklist", "nicklist"},
+}
+
+// startup sends Lith's startup sequence while the state dump triggered by
+// init is still arriving, and checks that every request is answered in
+// order, with its ID, from the complete state
+func (r *run) startup() error {
+	for _, req := range startupRequests {
+		if err := r.client.send("(%s) %s", req.id, req.command); err != nil {
+			return err
+		}
+	}
+	if err := r.client.send("sync"); err != nil {
+		return err
+	}
+
+	replies := make(map[string]*weechatproto.Message)
+	for _, req := range startupRequests {
+		// Broadcasts (core buffer lines, _buffer_* events) may come in
+		// between; a reply to a later request may not
+		msg, err := r.client.expect(r.cfg.Timeout, func(msg *weechatproto.Message) bool {
+			return msg.ID != "" && !strings.HasPrefix(msg.ID, "_")
+		})
+		if err != nil {
+			return fmt.Errorf("waiting for %s: %w", req.id, err)
+		}
+		if msg.ID != req.id {
+			return fmt.Errorf("got reply %q while expecting %q", msg.ID, req.id)
+		}
+		replies[req.id] = msg
+	}
+
+	bufferPtr := findBuffer(replies["buffers"], testBuffer)
+	if bufferPtr == "" {
+		return fmt.Errorf("buffer list sent before the state dump completed: %s missing", testBuffer)
+	}
+	if h, ok := hdataOf(replies["lines"]); !ok || h.Path != "line_data" {
+		return fmt.Errorf("lines reply carries no line hdata")
+	}
+	if h, ok := hdataOf(replies["hotlist"]); !ok || h.Path != "hotlist" {
+		return fmt.Errorf("hotlist reply carries no hotlist hdata")
+	}
+
+	h, ok := hdataOf(replies["nicklist"])
+	if !ok || h.Path != "buffer/nicklist_item" {
+		return fmt.Errorf("nicklist reply carries no buffer/nicklist_item hdata")
+	}
+	for _, item := range h.Items {
+		if len(item.Pointers) == 2 && item.Pointers[0] == bufferPtr {
+			return nil
+		}
+	}
+	return fmt.Errorf("nicklist reply lacks the nicks of %s", testBuffer)
+}
+
+func (r *run) buffers() error {
+	// The state dump arrives asynchronously; poll until the channel shows up
+	deadline := time.Now().Add(r.cfg.Timeout)
+	for time.Now().Before(deadline) {
+		if err := r.client.send("(listbuffers) hdata buffer:gui_buffers(*) number,name,short_name"); err != nil {
+			return err
+		}
+		msg, err := r.client.expect(r.cfg.Timeout, withID("listbuffers"))
+		if err != nil {
+			return err
+		}
+		if r.bufferPtr = findBuffer(msg, testBuffer); r.bufferPtr != "" {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("buffer %s not listed", testBuffer)
+}
+
+func (r *run) lines() error {
+	if err := r.client.send("(listlines) hdata buffer:%s/own_lines/last_line(-10)/data date,prefix,message", r.bufferPtr); err != nil {
+		return err
+	}
+	msg, err := r.client.expect(r.cfg.Timeout, withID("listlines"))
+	if err != nil {
+		return err
+	}
+	if _, ok := hdataOf(msg); !ok {
+		return fmt.Errorf("lines reply carries no hdata")
+	}
+	return nil
+}
+
+func (r *run) nicklist() error {
+	if err := r.client.send("(nicklist) nicklist %s", r.bufferPtr); err != nil {
+		return err
+	}
+	_, err := r.client.expect(r.cfg.Timeout, func(msg *weechatproto.Message) bool {
+		h, ok := hdataOf(msg)
+		return ok && msg.ID == "nicklist" && h.Path == "nicklist_item" && h.Count > 0
+	})
+	return err
+}
+
+func (r *run) sync() error {
+	if err := r.client.send("sync"); err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("selftest line %d", time.Now().UnixNano())
+	if err := r.erssi.Send(&erssiproto.WebMessage{
+		Type:      erssiproto.Message,
+		ServerTag: testServer,
+		Target:    testChannel,
+		Nick:      "alice",
+		Text:      text,
+	}); err != nil {
+		return err
+	}
+
+	_, err := r.client.expect(r.cfg.Timeout, func(msg *weechatproto.Message) bool {
+		return hasLine(msg, text)
+	})
+	return err
+}
+
+func (r *run) input() error {
+	text := "hello from selftest"
+	if err := r.client.send("input %s %s", r.bufferPtr, text); err != nil {
+		return err
+	}
+	return r.waitErssi(func(msg *erssiproto.WebMessage) bool {
+		return msg.Type == erssiproto.Message && msg.Text == text
+	})
+}
+
+// waitErssi waits until the fake erssi receives a matching message
+func (r *run) waitErssi(match func(*erssiproto.WebMessage) bool) error {
+	deadline := time.NewTimer(r.cfg.Timeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case msg := <-r.erssi.Received():
+			if match(msg) {
+				return nil
+			}
+		case <-deadline.C:
+			return fmt.Errorf("erssi did not receive expected message within %s", r.cfg.Timeout)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package selftest
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"erssi-lith-bridge/internal/bridge"
+	"erssi-lith-bridge/internal/erssi/erssitest"
+	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	soakServer = "soak"
+	soakPrefix = "soak " // Line text: "soak <seq> <unix nanos>"
+)
+
+// SoakConfig describes a synthetic load run
+type SoakConfig struct {
+	Channels int           // Channels in the fake erssi state
+	Rate     float64       // Messages per second per channel
+	Clients  int           // Relay clients connected and synced
+	Duration time.Duration // How long messages are generated
+	Timeout  time.Duration // Setup and drain timeout
+	Logger   *logrus.Logger
+}
+
+// SoakReport is the outcome of a load run
+type SoakReport struct {
+	Config  SoakConfig
+	Err     error // Setup failure; the numbers are then incomplete
+	Elapsed time.Duration
+
+	Sent      int64   // Messages the fake erssi sent
+	Delivered []int64 // Lines each client received
+
+	// Latency from the fake erssi sending a message until a client
+	// decoded its line, over all clients
+	Latency struct{ P50, P90, P99, Max time.Duration }
+
+	// Memory of the whole process (bridge, fake erssi and clients)
+	HeapStart, HeapEnd, HeapPeak uint64
+	Goroutines                   int
+	GCRuns                       uint32
+}
+
+// soakRun holds the state of a load run
+type soakRun struct {
+	cfg     SoakConfig
+	erssi   *erssitest.Server
+	bridge  *bridge.Bridge
+	clients []*relayClient
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	delivered []atomic.Int64
+}
+
+// Soak runs the bridge in-process against a fake erssi, generates
+// Channels × Rate messages per second for Duration and measures what
+// Clients relay clients receive
+func Soak(cfg SoakConfig) *SoakReport {
+	if cfg.Channels <= 0 {
+		cfg.Channels = 50
+	}
+	if cfg.Rate <= 0 {
+		cfg.Rate = 1
+	}
+	if cfg.Clients <= 0 {
+		cfg.Clients = 1
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = 30 * time.Second
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = logrus.New()
+		cfg.Logger.SetOutput(io.Discard)
+	}
+
+	r := &soakRun{cfg: cfg, delivered: make([]atomic.Int64, cfg.Clients)}
+	defer r.cleanup()
+
+	report := &SoakReport{Config: cfg}
+	if err := r.setup(); err != nil {
+		report.Err = err
+		return report
+	}
+
+	runtime.GC()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	report.HeapStart = mem.HeapAlloc
+	gcStart := mem.NumGC
+
+	for i, c := range r.clients {
+		go r.consume(i, c)
+	}
+
+	stopSampling := make(chan struct{})
+	peak := make(chan uint64)
+	go samplePeakHeap(stopSampling, peak)
+
+	started := time.Now()
+	sent, err := r.generate()
+	report.Sent = sent
+	report.Err = err
+	r.drain(sent)
+	report.Elapsed = time.Since(started)
+
+	close(stopSampling)
+	report.HeapPeak = <-peak
+	runtime.ReadMemStats(&mem)
+	report.HeapEnd = mem.HeapAlloc
+	report.GCRuns = mem.NumGC - gcStart
+	report.Goroutines = runtime.NumGoroutine()
+
+	report.Delivered = make([]int64, len(r.delivered))
+	for i := range r.delivered {
+		report.Delivered[i] = r.delivered[i].Load()
+	}
+
+	r.mu.Lock()
+	latencies := r.latencies
+	r.mu.Unlock()
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	if n := len(latencies); n > 0 {
+		report.Latency.P50 = latencies[n*50/100]
+		report.Latency.P90 = latencies[n*90/100]
+		report.Latency.P99 = latencies[n*99/100]
+		report.Latency.Max = latencies[n-1]
+	}
+
+	return report
+}
+
+func (r *soakRun) cleanup() {
+	for _, c := range r.clients {
+		c.close()
+	}
+	if r.bridge != nil {
+		r.bridge.Stop()
+	}
+	if r.erssi != nil {
+		r.erssi.Close()
+	}
+}
+
+// setup starts the fake erssi and the bridge, and connects and syncs the
+// clients once the bridge lists every channel
+func (r *soakRun) setup() error {
+	channels := make([]erssitest.Channel, r.cfg.Channels)
+	for i := range channels {
+		channels[i] = erssitest.Channel{
+			Name:  soakChannel(i),
+			Nicks: []erssiproto.NickInfo{{Nick: "soaker", Prefix: "@"}, {Nick: "loadgen"}},
+		}
+	}
+
+	var err error
+	r.erssi, err = erssitest.NewServer([]erssitest.Network{{Tag: soakServer, Nick: "soaker", Channels: channels}})
+	if err != nil {
+		return fmt.Errorf("fake erssi: %w", err)
+	}
+
+	r.bridge, err = bridge.New(bridge.Config{
+		ErssiURL:   r.erssi.URL(),
+		ListenAddr: "127.0.0.1:0",
+		Logger:     r.cfg.Logger,
+	})
+	if err != nil {
+		return fmt.Errorf("bridge: %w", err)
+	}
+	if err := r.bridge.Start(); err != nil {
+		return fmt.Errorf("bridge: %w", err)
+	}
+
+	for i := 0; i < r.cfg.Clients; i++ {
+		c, err := dialRelay(r.bridge.RelayAddr(), r.cfg.Timeout)
+		if err != nil {
+			return fmt.Errorf("relay client %d: %w", i+1, err)
+		}
+		r.clients = append(r.clients, c)
+
+		if err := c.send("(handshake) handshake password_hash_algo=plain,compression=off"); err != nil {
+			return err
+		}
+		if _, err := c.expect(r.cfg.Timeout, withID("handshake")); err != nil {
+			return fmt.Errorf("relay client %d handshake: %w", i+1, err)
+		}
+		if err := c.send("init password=,compression=off"); err != nil {
+			return err
+		}
+	}
+
+	if err := r.waitForBuffers(); err != nil {
+		return err
+	}
+	for _, c := range r.clients {
+		if err := c.send("sync"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitForBuffers polls the buffer list until every channel is loaded
+func (r *soakRun) waitForBuffers() error {
+	c := r.clients[0]
+	deadline := time.Now().Add(r.cfg.Timeout)
+	for time.Now().Before(deadline) {
+		if err := c.send("(soakbuffers) hdata buffer:gui_buffers(*) name"); err != nil {
+			return err
+		}
+		msg, err := c.expect(r.cfg.Timeout, withID("soakbuffers"))
+		if err != nil {
+			return err
+		}
+		if findBuffer(msg, soakServer+"."+soakChannel(r.cfg.Channels-1)) != "" {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("bridge didn't list all %d channels within %s", r.cfg.Channels, r.cfg.Timeout)
+}
+
+// generate sends messages round-robin over the channels at the configured
+// total rate. Returns how many were sent.
+func (r *soakRun) generate() (int64, error) {
+	total := r.cfg.Rate * float64(r.cfg.Channels)
+	interval := time.Duration(float64(time.Second) / total)
+
+	// Send in batches every few milliseconds rather than sleeping per
+	// message, so high rates aren't limited by timer resolution
+	const tick = 5 * time.Millisecond
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+
+	started := time.Now()
+	var sent int64
+	for now := range ticker.C {
+		elapsed := now.Sub(started)
+		if elapsed >= r.cfg.Duration {
+			return sent, nil
+		}
+
+		due := int64(elapsed / interval)
+		for ; sent < due; sent++ {
+			err := r.erssi.Send(&erssiproto.WebMessage{
+				Type:      erssiproto.Message,
+				ServerTag: soakServer,
+				Target:    soakChannel(int(sent % int64(r.cfg.Channels))),
+				Nick:      "loadgen",
+				Text:      soakPrefix + strconv.FormatInt(sent, 10) + " " + strconv.FormatInt(time.Now().UnixNano(), 10),
+			})
+			if err != nil {
+				return sent, fmt.Errorf("fake erssi send: %w", err)
+			}
+		}
+	}
+	return sent, nil
+}
+
+// drain waits until every client received sent lines or the timeout expires
+func (r *soakRun) drain(sent int64) {
+	deadline := time.Now().Add(r.cfg.Timeout)
+	for time.Now().Before(deadline) {
+		done := true
+		for i := range r.delivered {
+			if r.delivered[i].Load() < sent {
+				done = false
+			}
+		}
+		if done {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// consume counts the soak lines a client receives and records their latency
+func (r *soakRun) consume(index int, c *relayClient) {
+	for msg := range c.messages {
+		h, ok := hdataOf(msg)
+		if !ok || h.Path != "line_data" {
+			continue
+		}
+		received := time.Now()
+
+		for _, item := range h.Items {
+			text := weechatproto.ObjectString(item.Objects["message"])
+			fields := strings.Fields(strings.TrimPrefix(text, soakPrefix))
+			if !strings.HasPrefix(text, soakPrefix) || len(fields) != 2 {
+				continue
+			}
+			sentAt, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				continue
+			}
+
+			r.delivered[index].Add(1)
+			r.mu.Lock()
+			r.latencies = append(r.latencies, received.Sub(time.Unix(0, sentAt)))
+			r.mu.Unlock()
+		}
+	}
+}
+
+// samplePeakHeap samples the heap size until stop is closed, then sends
+// the peak
+func samplePeakHeap(stop <-chan struct{}, peak chan<- uint64) {
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	var max uint64
+	var mem runtime.MemStats
+	for {
+		runtime.ReadMemStats(&mem)
+		if mem.HeapAlloc > max {
+			max = mem.HeapAlloc
+		}
+
+		select {
+		case <-stop:
+			peak <- max
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func soakChannel(i int) string {
+	return fmt.Sprintf("#soak%d", i+1)
+}
+
+// Print writes a human readable report
+func (r *SoakReport) Print(w io.Writer) {
+	cfg := r.Config
+	fmt.Fprintf(w, "load:       %d channels x %g msg/s, %d clients, %s\n",
+		cfg.Channels, cfg.Rate, cfg.Clients, cfg.Duration)
+
+	if r.Err != nil {
+		fmt.Fprintf(w, "error:      %v\n", r.Err)
+	}
+	if r.Elapsed == 0 {
+		return
+	}
+
+	seconds := r.Elapsed.Seconds()
+	fmt.Fprintf(w, "sent:       %d messages (%.0f/s)\n", r.Sent, float64(r.Sent)/seconds)
+	for i, n := range r.Delivered {
+		fmt.Fprintf(w, "client %-3d  %d lines (%.0f/s), %d missing\n", i+1, n, float64(n)/seconds, r.Sent-n)
+	}
+	fmt.Fprintf(w, "latency:    p50 %s  p90 %s  p99 %s  max %s\n",
+		roundLatency(r.Latency.P50), roundLatency(r.Latency.P90), roundLatency(r.Latency.P99), roundLatency(r.Latency.Max))
+	fmt.Fprintf(w, "heap:       %s at start, %s at end, %s peak (whole process)\n",
+		formatBytes(r.HeapStart), formatBytes(r.HeapEnd), formatBytes(r.HeapPeak))
+	fmt.Fprintf(w, "runtime:    %d goroutines, %d GC runs\n", r.Goroutines, r.GCRuns)
+}
+
+// Lossless reports whether the run completed and every client received
+// every message
+func (r *SoakReport) Lossless() bool {
+	if r.Err != nil || r.Elapsed == 0 {
+		return false
+	}
+	for _, n := range r.Delivered {
+		if n != r.Sent {
+			return false
+		}
+	}
+	return true
+}
+
+func roundLatency(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(100 * time.Microsecond)
+}
+
+func formatBytes(n uint64) string {
+	return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+}
@@ -0,0 +1,23 @@
+//go:build !tsnet
+
+package tailnet
+
+import "net"
+
+// Node is a running embedded tailnet node
+type Node struct{}
+
+// Start brings the node up and waits until it is connected to the tailnet
+func Start(cfg Config) (*Node, error) {
+	return nil, ErrNotSupported
+}
+
+// Listen opens a listener on the node's tailnet addresses
+func (n *Node) Listen(network, address string) (net.Listener, error) {
+	return nil, ErrNotSupported
+}
+
+// Close leaves the tailnet
+func (n *Node) Close() error {
+	return nil
+}
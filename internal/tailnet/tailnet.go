@@ -0,0 +1,30 @@
+// Package tailnet runs an embedded Tailscale node (tsnet) so the relay can
+// listen directly on a tailnet address with MagicDNS, without opening ports.
+// tsnet is a large dependency, so it is only compiled in with the tsnet build
+// tag; without it Start returns ErrNotSupported.
+package tailnet
+
+import (
+	"errors"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ErrNotSupported is returned by Start in builds without the tsnet tag
+var ErrNotSupported = errors.New("built without tailscale support (rebuild with -tags tsnet)")
+
+// Config holds embedded node configuration
+type Config struct {
+	// Hostname is the machine name on the tailnet (and its MagicDNS name)
+	Hostname string
+	// StateDir stores the node identity between restarts
+	StateDir string
+	// AuthKey registers the node without interactive login (TS_AUTHKEY)
+	AuthKey string
+	// ControlURL selects a Headscale server instead of Tailscale's
+	ControlURL string
+	// Ephemeral removes the node from the tailnet when it goes offline
+	Ephemeral bool
+
+	Logger *logrus.Logger
+}
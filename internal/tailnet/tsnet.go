@@ -0,0 +1,67 @@
+//go:build tsnet
+
+package tailnet
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"tailscale.com/tsnet"
+)
+
+// Node is a running embedded tailnet node
+type Node struct {
+	server *tsnet.Server
+	log    *logrus.Entry
+}
+
+// Start brings the node up and waits until it is connected to the tailnet
+func Start(cfg Config) (*Node, error) {
+	logger := cfg.Logger
+	if logger == nil {
+		logger = logrus.New()
+	}
+	log := logger.WithField("component", "tailnet")
+
+	server := &tsnet.Server{
+		Hostname:   cfg.Hostname,
+		Dir:        cfg.StateDir,
+		AuthKey:    cfg.AuthKey,
+		ControlURL: cfg.ControlURL,
+		Ephemeral:  cfg.Ephemeral,
+		Logf: func(format string, args ...any) {
+			log.Debugf(format, args...)
+		},
+		UserLogf: func(format string, args ...any) {
+			log.Infof(format, args...)
+		},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	status, err := server.Up(ctx)
+	if err != nil {
+		server.Close()
+		return nil, fmt.Errorf("failed to join tailnet: %w", err)
+	}
+
+	for _, ip := range status.TailscaleIPs {
+		log.Infof("Joined tailnet as %s (%s)", cfg.Hostname, ip)
+	}
+
+	return &Node{server: server, log: log}, nil
+}
+
+// Listen opens a listener on the node's tailnet addresses
+func (n *Node) Listen(network, address string) (net.Listener, error) {
+	return n.server.Listen(network, address)
+}
+
+// Close leaves the tailnet
+func (n *Node) Close() error {
+	return n.server.Close()
+}
@@ -0,0 +1,131 @@
+package translator
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Charset is the text encoding of an IRC network
+type Charset string
+
+const (
+	CharsetUTF8   Charset = "utf-8"
+	CharsetLatin1 Charset = "latin-1"
+	CharsetCP1252 Charset = "cp1252"
+)
+
+// charsetAliases maps the accepted spellings of the charsets
+var charsetAliases = map[string]Charset{
+	"utf-8":        CharsetUTF8,
+	"utf8":         CharsetUTF8,
+	"latin-1":      CharsetLatin1,
+	"latin1":       CharsetLatin1,
+	"iso-8859-1":   CharsetLatin1,
+	"cp1252":       CharsetCP1252,
+	"windows-1252": CharsetCP1252,
+}
+
+// charmap returns the single-byte table of a charset, nil for UTF-8
+func (c Charset) charmap() *charmap.Charmap {
+	switch c {
+	case CharsetLatin1:
+		return charmap.ISO8859_1
+	case CharsetCP1252:
+		return charmap.Windows1252
+	}
+	return nil
+}
+
+// ParseCharsets parses "server=charset" entries into a map by server tag.
+// A "*" entry sets the charset of unlisted servers (default UTF-8).
+func ParseCharsets(entries []string) (map[string]Charset, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	charsets := make(map[string]Charset)
+	for _, entry := range entries {
+		server, name, ok := strings.Cut(entry, "=")
+		server = strings.TrimSpace(server)
+		if !ok || server == "" {
+			return nil, fmt.Errorf("invalid charset %q (want server=charset)", entry)
+		}
+		charset, ok := charsetAliases[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown charset %q for %s (want utf-8, latin-1 or cp1252)", name, server)
+		}
+		charsets[server] = charset
+	}
+	return charsets, nil
+}
+
+// SetCharsets sets the text encoding of servers, see ParseCharsets. Must
+// be called before any state is loaded.
+func (t *Translator) SetCharsets(charsets map[string]Charset) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.charsets = charsets
+}
+
+// charmapLocked returns the table of a server's charset, nil for UTF-8
+// (buffersMu held)
+func (t *Translator) charmapLocked(serverTag string) *charmap.Charmap {
+	charset, ok := t.charsets[serverTag]
+	if !ok {
+		charset = t.charsets["*"]
+	}
+	return charset.charmap()
+}
+
+// decodeText converts text of a legacy network to UTF-8. Without recode
+// rules irssi passes the network's bytes through and fe-web carries each
+// of them as the Latin-1 character of the same value. Text with other
+// characters was already recoded by irssi and is kept, and so are bytes
+// that form valid UTF-8, like irssi's recode_autodetect_utf8.
+func (t *Translator) decodeText(serverTag, text string) string {
+	table := t.charmapLocked(serverTag)
+	if table == nil {
+		return text
+	}
+
+	raw := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xff {
+			return text
+		}
+		raw = append(raw, byte(r))
+	}
+	if utf8.Valid(raw) {
+		return string(raw)
+	}
+
+	var decoded strings.Builder
+	for _, b := range raw {
+		decoded.WriteRune(table.DecodeByte(b))
+	}
+	return decoded.String()
+}
+
+// encodeText converts outgoing text to the bytes of a legacy network,
+// carried as Latin-1 characters like incoming text (see decodeText).
+// Characters the charset lacks become "?".
+func (t *Translator) encodeText(serverTag, text string) string {
+	table := t.charmapLocked(serverTag)
+	if table == nil {
+		return text
+	}
+
+	var encoded strings.Builder
+	for _, r := range text {
+		b, ok := table.EncodeRune(r)
+		if !ok {
+			b = '?'
+		}
+		encoded.WriteRune(rune(b))
+	}
+	return encoded.String()
+}
@@ -0,0 +1,477 @@
+package translator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// OutgoingColors decides what happens to formatting in text sent to erssi.
+// Clients may compose text with WeeChat color codes, which mean nothing on
+// IRC.
+type OutgoingColors string
+
+const (
+	// ColorsConvert translates WeeChat codes to mIRC formatting
+	ColorsConvert OutgoingColors = "convert"
+	// ColorsStrip removes WeeChat codes and mIRC formatting
+	ColorsStrip OutgoingColors = "strip"
+)
+
+// ParseOutgoingColors parses an outgoing colors mode (empty means convert)
+func ParseOutgoingColors(s string) (OutgoingColors, error) {
+	switch OutgoingColors(strings.ToLower(s)) {
+	case "", ColorsConvert:
+		return ColorsConvert, nil
+	case ColorsStrip:
+		return ColorsStrip, nil
+	default:
+		return "", fmt.Errorf("unknown outgoing colors mode %q (want convert or strip)", s)
+	}
+}
+
+// SetOutgoingColors sets how formatting in outgoing text is handled
+func (t *Translator) SetOutgoingColors(mode OutgoingColors) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.outgoingColors = mode
+}
+
+// WeeChat formatting codes (see the relay protocol, "Colors")
+const (
+	wcColor     = 0x19 // Color spec follows
+	wcSetAttr   = 0x1a // Attribute char follows
+	wcUnsetAttr = 0x1b // Attribute char follows
+	wcReset     = 0x1c // Reset color and attributes
+)
+
+// mIRC formatting codes
+const (
+	ircBold      = 0x02
+	ircColor     = 0x03
+	ircHexColor  = 0x04
+	ircReset     = 0x0f
+	ircMonospace = 0x11
+	ircReverse   = 0x16
+	ircItalic    = 0x1d
+	ircStrike    = 0x1e
+	ircUnderline = 0x1f
+
+	ircDefaultColor = 99
+)
+
+// ircAttrs maps WeeChat attribute chars to mIRC toggles ("|", keep
+// attributes, has no mIRC equivalent)
+var ircAttrs = map[byte]byte{
+	'*': ircBold,
+	'!': ircReverse,
+	'/': ircItalic,
+	'_': ircUnderline,
+}
+
+// ircColorOfStd maps WeeChat's basic colors (see weechatproto.ColorCode)
+// to mIRC colors
+var ircColorOfStd = []int{
+	ircDefaultColor, 1, 14, 5, 4, 3, 9, 7, 8, 2, 12, 6, 13, 10, 11, 15, 0,
+}
+
+// ircColorOfTerm maps the 16 terminal colors at the start of the 256-color
+// palette to mIRC colors
+var ircColorOfTerm = []int{1, 5, 3, 7, 2, 6, 10, 15, 14, 4, 9, 8, 12, 13, 11, 0}
+
+// ircPalette is the RGB of the 16 mIRC colors, for approximating the rest
+// of the 256-color palette
+var ircPalette = [16][3]int{
+	{255, 255, 255}, {0, 0, 0}, {0, 0, 127}, {0, 147, 0},
+	{255, 0, 0}, {127, 0, 0}, {156, 0, 156}, {252, 127, 0},
+	{255, 255, 0}, {0, 252, 0}, {0, 147, 147}, {0, 255, 255},
+	{0, 0, 252}, {255, 0, 255}, {127, 127, 127}, {210, 210, 210},
+}
+
+// formatOutgoing applies the outgoing colors mode to text
+func formatOutgoing(text string, mode OutgoingColors) string {
+	if mode == ColorsStrip {
+		return stripFormatting(text)
+	}
+	if !strings.ContainsAny(text, "\x19\x1a\x1b\x1c") {
+		return text
+	}
+	return weechatToIRC(text)
+}
+
+// weechatToIRC translates WeeChat color codes to mIRC formatting. Codes
+// without an IRC equivalent (bar colors, emphasis, option colors) are
+// dropped; mIRC codes already in text are kept.
+func weechatToIRC(text string) string {
+	var out strings.Builder
+	out.Grow(len(text))
+
+	// mIRC attributes toggle, so track which are on
+	on := make(map[byte]bool)
+	fg := ircDefaultColor
+	setAttr := func(code byte, set bool) {
+		if on[code] != set {
+			on[code] = set
+			out.WriteByte(code)
+		}
+	}
+
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case wcReset:
+			out.WriteByte(ircReset)
+			clear(on)
+			fg = ircDefaultColor
+			i++
+
+		case wcSetAttr, wcUnsetAttr:
+			if i+1 < len(text) {
+				if code, ok := ircAttrs[text[i+1]]; ok {
+					setAttr(code, text[i] == wcSetAttr)
+				}
+				i++
+			}
+			i++
+
+		case wcColor:
+			spec := parseColorSpec(text[i+1:])
+			i += 1 + spec.length
+			for _, attr := range spec.attrs {
+				setAttr(attr, true)
+			}
+			switch {
+			case spec.resetColor:
+				fg = ircDefaultColor
+				fmt.Fprintf(&out, "\x03%02d,%02d", ircDefaultColor, ircDefaultColor)
+			case spec.fg >= 0 && spec.bg >= 0:
+				fg = spec.fg
+				fmt.Fprintf(&out, "\x03%02d,%02d", spec.fg, spec.bg)
+			case spec.fg >= 0:
+				fg = spec.fg
+				fmt.Fprintf(&out, "\x03%02d", spec.fg)
+			case spec.bg >= 0:
+				// mIRC has no background-only code, repeat the foreground
+				fmt.Fprintf(&out, "\x03%02d,%02d", fg, spec.bg)
+			}
+
+		default:
+			out.WriteByte(text[i])
+			i++
+		}
+	}
+
+	return out.String()
+}
+
+// colorSpec is a parsed WeeChat color code, with colors as mIRC numbers
+// (-1 = unchanged)
+type colorSpec struct {
+	length     int // Bytes after the 0x19
+	fg, bg     int
+	attrs      []byte // mIRC toggles to switch on
+	resetColor bool
+}
+
+// parseColorSpec parses the spec following a 0x19 byte
+func parseColorSpec(s string) colorSpec {
+	spec := colorSpec{fg: -1, bg: -1}
+	if s == "" {
+		return spec
+	}
+
+	switch s[0] {
+	case wcReset:
+		spec.length = 1
+		spec.resetColor = true
+	case 'F', 'B':
+		n := 1
+		if s[0] == 'F' {
+			n += spec.parseAttrs(s[n:])
+		}
+		color, length := parseColor(s[n:])
+		spec.length = n + length
+		if s[0] == 'F' {
+			spec.fg = color
+		} else {
+			spec.bg = color
+		}
+	case '*':
+		n := 1 + spec.parseAttrs(s[1:])
+		color, length := parseColor(s[n:])
+		spec.fg = color
+		n += length
+		if n < len(s) && (s[n] == ',' || s[n] == '~') {
+			color, length = parseColor(s[n+1:])
+			if length > 0 {
+				spec.bg = color
+				n += 1 + length
+			}
+		}
+		spec.length = n
+	case 'b':
+		// Bar color or attribute: one char, no IRC meaning
+		spec.length = min(2, len(s))
+	case 'E':
+		// Emphasis (search highlight)
+		spec.length = 1
+	default:
+		// Color of a WeeChat option
+		_, spec.length = parseColor(s)
+	}
+
+	return spec
+}
+
+// parseAttrs consumes WeeChat attribute chars. Returns the bytes consumed.
+func (spec *colorSpec) parseAttrs(s string) int {
+	n := 0
+	for n < len(s) {
+		if code, ok := ircAttrs[s[n]]; ok {
+			spec.attrs = append(spec.attrs, code)
+		} else if s[n] != '|' {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// parseColor parses a basic ("05") or 256-color ("@00214") WeeChat color
+// into a mIRC color. Returns -1 and the bytes consumed if it isn't one.
+func parseColor(s string) (color, length int) {
+	if strings.HasPrefix(s, "@") {
+		n, ok := parseDigits(s[1:], 5)
+		if !ok {
+			return -1, 0
+		}
+		return ircColorOfExt(n), 6
+	}
+
+	n, ok := parseDigits(s, 2)
+	if !ok {
+		return -1, 0
+	}
+	if n >= len(ircColorOfStd) {
+		return -1, 2
+	}
+	return ircColorOfStd[n], 2
+}
+
+// parseDigits parses exactly width leading decimal digits
+func parseDigits(s string, width int) (int, bool) {
+	if len(s) < width {
+		return 0, false
+	}
+	for i := 0; i < width; i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	n, _ := strconv.Atoi(s[:width])
+	return n, true
+}
+
+// ircColorOfExt approximates a 256-color palette entry with the nearest
+// mIRC color
+func ircColorOfExt(n int) int {
+	if n < len(ircColorOfTerm) {
+		return ircColorOfTerm[n]
+	}
+	if n > 255 {
+		return ircDefaultColor
+	}
+
+	var rgb [3]int
+	if n >= 232 {
+		// Grayscale ramp
+		level := 8 + (n-232)*10
+		rgb = [3]int{level, level, level}
+	} else {
+		// 6x6x6 color cube
+		n -= 16
+		for i, v := range []int{n / 36, n / 6 % 6, n % 6} {
+			if v > 0 {
+				rgb[i] = 55 + v*40
+			}
+		}
+	}
+
+	best, bestDist := 0, -1
+	for i, p := range ircPalette {
+		dist := 0
+		for c := range rgb {
+			d := rgb[c] - p[c]
+			dist += d * d
+		}
+		if bestDist < 0 || dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+// stripFormatting removes WeeChat color codes and mIRC formatting
+func stripFormatting(text string) string {
+	if !strings.ContainsAny(text, "\x02\x03\x04\x0f\x11\x16\x19\x1a\x1b\x1c\x1d\x1e\x1f") {
+		return text
+	}
+
+	var out strings.Builder
+	out.Grow(len(text))
+
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case wcColor:
+			i += 1 + parseColorSpec(text[i+1:]).length
+		case wcSetAttr, wcUnsetAttr:
+			i += min(2, len(text)-i)
+		case ircColor:
+			i += 1 + ircColorLength(text[i+1:], isDigit, 2)
+		case ircHexColor:
+			i += 1 + ircColorLength(text[i+1:], isHexDigit, 6)
+		case wcReset, ircBold, ircReset, ircMonospace, ircReverse, ircItalic, ircStrike, ircUnderline:
+			i++
+		default:
+			out.WriteByte(text[i])
+			i++
+		}
+	}
+
+	return out.String()
+}
+
+// ircColorLength returns the length of the "fg[,bg]" after a mIRC color
+// code, each at most width digits
+func ircColorLength(s string, digit func(byte) bool, width int) int {
+	count := func(s string) int {
+		n := 0
+		for n < len(s) && n < width && digit(s[n]) {
+			n++
+		}
+		return n
+	}
+
+	n := count(s)
+	if n > 0 && n < len(s) && s[n] == ',' {
+		if bg := count(s[n+1:]); bg > 0 {
+			n += 1 + bg
+		}
+	}
+	return n
+}
+
+func isDigit(b byte) bool {
+	return b >= '0' && b <= '9'
+}
+
+func isHexDigit(b byte) bool {
+	return isDigit(b) || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+// PreviewOutgoing returns text as the outgoing colors mode sends it to IRC,
+// with formatting codes spelled out in caret notation (^B bold, ^C color,
+// ^O reset...), and as WeeChat codes showing how IRC users will see it
+func (t *Translator) PreviewOutgoing(text string) (sent, shown string) {
+	t.buffersMu.RLock()
+	mode := t.outgoingColors
+	t.buffersMu.RUnlock()
+
+	out := formatOutgoing(text, mode)
+	return caretNotation(out), ircToWeechat(out)
+}
+
+// caretNotation makes control characters visible as ^ and a letter
+func caretNotation(text string) string {
+	var out strings.Builder
+	out.Grow(len(text))
+
+	for i := 0; i < len(text); i++ {
+		if text[i] < 0x20 {
+			out.WriteByte('^')
+			out.WriteByte(text[i] + 0x40)
+			continue
+		}
+		out.WriteByte(text[i])
+	}
+	return out.String()
+}
+
+// ircAttrChars maps mIRC toggles to WeeChat attribute chars
+var ircAttrChars = map[byte]byte{
+	ircBold:      '*',
+	ircReverse:   '!',
+	ircItalic:    '/',
+	ircUnderline: '_',
+}
+
+// weechatColorOfIRC returns the WeeChat basic color of a mIRC color, "00"
+// (default) for 99 and those out of range
+func weechatColorOfIRC(color int) string {
+	for std, irc := range ircColorOfStd {
+		if irc == color {
+			return fmt.Sprintf("%02d", std)
+		}
+	}
+	return "00"
+}
+
+// ircToWeechat translates mIRC formatting to WeeChat color codes, the
+// reverse of weechatToIRC. Hex colors, monospace and strikethrough have no
+// WeeChat equivalent and are dropped.
+func ircToWeechat(text string) string {
+	if !strings.ContainsAny(text, "\x02\x03\x04\x0f\x11\x16\x1d\x1e\x1f") {
+		return text
+	}
+
+	var out strings.Builder
+	out.Grow(len(text))
+
+	on := make(map[byte]bool)
+	for i := 0; i < len(text); {
+		switch text[i] {
+		case ircBold, ircReverse, ircItalic, ircUnderline:
+			on[text[i]] = !on[text[i]]
+			if on[text[i]] {
+				out.WriteByte(wcSetAttr)
+			} else {
+				out.WriteByte(wcUnsetAttr)
+			}
+			out.WriteByte(ircAttrChars[text[i]])
+			i++
+
+		case ircColor:
+			n := ircColorLength(text[i+1:], isDigit, 2)
+			fgSpec, bgSpec, _ := strings.Cut(text[i+1:i+1+n], ",")
+			i += 1 + n
+			if fgSpec == "" {
+				// A bare ^C resets the colors
+				out.WriteString("\x19\x1c")
+				continue
+			}
+			fg, _ := strconv.Atoi(fgSpec)
+			fmt.Fprintf(&out, "\x19*%s", weechatColorOfIRC(fg))
+			if bgSpec != "" {
+				bg, _ := strconv.Atoi(bgSpec)
+				fmt.Fprintf(&out, ",%s", weechatColorOfIRC(bg))
+			}
+
+		case ircHexColor:
+			i += 1 + ircColorLength(text[i+1:], isHexDigit, 6)
+
+		case ircReset:
+			out.WriteByte(wcReset)
+			clear(on)
+			i++
+
+		case ircMonospace, ircStrike:
+			i++
+
+		default:
+			out.WriteByte(text[i])
+			i++
+		}
+	}
+
+	return out.String()
+}
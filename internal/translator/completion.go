@@ -0,0 +1,149 @@
+package translator
+
+import (
+	"sort"
+	"strings"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// nickCompleter follows a nick completed at the start of the input
+const nickCompleter = ":"
+
+// completionCommands are the commands completed after "/": the common
+// irssi commands (fe-web doesn't list them) and those of the bridge
+var completionCommands = []string{
+	"action", "away", "back", "ban", "bridge", "close", "context", "ctcp",
+	"cycle", "delete", "deop", "devoice", "edit", "ignore", "invite", "join",
+	"kick", "kickban", "knock", "list", "me", "mode", "msg", "names", "nick",
+	"notice", "op", "part", "query", "quit", "quote", "topic", "unban",
+	"unignore", "voice", "who", "whois", "whowas",
+}
+
+// Complete completes the word before position (in characters, -1 = the
+// end) of data, the input of a buffer, like WeeChat's completion command:
+// commands after a leading "/", channels of the server for words starting
+// with "#", and nicks of the buffer otherwise, recent speakers first.
+// Buffers unknown or outside the view get an empty reply.
+func (t *Translator) Complete(bufferPtr, data string, position int, msgID string, view BufferView) *weechatproto.Message {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	var buf *BufferState
+	for _, b := range t.buffers {
+		if b.Pointer == bufferPtr && view.shows(b) {
+			buf = b
+			break
+		}
+	}
+	if buf == nil {
+		return weechatproto.CreateCompletionHDataWithID(nil, msgID)
+	}
+
+	input := []rune(data)
+	if position < 0 || position > len(input) {
+		position = len(input)
+	}
+	start := position
+	for start > 0 && input[start-1] != ' ' {
+		start--
+	}
+	word := string(input[start:position])
+
+	completion := &weechatproto.CompletionData{
+		Pointer:  t.generatePointer(),
+		Context:  "auto",
+		AddSpace: true,
+	}
+	var candidates []string
+	switch {
+	case start == 0 && strings.HasPrefix(word, "/") && !strings.HasPrefix(word, "//"):
+		completion.Context = "command"
+		word = word[1:]
+		start++
+		candidates = completionCommands
+	case strings.HasPrefix(word, "#"):
+		candidates = t.channelNamesLocked(buf.ServerTag)
+	default:
+		if len(input) > 0 && input[0] == '/' {
+			completion.Context = "command_arg"
+		}
+		candidates = recentNicksFirst(buf)
+	}
+
+	completion.BaseWord = word
+	completion.PosStart = start
+	completion.PosEnd = position - 1
+	for _, candidate := range candidates {
+		if len(candidate) >= len(word) && strings.EqualFold(candidate[:len(word)], word) {
+			if completion.Context == "auto" && start == 0 && !strings.HasPrefix(candidate, "#") {
+				candidate += nickCompleter
+			}
+			completion.List = append(completion.List, candidate)
+		}
+	}
+
+	return weechatproto.CreateCompletionHDataWithID(completion, msgID)
+}
+
+// CompletionNicks returns the nicks of a buffer in completion order, recent
+// speakers first, for clients that complete on their own. Buffers unknown or
+// outside the view have none.
+func (t *Translator) CompletionNicks(bufferPtr string, view BufferView) []string {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	for _, buf := range t.buffers {
+		if buf.Pointer == bufferPtr && view.shows(buf) {
+			return recentNicksFirst(buf)
+		}
+	}
+	return nil
+}
+
+// channelNamesLocked returns the channels of a server, sorted. Caller must
+// hold buffersMu.
+func (t *Translator) channelNamesLocked(serverTag string) []string {
+	var names []string
+	for _, buf := range t.buffers {
+		if buf.ServerTag == serverTag && strings.HasPrefix(buf.ShortName, "#") {
+			names = append(names, buf.ShortName)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// recentNicksFirst returns the nicks of a buffer: those who spoke, most
+// recent first, then the others in alphabetical order
+func recentNicksFirst(buf *BufferState) []string {
+	present := make(map[string]bool, len(buf.Nicks))
+	var others []string
+	for _, nick := range buf.Nicks {
+		if nick.IsGroup {
+			continue
+		}
+		present[nick.Name] = true
+		others = append(others, nick.Name)
+	}
+
+	var nicks []string
+	seen := make(map[string]bool)
+	for i := len(buf.Lines) - 1; i >= 0; i-- {
+		for _, tag := range strings.Split(buf.Lines[i].Tags, ",") {
+			nick, ok := strings.CutPrefix(tag, "nick_")
+			if ok && present[nick] && !seen[nick] {
+				seen[nick] = true
+				nicks = append(nicks, nick)
+			}
+		}
+	}
+
+	sort.Slice(others, func(i, j int) bool { return strings.ToLower(others[i]) < strings.ToLower(others[j]) })
+	for _, nick := range others {
+		if !seen[nick] {
+			nicks = append(nicks, nick)
+		}
+	}
+	return nicks
+}
@@ -0,0 +1,101 @@
+package translator
+
+import (
+	"strings"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// BufferLines returns a copy of the in-memory lines of a buffer, oldest
+// first
+func (t *Translator) BufferLines(serverTag, target string) []weechatproto.LineData {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	buf, ok := t.buffers[getBufferKey(serverTag, target)]
+	if !ok {
+		return nil
+	}
+	return append([]weechatproto.LineData(nil), buf.Lines...)
+}
+
+// OpenTempBuffer creates a read-only buffer holding lines that is not part
+// of the buffer list, for showing to one client. Returns its pointer and
+// the events that open and fill it. The lines never notify.
+func (t *Translator) OpenTempBuffer(name, shortName, title string, lines []weechatproto.LineData) (string, []*weechatproto.Message) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	buf := &BufferState{
+		Pointer:   t.generatePointer(),
+		Number:    t.nextBufferNum,
+		Name:      name,
+		ShortName: shortName,
+		Title:     title,
+		Lines:     make([]weechatproto.LineData, len(lines)),
+	}
+	for i, line := range lines {
+		line.Pointer = t.generatePointer()
+		line.BufferPtr = buf.Pointer
+		line.Tags = quietTags(line.Tags)
+		buf.Lines[i] = line
+	}
+	t.tempBuffers[buf.Pointer] = buf
+
+	data := weechatproto.BufferData{
+		Pointer:        buf.Pointer,
+		Number:         buf.Number,
+		Name:           buf.Name,
+		ShortName:      buf.ShortName,
+		Title:          buf.Title,
+		LocalVariables: "type=context",
+	}
+	events := []*weechatproto.Message{
+		weechatproto.CreateBuffersHDataWithID([]weechatproto.BufferData{data}, "_buffer_opened"),
+	}
+	if len(buf.Lines) > 0 {
+		events = append(events, weechatproto.CreateLinesHDataWithID(buf.Lines, "_buffer_line_added"))
+	}
+	return buf.Pointer, events
+}
+
+// IsTempBuffer reports whether a pointer belongs to a buffer opened with
+// OpenTempBuffer
+func (t *Translator) IsTempBuffer(bufferPtr string) bool {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	_, ok := t.tempBuffers[bufferPtr]
+	return ok
+}
+
+// CloseTempBuffer forgets a temporary buffer and returns the event closing
+// it, or nil if there is no such buffer
+func (t *Translator) CloseTempBuffer(bufferPtr string) *weechatproto.Message {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	buf, ok := t.tempBuffers[bufferPtr]
+	if !ok {
+		return nil
+	}
+	delete(t.tempBuffers, bufferPtr)
+
+	return weechatproto.CreateBuffersHDataWithID([]weechatproto.BufferData{{
+		Pointer:   buf.Pointer,
+		Number:    buf.Number,
+		Name:      buf.Name,
+		ShortName: buf.ShortName,
+	}}, "_buffer_closing")
+}
+
+// quietTags replaces the notify tags of a line with notify_none
+func quietTags(tags string) string {
+	var kept []string
+	for _, tag := range strings.Split(tags, ",") {
+		if tag != "" && !strings.HasPrefix(tag, "notify_") {
+			kept = append(kept, tag)
+		}
+	}
+	return strings.Join(append(kept, "notify_none"), ",")
+}
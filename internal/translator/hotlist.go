@@ -0,0 +1,112 @@
+package translator
+
+import (
+	"strings"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// Hotlist priorities of WeeChat, which index the counts of a hotlist entry
+const (
+	hotlistLow = iota
+	hotlistMessage
+	hotlistPrivate
+	hotlistHighlight
+	hotlistPriorities
+)
+
+// hotlistPriority returns the priority a line raises a buffer to, or -1 for
+// lines that don't reach the hotlist (own, muted and notify_none lines)
+func hotlistPriority(line weechatproto.LineData, private bool) int {
+	tags := "," + line.Tags + ","
+	switch {
+	case strings.Contains(tags, ",notify_none,"):
+		return -1
+	case line.Highlight:
+		return hotlistHighlight
+	case strings.Contains(tags, ",notify_message,") && private:
+		return hotlistPrivate
+	case strings.Contains(tags, ",notify_message,"):
+		return hotlistMessage
+	default:
+		return hotlistLow
+	}
+}
+
+// addToHotlistLocked counts a new line of a buffer as unread.
+// Caller must hold buffersMu.
+func (t *Translator) addToHotlistLocked(buf *BufferState, line weechatproto.LineData) {
+	priority := hotlistPriority(line, !buf.IsServer && !IsChannel(buf.ShortName))
+	if priority < 0 {
+		return
+	}
+	if buf.hotlistPointer == "" {
+		buf.hotlistPointer = t.generatePointer()
+	}
+	if buf.hotlistCount == [hotlistPriorities]int32{} {
+		buf.hotlistSince = line.DatePrinted
+	}
+	buf.hotlistCount[priority]++
+}
+
+// ClearHotlist marks the lines of a buffer (empty target = the server
+// buffer) as read. Returns false if the buffer doesn't exist.
+func (t *Translator) ClearHotlist(serverTag, target string) bool {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	key := serverTag
+	if target != "" {
+		key = getBufferKey(serverTag, target)
+	}
+	buf, ok := t.buffers[key]
+	if !ok {
+		return false
+	}
+	buf.hotlistCount = [hotlistPriorities]int32{}
+	return true
+}
+
+// ClearHotlistByPointer is ClearHotlist for a buffer pointer
+func (t *Translator) ClearHotlistByPointer(bufferPtr string) bool {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	for _, buf := range t.buffers {
+		if buf.Pointer == bufferPtr {
+			buf.hotlistCount = [hotlistPriorities]int32{}
+			return true
+		}
+	}
+	return false
+}
+
+// GetHotlist returns the buffers in the view with unread lines, in buffer
+// order (for hdata hotlist:gui_hotlist(*)). Like in WeeChat, an entry has
+// the highest priority of its lines and their count per priority.
+func (t *Translator) GetHotlist(msgID string, view BufferView) *weechatproto.Message {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	var entries []weechatproto.HotlistData
+	for _, buf := range t.sortedBuffersLocked() {
+		if buf.hotlistCount == [hotlistPriorities]int32{} || !view.shows(buf) {
+			continue
+		}
+
+		entry := weechatproto.HotlistData{
+			Pointer:   buf.hotlistPointer,
+			BufferPtr: buf.Pointer,
+			Since:     buf.hotlistSince,
+			Count:     buf.hotlistCount[:],
+		}
+		for priority, count := range buf.hotlistCount {
+			if count > 0 {
+				entry.Priority = int32(priority)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return weechatproto.CreateHotlistHDataWithID(entries, msgID)
+}
@@ -0,0 +1,44 @@
+package translator
+
+import (
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// InfoList answers an infolist request, which some clients fall back to
+// when hdata fails:
+//
+//	infolist buffer              every buffer in the view
+//	infolist buffer 0x123        one buffer
+//	infolist buffer_lines 0x123  the lines of a buffer
+//	infolist hotlist             always empty
+//
+// Other infolists, and buffers that are unknown or outside the view, get an
+// empty list.
+func (t *Translator) InfoList(name, pointer, msgID string, view BufferView) *weechatproto.Message {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	switch name {
+	case "buffer":
+		var buffers []weechatproto.BufferData
+		for _, buf := range t.sortedBuffersLocked() {
+			if view.shows(buf) && (pointer == "" || buf.Pointer == pointer) {
+				buffers = append(buffers, t.bufferData(buf))
+			}
+		}
+		return weechatproto.CreateBuffersInfoListWithID(buffers, msgID)
+
+	case "buffer_lines":
+		if buf, ok := t.tempBuffers[pointer]; ok {
+			return weechatproto.CreateLinesInfoListWithID(buf.Lines, msgID)
+		}
+		for _, buf := range t.buffers {
+			if buf.Pointer == pointer && view.shows(buf) {
+				return weechatproto.CreateLinesInfoListWithID(t.visibleLinesLocked(buf, view), msgID)
+			}
+		}
+		return weechatproto.CreateLinesInfoListWithID(nil, msgID)
+	}
+
+	return weechatproto.CreateInfoListWithID(name, nil, msgID)
+}
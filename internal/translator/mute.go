@@ -0,0 +1,50 @@
+package translator
+
+import (
+	"maps"
+	"time"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// MuteBuffer silences the notifications of a buffer until the given time:
+// its new lines still arrive, but tagged notify_none and never as
+// highlights, so clients neither add them to the hotlist nor push them. A
+// zero until unmutes. Returns a _buffer_localvar_changed event carrying the
+// new notify local variable, or nil if the buffer doesn't exist (the mute
+// still applies once it is created).
+func (t *Translator) MuteBuffer(serverTag, target string, until time.Time) *weechatproto.Message {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	key := getBufferKey(serverTag, target)
+	if until.IsZero() {
+		delete(t.mutes, key)
+	} else {
+		t.mutes[key] = until
+	}
+
+	buf, ok := t.buffers[key]
+	if !ok {
+		return nil
+	}
+	return weechatproto.CreateBuffersHDataWithID([]weechatproto.BufferData{t.bufferData(buf)}, "_buffer_localvar_changed")
+}
+
+// Mutes returns the muted buffers ("server.target", lowercase) and when
+// their mute ends. Expired mutes are left out.
+func (t *Translator) Mutes() map[string]time.Time {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	mutes := maps.Clone(t.mutes)
+	now := t.clock.Now()
+	maps.DeleteFunc(mutes, func(_ string, until time.Time) bool { return !until.After(now) })
+	return mutes
+}
+
+// mutedLocked reports whether a buffer is muted (buffersMu held)
+func (t *Translator) mutedLocked(key string) bool {
+	until, ok := t.mutes[key]
+	return ok && until.After(t.clock.Now())
+}
@@ -0,0 +1,81 @@
+package translator
+
+import (
+	"strings"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// ownNickPlaceholder is replaced by the own nick in the own-message prefix
+const ownNickPlaceholder = "{nick}"
+
+// SetOwnStyle sets how the prefix of the user's own messages is displayed.
+// prefix replaces the nick ("{nick}" stands for the nick, empty = just the
+// nick); color is a WeeChat color name or 256-color number (empty = client
+// default).
+func (t *Translator) SetOwnStyle(prefix, color string) error {
+	code := ""
+	if color != "" {
+		var err error
+		if code, err = weechatproto.ColorCode(color); err != nil {
+			return err
+		}
+	}
+
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.ownPrefix = prefix
+	t.ownColor = code
+	return nil
+}
+
+// SetOwnNick records the user's nick on a server
+func (t *Translator) SetOwnNick(serverTag, nick string) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.setOwnNickLocked(serverTag, nick)
+}
+
+// OwnNick returns the user's nick on a server, if known
+func (t *Translator) OwnNick(serverTag string) string {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	return t.ownNicks[serverTag]
+}
+
+// setOwnNickLocked records the own nick. Caller must hold buffersMu.
+func (t *Translator) setOwnNickLocked(serverTag, nick string) {
+	if serverTag == "" || nick == "" || t.ownNicks[serverTag] == nick {
+		return
+	}
+	t.log.Debugf("Own nick on %s is %s", serverTag, nick)
+	t.ownNicks[serverTag] = nick
+}
+
+// ownMessageNick returns the nick of an own message: the echoed nick (which
+// also updates the tracked one), or the tracked nick when erssi omitted it.
+// Caller must hold buffersMu.
+func (t *Translator) ownMessageNick(msg *erssiproto.WebMessage) string {
+	if msg.Nick != "" {
+		t.setOwnNickLocked(msg.ServerTag, msg.Nick)
+		return msg.Nick
+	}
+	return t.ownNicks[msg.ServerTag]
+}
+
+// ownPrefixLocked returns the displayed prefix of an own message.
+// Caller must hold buffersMu.
+func (t *Translator) ownPrefixLocked(nick string) string {
+	prefix := nick
+	if t.ownPrefix != "" {
+		prefix = strings.ReplaceAll(t.ownPrefix, ownNickPlaceholder, nick)
+	}
+	if t.ownColor != "" {
+		prefix = t.ownColor + prefix + weechatproto.ColorReset
+	}
+	return prefix
+}
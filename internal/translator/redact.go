@@ -0,0 +1,39 @@
+package translator
+
+import (
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// RedactLines removes the in-memory lines whose tags match from every
+// buffer. For each affected buffer it returns a _buffer_cleared event
+// followed by the remaining lines, so connected clients can redraw it
+// without the removed lines.
+func (t *Translator) RedactLines(match func(tags string) bool) (removed int, events []*weechatproto.Message) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	for _, buf := range t.buffers {
+		kept := buf.Lines[:0]
+		for _, line := range buf.Lines {
+			if match(line.Tags) {
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if len(kept) == len(buf.Lines) {
+			continue
+		}
+
+		// Clear the tail so removed lines don't linger in the backing array
+		clear(buf.Lines[len(kept):])
+		removed += len(buf.Lines) - len(kept)
+		buf.Lines = kept
+
+		events = append(events,
+			weechatproto.CreateBuffersHDataWithID([]weechatproto.BufferData{t.bufferData(buf)}, "_buffer_cleared"))
+		if len(kept) > 0 {
+			events = append(events, weechatproto.CreateLinesHDataWithID(kept, "_buffer_line_added"))
+		}
+	}
+	return removed, events
+}
@@ -0,0 +1,80 @@
+package translator
+
+import (
+	"strings"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// IsChannel reports whether a target names a channel rather than a query
+func IsChannel(target string) bool {
+	return target != "" && strings.ContainsRune("#&!+", rune(target[0]))
+}
+
+// HasBuffer reports whether a buffer exists for a server's target
+func (t *Translator) HasBuffer(serverTag, target string) bool {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	_, ok := t.buffers[getBufferKey(serverTag, target)]
+	return ok
+}
+
+// CloseStaleChannels removes the channel buffers of a server that a fresh
+// state dump no longer lists (joined channels, lowercase). It returns a
+// _buffer_closing event per removed buffer. Query buffers are kept, and
+// persisted history is left alone.
+func (t *Translator) CloseStaleChannels(serverTag string, joined map[string]struct{}) []*weechatproto.Message {
+	return t.closeStale(serverTag, joined, true)
+}
+
+// CloseStaleQueries removes the query buffers of a server that a fresh
+// state dump no longer lists (open queries, lowercase), like
+// CloseStaleChannels
+func (t *Translator) CloseStaleQueries(serverTag string, open map[string]struct{}) []*weechatproto.Message {
+	return t.closeStale(serverTag, open, false)
+}
+
+// closeStale removes the channel or query buffers of a server missing from
+// listed
+func (t *Translator) closeStale(serverTag string, listed map[string]struct{}, channels bool) []*weechatproto.Message {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	var events []*weechatproto.Message
+	for key, buf := range t.buffers {
+		if buf.IsServer || buf.IsCore || buf.ServerTag != serverTag || IsChannel(buf.ShortName) != channels || buf.ShortName == WallopsTarget {
+			continue
+		}
+		if _, ok := listed[strings.ToLower(buf.ShortName)]; ok {
+			continue
+		}
+
+		delete(t.buffers, key)
+		t.log.Infof("Closed buffer %s: not in the state dump of %s", buf.Name, serverTag)
+		events = append(events,
+			weechatproto.CreateBuffersHDataWithID([]weechatproto.BufferData{t.bufferData(buf)}, "_buffer_closing"))
+	}
+	return events
+}
+
+// BufferTargets returns the channel and query buffers of every server, by
+// lowercase target
+func (t *Translator) BufferTargets() map[string]map[string]string {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	targets := make(map[string]map[string]string)
+	for _, buf := range t.buffers {
+		if buf.IsCore || buf.ServerTag == "" {
+			continue
+		}
+		if targets[buf.ServerTag] == nil {
+			targets[buf.ServerTag] = make(map[string]string)
+		}
+		if !buf.IsServer && buf.ShortName != WallopsTarget {
+			targets[buf.ServerTag][strings.ToLower(buf.ShortName)] = buf.ShortName
+		}
+	}
+	return targets
+}
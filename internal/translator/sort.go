@@ -0,0 +1,46 @@
+package translator
+
+import (
+	"sort"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// SortByActivity renumbers buffers so the most recently active come first
+// (after the core buffer). Buffers without activity keep their relative
+// order at the end. Returns a _buffer_moved event for the buffers whose
+// number changed, or nil if the order is unchanged.
+func (t *Translator) SortByActivity() *weechatproto.Message {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	bufferList := make([]*BufferState, 0, len(t.buffers))
+	for _, buf := range t.buffers {
+		if !buf.IsCore {
+			bufferList = append(bufferList, buf)
+		}
+	}
+
+	sort.Slice(bufferList, func(i, j int) bool {
+		a, b := bufferList[i], bufferList[j]
+		if !a.LastActivity.Equal(b.LastActivity) {
+			return a.LastActivity.After(b.LastActivity)
+		}
+		return a.Number < b.Number
+	})
+
+	var moved []weechatproto.BufferData
+	for i, buf := range bufferList {
+		number := int32(i + 2)
+		if buf.Number != number {
+			buf.Number = number
+			moved = append(moved, t.bufferData(buf))
+		}
+	}
+	t.nextBufferNum = int32(len(bufferList) + 2)
+
+	if len(moved) == 0 {
+		return nil
+	}
+	return weechatproto.CreateBuffersHDataWithID(moved, "_buffer_moved")
+}
@@ -0,0 +1,118 @@
+package translator
+
+import (
+	"sort"
+	"strings"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// sortedBuffersLocked returns every buffer ordered by number.
+// Caller must hold buffersMu.
+func (t *Translator) sortedBuffersLocked() []*BufferState {
+	buffers := make([]*BufferState, 0, len(t.buffers))
+	for _, buf := range t.buffers {
+		buffers = append(buffers, buf)
+	}
+	sort.Slice(buffers, func(i, j int) bool {
+		return buffers[i].Number < buffers[j].Number
+	})
+	return buffers
+}
+
+// GetAllBufferLines returns the last count lines of every buffer in the
+// view, in buffer order (for hdata buffer:gui_buffers(*)/own_lines/...)
+func (t *Translator) GetAllBufferLines(count int, msgID string, view BufferView) *weechatproto.Message {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	var lines []weechatproto.LineData
+	for _, buf := range t.sortedBuffersLocked() {
+		if !view.shows(buf) {
+			continue
+		}
+		visible := t.visibleLinesLocked(buf, view)
+		start := 0
+		if len(visible) > count {
+			start = len(visible) - count
+		}
+		lines = append(lines, visible[start:]...)
+	}
+
+	return weechatproto.CreateLinesHDataWithID(lines, msgID)
+}
+
+// GetAllNicklists returns the cached nicklists of every buffer in the view,
+// in buffer order (for a nicklist request without buffer)
+func (t *Translator) GetAllNicklists(msgID string, view BufferView) *weechatproto.Message {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	var nicklists []weechatproto.BufferNicklist
+	for _, buf := range t.sortedBuffersLocked() {
+		if len(buf.Nicks) > 0 && view.shows(buf) {
+			nicklists = append(nicklists, weechatproto.BufferNicklist{BufferPtr: buf.Pointer, Nicks: buf.Nicks})
+		}
+	}
+
+	return weechatproto.CreateBuffersNicklistHDataWithID(nicklists, msgID)
+}
+
+// BufferBacklog is the last lines of one buffer
+type BufferBacklog struct {
+	Pointer string
+	Lines   []weechatproto.LineData
+}
+
+// SyncBacklog returns the last count lines of the buffers in the view that
+// a sync command names, in buffer order. Names are WeeChat full names
+// ("irc.libera.#go", "irc.server.libera"), bridge names ("libera.#go"),
+// pointers or "*" for every buffer. Buffers without lines are left out.
+func (t *Translator) SyncBacklog(names []string, count int, view BufferView) []BufferBacklog {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	var backlog []BufferBacklog
+	for _, buf := range t.sortedBuffersLocked() {
+		if !view.shows(buf) || !syncNames(buf, names) {
+			continue
+		}
+		visible := t.visibleLinesLocked(buf, view)
+		if len(visible) == 0 {
+			continue
+		}
+		lines := visible[max(0, len(visible)-count):]
+		backlog = append(backlog, BufferBacklog{Pointer: buf.Pointer, Lines: lines})
+	}
+	return backlog
+}
+
+// BufferPointers returns the pointers of the buffers a sync command names,
+// see SyncBacklog
+func (t *Translator) BufferPointers(names []string) []string {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	var pointers []string
+	for _, buf := range t.sortedBuffersLocked() {
+		if syncNames(buf, names) {
+			pointers = append(pointers, buf.Pointer)
+		}
+	}
+	return pointers
+}
+
+// syncNames reports whether a sync command's buffer list includes a buffer
+func syncNames(buf *BufferState, names []string) bool {
+	for _, name := range names {
+		switch {
+		case name == "*", name == buf.Pointer, strings.EqualFold(name, buf.Name):
+			return true
+		case buf.IsServer && strings.EqualFold(name, "irc.server."+buf.Name):
+			return true
+		case !buf.IsServer && !buf.IsCore && strings.EqualFold(name, "irc."+buf.Name):
+			return true
+		}
+	}
+	return false
+}
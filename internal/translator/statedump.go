@@ -0,0 +1,150 @@
+package translator
+
+import (
+	"encoding/json"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// DumpedChannel is a channel carried inline by a state dump
+type DumpedChannel struct {
+	ServerTag string
+	Name      string
+}
+
+// ApplyStateDump populates buffers, topics, modes and nicklists from a state
+// dump payload without emitting anything. It returns the channel buffers
+// that were filled in, so callers can skip per-channel nicklist requests
+// when the dump already carried them.
+func (t *Translator) ApplyStateDump(stateDump *erssiproto.WebMessage) []DumpedChannel {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	state := t.parseStateDump(stateDump)
+	if state == nil {
+		return nil
+	}
+
+	var applied []DumpedChannel
+	for _, server := range state.Servers {
+		serverTag := server.Tag
+		if serverTag == "" {
+			continue
+		}
+		t.setOwnNickLocked(serverTag, server.Nick)
+
+		for _, ch := range server.Channels {
+			if ch.Name == "" || !t.allowsBuffer(serverTag, ch.Name) {
+				continue
+			}
+			t.applyChannelInfo(serverTag, ch.Info())
+			applied = append(applied, DumpedChannel{ServerTag: serverTag, Name: ch.Name})
+		}
+
+		for _, query := range server.Queries {
+			if query.Nick == "" || !t.allowsBuffer(serverTag, query.Nick) {
+				continue
+			}
+			t.createBufferWithTopic(serverTag, query.Nick, "")
+		}
+	}
+
+	if len(applied) > 0 {
+		t.log.Infof("Applied channel info for %d channels from state dump", len(applied))
+	}
+
+	return applied
+}
+
+// allowsBuffer applies the buffer filter. Caller must hold buffersMu.
+func (t *Translator) allowsBuffer(serverTag, target string) bool {
+	return t.bufferFilter == nil || t.bufferFilter(serverTag, target)
+}
+
+// ApplyChannelInfo stores channel metadata carried inline by a state dump
+// channel_join (extra_data with topic/mode/user_count/nicks).
+// Returns true if a nicklist was included.
+func (t *Translator) ApplyChannelInfo(serverTag string, info erssiproto.ChannelInfo) bool {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	buffer := t.applyChannelInfo(serverTag, info)
+	return len(buffer.Nicks) > 0
+}
+
+// HasNicklist reports whether a nicklist is cached for the buffer
+func (t *Translator) HasNicklist(bufferPtr string) bool {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	for _, buf := range t.buffers {
+		if buf.Pointer == bufferPtr {
+			return len(buf.Nicks) > 0
+		}
+	}
+	return false
+}
+
+// GetBufferNicklist returns the cached nicklist for a buffer as WeeChat HData
+// answering the request msgID
+func (t *Translator) GetBufferNicklist(bufferPtr, msgID string) *weechatproto.Message {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	for _, buf := range t.buffers {
+		if buf.Pointer == bufferPtr {
+			return weechatproto.CreateNicklistHDataWithID(buf.Nicks, msgID)
+		}
+	}
+
+	return weechatproto.CreateNicklistHDataWithID([]weechatproto.NickData{}, msgID)
+}
+
+// applyChannelInfo creates/updates a channel buffer from dump metadata.
+// Caller must hold buffersMu.
+func (t *Translator) applyChannelInfo(serverTag string, info erssiproto.ChannelInfo) *BufferState {
+	buffer := t.createBufferWithTopic(serverTag, info.Name, info.Topic)
+
+	if info.Mode != "" {
+		buffer.Mode = info.Mode
+	}
+	if len(info.Nicks) > 0 && !t.nicklistDisabled {
+		buffer.Nicks = t.convertNicks(info.Nicks)
+	}
+
+	if info.UserCount > 0 {
+		buffer.UserCount = info.UserCount
+	}
+	if buffer.UserCount < len(buffer.Nicks) {
+		buffer.UserCount = len(buffer.Nicks)
+	}
+
+	return buffer
+}
+
+// parseStateDump decodes a state dump payload, logging what's unusable.
+// Returns nil if the payload is malformed.
+func (t *Translator) parseStateDump(stateDump *erssiproto.WebMessage) *erssiproto.State {
+	state, err := erssiproto.ParseStateDump(stateDump)
+	if err != nil {
+		t.log.Warnf("Ignoring malformed state dump: %v", err)
+		return nil
+	}
+	for _, v := range state.Validate() {
+		t.log.Warnf("Skipping state dump entry: %v", v)
+	}
+	return state
+}
+
+// ParseChannelInfo converts the extra data of a channel_join into
+// ChannelInfo. Nicks may be given either as objects or as prefixed strings,
+// see erssiproto.StateChannel.
+func ParseChannelInfo(channel map[string]interface{}) erssiproto.ChannelInfo {
+	var info erssiproto.StateChannel
+	if data, err := json.Marshal(channel); err == nil {
+		// A malformed field leaves the ones decoded before it
+		json.Unmarshal(data, &info)
+	}
+	return info.Info()
+}
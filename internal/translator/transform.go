@@ -0,0 +1,151 @@
+package translator
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// InputTransform rewrites plain text typed in matching buffers before it is
+// sent to erssi. Commands (text starting with /) are never transformed.
+type InputTransform struct {
+	Buffer string // Buffer pattern "server.target", * matches anything
+	Kind   string // prefix, suffix, trim or correct
+	Arg    string // Text to add (prefix, suffix) or the typo (correct)
+	Repl   string // Replacement word (correct)
+
+	match *regexp.Regexp
+	word  *regexp.Regexp
+}
+
+// NewInputTransform validates a transform and prepares its patterns
+func NewInputTransform(buffer, kind, arg, repl string) (InputTransform, error) {
+	t := InputTransform{Buffer: buffer, Kind: kind, Arg: arg, Repl: repl}
+
+	switch kind {
+	case "prefix", "suffix":
+		if arg == "" {
+			return t, fmt.Errorf("%s needs a text", kind)
+		}
+	case "trim":
+	case "correct":
+		if arg == "" || repl == "" {
+			return t, fmt.Errorf("correct needs a typo and its correction")
+		}
+		t.word = regexp.MustCompile(`\b` + regexp.QuoteMeta(arg) + `\b`)
+	default:
+		return t, fmt.Errorf("unknown transform %q (want prefix, suffix, trim or correct)", kind)
+	}
+
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(buffer), `\*`, `.*`)
+	t.match = regexp.MustCompile(`(?i)^` + pattern + `$`)
+	return t, nil
+}
+
+// apply runs the transform on text
+func (t InputTransform) apply(text string) string {
+	switch t.Kind {
+	case "prefix":
+		if !strings.HasPrefix(text, t.Arg) {
+			text = t.Arg + text
+		}
+	case "suffix":
+		if !strings.HasSuffix(text, t.Arg) {
+			text += t.Arg
+		}
+	case "trim":
+		text = strings.TrimRight(text, " \t")
+	case "correct":
+		text = t.word.ReplaceAllLiteralString(text, t.Repl)
+	}
+	return text
+}
+
+// LoadInputTransforms reads a transform file. Each line is
+// "<buffer> <transform> [args]"; blank lines and # comments are ignored.
+// Arguments with spaces are double-quoted. Transforms run in file order.
+//
+//	libera.#botctl  prefix  "!bot "
+//	*               trim
+//	*               correct teh the
+func LoadInputTransforms(path string) ([]InputTransform, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var transforms []InputTransform
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields, err := splitQuoted(line)
+		if err != nil || len(fields) < 2 || len(fields) > 4 {
+			return nil, fmt.Errorf("%s:%d: expected \"<buffer> <transform> [args]\"", path, lineNo)
+		}
+		fields = append(fields, "", "")
+
+		t, err := NewInputTransform(fields[0], strings.ToLower(fields[1]), fields[2], fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		transforms = append(transforms, t)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return transforms, nil
+}
+
+// splitQuoted splits a line on whitespace, keeping "quoted strings" whole
+func splitQuoted(line string) ([]string, error) {
+	var fields []string
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] != '"' {
+			field, rest, _ := strings.Cut(line, " ")
+			fields = append(fields, field)
+			line = rest
+			continue
+		}
+
+		quoted, err := strconv.QuotedPrefix(line)
+		if err != nil {
+			return nil, err
+		}
+		field, _ := strconv.Unquote(quoted)
+		fields = append(fields, field)
+		line = line[len(quoted):]
+	}
+	return fields, nil
+}
+
+// SetInputTransforms installs the outgoing text pipeline
+func (t *Translator) SetInputTransforms(transforms []InputTransform) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.inputTransforms = transforms
+}
+
+// transformInput runs the pipeline for a buffer. Caller must hold buffersMu.
+func (t *Translator) transformInput(serverTag, target, text string) string {
+	if strings.HasPrefix(text, "/") && !strings.HasPrefix(text, "//") {
+		return text
+	}
+
+	name := serverTag + "." + target
+	for _, tr := range t.inputTransforms {
+		if tr.match.MatchString(name) {
+			text = tr.apply(text)
+		}
+	}
+	return text
+}
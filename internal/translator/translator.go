@@ -1,13 +1,13 @@
 package translator
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"erssi-lith-bridge/internal/clock"
 	"erssi-lith-bridge/pkg/erssiproto"
 	"erssi-lith-bridge/pkg/weechatproto"
 
@@ -22,7 +22,68 @@ type Translator struct {
 	buffers   map[string]*BufferState
 	buffersMu sync.RWMutex
 
+	// Read-only buffers outside the buffer list, by pointer (see
+	// OpenTempBuffer)
+	tempBuffers map[string]*BufferState
+
+	// Muted buffers by key, until when (see MuteBuffer)
+	mutes map[string]time.Time
+
 	nextBufferNum int32
+
+	// Don't keep nicklists (see DisableNicklist)
+	nicklistDisabled bool
+
+	// Buffers a state dump may create (see SetBufferFilter)
+	bufferFilter func(serverTag, target string) bool
+
+	// Notify level of the wallops buffers, "" = no wallops buffers (see
+	// SetWallopsBuffer)
+	wallopsNotify NotifyLevel
+
+	// Text encoding by server tag, "*" for the others (see SetCharsets)
+	charsets map[string]Charset
+
+	// Rewrites applied to outgoing text (see SetInputTransforms)
+	inputTransforms []InputTransform
+
+	// Formatting of outgoing text (see SetOutgoingColors)
+	outgoingColors OutgoingColors
+
+	// Persistent line history (optional, see SetHistory)
+	history History
+
+	// The user's nick per server, and how own messages are displayed
+	// (see SetOwnStyle)
+	ownNicks  map[string]string
+	ownPrefix string
+	ownColor  string // WeeChat color code
+
+	// Time and pointer sources (see SetClock, SetIDGenerator)
+	clock clock.Clock
+	ids   clock.IDGenerator
+
+	// Scopes of the core buffer lines about a server, buffer or upstream,
+	// by line pointer (see ScopedCoreLine)
+	coreScopes map[string]coreScope
+}
+
+// History persists buffer lines across restarts
+type History interface {
+	// Load returns the stored lines of a buffer, oldest first
+	Load(serverTag, target string) []weechatproto.LineData
+	// Record stores a new line
+	Record(serverTag, target string, line weechatproto.LineData)
+}
+
+// SetHistory enables persistent history: new buffers start with their
+// stored lines and every incoming line is recorded. Must be called before
+// any state is loaded.
+func (t *Translator) SetHistory(h History) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.history = h
 }
 
 // BufferState tracks state for a buffer (channel/query/server)
@@ -33,9 +94,59 @@ type BufferState struct {
 	Name      string
 	ShortName string
 	Title     string
+	Mode      string // Channel mode string from the state dump (e.g. "+nt")
+	UserCount int    // User count reported by erssi (may exceed len(Nicks))
 	Lines     []weechatproto.LineData
 	Nicks     []weechatproto.NickData
 	IsServer  bool // True if this is a server buffer (not a channel)
+	IsCore    bool // True for the core.weechat buffer (bridge status)
+
+	// Time of the last message, for SortByActivity
+	LastActivity time.Time
+
+	// Unread lines by hotlist priority, since when, and the pointer of the
+	// buffer's hotlist entry (see GetHotlist)
+	hotlistCount   [hotlistPriorities]int32
+	hotlistSince   int64
+	hotlistPointer string
+
+	// Recently seen IRCv3 msgids, for deduplication
+	msgIDs     map[string]struct{}
+	msgIDOrder []string
+}
+
+// maxSeenMsgIDs bounds the per-buffer msgid deduplication window
+const maxSeenMsgIDs = 1000
+
+// seenMsgID records a msgid and reports whether it was already known
+func (b *BufferState) seenMsgID(id string) bool {
+	if b.msgIDs == nil {
+		b.msgIDs = make(map[string]struct{})
+	}
+	if _, ok := b.msgIDs[id]; ok {
+		return true
+	}
+
+	b.msgIDs[id] = struct{}{}
+	b.msgIDOrder = append(b.msgIDOrder, id)
+	if len(b.msgIDOrder) > maxSeenMsgIDs {
+		delete(b.msgIDs, b.msgIDOrder[0])
+		b.msgIDOrder = b.msgIDOrder[1:]
+	}
+	return false
+}
+
+// insertLine adds a line keeping Lines sorted by date. Live lines are
+// appended; older (backlog) lines are inserted after the last line that is
+// not newer than them.
+func (b *BufferState) insertLine(line weechatproto.LineData) {
+	i := len(b.Lines)
+	for i > 0 && b.Lines[i-1].Date > line.Date {
+		i--
+	}
+	b.Lines = append(b.Lines, weechatproto.LineData{})
+	copy(b.Lines[i+1:], b.Lines[i:])
+	b.Lines[i] = line
 }
 
 // NewTranslator creates a new protocol translator
@@ -44,11 +155,133 @@ func NewTranslator(logger *logrus.Logger) *Translator {
 		logger = logrus.New()
 	}
 
-	return &Translator{
+	t := &Translator{
 		log:           logger.WithField("component", "translator"),
 		buffers:       make(map[string]*BufferState),
-		nextBufferNum: 1,
+		tempBuffers:   make(map[string]*BufferState),
+		mutes:         make(map[string]time.Time),
+		ownNicks:      make(map[string]string),
+		nextBufferNum: 2,
+		clock:         clock.System,
+		ids:           clock.NewPointers(uint64(time.Now().UnixNano())),
+	}
+
+	// WeeChat always has core.weechat as buffer 1; the bridge uses it for
+	// its own status messages
+	t.buffers[coreBufferKey] = &BufferState{
+		Pointer:   t.generatePointer(),
+		Number:    1,
+		Name:      "core.weechat",
+		ShortName: "weechat",
+		Title:     "WeeChat (via erssi bridge)",
+		Lines:     make([]weechatproto.LineData, 0),
+		Nicks:     make([]weechatproto.NickData, 0),
+		IsCore:    true,
+	}
+
+	return t
+}
+
+// DisableNicklist stops the translator from storing nicklists and makes
+// buffers advertise nicklist=0. Must be called before any state is loaded.
+func (t *Translator) DisableNicklist() {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.nicklistDisabled = true
+}
+
+// NicklistDisabled reports whether nicklist support is turned off
+func (t *Translator) NicklistDisabled() bool {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	return t.nicklistDisabled
+}
+
+// SetBufferFilter restricts the channels and queries a state dump may
+// create buffers for (nil = all). Must be called before any state is loaded.
+func (t *Translator) SetBufferFilter(allow func(serverTag, target string) bool) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.bufferFilter = allow
+}
+
+// SetClock replaces the wall clock used for line dates, e.g. with a
+// clock.Manual for deterministic output
+func (t *Translator) SetClock(c clock.Clock) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.clock = c
+}
+
+// SetIDGenerator replaces the source of buffer, line and nick pointers.
+// The core buffer gets a new pointer too, so this must be called before
+// any state is loaded.
+func (t *Translator) SetIDGenerator(ids clock.IDGenerator) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.ids = ids
+	t.buffers[coreBufferKey].Pointer = t.generatePointer()
+}
+
+// coreBufferKey is the buffers map key of the core buffer
+const coreBufferKey = "core"
+
+// CoreLine appends a bridge status line to the core buffer and returns it
+// as HData for broadcasting
+func (t *Translator) CoreLine(text string) *weechatproto.Message {
+	return t.coreLine(text, nil)
+}
+
+// ScopedCoreLine is CoreLine for a status line about a server (empty target)
+// or a buffer: views that don't include that buffer never get it
+func (t *Translator) ScopedCoreLine(serverTag, target, text string) *weechatproto.Message {
+	return t.coreLine(text, &coreScope{serverTag: serverTag, target: target})
+}
+
+// UnrestrictedCoreLine is CoreLine for a status line about an upstream,
+// which only clients seeing every buffer get
+func (t *Translator) UnrestrictedCoreLine(text string) *weechatproto.Message {
+	return t.coreLine(text, &coreScope{unrestricted: true})
+}
+
+func (t *Translator) coreLine(text string, scope *coreScope) *weechatproto.Message {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	core := t.buffers[coreBufferKey]
+	now := t.clock.Now().Unix()
+
+	line := weechatproto.LineData{
+		Pointer:     t.generatePointer(),
+		BufferPtr:   core.Pointer,
+		Date:        now,
+		DatePrinted: now,
+		Displayed:   true,
+		Tags:        "no_highlight,notify_none,bridge_status",
+		Prefix:      "--",
+		Message:     text,
+	}
+
+	core.insertLine(line)
+	if scope != nil {
+		if t.coreScopes == nil {
+			t.coreScopes = make(map[string]coreScope)
+		}
+		t.coreScopes[line.Pointer] = *scope
 	}
+	if len(core.Lines) > 500 {
+		for _, dropped := range core.Lines[:len(core.Lines)-500] {
+			delete(t.coreScopes, dropped.Pointer)
+		}
+		core.Lines = core.Lines[len(core.Lines)-500:]
+	}
+
+	return weechatproto.CreateLinesHData([]weechatproto.LineData{line})
 }
 
 // ErssiToBufferList converts erssi state dump to WeeChat buffer list
@@ -58,106 +291,60 @@ func (t *Translator) ErssiToBufferList(stateDump *erssiproto.WebMessage) *weecha
 
 	t.log.Debug("Parsing state dump...")
 
-	// Parse state dump - try both ExtraData and Text fields
-	var parsedData interface{}
-
-	if stateDump.ExtraData != nil && len(stateDump.ExtraData) > 0 {
-		parsedData = stateDump.ExtraData
-		t.log.Debug("Using ExtraData for state dump")
-	} else if stateDump.Text != "" {
-		// Try to parse Text field as JSON
-		var data map[string]interface{}
-		if err := json.Unmarshal([]byte(stateDump.Text), &data); err == nil {
-			parsedData = data
-			t.log.Debug("Using Text field for state dump")
-		}
-	}
+	state := t.parseStateDump(stateDump)
 
 	buffers := make([]weechatproto.BufferData, 0)
 
 	// Add core buffer first
-	corePtr := t.generatePointer()
-	buffers = append(buffers, weechatproto.BufferData{
-		Pointer:        corePtr,
-		Number:         1,
-		Name:           "core.weechat",
-		ShortName:      "weechat",
-		Hidden:         false,
-		Title:          "WeeChat (via erssi bridge)",
-		LocalVariables: "type=server",
-	})
+	buffers = append(buffers, t.bufferData(t.buffers[coreBufferKey]))
 
-	t.buffers["core"] = &BufferState{
-		Pointer:   corePtr,
-		Number:    1,
-		Name:      "core.weechat",
-		ShortName: "weechat",
-		Lines:     make([]weechatproto.LineData, 0),
-		Nicks:     make([]weechatproto.NickData, 0),
+	var servers []erssiproto.StateServer
+	if state != nil {
+		servers = state.Servers
 	}
+	for _, server := range servers {
+		serverTag := server.Tag
+		if serverTag == "" {
+			continue
+		}
+
+		t.log.Debugf("Processing server: %s", serverTag)
+
+		// Process channels
+		for _, ch := range server.Channels {
+			if ch.Name == "" {
+				continue
+			}
+			buffer := t.applyChannelInfo(serverTag, ch.Info())
+			buffers = append(buffers, weechatproto.BufferData{
+				Pointer:        buffer.Pointer,
+				Number:         buffer.Number,
+				Name:           buffer.Name,
+				ShortName:      buffer.ShortName,
+				Hidden:         false,
+				Title:          buffer.Title,
+				LocalVariables: "type=channel",
+			})
+			t.log.Debugf("Created buffer for channel: %s.%s", serverTag, ch.Name)
+		}
 
-	// Parse servers structure
-	if dataMap, ok := parsedData.(map[string]interface{}); ok {
-		if serversData, ok := dataMap["servers"]; ok {
-			if serversList, ok := serversData.([]interface{}); ok {
-				for _, serverItem := range serversList {
-					if server, ok := serverItem.(map[string]interface{}); ok {
-						serverTag := getString(server, "tag")
-						if serverTag == "" {
-							continue
-						}
-
-						t.log.Debugf("Processing server: %s", serverTag)
-
-						// Process channels
-						if channelsData, ok := server["channels"].([]interface{}); ok {
-							for _, channelItem := range channelsData {
-								if channel, ok := channelItem.(map[string]interface{}); ok {
-									channelName := getString(channel, "name")
-									topic := getString(channel, "topic")
-
-									if channelName != "" {
-										buffer := t.createBufferWithTopic(serverTag, channelName, topic)
-										buffers = append(buffers, weechatproto.BufferData{
-											Pointer:        buffer.Pointer,
-											Number:         buffer.Number,
-											Name:           buffer.Name,
-											ShortName:      buffer.ShortName,
-											Hidden:         false,
-											Title:          buffer.Title,
-											LocalVariables: "type=channel",
-										})
-										t.log.Debugf("Created buffer for channel: %s.%s", serverTag, channelName)
-									}
-								}
-							}
-						}
-
-						// Process queries
-						if queriesData, ok := server["queries"].([]interface{}); ok {
-							for _, queryItem := range queriesData {
-								if query, ok := queryItem.(map[string]interface{}); ok {
-									nick := getString(query, "nick")
-
-									if nick != "" {
-										buffer := t.createBufferWithTopic(serverTag, nick, "")
-										buffers = append(buffers, weechatproto.BufferData{
-											Pointer:        buffer.Pointer,
-											Number:         buffer.Number,
-											Name:           buffer.Name,
-											ShortName:      buffer.ShortName,
-											Hidden:         false,
-											Title:          fmt.Sprintf("Private chat with %s", nick),
-											LocalVariables: "type=private",
-										})
-										t.log.Debugf("Created buffer for query: %s.%s", serverTag, nick)
-									}
-								}
-							}
-						}
-					}
-				}
+		// Process queries
+		for _, query := range server.Queries {
+			nick := query.Nick
+			if nick == "" {
+				continue
 			}
+			buffer := t.createBufferWithTopic(serverTag, nick, "")
+			buffers = append(buffers, weechatproto.BufferData{
+				Pointer:        buffer.Pointer,
+				Number:         buffer.Number,
+				Name:           buffer.Name,
+				ShortName:      buffer.ShortName,
+				Hidden:         false,
+				Title:          fmt.Sprintf("Private chat with %s", nick),
+				LocalVariables: "type=private",
+			})
+			t.log.Debugf("Created buffer for query: %s.%s", serverTag, nick)
 		}
 	}
 
@@ -165,35 +352,74 @@ func (t *Translator) ErssiToBufferList(stateDump *erssiproto.WebMessage) *weecha
 	return weechatproto.CreateBuffersHData(buffers)
 }
 
-// ErssiMessageToLine converts erssi message to WeeChat line
+// ErssiMessageToLine converts erssi message to WeeChat line.
+// Returns nil if the message carries an IRCv3 msgid that was already seen in
+// the buffer (duplicate delivery, e.g. backlog replay overlapping live lines).
 func (t *Translator) ErssiMessageToLine(msg *erssiproto.WebMessage) *weechatproto.Message {
 	t.buffersMu.Lock()
 	defer t.buffersMu.Unlock()
 
 	// Find or create buffer (normalize key)
-	normalizedTarget := strings.ToLower(msg.Target)
-	bufferKey := fmt.Sprintf("%s.%s", msg.ServerTag, normalizedTarget)
+	target := t.lineTargetLocked(msg)
+	bufferKey := getBufferKey(msg.ServerTag, target)
 	buffer, ok := t.buffers[bufferKey]
 	if !ok {
 		// Create new buffer
-		buffer = t.createBuffer(msg.ServerTag, msg.Target)
+		buffer = t.createBuffer(msg.ServerTag, target)
+	}
+
+	// Deduplicate on IRCv3 msgid
+	msgID := msg.MsgID()
+	if msgID != "" && buffer.seenMsgID(msgID) {
+		t.log.Debugf("Dropping duplicate message %s in %s", msgID, bufferKey)
+		return nil
+	}
+
+	// Prefer IRCv3 server-time over the time erssi processed the message
+	date := msg.Timestamp
+	if serverTime, ok := msg.ServerTime(); ok {
+		date = serverTime.Unix()
+	}
+	if date == 0 {
+		date = t.clock.Now().Unix()
+	}
+
+	// Own messages may come back without a nick
+	nick, prefix := msg.Nick, msg.Nick
+	if msg.IsOwn {
+		nick = t.ownMessageNick(msg)
+		prefix = t.ownPrefixLocked(nick)
 	}
 
 	// Create line data
 	line := weechatproto.LineData{
-		Pointer:      t.generatePointer(),
-		BufferPtr:    buffer.Pointer,
-		Date:         msg.Timestamp,
-		DatePrinted:  time.Now().Unix(),
-		Displayed:    true,
-		Highlight:    msg.IsHighlight,
-		Tags:         t.generateTags(msg),
-		Prefix:       msg.Nick,
-		Message:      msg.Text,
-	}
-
-	// Add to buffer lines (keep last 500 lines for history)
-	buffer.Lines = append(buffer.Lines, line)
+		Pointer:     t.generatePointer(),
+		BufferPtr:   buffer.Pointer,
+		Date:        date,
+		DatePrinted: t.clock.Now().Unix(),
+		Displayed:   true,
+		Highlight:   msg.IsHighlight && !msg.IsOwn,
+		Tags:        t.generateTags(msg, nick),
+		Prefix:      prefix,
+		Message:     t.decodeText(msg.ServerTag, msg.Text),
+	}
+	if t.mutedLocked(bufferKey) {
+		line.Highlight = false
+		line.Tags = quietTags(line.Tags)
+	} else if target == WallopsTarget {
+		line.Tags, line.Highlight = notifyTags(t.wallopsNotify, line.Tags, line.Highlight)
+	}
+
+	// Add to buffer lines (keep last 500 lines for history), in date order
+	// so server-time stamped backlog lands where it belongs
+	buffer.insertLine(line)
+	t.addToHotlistLocked(buffer, line)
+	if t.history != nil {
+		t.history.Record(msg.ServerTag, target, line)
+	}
+	if lineTime := time.Unix(date, 0); lineTime.After(buffer.LastActivity) {
+		buffer.LastActivity = lineTime
+	}
 	if len(buffer.Lines) > 500 {
 		buffer.Lines = buffer.Lines[len(buffer.Lines)-500:]
 	}
@@ -202,6 +428,25 @@ func (t *Translator) ErssiMessageToLine(msg *erssiproto.WebMessage) *weechatprot
 	return weechatproto.CreateLinesHData([]weechatproto.LineData{line})
 }
 
+// LocalNotice builds a bridge-generated line for a buffer. The line is not
+// stored in the buffer history, it is meant for the requesting client only.
+func (t *Translator) LocalNotice(bufferPtr, text string) *weechatproto.Message {
+	now := t.clock.Now().Unix()
+
+	line := weechatproto.LineData{
+		Pointer:     t.generatePointer(),
+		BufferPtr:   bufferPtr,
+		Date:        now,
+		DatePrinted: now,
+		Displayed:   true,
+		Tags:        "no_highlight,notify_none,bridge_notice",
+		Prefix:      "--",
+		Message:     text,
+	}
+
+	return weechatproto.CreateLinesHData([]weechatproto.LineData{line})
+}
+
 // ErssiNicklistToWeeChat converts erssi nicklist to WeeChat format
 func (t *Translator) ErssiNicklistToWeeChat(msg *erssiproto.WebMessage, nicks []erssiproto.NickInfo) *weechatproto.Message {
 	t.buffersMu.Lock()
@@ -215,22 +460,17 @@ func (t *Translator) ErssiNicklistToWeeChat(msg *erssiproto.WebMessage, nicks []
 		buffer = t.createBuffer(msg.ServerTag, msg.Target)
 	}
 
-	// Convert nicks
-	nickData := make([]weechatproto.NickData, len(nicks))
-	for i, nick := range nicks {
-		nickData[i] = weechatproto.NickData{
-			Pointer:      t.generatePointer(),
-			IsGroup:      false,
-			Visible:      true,
-			Name:         nick.Nick,
-			Color:        "default",
-			Prefix:       nick.Prefix,
-			PrefixColor:  t.getPrefixColor(nick.Prefix),
-		}
+	if t.nicklistDisabled {
+		buffer.UserCount = len(nicks)
+		return weechatproto.CreateNicklistHData([]weechatproto.NickData{})
 	}
 
+	// Convert nicks
+	nickData := t.convertNicks(nicks)
+
 	// Update buffer state
 	buffer.Nicks = nickData
+	buffer.UserCount = len(nickData)
 
 	return weechatproto.CreateNicklistHData(nickData)
 }
@@ -267,27 +507,52 @@ func (t *Translator) ParseHDataCommand(args []string) (path string, params strin
 
 // WeeChat to erssi conversion
 
+// ErrUnknownBuffer is returned for input to a buffer pointer the bridge
+// doesn't know, typically one a client kept from before a bridge restart
+var ErrUnknownBuffer = errors.New("unknown buffer")
+
+// ErrNoInput is returned for input to a known buffer that doesn't take
+// any, such as the core buffer
+var ErrNoInput = errors.New("buffer takes no input")
+
 // InputToErssiCommand converts WeeChat input to erssi command
 func (t *Translator) InputToErssiCommand(bufferPtr, text string) (*erssiproto.WebMessage, error) {
 	t.buffersMu.RLock()
 	defer t.buffersMu.RUnlock()
 
 	// Find buffer by pointer
-	var serverTag, target string
-	for key, buf := range t.buffers {
+	var buffer *BufferState
+	for _, buf := range t.buffers {
 		if buf.Pointer == bufferPtr {
-			parts := strings.SplitN(key, ".", 2)
-			if len(parts) == 2 {
-				serverTag = parts[0]
-				target = parts[1]
-			}
+			buffer = buf
 			break
 		}
 	}
 
-	if serverTag == "" {
-		return nil, fmt.Errorf("buffer not found: %s", bufferPtr)
+	// Server buffers send to the server itself, for commands such as /join
+	var serverTag, target string
+	switch {
+	case buffer == nil:
+		return nil, fmt.Errorf("%w: %s", ErrUnknownBuffer, bufferPtr)
+	case buffer.IsCore:
+		return nil, fmt.Errorf("%w: the core buffer only takes /bridge commands", ErrNoInput)
+	case buffer.IsServer:
+		serverTag = buffer.ServerTag
+	default:
+		serverTag, target = buffer.ServerTag, buffer.ShortName
+	}
+	if target == WallopsTarget {
+		return nil, fmt.Errorf("%w: %s.%s is read-only", ErrNoInput, serverTag, WallopsTarget)
+	}
+
+	if len(t.inputTransforms) > 0 {
+		text = t.transformInput(serverTag, target, text)
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("input is empty after transforms")
+		}
 	}
+	text = formatOutgoing(text, t.outgoingColors)
+	text = t.encodeText(serverTag, text)
 
 	return &erssiproto.WebMessage{
 		Type:      erssiproto.Message,
@@ -297,6 +562,20 @@ func (t *Translator) InputToErssiCommand(bufferPtr, text string) (*erssiproto.We
 	}, nil
 }
 
+// MessageToErssi builds a message to a target of a server that no client
+// typed (e.g. from the bridge's inject pipe), in the server's charset
+func (t *Translator) MessageToErssi(serverTag, target, text string) *erssiproto.WebMessage {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	return &erssiproto.WebMessage{
+		Type:      erssiproto.Message,
+		ServerTag: serverTag,
+		Target:    target,
+		Text:      t.encodeText(serverTag, text),
+	}
+}
+
 // Helper methods
 
 func (t *Translator) createBuffer(serverTag, target string) *BufferState {
@@ -338,6 +617,25 @@ func (t *Translator) EnsureServerBuffer(serverTag string) *BufferState {
 	return buffer
 }
 
+// restoreHistory fills a new buffer with its persisted lines.
+// Caller must hold buffersMu.
+func (t *Translator) restoreHistory(buffer *BufferState, serverTag, target string) {
+	if t.history == nil {
+		return
+	}
+
+	lines := t.history.Load(serverTag, target)
+	for _, line := range lines {
+		line.Pointer = t.generatePointer()
+		line.BufferPtr = buffer.Pointer
+		buffer.Lines = append(buffer.Lines, line)
+	}
+	if len(lines) > 0 {
+		buffer.LastActivity = time.Unix(lines[len(lines)-1].Date, 0)
+		t.log.Debugf("Restored %d lines for %s.%s", len(lines), serverTag, target)
+	}
+}
+
 // EnsureBuffer creates a buffer if it doesn't exist (thread-safe, public)
 func (t *Translator) EnsureBuffer(serverTag, target string) *BufferState {
 	t.buffersMu.Lock()
@@ -347,6 +645,8 @@ func (t *Translator) EnsureBuffer(serverTag, target string) *BufferState {
 }
 
 func (t *Translator) createBufferWithTopic(serverTag, target, topic string) *BufferState {
+	topic = t.decodeText(serverTag, topic)
+
 	// Normalize channel name for key
 	normalizedTarget := strings.ToLower(target)
 	bufferKey := fmt.Sprintf("%s.%s", serverTag, normalizedTarget)
@@ -375,6 +675,7 @@ func (t *Translator) createBufferWithTopic(serverTag, target, topic string) *Buf
 	}
 
 	t.buffers[bufferKey] = buffer
+	t.restoreHistory(buffer, serverTag, target)
 
 	t.log.Debugf("Created buffer: %s (ptr=%s, num=%d)", bufferKey, buffer.Pointer, buffer.Number)
 
@@ -383,26 +684,54 @@ func (t *Translator) createBufferWithTopic(serverTag, target, topic string) *Buf
 
 func (t *Translator) generatePointer() string {
 	// Generate a fake pointer (hex string)
-	return fmt.Sprintf("0x%x", time.Now().UnixNano())
+	return t.ids.NewID()
 }
 
-func (t *Translator) generateTags(msg *erssiproto.WebMessage) string {
+func (t *Translator) generateTags(msg *erssiproto.WebMessage, nick string) string {
 	tags := []string{}
 
-	// Add standard tags
-	tags = append(tags, "notify_message")
+	// Add standard tags; own messages and lines irssi prints without
+	// activity never notify
+	if msg.IsOwn {
+		tags = append(tags, "self_msg", "notify_none", "no_highlight")
+	} else if msg.Level&erssiproto.LevelNoActivity != 0 {
+		tags = append(tags, "notify_none")
+	} else {
+		tags = append(tags, "notify_message")
+
+		if msg.IsHighlight {
+			tags = append(tags, "notify_highlight")
+		}
+	}
 
-	if msg.IsHighlight {
-		tags = append(tags, "notify_highlight")
+	if nick != "" {
+		tags = append(tags, fmt.Sprintf("nick_%s", nick))
 	}
 
-	if msg.Nick != "" {
-		tags = append(tags, fmt.Sprintf("nick_%s", msg.Nick))
+	if host := msg.Host(); host != "" {
+		tags = append(tags, fmt.Sprintf("host_%s", host))
 	}
 
 	return strings.Join(tags, ",")
 }
 
+// convertNicks converts erssi nick entries to WeeChat nicklist items
+func (t *Translator) convertNicks(nicks []erssiproto.NickInfo) []weechatproto.NickData {
+	nickData := make([]weechatproto.NickData, len(nicks))
+	for i, nick := range nicks {
+		nickData[i] = weechatproto.NickData{
+			Pointer:     t.generatePointer(),
+			IsGroup:     false,
+			Visible:     true,
+			Name:        nick.Nick,
+			Color:       "default",
+			Prefix:      nick.Prefix,
+			PrefixColor: t.getPrefixColor(nick.Prefix),
+		}
+	}
+	return nickData
+}
+
 func (t *Translator) getPrefixColor(prefix string) string {
 	switch prefix {
 	case "@":
@@ -416,45 +745,58 @@ func (t *Translator) getPrefixColor(prefix string) string {
 	}
 }
 
-// GetAllBuffers returns all buffers as WeeChat HData (for responding to hdata requests)
-func (t *Translator) GetAllBuffers(msgID string) *weechatproto.Message {
+// GetAllBuffers returns the buffers of the view as WeeChat HData
+// (for responding to hdata requests)
+func (t *Translator) GetAllBuffers(msgID string, view BufferView) *weechatproto.Message {
 	t.buffersMu.RLock()
 	defer t.buffersMu.RUnlock()
 
-	// Collect all buffers and sort by number (server buffers first, then channels)
-	bufferList := make([]*BufferState, 0, len(t.buffers))
-	for _, buf := range t.buffers {
-		bufferList = append(bufferList, buf)
-	}
-
-	// Sort by buffer number
-	sort.Slice(bufferList, func(i, j int) bool {
-		return bufferList[i].Number < bufferList[j].Number
-	})
+	// Sorted by number (server buffers first, then channels)
+	bufferList := t.sortedBuffersLocked()
 
 	buffers := make([]weechatproto.BufferData, 0, len(bufferList))
 
 	for _, buf := range bufferList {
-		// Set local_variables based on buffer type
-		localVars := "type=channel,server=" + buf.ServerTag
-		if buf.IsServer {
-			localVars = "type=server"
+		if view.shows(buf) {
+			buffers = append(buffers, t.bufferData(buf))
 		}
-
-		buffers = append(buffers, weechatproto.BufferData{
-			Pointer:        buf.Pointer,
-			Number:         buf.Number,
-			Name:           buf.Name,
-			ShortName:      buf.ShortName,
-			Hidden:         false,
-			Title:          buf.Title,
-			LocalVariables: localVars,
-		})
 	}
 
 	return weechatproto.CreateBuffersHDataWithID(buffers, msgID)
 }
 
+// bufferData converts buffer state to WeeChat buffer metadata
+func (t *Translator) bufferData(buf *BufferState) weechatproto.BufferData {
+	// Set local_variables based on buffer type
+	localVars := "type=channel,server=" + buf.ServerTag
+	if buf.IsServer {
+		localVars = "type=server"
+	}
+	if buf.IsCore {
+		localVars = "plugin=core,name=weechat"
+	}
+	wallops := !buf.IsServer && !buf.IsCore && buf.ShortName == WallopsTarget
+	if wallops {
+		localVars = "type=wallops,server=" + buf.ServerTag
+	}
+	if !buf.IsServer && !buf.IsCore && t.mutedLocked(getBufferKey(buf.ServerTag, buf.ShortName)) {
+		localVars += ",notify=none"
+	} else if wallops && t.wallopsNotify != "" {
+		localVars += ",notify=" + string(t.wallopsNotify)
+	}
+
+	return weechatproto.BufferData{
+		Pointer:        buf.Pointer,
+		Number:         buf.Number,
+		Name:           buf.Name,
+		ShortName:      buf.ShortName,
+		Hidden:         false,
+		Title:          buf.Title,
+		Nicklist:       !buf.IsServer && !buf.IsCore && !wallops && !t.nicklistDisabled,
+		LocalVariables: localVars,
+	}
+}
+
 // getBufferKey returns the buffer key for a server and target
 func getBufferKey(serverTag, target string) string {
 	normalizedTarget := strings.ToLower(target)
@@ -469,21 +811,7 @@ func (t *Translator) GetBufferOpenedEvent(serverTag, target string) *weechatprot
 	bufferKey := getBufferKey(serverTag, target)
 
 	if buf, exists := t.buffers[bufferKey]; exists {
-		// Set local_variables based on buffer type
-		localVars := "type=channel,server=" + buf.ServerTag
-		if buf.IsServer {
-			localVars = "type=server"
-		}
-
-		buffers := []weechatproto.BufferData{{
-			Pointer:        buf.Pointer,
-			Number:         buf.Number,
-			Name:           buf.Name,
-			ShortName:      buf.ShortName,
-			Hidden:         false,
-			Title:          buf.Title,
-			LocalVariables: localVars,
-		}}
+		buffers := []weechatproto.BufferData{t.bufferData(buf)}
 		return weechatproto.CreateBuffersHDataWithID(buffers, "_buffer_opened")
 	}
 
@@ -503,29 +831,28 @@ func (t *Translator) GetBufferList() []string {
 	return result
 }
 
-// GetEmptyHotlist returns an empty hotlist response
-func (t *Translator) GetEmptyHotlist(msgID string) *weechatproto.Message {
-	// Return empty hotlist HData
-	return weechatproto.CreateEmptyHotlistWithID(msgID)
-}
-
-// GetBufferLines returns lines for a buffer
-func (t *Translator) GetBufferLines(bufferPtr string, count int, msgID string) *weechatproto.Message {
+// GetBufferLines returns lines for a buffer, as the view sees them
+func (t *Translator) GetBufferLines(bufferPtr string, count int, msgID string, view BufferView) *weechatproto.Message {
 	t.buffersMu.RLock()
 	defer t.buffersMu.RUnlock()
 
 	for _, buf := range t.buffers {
 		if buf.Pointer == bufferPtr {
 			// Return last N lines
+			visible := t.visibleLinesLocked(buf, view)
 			start := 0
-			if len(buf.Lines) > count {
-				start = len(buf.Lines) - count
+			if len(visible) > count {
+				start = len(visible) - count
 			}
-			lines := buf.Lines[start:]
+			lines := visible[start:]
 
 			return weechatproto.CreateLinesHDataWithID(lines, msgID)
 		}
 	}
+	if buf, ok := t.tempBuffers[bufferPtr]; ok {
+		lines := buf.Lines[max(0, len(buf.Lines)-count):]
+		return weechatproto.CreateLinesHDataWithID(lines, msgID)
+	}
 
 	// Return empty if buffer not found
 	return weechatproto.CreateLinesHDataWithID([]weechatproto.LineData{}, msgID)
@@ -548,13 +875,3 @@ func (t *Translator) GetBufferInfo(bufferPtr string) (serverTag, target string)
 
 	return "", ""
 }
-
-// getString safely extracts a string from a map
-func getString(m map[string]interface{}, key string) string {
-	if val, ok := m[key]; ok {
-		if str, ok := val.(string); ok {
-			return str
-		}
-	}
-	return ""
-}
@@ -0,0 +1,176 @@
+package translator
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"erssi-lith-bridge/internal/clock"
+	"erssi-lith-bridge/pkg/erssiproto"
+	"erssi-lith-bridge/pkg/weechatproto"
+
+	"github.com/sirupsen/logrus"
+)
+
+// testStart is the time of the manual clock of the tests
+var testStart = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestTranslator returns a translator on a manual clock with pointers
+// counted from 0x1000
+func newTestTranslator() (*Translator, *clock.Manual) {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	clk := clock.NewManual(testStart)
+	t := NewTranslator(logger)
+	t.SetClock(clk)
+	t.SetIDGenerator(clock.NewPointers(0x1000))
+	return t, clk
+}
+
+// lineDates returns the date and date_printed of the first line of a
+// line message
+func lineDates(t *testing.T, msg *weechatproto.Message) (date, printed int64) {
+	t.Helper()
+	for _, obj := range msg.Data {
+		if h, ok := obj.(weechatproto.HData); ok && len(h.Items) > 0 {
+			date, _ := h.Items[0].Objects["date"].(weechatproto.Time)
+			printed, _ := h.Items[0].Objects["date_printed"].(weechatproto.Time)
+			return date.Value, printed.Value
+		}
+	}
+	t.Fatalf("%s carries no line", msg.ID)
+	return 0, 0
+}
+
+func TestPointersComeFromIDGenerator(t *testing.T) {
+	tr, _ := newTestTranslator()
+
+	// The core buffer gets the first pointer when the generator is set
+	pointers := make(map[string]string)
+	for _, item := range allBufferItems(tr) {
+		pointers[weechatproto.ObjectString(item.Objects["name"])] = item.Pointers[0]
+	}
+	if pointers["core.weechat"] != "0x1000" {
+		t.Errorf("core buffer pointer %q, want 0x1000", pointers["core.weechat"])
+	}
+
+	if ptr := tr.EnsureServerBuffer("libera").Pointer; ptr != "0x1001" {
+		t.Errorf("server buffer pointer %q, want 0x1001", ptr)
+	}
+	if ptr := tr.EnsureBuffer("libera", "#go").Pointer; ptr != "0x1002" {
+		t.Errorf("channel buffer pointer %q, want 0x1002", ptr)
+	}
+	if ptr := tr.EnsureBuffer("libera", "#GO").Pointer; ptr != "0x1002" {
+		t.Errorf("existing buffer got a new pointer %q", ptr)
+	}
+}
+
+func TestLineDatesComeFromClock(t *testing.T) {
+	tr, clk := newTestTranslator()
+	msg := &erssiproto.WebMessage{Type: erssiproto.Message, ServerTag: "libera", Target: "#go", Nick: "alice", Text: "hi"}
+
+	date, printed := lineDates(t, tr.ErssiMessageToLine(msg))
+	if date != testStart.Unix() || printed != testStart.Unix() {
+		t.Errorf("line dated %d/%d, want the clock's %d", date, printed, testStart.Unix())
+	}
+
+	// A timestamp from erssi wins for the date; printing is still now
+	clk.Advance(time.Hour)
+	msg.Timestamp = testStart.Add(time.Minute).Unix()
+	date, printed = lineDates(t, tr.ErssiMessageToLine(msg))
+	if date != msg.Timestamp {
+		t.Errorf("line dated %d, want erssi's %d", date, msg.Timestamp)
+	}
+	if want := testStart.Add(time.Hour).Unix(); printed != want {
+		t.Errorf("line printed at %d, want the advanced clock's %d", printed, want)
+	}
+
+	date, _ = lineDates(t, tr.CoreLine("status"))
+	if want := testStart.Add(time.Hour).Unix(); date != want {
+		t.Errorf("core line dated %d, want %d", date, want)
+	}
+}
+
+func TestOutputIsDeterministic(t *testing.T) {
+	run := func() []byte {
+		tr, clk := newTestTranslator()
+		var out bytes.Buffer
+		encoder := weechatproto.NewEncoder(&out)
+
+		encode := func(msg *weechatproto.Message) {
+			if msg == nil {
+				return
+			}
+			if err := encoder.EncodeMessage(msg); err != nil {
+				t.Fatalf("encode: %v", err)
+			}
+		}
+
+		tr.EnsureServerBuffer("libera")
+		for i, text := range []string{"one", "two", "three"} {
+			clk.Advance(time.Duration(i) * time.Second)
+			encode(tr.ErssiMessageToLine(&erssiproto.WebMessage{
+				Type: erssiproto.Message, ServerTag: "libera", Target: "#go", Nick: "alice", Text: text,
+			}))
+		}
+		encode(tr.CoreLine("status"))
+		encode(tr.GetAllBuffers("buffers", nil))
+		return out.Bytes()
+	}
+
+	first, second := run(), run()
+	if len(first) == 0 {
+		t.Fatal("no output")
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("two runs on the same clock and IDs encoded differently")
+	}
+}
+
+// allBufferItems returns the items of the buffer list hdata
+func allBufferItems(tr *Translator) []weechatproto.HDataItem {
+	for _, obj := range tr.GetAllBuffers("buffers", nil).Data {
+		if h, ok := obj.(weechatproto.HData); ok {
+			return h.Items
+		}
+	}
+	return nil
+}
+
+func TestScopedCoreLines(t *testing.T) {
+	tr, _ := newTestTranslator()
+	tr.CoreLine("for everyone")
+	tr.ScopedCoreLine("libera", "#go", "about #go")
+	tr.ScopedCoreLine("libera", "", "about libera")
+	tr.UnrestrictedCoreLine("about the upstream")
+
+	var core string
+	for _, item := range allBufferItems(tr) {
+		if weechatproto.ObjectString(item.Objects["name"]) == "core.weechat" {
+			core = item.Pointers[0]
+		}
+	}
+
+	tests := []struct {
+		name string
+		view BufferView
+		want []string
+	}{
+		{"unrestricted", nil, []string{"for everyone", "about #go", "about libera", "about the upstream"}},
+		{"channel", func(serverTag, target string) bool { return target == "#go" }, []string{"for everyone", "about #go"}},
+		{"server", func(serverTag, target string) bool { return serverTag == "libera" }, []string{"for everyone", "about #go", "about libera"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		h := tr.GetBufferLines(core, 50, "lines", tt.view).Data[0].(weechatproto.HData)
+		for _, item := range h.Items {
+			got = append(got, weechatproto.ObjectString(item.Objects["message"]))
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s view: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package translator
+
+import "erssi-lith-bridge/pkg/weechatproto"
+
+// BufferView selects the buffers a relay client may see, by server tag
+// and target. Server buffers are asked about with an empty target; the
+// core buffer and context buffers are always visible. A nil view shows
+// every buffer.
+type BufferView func(serverTag, target string) bool
+
+// shows reports whether the view includes a buffer
+func (v BufferView) shows(buf *BufferState) bool {
+	if v == nil || buf.IsCore {
+		return true
+	}
+	if buf.IsServer {
+		return v(buf.ServerTag, "")
+	}
+	return v(buf.ServerTag, buf.ShortName)
+}
+
+// coreScope limits a core buffer line to the views including a buffer
+// (empty target = the server buffer), or to unrestricted views
+type coreScope struct {
+	serverTag    string
+	target       string
+	unrestricted bool
+}
+
+// shows reports whether the view includes a scoped core line
+func (s coreScope) shows(view BufferView) bool {
+	if view == nil {
+		return true
+	}
+	return !s.unrestricted && view(s.serverTag, s.target)
+}
+
+// visibleLinesLocked returns the lines of a buffer the view includes: every
+// line but the core buffer lines scoped to buffers outside the view.
+// Caller must hold buffersMu.
+func (t *Translator) visibleLinesLocked(buf *BufferState, view BufferView) []weechatproto.LineData {
+	if view == nil || !buf.IsCore || len(t.coreScopes) == 0 {
+		return buf.Lines
+	}
+
+	lines := make([]weechatproto.LineData, 0, len(buf.Lines))
+	for _, line := range buf.Lines {
+		if scope, ok := t.coreScopes[line.Pointer]; !ok || scope.shows(view) {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// BufferVisible reports whether the view includes the buffer with the given
+// pointer. Unknown pointers are not visible.
+func (t *Translator) BufferVisible(bufferPtr string, view BufferView) bool {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	if _, ok := t.tempBuffers[bufferPtr]; ok {
+		return true
+	}
+	for _, buf := range t.buffers {
+		if buf.Pointer == bufferPtr {
+			return view.shows(buf)
+		}
+	}
+	return false
+}
+
+// BufferKnown reports whether a buffer pointer belongs to a buffer of the
+// bridge, visible to a client or not
+func (t *Translator) BufferKnown(bufferPtr string) bool {
+	return t.BufferVisible(bufferPtr, nil)
+}
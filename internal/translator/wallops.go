@@ -0,0 +1,76 @@
+package translator
+
+import (
+	"fmt"
+	"strings"
+
+	"erssi-lith-bridge/pkg/erssiproto"
+)
+
+// WallopsTarget is the target of the per-network buffer collecting wallops
+// and global notices (see SetWallopsBuffer). "*" can't start a nick or a
+// channel name, so it never clashes with a real buffer.
+const WallopsTarget = "*wallops"
+
+// NotifyLevel is the WeeChat notify level of a buffer: which of its lines
+// reach the hotlist
+type NotifyLevel string
+
+const (
+	NotifyNone      NotifyLevel = "none"
+	NotifyHighlight NotifyLevel = "highlight"
+	NotifyMessage   NotifyLevel = "message"
+	NotifyAll       NotifyLevel = "all"
+)
+
+// ParseNotifyLevel parses a notify level (empty stays empty)
+func ParseNotifyLevel(s string) (NotifyLevel, error) {
+	switch level := NotifyLevel(strings.ToLower(strings.TrimSpace(s))); level {
+	case "", NotifyNone, NotifyHighlight, NotifyMessage, NotifyAll:
+		return level, nil
+	default:
+		return "", fmt.Errorf("unknown notify level %q (want none, highlight, message or all)", s)
+	}
+}
+
+// SetWallopsBuffer moves wallops and global notices out of the server
+// buffer into a "<server>.*wallops" buffer per network, opened with the
+// first of them, whose lines notify at the given level. "" keeps them
+// where erssi puts them. Must be called before any state is loaded.
+func (t *Translator) SetWallopsBuffer(notify NotifyLevel) {
+	t.buffersMu.Lock()
+	defer t.buffersMu.Unlock()
+
+	t.wallopsNotify = notify
+}
+
+// LineTarget returns the target of the buffer a message line goes to
+func (t *Translator) LineTarget(msg *erssiproto.WebMessage) string {
+	t.buffersMu.RLock()
+	defer t.buffersMu.RUnlock()
+
+	return t.lineTargetLocked(msg)
+}
+
+// lineTargetLocked is LineTarget with buffersMu held
+func (t *Translator) lineTargetLocked(msg *erssiproto.WebMessage) string {
+	if t.wallopsNotify != "" && msg.IsOperBroadcast() {
+		return WallopsTarget
+	}
+	return msg.Target
+}
+
+// notifyTags applies a buffer notify level to the tags and highlight of a
+// line: below the level, the line is tagged notify_none and loses its
+// highlight
+func notifyTags(level NotifyLevel, tags string, highlight bool) (string, bool) {
+	switch level {
+	case NotifyNone:
+		return quietTags(tags), false
+	case NotifyHighlight:
+		if !highlight {
+			return quietTags(tags), false
+		}
+	}
+	return tags, highlight
+}
@@ -0,0 +1,115 @@
+package weechat
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// AccessList limits the addresses relay clients may connect from. Denied
+// networks are always refused; with allowed networks, so is every address
+// outside them. The zero value lets everyone in. Unix socket clients have
+// no address and are not checked.
+type AccessList struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// ParseAccessList parses the allowed and denied networks, each a CIDR
+// ("192.168.1.0/24", "fd7a:115c:a1e0::/48") or a single address
+func ParseAccessList(allow, deny []string) (AccessList, error) {
+	var list AccessList
+	var err error
+	if list.Allow, err = parsePrefixes(allow); err != nil {
+		return AccessList{}, err
+	}
+	if list.Deny, err = parsePrefixes(deny); err != nil {
+		return AccessList{}, err
+	}
+	return list, nil
+}
+
+// parsePrefixes parses CIDRs and single addresses
+func parsePrefixes(items []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q (want a CIDR or an IP address)", item)
+			}
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q (want a CIDR or an IP address)", item)
+		}
+		if prefix.Addr().Is4In6() {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// enabled reports whether the list restricts anything
+func (a AccessList) enabled() bool {
+	return len(a.Allow) > 0 || len(a.Deny) > 0
+}
+
+// permits reports whether a client address may connect
+func (a AccessList) permits(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range a.Deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(a.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range a.Allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// admit decides whether a new connection is accepted, given the address of
+// its peer ("ip:port", "" for unix sockets) and of the client behind it
+// (the forwarded one behind a reverse proxy). It returns why not, or "" to
+// accept it.
+func (s *Server) admit(peer, remote string) string {
+	if s.access.enabled() && peer != "" {
+		addrPort, err := netip.ParseAddrPort(peer)
+		if err != nil || !s.access.permits(addrPort.Addr()) {
+			return "address not allowed"
+		}
+	}
+	if reason := s.bannedReason(remote); reason != "" {
+		return reason
+	}
+
+	if s.maxClients > 0 {
+		s.clientsMu.RLock()
+		n := len(s.clients)
+		s.clientsMu.RUnlock()
+		if n >= s.maxClients {
+			return fmt.Sprintf("%d clients connected already", n)
+		}
+	}
+	return ""
+}
+
+// reject refuses a new connection before it speaks
+func (s *Server) reject(remote, reason string) {
+	s.stats.connectionsRejected.Add(1)
+	s.log.Warnf("Rejecting relay client %s: %s", remote, reason)
+}
@@ -0,0 +1,40 @@
+package weechat
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestAccessList(t *testing.T) {
+	list, err := ParseAccessList([]string{"192.168.1.0/24", "fd7a:115c:a1e0::/48", "::ffff:10.0.0.0/104"}, []string{"192.168.1.66"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want bool
+	}{
+		{"192.168.1.10", true},
+		{"::ffff:192.168.1.10", true},
+		{"192.168.1.66", false},
+		{"192.168.2.10", false},
+		{"10.1.2.3", true},
+		{"fd7a:115c:a1e0:ab12::1", true},
+		{"fd7a:115c:a1e1::1", false},
+	}
+	for _, tt := range tests {
+		if got := list.permits(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.addr, got, tt.want)
+		}
+	}
+
+	if (AccessList{}).enabled() || !(AccessList{}).permits(netip.MustParseAddr("203.0.113.1")) {
+		t.Errorf("empty access list restricts")
+	}
+	for _, bad := range []string{"192.168.1.0/33", "example.com", "192.168.1"} {
+		if _, err := ParseAccessList([]string{bad}, nil); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
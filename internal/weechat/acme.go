@@ -0,0 +1,93 @@
+package weechat
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// ACMEConfig obtains and renews certificates for the relay listeners from
+// Let's Encrypt, turning them into TLS listeners. Enabling it accepts the
+// Let's Encrypt terms of service.
+type ACMEConfig struct {
+	Domains  []string // Names to get certificates for; empty = disabled
+	CacheDir string   // Where certificates and the account key are kept
+	Email    string   // Contact for expiry notices (optional)
+
+	// HTTPAddr serves HTTP-01 challenges (e.g. ":80"). Without it only
+	// TLS-ALPN-01 is used, which needs the relay reachable on port 443.
+	HTTPAddr string
+}
+
+// enabled reports whether ACME is configured
+func (cfg ACMEConfig) enabled() bool {
+	return len(cfg.Domains) > 0
+}
+
+// manager creates the certificate manager
+func (cfg ACMEConfig) manager() (*autocert.Manager, error) {
+	if cfg.CacheDir == "" {
+		return nil, fmt.Errorf("ACME needs a cache directory, or every restart requests new certificates")
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		HostPolicy: autocert.HostWhitelist(cfg.Domains...),
+		Email:      cfg.Email,
+	}, nil
+}
+
+// startACME sets up the TLS configuration of the relay listeners and the
+// HTTP-01 challenge listener
+func (s *Server) startACME() error {
+	m, err := s.acme.manager()
+	if err != nil {
+		return err
+	}
+	s.tlsConfig = m.TLSConfig()
+
+	if s.acme.HTTPAddr != "" {
+		listener, err := net.Listen("tcp", s.acme.HTTPAddr)
+		if err != nil {
+			return fmt.Errorf("failed to listen for ACME challenges: %w", err)
+		}
+		s.acmeServer = &http.Server{
+			Handler:           m.HTTPHandler(nil),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		s.log.Infof("ACME HTTP challenges served on %s", listener.Addr())
+
+		go func() {
+			if err := s.acmeServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.log.Errorf("ACME challenge server error: %v", err)
+			}
+		}()
+	}
+
+	s.log.Infof("Relay TLS certificates from Let's Encrypt for %v (cache %s)", s.acme.Domains, s.acme.CacheDir)
+	return nil
+}
+
+// closeACME stops the HTTP-01 challenge listener
+func (s *Server) closeACME() {
+	if s.acmeServer != nil {
+		s.acmeServer.Close()
+	}
+}
+
+// wsTLSConfig returns the TLS configuration of the websocket listener.
+// Websockets need HTTP/1.1, so h2 is not offered.
+func (s *Server) wsTLSConfig() *tls.Config {
+	cfg := s.tlsConfig.Clone()
+	cfg.NextProtos = slices.DeleteFunc(slices.Clone(cfg.NextProtos), func(proto string) bool {
+		return proto != "http/1.1" && proto != acme.ALPNProto
+	})
+	return cfg
+}
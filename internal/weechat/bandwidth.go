@@ -0,0 +1,155 @@
+package weechat
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// minBandwidthBurst is the smallest burst of a client bandwidth cap, so
+// live lines go out right away even under a low cap
+const minBandwidthBurst = 16 << 10
+
+// ParseBandwidth parses a per-client bandwidth cap in bytes per second,
+// with an optional k or m suffix (KiB, MiB). Empty or 0 means unlimited.
+func ParseBandwidth(s string) (int64, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	if value == "" {
+		return 0, nil
+	}
+
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(value, "k"):
+		unit, value = 1<<10, strings.TrimSuffix(value, "k")
+	case strings.HasSuffix(value, "m"):
+		unit, value = 1<<20, strings.TrimSuffix(value, "m")
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q (want bytes per second, e.g. 256k)", s)
+	}
+	return n * unit, nil
+}
+
+// bandwidthLimiter paces what is sent to a client with a token bucket in
+// bytes. Sends are charged after the fact and may drive the bucket into
+// debt; paced senders then wait for it to refill. Not safe for concurrent
+// use (guarded by the client mutex).
+type bandwidthLimiter struct {
+	rate   float64 // Bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newBandwidthLimiter(rate int64) *bandwidthLimiter {
+	burst := float64(max(rate, minBandwidthBurst))
+	return &bandwidthLimiter{rate: float64(rate), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// charge takes n bytes from the bucket and returns how long it takes to
+// pay off the debt (0 if there is none)
+func (l *bandwidthLimiter) charge(n int, now time.Time) time.Duration {
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// countingReader counts the bytes read from a client
+type countingReader struct {
+	r             io.Reader
+	client, total *atomic.Int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.client.Add(int64(n))
+	r.total.Add(int64(n))
+	return n, err
+}
+
+// countingWriter counts the bytes written to a client
+type countingWriter struct {
+	w             io.Writer
+	client, total *atomic.Int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.client.Add(int64(n))
+	w.total.Add(int64(n))
+	return n, err
+}
+
+// ClientStats describes one connected relay client
+type ClientStats struct {
+	Remote        string
+	WebSocket     bool
+	Authenticated bool
+	Connected     time.Time
+
+	BytesSent     int64
+	BytesReceived int64
+	MessagesSent  int64
+
+	// Compression is the negotiated compression. MessagesCompressed counts
+	// the messages sent compressed; MessageBytes and MessageBytesSent are
+	// the size of all messages before and after compression.
+	Compression        weechatproto.Compression
+	MessagesCompressed int64
+	MessageBytes       int64
+	MessageBytesSent   int64
+
+	// Throttled is how long replies to the client waited for its
+	// bandwidth cap
+	Throttled time.Duration
+
+	// Queued is how many messages wait for the client's writer, Dropped how
+	// many broadcasts it missed by falling behind
+	Queued  int
+	Dropped int64
+}
+
+// ClientStats returns the counters of every connected client
+func (s *Server) ClientStats() []ClientStats {
+	s.clientsMu.RLock()
+	defer s.clientsMu.RUnlock()
+
+	stats := make([]ClientStats, 0, len(s.clients))
+	for _, client := range s.clients {
+		stats = append(stats, client.Stats())
+	}
+	return stats
+}
+
+// Stats returns the counters of a client
+func (c *Client) Stats() ClientStats {
+	return ClientStats{
+		Remote:        c.remote,
+		WebSocket:     c.webSocket,
+		Authenticated: c.authenticated,
+		Connected:     c.connected,
+		BytesSent:     c.bytesSent.Load(),
+		BytesReceived: c.bytesReceived.Load(),
+		MessagesSent:  c.messagesSent.Load(),
+		Throttled:     time.Duration(c.throttled.Load()),
+		Queued:        len(c.queue),
+		Dropped:       c.dropped.Load(),
+
+		Compression:        c.compression,
+		MessagesCompressed: c.messagesCompressed.Load(),
+		MessageBytes:       c.messageBytes.Load(),
+		MessageBytesSent:   c.messageBytesSent.Load(),
+	}
+}
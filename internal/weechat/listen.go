@@ -0,0 +1,264 @@
+package weechat
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultSocketMode is the permission of unix socket listeners
+const defaultSocketMode os.FileMode = 0o660
+
+// ListenSpec is one address to listen on
+type ListenSpec struct {
+	Network string // "tcp" (dual-stack where possible), "tcp4", "tcp6" or "unix"
+	Address string // host:port, host may be empty for all addresses; socket path for unix
+}
+
+func (s ListenSpec) String() string {
+	if s.Network == "tcp" {
+		return s.Address
+	}
+	return s.Network + ":" + s.Address
+}
+
+// ParseListenSpecs parses comma-separated listen addresses, each optionally
+// prefixed with its address family: "tcp4:0.0.0.0:9000,tcp6:[::]:9000".
+// Without a prefix the address is listened on as "tcp". Unix sockets are
+// given as "unix:///run/bridge.sock" (or "unix:/run/bridge.sock").
+func ParseListenSpecs(list string) ([]ListenSpec, error) {
+	var specs []ListenSpec
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		if path, ok := unixSocketPath(item); ok {
+			if path == "" {
+				return nil, fmt.Errorf("invalid listen address %q: no socket path", item)
+			}
+			specs = append(specs, ListenSpec{Network: "unix", Address: path})
+			continue
+		}
+
+		spec := ListenSpec{Network: "tcp", Address: item}
+		for _, network := range []string{"tcp4", "tcp6", "tcp"} {
+			// "tcp4:9000" is host tcp4, port 9000; a prefix needs an
+			// address with a port after it
+			if rest, ok := strings.CutPrefix(item, network+":"); ok && strings.Contains(rest, ":") {
+				spec = ListenSpec{Network: network, Address: rest}
+				break
+			}
+		}
+
+		if _, _, err := net.SplitHostPort(spec.Address); err != nil {
+			return nil, fmt.Errorf("invalid listen address %q: %w", item, err)
+		}
+		specs = append(specs, spec)
+	}
+
+	if len(specs) == 0 {
+		return nil, fmt.Errorf("no listen address")
+	}
+	return specs, nil
+}
+
+// unixSocketPath returns the socket path of a unix: listen address
+func unixSocketPath(item string) (string, bool) {
+	rest, ok := strings.CutPrefix(item, "unix:")
+	if !ok {
+		return "", false
+	}
+	return strings.TrimPrefix(rest, "//"), true
+}
+
+// ParseSocketMode parses the octal permissions of unix socket listeners
+// (empty means 0660)
+func ParseSocketMode(s string) (os.FileMode, error) {
+	if s == "" {
+		return defaultSocketMode, nil
+	}
+	mode, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid socket mode %q (want octal permissions, e.g. 0660)", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+// listenUnix opens a unix socket listener with the configured permissions,
+// replacing a stale socket file left by a previous run. Unix sockets are
+// always local, whatever Config.Listen does.
+func (s *Server) listenUnix(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("socket %s is in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, s.socketMode); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return l, nil
+}
+
+// listenSpecs opens a listener for every address in list and merges them
+// into one. A wildcard "tcp" address that the OS only bound for IPv4 (no
+// dual-stack sockets, e.g. OpenBSD) also gets an IPv6 listener when the
+// host has IPv6.
+func (s *Server) listenSpecs(list string) (net.Listener, error) {
+	specs, err := ParseListenSpecs(list)
+	if err != nil {
+		return nil, err
+	}
+
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, l := range listeners {
+			l.Close()
+		}
+	}
+
+	for _, spec := range specs {
+		var l net.Listener
+		if spec.Network == "unix" {
+			l, err = s.listenUnix(spec.Address)
+		} else {
+			l, err = s.listen(spec.Network, spec.Address)
+		}
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("%s: %w", spec, err)
+		}
+		listeners = append(listeners, l)
+
+		if s.systemListen && spec.Network == "tcp" && ipv4Only(spec.Address, l.Addr()) {
+			if l6, err := s.listen("tcp6", spec.Address); err == nil {
+				s.log.Infof("%s is IPv4-only on this system, also listening on %s", spec.Address, l6.Addr())
+				listeners = append(listeners, l6)
+			} else {
+				s.log.Debugf("No IPv6 listener for %s: %v", spec.Address, err)
+			}
+		}
+	}
+
+	if len(listeners) == 1 {
+		return listeners[0], nil
+	}
+	return newMultiListener(listeners), nil
+}
+
+// ipv4Only reports whether a wildcard address ended up bound to IPv4 only
+func ipv4Only(address string, bound net.Addr) bool {
+	host, _, _ := net.SplitHostPort(address)
+	if host != "" {
+		return false
+	}
+	tcpAddr, ok := bound.(*net.TCPAddr)
+	return ok && tcpAddr.IP.To4() != nil
+}
+
+// listenerAddrs returns the addresses of a (possibly merged) listener
+func listenerAddrs(l net.Listener) []net.Addr {
+	if m, ok := l.(*multiListener); ok {
+		addrs := make([]net.Addr, len(m.listeners))
+		for i, sub := range m.listeners {
+			addrs[i] = sub.Addr()
+		}
+		return addrs
+	}
+	return []net.Addr{l.Addr()}
+}
+
+// formatAddrs joins listener addresses for logging
+func formatAddrs(addrs []net.Addr) string {
+	names := make([]string, len(addrs))
+	for i, addr := range addrs {
+		names[i] = addr.String()
+	}
+	return strings.Join(names, ", ")
+}
+
+// multiListener accepts connections from several listeners
+type multiListener struct {
+	listeners []net.Listener
+	accepted  chan acceptResult
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(listeners []net.Listener) *multiListener {
+	m := &multiListener{
+		listeners: listeners,
+		accepted:  make(chan acceptResult),
+		done:      make(chan struct{}),
+	}
+	for _, l := range listeners {
+		go m.acceptFrom(l)
+	}
+	return m
+}
+
+func (m *multiListener) acceptFrom(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		select {
+		case m.accepted <- acceptResult{conn, err}:
+		case <-m.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// Accept returns the next connection from any of the listeners
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.accepted:
+		return r.conn, r.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes every listener
+func (m *multiListener) Close() error {
+	var errs []error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		for _, l := range m.listeners {
+			if err := l.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// Addr returns the address of the first listener
+func (m *multiListener) Addr() net.Addr {
+	return m.listeners[0].Addr()
+}
@@ -0,0 +1,86 @@
+package weechat
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// DefaultPingInterval is a sensible interval of the liveness checks of
+	// relay clients (Config.PingInterval)
+	DefaultPingInterval = time.Minute
+
+	// missedProbes is how many keepalive probes or websocket pings in a row
+	// a client may leave unanswered before it counts as gone
+	missedProbes = 2
+)
+
+// watchLiveness starts the server-side liveness checks of a new client, so
+// clients that vanished without closing the connection (a phone losing
+// its network) are noticed: TCP keepalive probes on TCP connections, pings
+// on websocket ones, which browsers and proxies answer by themselves.
+// Nothing is checked when PingInterval is 0.
+func (s *Server) watchLiveness(client *Client) {
+	if s.pingInterval <= 0 {
+		return
+	}
+
+	conn := client.conn
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	switch conn := conn.(type) {
+	case *net.TCPConn:
+		conn.SetKeepAliveConfig(net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     s.pingInterval,
+			Interval: s.pingInterval,
+			Count:    missedProbes,
+		})
+	case *wsConn:
+		client.lastPong.Store(time.Now().UnixNano())
+		conn.SetPongHandler(func(string) error {
+			client.lastPong.Store(time.Now().UnixNano())
+			client.touch()
+			return nil
+		})
+		go s.pingWebSocket(client, conn)
+	}
+}
+
+// pingWebSocket pings a websocket client every PingInterval until it
+// disconnects, and disconnects it when the pongs stop
+func (s *Server) pingWebSocket(client *Client, conn *wsConn) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-client.closed:
+			return
+		case <-ticker.C:
+		}
+
+		silent := time.Since(time.Unix(0, client.lastPong.Load()))
+		if silent > missedProbes*s.pingInterval {
+			client.log.Warnf("No pong for %s, disconnecting", silent.Round(time.Second))
+			s.stats.clientsTimedOut.Add(1)
+			conn.Close()
+			return
+		}
+		if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(s.writeTimeout)); err != nil {
+			return
+		}
+	}
+}
+
+// touch pushes back the idle deadline of an authenticated client; any
+// command, or a websocket pong, shows it is still there
+func (c *Client) touch() {
+	if c.server.idleTimeout > 0 && c.idleWatch.Load() {
+		c.conn.SetReadDeadline(time.Now().Add(c.server.idleTimeout))
+	}
+}
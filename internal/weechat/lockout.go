@@ -0,0 +1,166 @@
+package weechat
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultLockoutWindow is how long failed inits count towards a ban
+	// (LockoutConfig.Window)
+	DefaultLockoutWindow = 10 * time.Minute
+	// DefaultLockoutBanTime is how long an address stays banned
+	// (LockoutConfig.BanTime)
+	DefaultLockoutBanTime = 15 * time.Minute
+)
+
+// LockoutConfig bans addresses that keep failing init, to slow down
+// password guessing. IPv6 clients are grouped by /64, which a single host
+// usually has to itself; unix socket clients are never banned.
+type LockoutConfig struct {
+	MaxFailures int           // Failed inits within Window that ban an address (0 = off)
+	Window      time.Duration // Default DefaultLockoutWindow
+	BanTime     time.Duration // Default DefaultLockoutBanTime
+}
+
+// lockout tracks failed inits by address
+type lockout struct {
+	cfg LockoutConfig
+
+	mu    sync.Mutex
+	hosts map[netip.Prefix]*lockoutHost
+}
+
+// lockoutHost is the failure record of one address
+type lockoutHost struct {
+	failures    []time.Time // Within the window, oldest first
+	bannedUntil time.Time
+}
+
+// newLockout returns the lockout of cfg, nil when it is off
+func newLockout(cfg LockoutConfig) *lockout {
+	if cfg.MaxFailures <= 0 {
+		return nil
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultLockoutWindow
+	}
+	if cfg.BanTime <= 0 {
+		cfg.BanTime = DefaultLockoutBanTime
+	}
+	return &lockout{cfg: cfg, hosts: make(map[netip.Prefix]*lockoutHost)}
+}
+
+// lockoutKey returns the network a client address ("ip:port" or "ip") is
+// tracked as, false for addresses that aren't IPs
+func lockoutKey(remote string) (netip.Prefix, bool) {
+	host := remote
+	if h, _, err := net.SplitHostPort(remote); err == nil {
+		host = h
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Prefix{}, false
+	}
+	addr = addr.Unmap().WithZone("")
+	if addr.Is6() {
+		prefix, _ := addr.Prefix(64)
+		return prefix, true
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), true
+}
+
+// banned returns how long a client address stays banned, 0 if it isn't
+func (l *lockout) banned(remote string) time.Duration {
+	key, ok := lockoutKey(remote)
+	if l == nil || !ok {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if host := l.hosts[key]; host != nil {
+		return max(0, time.Until(host.bannedUntil))
+	}
+	return 0
+}
+
+// fail records a failed init and reports whether it banned the address
+func (l *lockout) fail(remote string) bool {
+	key, ok := lockoutKey(remote)
+	if l == nil || !ok {
+		return false
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.pruneLocked(now)
+
+	host := l.hosts[key]
+	if host == nil {
+		host = &lockoutHost{}
+		l.hosts[key] = host
+	}
+	host.failures = append(host.failures, now)
+	if len(host.failures) < l.cfg.MaxFailures {
+		return false
+	}
+	host.failures = nil
+	host.bannedUntil = now.Add(l.cfg.BanTime)
+	return true
+}
+
+// succeed forgets the failures of an address that authenticated
+func (l *lockout) succeed(remote string) {
+	key, ok := lockoutKey(remote)
+	if l == nil || !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if host := l.hosts[key]; host != nil && !time.Now().Before(host.bannedUntil) {
+		delete(l.hosts, key)
+	}
+}
+
+// pruneLocked drops failures older than the window and expired bans.
+// Caller must hold mu.
+func (l *lockout) pruneLocked(now time.Time) {
+	cutoff := now.Add(-l.cfg.Window)
+	for key, host := range l.hosts {
+		i := 0
+		for i < len(host.failures) && host.failures[i].Before(cutoff) {
+			i++
+		}
+		host.failures = host.failures[i:]
+		if len(host.failures) == 0 && !now.Before(host.bannedUntil) {
+			delete(l.hosts, key)
+		}
+	}
+}
+
+// authFailed counts a failed init of a client, banning its address once
+// it failed too often
+func (s *Server) authFailed(client *Client) {
+	s.stats.authFailures.Add(1)
+	if s.lockout.fail(client.remote) {
+		s.stats.addressesBanned.Add(1)
+		client.log.Warnf("Banning %s for %s after %d failed logins", client.remote, s.lockout.cfg.BanTime, s.lockout.cfg.MaxFailures)
+	}
+}
+
+// bannedReason returns why a client address may not connect, "" if it may
+func (s *Server) bannedReason(remote string) string {
+	if left := s.lockout.banned(remote); left > 0 {
+		return fmt.Sprintf("banned for %s after failed logins", left.Round(time.Second))
+	}
+	return ""
+}
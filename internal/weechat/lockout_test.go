@@ -0,0 +1,116 @@
+package weechat
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockoutBans(t *testing.T) {
+	l := newLockout(LockoutConfig{MaxFailures: 3})
+
+	for i := 1; i <= 2; i++ {
+		if l.fail("192.0.2.1:4000") {
+			t.Fatalf("banned after %d failures", i)
+		}
+	}
+	if l.banned("192.0.2.1:4001") > 0 {
+		t.Fatalf("banned before the last failure")
+	}
+	if !l.fail("192.0.2.1:4002") {
+		t.Fatalf("not banned after 3 failures")
+	}
+
+	tests := []struct {
+		remote string
+		banned bool
+	}{
+		{"192.0.2.1:5000", true},
+		{"192.0.2.1", true},
+		{"[::ffff:192.0.2.1]:5000", true},
+		{"192.0.2.2:5000", false},
+	}
+	for _, tt := range tests {
+		if got := l.banned(tt.remote) > 0; got != tt.banned {
+			t.Errorf("%s banned: got %v, want %v", tt.remote, got, tt.banned)
+		}
+	}
+
+	// A login from the banned address during the ban doesn't lift it
+	l.succeed("192.0.2.1:5000")
+	if l.banned("192.0.2.1:5000") == 0 {
+		t.Errorf("ban lifted by a login")
+	}
+}
+
+func TestLockoutGroupsIPv6By64(t *testing.T) {
+	l := newLockout(LockoutConfig{MaxFailures: 2})
+
+	l.fail("[2001:db8:1:2::1]:4000")
+	if !l.fail("[2001:db8:1:2:ffff::9]:4000") {
+		t.Fatalf("failures within one /64 didn't add up")
+	}
+
+	tests := []struct {
+		remote string
+		banned bool
+	}{
+		{"[2001:db8:1:2::abcd]:5000", true},
+		{"[2001:db8:1:2::1%eth0]:5000", true},
+		{"[2001:db8:1:3::1]:5000", false},
+	}
+	for _, tt := range tests {
+		if got := l.banned(tt.remote) > 0; got != tt.banned {
+			t.Errorf("%s banned: got %v, want %v", tt.remote, got, tt.banned)
+		}
+	}
+}
+
+func TestLockoutSkipsUnixSockets(t *testing.T) {
+	l := newLockout(LockoutConfig{MaxFailures: 1})
+
+	for _, remote := range []string{"", "@", "/run/bridge.sock"} {
+		if l.fail(remote) {
+			t.Errorf("unix socket client %q banned", remote)
+		}
+		if l.banned(remote) > 0 {
+			t.Errorf("unix socket client %q reported banned", remote)
+		}
+	}
+}
+
+func TestLockoutExpires(t *testing.T) {
+	l := newLockout(LockoutConfig{MaxFailures: 2, Window: 30 * time.Millisecond, BanTime: 30 * time.Millisecond})
+
+	// Failures outside the window don't add up
+	l.fail("192.0.2.1:4000")
+	time.Sleep(50 * time.Millisecond)
+	if l.fail("192.0.2.1:4000") {
+		t.Fatalf("failure from before the window counted")
+	}
+
+	if !l.fail("192.0.2.1:4000") {
+		t.Fatalf("not banned")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if left := l.banned("192.0.2.1:4000"); left > 0 {
+		t.Errorf("still banned for %s after the ban time", left)
+	}
+
+	// A login clears the failures once the ban is over
+	l.fail("192.0.2.1:4000")
+	l.succeed("192.0.2.1:4000")
+	if l.fail("192.0.2.1:4000") {
+		t.Errorf("failures from before a login counted")
+	}
+}
+
+func TestLockoutOff(t *testing.T) {
+	l := newLockout(LockoutConfig{})
+	if l != nil {
+		t.Fatalf("lockout without MaxFailures is on")
+	}
+	if l.fail("192.0.2.1:4000") || l.banned("192.0.2.1:4000") > 0 {
+		t.Errorf("disabled lockout banned")
+	}
+	l.succeed("192.0.2.1:4000")
+}
@@ -0,0 +1,107 @@
+package weechat
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"erssi-lith-bridge/internal/clock"
+)
+
+// DefaultNonceTTL is how long after the handshake its nonce can salt an
+// init password hash
+const DefaultNonceTTL = 30 * time.Second
+
+// nonceAttempts bounds the retries when the generator hands out a nonce
+// still on record
+const nonceAttempts = 8
+
+var (
+	errNonceUnknown = errors.New("unknown or expired handshake nonce")
+	errNonceUsed    = errors.New("handshake nonce already used")
+	errNonceExpired = errors.New("handshake nonce expired")
+)
+
+// nonceEntry is a nonce on record
+type nonceEntry struct {
+	issued time.Time
+	used   bool
+}
+
+// nonceRegistry hands out handshake nonces and accepts each one once,
+// within its TTL. Used nonces stay on record until they would have expired,
+// so a password hash captured on one connection can't be replayed on
+// another even if the generator repeats itself.
+type nonceRegistry struct {
+	gen clock.IDGenerator
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]nonceEntry
+}
+
+func newNonceRegistry(gen clock.IDGenerator, ttl time.Duration) *nonceRegistry {
+	return &nonceRegistry{gen: gen, ttl: ttl, entries: make(map[string]nonceEntry)}
+}
+
+// issue returns a new nonce, one not on record
+func (r *nonceRegistry) issue() (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	r.prune(now)
+
+	for i := 0; i < nonceAttempts; i++ {
+		nonce := r.gen.NewID()
+		if _, ok := r.entries[nonce]; !ok {
+			r.entries[nonce] = nonceEntry{issued: now}
+			return nonce, nil
+		}
+	}
+	return "", errors.New("nonce generator keeps repeating nonces")
+}
+
+// consume uses up a nonce. It fails if the nonce wasn't issued, was used
+// already or is older than the TTL.
+func (r *nonceRegistry) consume(nonce string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.entries[nonce]
+	switch {
+	case !ok:
+		return errNonceUnknown
+	case entry.used:
+		return errNonceUsed
+	}
+
+	entry.used = true
+	r.entries[nonce] = entry
+	if time.Since(entry.issued) > r.ttl {
+		return errNonceExpired
+	}
+	return nil
+}
+
+// revoke uses up a nonce without checking it, e.g. when the client makes a
+// new handshake
+func (r *nonceRegistry) revoke(nonce string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.entries[nonce]; ok {
+		entry.used = true
+		r.entries[nonce] = entry
+	}
+}
+
+// prune forgets the nonces past their TTL, which no init can use anymore.
+// Caller must hold mu.
+func (r *nonceRegistry) prune(now time.Time) {
+	for nonce, entry := range r.entries {
+		if now.Sub(entry.issued) > r.ttl {
+			delete(r.entries, nonce)
+		}
+	}
+}
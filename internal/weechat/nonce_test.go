@@ -0,0 +1,74 @@
+package weechat
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"erssi-lith-bridge/internal/clock"
+)
+
+// repeatingIDs hands out the same ID every time
+type repeatingIDs struct{}
+
+func (repeatingIDs) NewID() string { return "a1b2c3d4" }
+
+func TestNonceUsedOnce(t *testing.T) {
+	r := newNonceRegistry(clock.NewPointers(1), time.Minute)
+
+	nonce, err := r.issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := r.issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce == other {
+		t.Fatalf("two handshakes got nonce %s", nonce)
+	}
+
+	if err := r.consume(nonce); err != nil {
+		t.Fatalf("first init: %v", err)
+	}
+	if err := r.consume(nonce); !errors.Is(err, errNonceUsed) {
+		t.Errorf("replayed nonce: got %v, want %v", err, errNonceUsed)
+	}
+	if err := r.consume("0xdeadbeef"); !errors.Is(err, errNonceUnknown) {
+		t.Errorf("unknown nonce: got %v, want %v", err, errNonceUnknown)
+	}
+
+	r.revoke(other)
+	if err := r.consume(other); !errors.Is(err, errNonceUsed) {
+		t.Errorf("nonce of a repeated handshake: got %v, want %v", err, errNonceUsed)
+	}
+}
+
+func TestNonceExpires(t *testing.T) {
+	r := newNonceRegistry(clock.NewPointers(1), 20*time.Millisecond)
+
+	nonce, err := r.issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if err := r.consume(nonce); !errors.Is(err, errNonceExpired) {
+		t.Errorf("late init: got %v, want %v", err, errNonceExpired)
+	}
+}
+
+func TestNonceNotReissued(t *testing.T) {
+	r := newNonceRegistry(repeatingIDs{}, time.Minute)
+
+	nonce, err := r.issue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.consume(nonce); err != nil {
+		t.Fatal(err)
+	}
+	// A used nonce stays on record, so a replay can't get it issued again
+	if again, err := r.issue(); err == nil {
+		t.Errorf("nonce %s issued twice", again)
+	}
+}
@@ -0,0 +1,63 @@
+package weechat
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Options are the comma-separated key=value options of handshake and init
+// ("password=secret,compression=off")
+type Options map[string]string
+
+// secretOptions are the init options kept from the bridge and the logs
+var secretOptions = []string{"password", "password_hash", "totp"}
+
+// ParseOptions parses the options of handshake or init, given the raw text
+// after the command. Like WeeChat, a backslash before a comma makes it part
+// of the value ("password=a\,b" is "a,b"). Values are kept as sent, spaces
+// included.
+func ParseOptions(text string) Options {
+	options := make(Options)
+	if text == "" {
+		return options
+	}
+
+	var option strings.Builder
+	add := func() {
+		key, value, _ := strings.Cut(option.String(), "=")
+		if key = strings.TrimSpace(key); key != "" {
+			options[key] = value
+		}
+		option.Reset()
+	}
+	for i := 0; i < len(text); i++ {
+		switch {
+		case text[i] == '\\' && i+1 < len(text) && text[i+1] == ',':
+			option.WriteByte(',')
+			i++
+		case text[i] == ',':
+			add()
+		default:
+			option.WriteByte(text[i])
+		}
+	}
+	add()
+	return options
+}
+
+// public returns the options without the secrets
+func (o Options) public() Options {
+	public := make(Options, len(o))
+	for key, value := range o {
+		public[key] = value
+	}
+	for _, key := range secretOptions {
+		delete(public, key)
+	}
+	return public
+}
+
+// commandText returns the raw text after the command name of a line
+func commandText(line, cmd string) string {
+	return strings.TrimLeftFunc(strings.TrimPrefix(line, cmd), unicode.IsSpace)
+}
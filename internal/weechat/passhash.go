@@ -0,0 +1,140 @@
+package weechat
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// HashAuthenticator is an Authenticator that knows the plaintext secret and
+// can therefore check the hashed passwords of relay protocol 2.9
+// (password_hash in init). Authenticators without it only offer plain.
+type HashAuthenticator interface {
+	Authenticator
+
+	// AuthenticateHash calls verify with the secret and returns the
+	// account when it reports a match
+	AuthenticateHash(ctx context.Context, verify func(secret string) bool, remote string) (account string, err error)
+}
+
+// Password hash algorithms of the handshake, most preferred first
+var passwordHashAlgos = []string{"pbkdf2+sha512", "pbkdf2+sha256", "sha512", "sha256", "plain"}
+
+// DefaultPasswordHashIterations is the PBKDF2 iteration count clients are
+// told to use, WeeChat's default
+const DefaultPasswordHashIterations = 100000
+
+// errBadPasswordHash rejects a malformed or mismatching password_hash
+var errBadPasswordHash = errors.New("invalid password_hash")
+
+// hashAlgos returns the algorithms the server can verify
+func (s *Server) hashAlgos() []string {
+	if s.auth == nil {
+		return passwordHashAlgos
+	}
+	if _, ok := s.auth.(HashAuthenticator); ok {
+		return passwordHashAlgos
+	}
+	return []string{"plain"}
+}
+
+// negotiateHashAlgo picks the strongest of the colon-separated algorithms
+// a client offers that the server supports, "" if there is none. Clients
+// offering nothing get plain, like from WeeChat.
+func (s *Server) negotiateHashAlgo(offered string) string {
+	if offered == "" {
+		offered = "plain"
+	}
+	algos := strings.Split(offered, ":")
+	for _, algo := range s.hashAlgos() {
+		if slices.Contains(algos, algo) {
+			return algo
+		}
+	}
+	return ""
+}
+
+// passwordHash is a parsed password_hash: "algo:salt:hash", or
+// "algo:salt:iterations:hash" for PBKDF2
+type passwordHash struct {
+	newHash    func() hash.Hash
+	iterations int // 0 = plain hash(salt + secret)
+	salt       []byte
+	sum        []byte
+}
+
+// parsePasswordHash parses a password_hash for the negotiated algorithm.
+// The salt is the server nonce followed by a client nonce, both in hex, so
+// a hash captured from one connection is useless on the next. PBKDF2
+// hashes must use the iteration count of the handshake.
+func parsePasswordHash(value, algo, nonce string, iterations int) (*passwordHash, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) < 3 || parts[0] != algo {
+		return nil, errBadPasswordHash
+	}
+
+	p := &passwordHash{}
+	base, pbkdf2Algo := strings.CutPrefix(algo, "pbkdf2+")
+	switch base {
+	case "sha256":
+		p.newHash = sha256.New
+	case "sha512":
+		p.newHash = sha512.New
+	default:
+		return nil, errBadPasswordHash
+	}
+
+	if pbkdf2Algo {
+		if len(parts) != 4 {
+			return nil, errBadPasswordHash
+		}
+		n, err := strconv.Atoi(parts[2])
+		if err != nil || n != iterations {
+			return nil, errBadPasswordHash
+		}
+		p.iterations = n
+		parts = []string{parts[0], parts[1], parts[3]}
+	} else if len(parts) != 3 {
+		return nil, errBadPasswordHash
+	}
+
+	saltHex := parts[1]
+	if nonce == "" || len(saltHex) <= len(nonce) || !strings.EqualFold(saltHex[:len(nonce)], nonce) {
+		return nil, errBadPasswordHash
+	}
+	var err error
+	if p.salt, err = hex.DecodeString(saltHex); err != nil {
+		return nil, errBadPasswordHash
+	}
+	if p.sum, err = hex.DecodeString(parts[2]); err != nil {
+		return nil, errBadPasswordHash
+	}
+	return p, nil
+}
+
+// matches reports whether the hash was computed from the secret:
+// hash(salt + secret), or PBKDF2(secret, salt, iterations)
+func (p *passwordHash) matches(secret string) bool {
+	var sum []byte
+	if p.iterations > 0 {
+		key, err := pbkdf2.Key(p.newHash, secret, p.salt, p.iterations, p.newHash().Size())
+		if err != nil {
+			return false
+		}
+		sum = key
+	} else {
+		h := p.newHash()
+		h.Write(p.salt)
+		h.Write([]byte(secret))
+		sum = h.Sum(nil)
+	}
+	return subtle.ConstantTimeCompare(sum, p.sum) == 1
+}
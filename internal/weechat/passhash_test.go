@@ -0,0 +1,122 @@
+package weechat
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"testing"
+)
+
+// plainAuth only checks plaintext passwords
+type plainAuth struct{}
+
+func (plainAuth) Authenticate(ctx context.Context, password, remote string) (string, error) {
+	return "", nil
+}
+
+// hashAuth knows the secret, so it checks hashed passwords too
+type hashAuth struct{ plainAuth }
+
+func (hashAuth) AuthenticateHash(ctx context.Context, verify func(secret string) bool, remote string) (string, error) {
+	return "", nil
+}
+
+func TestNegotiateHashAlgo(t *testing.T) {
+	tests := []struct {
+		auth    Authenticator
+		offered string
+		want    string
+	}{
+		{hashAuth{}, "plain:sha256:sha512:pbkdf2+sha256:pbkdf2+sha512", "pbkdf2+sha512"},
+		{hashAuth{}, "plain:sha256:pbkdf2+sha256", "pbkdf2+sha256"},
+		{hashAuth{}, "sha256:sha512", "sha512"},
+		{hashAuth{}, "plain", "plain"},
+		{hashAuth{}, "", "plain"},
+		{hashAuth{}, "md5:sha1", ""},
+		{nil, "sha256:plain", "sha256"},
+		{plainAuth{}, "plain:pbkdf2+sha512", "plain"},
+		{plainAuth{}, "pbkdf2+sha512", ""},
+	}
+	for _, tt := range tests {
+		s := &Server{auth: tt.auth}
+		if got := s.negotiateHashAlgo(tt.offered); got != tt.want {
+			t.Errorf("%T offered %q: got %q, want %q", tt.auth, tt.offered, got, tt.want)
+		}
+	}
+}
+
+// clientHash computes a password_hash like a relay client, with the server
+// nonce followed by a client nonce as salt
+func clientHash(algo, nonce, secret string, iterations int) string {
+	salt, _ := hex.DecodeString(nonce + "c11e47")
+	newHash := map[string]func() hash.Hash{
+		"sha256": sha256.New, "sha512": sha512.New,
+		"pbkdf2+sha256": sha256.New, "pbkdf2+sha512": sha512.New,
+	}[algo]
+
+	if algo == "sha256" || algo == "sha512" {
+		h := newHash()
+		h.Write(salt)
+		h.Write([]byte(secret))
+		return fmt.Sprintf("%s:%x:%x", algo, salt, h.Sum(nil))
+	}
+	key, _ := pbkdf2.Key(newHash, secret, salt, iterations, newHash().Size())
+	return fmt.Sprintf("%s:%x:%d:%x", algo, salt, iterations, key)
+}
+
+func TestPasswordHash(t *testing.T) {
+	const (
+		nonce      = "a1b2c3d4e5f60718"
+		iterations = 1000
+	)
+
+	for _, algo := range []string{"sha256", "sha512", "pbkdf2+sha256", "pbkdf2+sha512"} {
+		p, err := parsePasswordHash(clientHash(algo, nonce, "secret", iterations), algo, nonce, iterations)
+		if err != nil {
+			t.Errorf("%s: %v", algo, err)
+			continue
+		}
+		if !p.matches("secret") {
+			t.Errorf("%s: right password rejected", algo)
+		}
+		if p.matches("wrong") || p.matches("") {
+			t.Errorf("%s: wrong password accepted", algo)
+		}
+	}
+}
+
+func TestPasswordHashRejected(t *testing.T) {
+	const (
+		nonce      = "a1b2c3d4e5f60718"
+		iterations = 1000
+	)
+	valid := clientHash("sha256", nonce, "secret", 0)
+
+	tests := []struct {
+		name  string
+		value string
+		algo  string
+		nonce string
+	}{
+		{"other algorithm than negotiated", valid, "sha512", nonce},
+		{"unsupported algorithm", "md5:" + nonce + "00:00", "md5", nonce},
+		{"salt of another handshake", valid, "sha256", "0102030405060708"},
+		{"salt is only the nonce", "sha256:" + nonce + ":00", "sha256", nonce},
+		{"no nonce issued", valid, "sha256", ""},
+		{"salt not hex", "sha256:" + nonce + "zz:00", "sha256", nonce},
+		{"hash not hex", "sha256:" + nonce + "00:zz", "sha256", nonce},
+		{"missing hash", "sha256:" + nonce + "00", "sha256", nonce},
+		{"iterations in a plain hash", "sha256:" + nonce + "00:1000:00", "sha256", nonce},
+		{"other iteration count", clientHash("pbkdf2+sha256", nonce, "secret", 10), "pbkdf2+sha256", nonce},
+		{"pbkdf2 without iterations", "pbkdf2+sha256:" + nonce + "00:00", "pbkdf2+sha256", nonce},
+	}
+	for _, tt := range tests {
+		if _, err := parsePasswordHash(tt.value, tt.algo, tt.nonce, iterations); err == nil {
+			t.Errorf("%s: accepted %q", tt.name, tt.value)
+		}
+	}
+}
@@ -2,23 +2,67 @@ package weechat
 
 import (
 	"bufio"
-	"crypto/rand"
-	"encoding/hex"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"erssi-lith-bridge/internal/chaos"
+	"erssi-lith-bridge/internal/clock"
 	"erssi-lith-bridge/pkg/weechatproto"
 
+	"github.com/gorilla/websocket"
 	"github.com/sirupsen/logrus"
 )
 
 // Server implements WeeChat relay protocol server
 type Server struct {
-	addr     string
-	listener net.Listener
-	log      *logrus.Entry
+	addr             string
+	mode             ProtocolMode
+	requireHandshake bool
+	auth             Authenticator
+	hashIterations   int
+	totpSecret       []byte
+	nonces           *nonceRegistry
+	listener         net.Listener
+	listen           ListenFunc
+	systemListen     bool // listen is net.Listen
+	socketMode       os.FileMode
+	chaos            chaos.Config
+	clientBandwidth  int64
+	compressMinSize  int
+	writeQueueSize   int
+	writeTimeout     time.Duration
+	maxClients       int
+	access           AccessList
+	idleTimeout      time.Duration
+	pingInterval     time.Duration
+	lockout          *lockout // nil = off
+	log              *logrus.Entry
+
+	// Websocket relay (optional)
+	wsAddr     string
+	wsPath     string
+	wsAuth     HTTPAuth
+	wsOrigins  []string
+	wsListener net.Listener
+	wsServer   *http.Server
+	upgrader   websocket.Upgrader
+
+	// TLS for the relay listeners (nil = plain TCP), see ACMEConfig
+	acme       ACMEConfig
+	tlsConfig  *tls.Config
+	acmeServer *http.Server
 
 	// Client management
 	clients   map[*Client]*Client
@@ -28,31 +72,214 @@ type Server struct {
 	onCommand    func(*Client, string, string, []string) // client, msgID, command, args
 	onClientConn func(*Client)
 	onClientDisc func(*Client)
+	onBroadcast  func(*weechatproto.Message, int) // message, clients it went to
 
-	done chan struct{}
+	stats serverStats
+	done  chan struct{}
 }
 
+// commandQueueSize is how many received commands of a client may wait while
+// an earlier one is handled before reading pauses
+const commandQueueSize = 64
+
+// ProtocolMode controls how the server reacts to malformed commands
+type ProtocolMode string
+
+const (
+	// ProtocolLenient silently ignores malformed commands (like WeeChat does)
+	ProtocolLenient ProtocolMode = "lenient"
+	// ProtocolStrict answers malformed commands with an error message
+	ProtocolStrict ProtocolMode = "strict"
+)
+
+// ParseProtocolMode parses a protocol mode name (empty means lenient)
+func ParseProtocolMode(s string) (ProtocolMode, error) {
+	switch ProtocolMode(strings.ToLower(s)) {
+	case "", ProtocolLenient:
+		return ProtocolLenient, nil
+	case ProtocolStrict:
+		return ProtocolStrict, nil
+	default:
+		return "", fmt.Errorf("unknown protocol mode %q (want strict or lenient)", s)
+	}
+}
+
+// Authenticator verifies the password a client sends with init and returns
+// its account (see package relayauth)
+type Authenticator interface {
+	Authenticate(ctx context.Context, password, remote string) (account string, err error)
+}
+
+// authTimeout bounds a password check, e.g. by an external verifier
+const authTimeout = 15 * time.Second
+
+// initTimeout is how long a new client has to authenticate before it is
+// disconnected, like WeeChat's relay.network.auth_timeout
+const initTimeout = 60 * time.Second
+
+// ListenFunc opens a listener, like net.Listen
+type ListenFunc func(network, address string) (net.Listener, error)
+
 // Config holds server configuration
 type Config struct {
+	// Address is a comma-separated list of listen addresses, each
+	// optionally prefixed with tcp4: or tcp6:, or unix socket paths
+	// ("unix:///run/bridge.sock"), see ParseListenSpecs
 	Address string
 	Logger  *logrus.Logger
+
+	// Listen opens the relay listeners (default net.Listen). Lets the relay
+	// live on another network stack, e.g. an embedded tailnet node.
+	Listen ListenFunc
+
+	// SocketMode is the permission of unix socket listeners (default 0660)
+	SocketMode os.FileMode
+
+	// Mode selects strict or lenient handling of malformed commands
+	Mode ProtocolMode
+
+	// Auth verifies the init password (nil = every client is accepted)
+	Auth Authenticator
+
+	// PasswordHashIterations is the PBKDF2 iteration count of hashed
+	// passwords (default DefaultPasswordHashIterations)
+	PasswordHashIterations int
+
+	// TOTPSecret enables a time-based one-time password as second factor:
+	// clients must send the current code as totp in init (see
+	// ParseTOTPSecret). Nil = password only.
+	TOTPSecret []byte
+
+	// RequireHandshake rejects init from clients that didn't negotiate
+	// with the handshake command first. Pre-2.9 clients go straight to init
+	// with a plaintext password; enable this when hashed auth is mandated.
+	RequireHandshake bool
+
+	// WebSocketAddr enables a websocket relay listener (e.g. for browser
+	// clients or behind a reverse proxy) serving the relay protocol at
+	// WebSocketPath. An address may carry the path itself (":9001/weechat").
+	WebSocketAddr string
+	WebSocketPath string   // URL path of the relay (default "/weechat")
+	WebSocketAuth HTTPAuth // Optional Authorization header check
+
+	// WebSocketOrigins are the Origins browser clients (Glowing Bear) may
+	// connect from: "*", "https://host" or a host pattern with * wildcards.
+	// Empty = same origin only. Requests without Origin (native clients)
+	// are always accepted.
+	WebSocketOrigins []string
+
+	// ACME serves the relay listeners over TLS with certificates from
+	// Let's Encrypt (disabled without domains)
+	ACME ACMEConfig
+
+	// ClientBandwidth caps what each client is sent, in bytes per second
+	// (0 = unlimited). Replies such as backlog are paced; live lines are
+	// never delayed but count against the cap.
+	ClientBandwidth int64
+
+	// CompressionMinSize is the smallest message, in bytes, compressed for
+	// clients that negotiated compression (0 = all of them)
+	CompressionMinSize int
+
+	// Each client has its own writer. WriteQueueSize messages may wait for
+	// it (default DefaultWriteQueueSize); a client staying further behind
+	// is disconnected, and so is one whose write takes longer than
+	// WriteTimeout (default DefaultWriteTimeout).
+	WriteQueueSize int
+	WriteTimeout   time.Duration
+
+	// MaxClients limits the connected clients, authenticated or not
+	// (0 = unlimited), and Access the addresses they may connect from.
+	// Connections beyond either are closed right after accept. Websocket
+	// clients are checked by the address of their peer, which is the
+	// reverse proxy if there is one.
+	MaxClients int
+	Access     AccessList
+
+	// IdleTimeout disconnects authenticated clients that send nothing for
+	// this long (0 = never). Clients such as Lith ping while idle.
+	// PingInterval enables server-side liveness checks, see watchLiveness
+	// (0 = off, DefaultPingInterval is a good value).
+	IdleTimeout  time.Duration
+	PingInterval time.Duration
+
+	// Lockout bans addresses that keep failing init (off by default)
+	Lockout LockoutConfig
+
+	// Nonces generates handshake nonces (default 16 random bytes in hex);
+	// replace it for deterministic output
+	Nonces clock.IDGenerator
+
+	// NonceTTL is how long a handshake nonce can be used for init (default
+	// DefaultNonceTTL). Each nonce is accepted once.
+	NonceTTL time.Duration
+
+	// Chaos injects latency, drops and reordering into client commands
+	// (testing only)
+	Chaos chaos.Config
 }
 
 // Client represents a connected Lith client
 type Client struct {
-	conn   net.Conn
-	server *Server
-	log    *logrus.Entry
+	conn      net.Conn
+	server    *Server
+	log       *logrus.Entry
+	remote    string
+	webSocket bool
+	connected time.Time
 
 	// Session state
 	authenticated bool
+	handshaked    bool // Client sent handshake before init (relay protocol >= 2.9)
 	nonce         string
+	hashAlgo      string // Negotiated password_hash_algo ("" = no handshake)
+	account       string // Set by the Authenticator ("" for a shared password)
+
+	// Negotiated in the handshake: compression of the frames after it, and
+	// whether commands are backslash-escaped (escape_commands=on)
+	compression    weechatproto.Compression
+	escapeCommands bool
+
+	initOptions Options // Options of init, without the secrets
+
+	// What the client synced, by buffer pointer or "*", see sync.go
+	syncMu sync.Mutex
+	syncs  map[string]SyncFlags
+
+	// Messages waiting for the writer goroutine, see writer.go. closed is
+	// closed when the client disconnects.
+	queue   chan outgoing
+	closed  chan struct{}
+	slow    atomic.Bool // Disconnected for falling behind
+	dropped atomic.Int64
+
+	// Liveness: whether the idle deadline applies (set by init), and when
+	// the last websocket pong came, see liveness.go
+	idleWatch atomic.Bool
+	lastPong  atomic.Int64
 
 	// Writer for sending messages
 	encoder *weechatproto.Encoder
 	mu      sync.Mutex
+	broken  bool // Set once a send failed; the stream can't be trusted anymore
+
+	// Traffic counters, and the bandwidth cap (nil = unlimited)
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+	messagesSent  atomic.Int64
+	throttled     atomic.Int64 // Nanoseconds
+	limiter       *bandwidthLimiter
+
+	// Compression counters: messages sent compressed, and the size of the
+	// messages before and after compression
+	messagesCompressed atomic.Int64
+	messageBytes       atomic.Int64
+	messageBytesSent   atomic.Int64
 }
 
+// ErrClientClosed is returned when sending to a client whose stream was torn down
+var ErrClientClosed = errors.New("client connection closed")
+
 // NewServer creates a new WeeChat protocol server
 func NewServer(cfg Config) *Server {
 	logger := cfg.Logger
@@ -60,15 +287,88 @@ func NewServer(cfg Config) *Server {
 		logger = logrus.New()
 	}
 
+	mode := cfg.Mode
+	if mode == "" {
+		mode = ProtocolLenient
+	}
+
+	listen := cfg.Listen
+	systemListen := listen == nil
+	if systemListen {
+		listen = net.Listen
+	}
+
+	wsPath := cfg.WebSocketPath
+	if wsPath == "" {
+		wsPath = "/weechat"
+	}
+	if !strings.HasPrefix(wsPath, "/") {
+		wsPath = "/" + wsPath
+	}
+
+	socketMode := cfg.SocketMode
+	if socketMode == 0 {
+		socketMode = defaultSocketMode
+	}
+
+	hashIterations := cfg.PasswordHashIterations
+	if hashIterations <= 0 {
+		hashIterations = DefaultPasswordHashIterations
+	}
+
+	nonces := cfg.Nonces
+	if nonces == nil {
+		nonces = clock.Random{Bytes: 16}
+	}
+	nonceTTL := cfg.NonceTTL
+	if nonceTTL <= 0 {
+		nonceTTL = DefaultNonceTTL
+	}
+
+	writeQueueSize := cfg.WriteQueueSize
+	if writeQueueSize <= 0 {
+		writeQueueSize = DefaultWriteQueueSize
+	}
+	writeTimeout := cfg.WriteTimeout
+	if writeTimeout <= 0 {
+		writeTimeout = DefaultWriteTimeout
+	}
+
 	return &Server{
-		addr:    cfg.Address,
-		log:     logger.WithField("component", "weechat-server"),
-		clients: make(map[*Client]*Client),
-		done:    make(chan struct{}),
+		addr:             cfg.Address,
+		nonces:           newNonceRegistry(nonces, nonceTTL),
+		mode:             mode,
+		requireHandshake: cfg.RequireHandshake,
+		auth:             cfg.Auth,
+		hashIterations:   hashIterations,
+		totpSecret:       cfg.TOTPSecret,
+		listen:           listen,
+		systemListen:     systemListen,
+		socketMode:       socketMode,
+		chaos:            cfg.Chaos,
+		clientBandwidth:  cfg.ClientBandwidth,
+		compressMinSize:  cfg.CompressionMinSize,
+		writeQueueSize:   writeQueueSize,
+		writeTimeout:     writeTimeout,
+		maxClients:       cfg.MaxClients,
+		access:           cfg.Access,
+		idleTimeout:      cfg.IdleTimeout,
+		pingInterval:     cfg.PingInterval,
+		lockout:          newLockout(cfg.Lockout),
+		wsAddr:           cfg.WebSocketAddr,
+		wsPath:           wsPath,
+		wsAuth:           cfg.WebSocketAuth,
+		wsOrigins:        cfg.WebSocketOrigins,
+		acme:             cfg.ACME,
+		log:              logger.WithField("component", "weechat-server"),
+		clients:          make(map[*Client]*Client),
+		done:             make(chan struct{}),
 	}
 }
 
-// OnCommand sets the command handler
+// OnCommand sets the command handler. A client's commands are handled one
+// at a time in the order they arrived; the handler may block to hold back
+// later replies.
 func (s *Server) OnCommand(handler func(*Client, string, string, []string)) {
 	s.onCommand = handler
 }
@@ -83,21 +383,56 @@ func (s *Server) OnClientDisconnected(handler func(*Client)) {
 	s.onClientDisc = handler
 }
 
+// OnBroadcast sets the handler called after each broadcast with the number
+// of clients the message was sent to
+func (s *Server) OnBroadcast(handler func(*weechatproto.Message, int)) {
+	s.onBroadcast = handler
+}
+
 // Start starts the server
 func (s *Server) Start() error {
-	listener, err := net.Listen("tcp", s.addr)
+	if s.acme.enabled() {
+		if err := s.startACME(); err != nil {
+			return err
+		}
+	}
+
+	listener, err := s.listenSpecs(s.addr)
 	if err != nil {
+		s.closeACME()
 		return fmt.Errorf("failed to listen: %w", err)
 	}
+	addrs := formatAddrs(listenerAddrs(listener))
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+		addrs += " (TLS)"
+	}
 
 	s.listener = listener
-	s.log.Infof("WeeChat protocol server listening on %s", s.addr)
+	s.log.Infof("WeeChat protocol server listening on %s", addrs)
+
+	if s.wsAddr != "" {
+		if err := s.startWebSocket(); err != nil {
+			listener.Close()
+			s.closeACME()
+			return err
+		}
+	}
 
 	go s.acceptLoop()
 
 	return nil
 }
 
+// Addr returns the (first) address the server is listening on (nil before
+// Start)
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
 // acceptLoop accepts new client connections
 func (s *Server) acceptLoop() {
 	for {
@@ -112,26 +447,53 @@ func (s *Server) acceptLoop() {
 			}
 		}
 
-		s.log.Infof("New client connected from %s", conn.RemoteAddr())
-
-		client := &Client{
-			conn:    conn,
-			server:  s,
-			log:     s.log.WithField("client", conn.RemoteAddr().String()),
-			encoder: weechatproto.NewEncoder(conn),
+		remote, peer := conn.RemoteAddr().String(), conn.RemoteAddr().String()
+		if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+			// Unix socket peers have no address of their own
+			remote, peer = "unix:"+conn.LocalAddr().String(), ""
 		}
+		if reason := s.admit(peer, remote); reason != "" {
+			s.reject(remote, reason)
+			conn.Close()
+			continue
+		}
+		s.log.Infof("New client connected from %s", remote)
+		s.addClient(conn, remote)
+	}
+}
 
-		s.clientsMu.Lock()
-		s.clients[client] = client
-		s.clientsMu.Unlock()
+// addClient registers a connection and starts serving it
+func (s *Server) addClient(conn net.Conn, remote string) {
+	_, webSocket := conn.(*wsConn)
+	client := &Client{
+		conn:      conn,
+		server:    s,
+		log:       s.log.WithField("client", remote),
+		remote:    remote,
+		webSocket: webSocket,
+		connected: time.Now(),
+		queue:     make(chan outgoing, s.writeQueueSize),
+		closed:    make(chan struct{}),
+	}
+	client.encoder = weechatproto.NewEncoder(countingWriter{w: conn, client: &client.bytesSent, total: &s.stats.bytesSent})
+	client.encoder.SetCompressionMinSize(s.compressMinSize)
+	if s.clientBandwidth > 0 {
+		client.limiter = newBandwidthLimiter(s.clientBandwidth)
+	}
 
-		// Notify about new client
-		if s.onClientConn != nil {
-			go s.onClientConn(client)
-		}
+	s.clientsMu.Lock()
+	s.clients[client] = client
+	s.clientsMu.Unlock()
 
-		go s.handleClient(client)
+	go client.writeLoop()
+	s.watchLiveness(client)
+
+	// Notify about new client
+	if s.onClientConn != nil {
+		go s.onClientConn(client)
 	}
+
+	go s.handleClient(client)
 }
 
 // handleClient handles a single client connection
@@ -142,8 +504,10 @@ func (s *Server) handleClient(client *Client) {
 		s.clientsMu.Lock()
 		delete(s.clients, client)
 		s.clientsMu.Unlock()
+		close(client.closed)
 
-		client.log.Info("Client disconnected")
+		client.log.Infof("Client disconnected (sent %d bytes, received %d bytes)",
+			client.bytesSent.Load(), client.bytesReceived.Load())
 
 		// Notify about disconnection
 		if s.onClientDisc != nil {
@@ -151,24 +515,77 @@ func (s *Server) handleClient(client *Client) {
 		}
 	}()
 
-	scanner := bufio.NewScanner(client.conn)
+	// Commands run one after another on their own goroutine, so replies go
+	// out in request order like from a real relay while a slow command
+	// (a buffer list held for the state dump) doesn't stall reading
+	lines := make(chan string, commandQueueSize)
+	stopped := make(chan struct{})
+	go s.runCommands(client, lines, stopped)
+	defer func() {
+		close(lines)
+		<-stopped
+	}()
+
+	// Chaos mode delays, drops or reorders commands like a bad link would
+	chaosInjector := chaos.New(s.chaos)
+	defer chaosInjector.Close()
+
+	// Lifted by a successful init
+	client.conn.SetReadDeadline(time.Now().Add(initTimeout))
+
+	scanner := bufio.NewScanner(countingReader{r: client.conn, client: &client.bytesReceived, total: &s.stats.bytesReceived})
 	for scanner.Scan() {
 		line := scanner.Text()
 		client.log.Debugf("Received command: %s", line)
+		client.touch()
 
-		if err := s.handleCommand(client, line); err != nil {
-			client.log.Errorf("Command error: %v", err)
+		chaosInjector.Apply(func() {
+			select {
+			case lines <- line:
+			case <-stopped:
+			}
+		})
+
+		select {
+		case <-stopped:
 			return
+		default:
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
+	var netErr net.Error
+	if err := scanner.Err(); errors.As(err, &netErr) && netErr.Timeout() {
+		if client.idleWatch.Load() {
+			s.stats.clientsTimedOut.Add(1)
+			client.log.Warnf("Client idle for %s, disconnecting", s.idleTimeout)
+		} else {
+			client.log.Warnf("Client did not authenticate within %s", initTimeout)
+		}
+	} else if err != nil {
 		client.log.Errorf("Scanner error: %v", err)
 	}
 }
 
+// runCommands handles a client's commands in order. A command error ends
+// the connection.
+func (s *Server) runCommands(client *Client, lines <-chan string, stopped chan<- struct{}) {
+	defer close(stopped)
+
+	for line := range lines {
+		if err := s.handleCommand(client, line); err != nil {
+			client.log.Errorf("Command error: %v", err)
+			client.conn.Close()
+			return
+		}
+	}
+}
+
 // handleCommand parses and handles a WeeChat command
 func (s *Server) handleCommand(client *Client, line string) error {
+	if client.escapeCommands {
+		line = unescapeCommand(line)
+	}
+
 	// Parse command: (id) command arguments
 	var msgID string
 	var cmd string
@@ -178,14 +595,15 @@ func (s *Server) handleCommand(client *Client, line string) error {
 	if strings.HasPrefix(line, "(") {
 		endIdx := strings.Index(line, ")")
 		if endIdx == -1 {
-			return fmt.Errorf("malformed message ID")
+			return s.protocolError(client, "", "malformed message ID")
 		}
 		msgID = line[1:endIdx]
-		line = strings.TrimSpace(line[endIdx+1:])
+		line = strings.TrimLeftFunc(line[endIdx+1:], unicode.IsSpace)
 	}
 
-	// Parse command and arguments
-	parts := strings.Fields(line)
+	// Parse command and arguments. New lines only come from escaped
+	// commands and stay in the arguments, for multi-line input.
+	parts := strings.FieldsFunc(line, func(r rune) bool { return r != '\n' && unicode.IsSpace(r) })
 	if len(parts) == 0 {
 		return nil // Empty command
 	}
@@ -195,14 +613,25 @@ func (s *Server) handleCommand(client *Client, line string) error {
 		args = parts[1:]
 	}
 
+	// Options and input are parsed from the raw text, whose spaces and
+	// commas may belong to a password or a message
+	switch cmd {
+	case "handshake":
+		client.log.Debugf("Command: %s, ID: %s", cmd, msgID)
+		return s.handleHandshake(client, msgID, ParseOptions(commandText(line, cmd)))
+	case "init":
+		client.log.Debugf("Command: %s, ID: %s", cmd, msgID)
+		return s.handleInit(client, msgID, ParseOptions(commandText(line, cmd)))
+	case "input":
+		args = inputArgs(commandText(line, cmd))
+	case "completion":
+		args = completionArgs(commandText(line, cmd))
+	}
+
 	client.log.Debugf("Command: %s, ID: %s, Args: %v", cmd, msgID, args)
 
 	// Handle command
 	switch cmd {
-	case "handshake":
-		return s.handleHandshake(client, msgID, args)
-	case "init":
-		return s.handleInit(client, msgID, args)
 	case "hdata":
 		return s.handleHData(client, msgID, args)
 	case "input":
@@ -213,68 +642,298 @@ func (s *Server) handleCommand(client *Client, line string) error {
 		return s.handleDesync(client, msgID, args)
 	case "nicklist":
 		return s.handleNicklist(client, msgID, args)
+	case "info":
+		return s.handleInfo(client, msgID, args)
+	case "infolist":
+		return s.handleInfoList(client, msgID, args)
+	case "test":
+		return s.handleTest(client, msgID)
+	case "ping":
+		return s.handlePing(client, commandText(line, cmd))
+	case "completion":
+		return s.handleCompletion(client, msgID, args)
 	case "quit":
 		return fmt.Errorf("client requested quit")
 	default:
-		client.log.Warnf("Unknown command: %s", cmd)
+		return s.protocolError(client, msgID, "unknown command: %s", cmd)
+	}
+}
+
+// protocolError handles a malformed command according to the server mode.
+// In strict mode the client gets an error message carrying the request ID;
+// in lenient mode the command is dropped with a log entry. The connection is
+// kept open in both cases.
+func (s *Server) protocolError(client *Client, msgID string, format string, args ...interface{}) error {
+	text := fmt.Sprintf(format, args...)
+
+	if s.mode != ProtocolStrict {
+		client.log.Warnf("Ignoring malformed command: %s", text)
+		return nil
+	}
+
+	client.log.Warnf("Rejecting malformed command: %s", text)
+	if err := client.SendMessage(weechatproto.CreateErrorMessage(msgID, text)); err != nil {
+		return err
 	}
 
 	return nil
 }
 
 // handleHandshake handles the handshake command
-func (s *Server) handleHandshake(client *Client, msgID string, args []string) error {
-	// Generate nonce
-	nonceBytes := make([]byte, 16)
-	if _, err := rand.Read(nonceBytes); err != nil {
+func (s *Server) handleHandshake(client *Client, msgID string, options Options) error {
+	// The handshake negotiates authentication, so it only makes sense before init
+	if client.authenticated {
+		return s.protocolError(client, msgID, "handshake: already initialized")
+	}
+
+	// A new handshake voids the nonce of the previous one
+	if client.nonce != "" {
+		s.nonces.revoke(client.nonce)
+	}
+	nonce, err := s.nonces.issue()
+	if err != nil {
 		return err
 	}
-	client.nonce = hex.EncodeToString(nonceBytes)
+	client.nonce = nonce
+	client.hashAlgo = s.negotiateHashAlgo(options["password_hash_algo"])
+	client.handshaked = true
+	client.compression = weechatproto.NegotiateCompression(options["compression"])
+	client.escapeCommands = options["escape_commands"] == "on"
+
+	if client.hashAlgo == "" {
+		client.log.Warnf("No common password hash algorithm (client offers %q)", options["password_hash_algo"])
+	}
 
-	// Send handshake response
-	msg := weechatproto.CreateHandshakeResponse(msgID, "plain", client.nonce)
-	return client.SendMessage(msg)
+	// Send handshake response. It goes out uncompressed; the frames after
+	// it use the negotiated compression.
+	msg := weechatproto.CreateHandshakeResponse(msgID, weechatproto.Handshake{
+		PasswordHashAlgo:       client.hashAlgo,
+		PasswordHashIterations: s.hashIterations,
+		TOTP:                   s.totpSecret != nil,
+		Nonce:                  client.nonce,
+		Compression:            client.compression,
+		EscapeCommands:         client.escapeCommands,
+	})
+	if err := client.SendMessage(msg); err != nil {
+		return err
+	}
+	if client.compression != weechatproto.CompressionOff {
+		client.log.Debugf("Compressing messages with %s", client.compression)
+	}
+	client.setCompression(client.compression)
+	return nil
 }
 
 // handleInit handles authentication
-func (s *Server) handleInit(client *Client, msgID string, args []string) error {
-	// TODO: Verify password
-	// For now, accept all connections
-	client.authenticated = true
+func (s *Server) handleInit(client *Client, msgID string, options Options) error {
+	if client.authenticated {
+		return s.protocolError(client, msgID, "init: already initialized")
+	}
 
-	client.log.Info("Client authenticated")
+	// Old clients (relay protocol < 2.9) skip the handshake and send init
+	// with a plaintext password right away
+	if !client.handshaked {
+		if s.requireHandshake {
+			client.log.Warn("Rejecting init without handshake (handshake required)")
+			if err := client.SendMessage(weechatproto.CreateErrorMessage(msgID, "init: handshake required")); err != nil {
+				return err
+			}
+			return fmt.Errorf("init without handshake")
+		}
+		client.log.Info("Client skipped handshake, using legacy plaintext init")
+	}
+
+	if s.auth != nil {
+		account, err := s.authenticate(client, options)
+		if err != nil {
+			client.log.Warnf("Authentication failed: %v", err)
+			s.authFailed(client)
+			if err := client.SendMessage(weechatproto.CreateErrorMessage(msgID, "init: authentication failed")); err != nil {
+				return err
+			}
+			return fmt.Errorf("authentication failed")
+		}
+		client.account = account
+	}
 
-	// Call command handler to trigger initial state sync
+	// The one-time password is checked after the password, so a wrong
+	// password doesn't reveal whether the code was right
+	if s.totpSecret != nil && !checkTOTP(s.totpSecret, options["totp"], time.Now()) {
+		client.log.Warn("Authentication failed: missing or invalid TOTP code")
+		s.authFailed(client)
+		if err := client.SendMessage(weechatproto.CreateErrorMessage(msgID, "init: authentication failed")); err != nil {
+			return err
+		}
+		return fmt.Errorf("authentication failed")
+	}
+	client.authenticated = true
+	client.initOptions = options.public()
+	s.lockout.succeed(client.remote)
+	client.conn.SetReadDeadline(time.Time{})
+	client.idleWatch.Store(true)
+	client.touch()
+
+	if client.account != "" {
+		client.log.Infof("Client authenticated as %s", client.account)
+	} else {
+		client.log.Info("Client authenticated")
+	}
+
+	// Call command handler to trigger initial state sync. The options are
+	// in InitOptions, without the secrets.
 	if s.onCommand != nil {
-		go s.onCommand(client, msgID, "init", args)
+		s.onCommand(client, msgID, "init", nil)
 	}
 
 	return nil
 }
 
+// authenticate checks the password or password_hash of init against the
+// algorithm negotiated in the handshake (plain without one)
+func (s *Server) authenticate(client *Client, options Options) (string, error) {
+	algo := client.hashAlgo
+	if !client.handshaked {
+		algo = "plain"
+	}
+
+	// The nonce salts a single init, shortly after the handshake
+	nonce := client.nonce
+	client.nonce = ""
+	if algo != "plain" && algo != "" {
+		if err := s.nonces.consume(nonce); err != nil {
+			return "", err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), authTimeout)
+	defer cancel()
+
+	switch algo {
+	case "":
+		return "", fmt.Errorf("no password hash algorithm negotiated")
+	case "plain":
+		if _, ok := options["password_hash"]; ok {
+			return "", fmt.Errorf("password_hash sent, but plain was negotiated")
+		}
+		return s.auth.Authenticate(ctx, options["password"], client.remote)
+	}
+
+	value, ok := options["password_hash"]
+	if !ok {
+		return "", fmt.Errorf("%s was negotiated, but no password_hash sent", algo)
+	}
+	hashed, err := parsePasswordHash(value, algo, nonce, s.hashIterations)
+	if err != nil {
+		return "", err
+	}
+	return s.auth.(HashAuthenticator).AuthenticateHash(ctx, hashed.matches, client.remote)
+}
+
+// unescapeCommand interprets the backslash sequences of a command from a
+// client that negotiated escape_commands (`\n` for a new line, `\t`, `\x41`,
+// `\u00e9`, `\\`, ...). Unknown sequences are kept as is.
+func unescapeCommand(line string) string {
+	if !strings.Contains(line, `\`) {
+		return line
+	}
+
+	var out strings.Builder
+	for len(line) > 0 {
+		if line[0] != '\\' {
+			r, size := utf8.DecodeRuneInString(line)
+			out.WriteRune(r)
+			line = line[size:]
+			continue
+		}
+		if strings.HasPrefix(line, `\e`) {
+			out.WriteByte(0x1b)
+			line = line[2:]
+			continue
+		}
+		r, multibyte, tail, err := strconv.UnquoteChar(line, '"')
+		if err != nil {
+			out.WriteByte('\\')
+			line = line[1:]
+			continue
+		}
+		if multibyte {
+			out.WriteRune(r)
+		} else {
+			out.WriteByte(byte(r))
+		}
+		line = tail
+	}
+	return out.String()
+}
+
+// InitOptions returns the options the client sent with init, except
+// password, password_hash and totp
+func (c *Client) InitOptions() Options {
+	return c.initOptions
+}
+
+// Account returns the account the client authenticated as ("" when the
+// relay has a shared password or none)
+func (c *Client) Account() string {
+	return c.account
+}
+
 // handleHData handles hdata requests
 func (s *Server) handleHData(client *Client, msgID string, args []string) error {
 	if !client.authenticated {
 		return fmt.Errorf("not authenticated")
 	}
 
+	if len(args) == 0 {
+		return s.protocolError(client, msgID, "hdata: missing path")
+	}
+
 	// Forward to command handler
 	if s.onCommand != nil {
-		go s.onCommand(client, msgID, "hdata", args)
+		s.onCommand(client, msgID, "hdata", args)
 	}
 
 	return nil
 }
 
+// inputArgs splits the text of an input command into the buffer and the
+// data. The data is kept verbatim past the one space separating them:
+// repeated, leading and trailing spaces are part of the message.
+func inputArgs(text string) []string {
+	i := strings.IndexAny(text, " \t")
+	if i < 0 {
+		return []string{text}
+	}
+	return []string{text[:i], text[i+1:]}
+}
+
+// completionArgs splits the text of a completion command into the buffer,
+// the position and the data, kept verbatim like the one of input
+func completionArgs(text string) []string {
+	buffer, rest, ok := strings.Cut(text, " ")
+	if !ok {
+		return []string{buffer}
+	}
+	position, data, ok := strings.Cut(rest, " ")
+	if !ok {
+		return []string{buffer, position}
+	}
+	return []string{buffer, position, data}
+}
+
 // handleInput handles input (send message) command
 func (s *Server) handleInput(client *Client, msgID string, args []string) error {
 	if !client.authenticated {
 		return fmt.Errorf("not authenticated")
 	}
 
+	if len(args) < 2 || args[1] == "" {
+		return s.protocolError(client, msgID, "input: need buffer and text")
+	}
+
 	// Forward to command handler
 	if s.onCommand != nil {
-		go s.onCommand(client, msgID, "input", args)
+		s.onCommand(client, msgID, "input", args)
 	}
 
 	return nil
@@ -288,7 +947,7 @@ func (s *Server) handleSync(client *Client, msgID string, args []string) error {
 
 	// Forward to command handler
 	if s.onCommand != nil {
-		go s.onCommand(client, msgID, "sync", args)
+		s.onCommand(client, msgID, "sync", args)
 	}
 
 	return nil
@@ -302,7 +961,7 @@ func (s *Server) handleDesync(client *Client, msgID string, args []string) error
 
 	// Forward to command handler
 	if s.onCommand != nil {
-		go s.onCommand(client, msgID, "desync", args)
+		s.onCommand(client, msgID, "desync", args)
 	}
 
 	return nil
@@ -316,38 +975,210 @@ func (s *Server) handleNicklist(client *Client, msgID string, args []string) err
 
 	// Forward to command handler
 	if s.onCommand != nil {
-		go s.onCommand(client, msgID, "nicklist", args)
+		s.onCommand(client, msgID, "nicklist", args)
+	}
+
+	return nil
+}
+
+// handleInfo handles info requests
+func (s *Server) handleInfo(client *Client, msgID string, args []string) error {
+	if !client.authenticated {
+		return fmt.Errorf("not authenticated")
+	}
+
+	if len(args) == 0 {
+		return s.protocolError(client, msgID, "info: missing name")
+	}
+
+	// Forward to command handler
+	if s.onCommand != nil {
+		s.onCommand(client, msgID, "info", args)
+	}
+
+	return nil
+}
+
+// handleInfoList handles infolist requests
+func (s *Server) handleInfoList(client *Client, msgID string, args []string) error {
+	if !client.authenticated {
+		return fmt.Errorf("not authenticated")
+	}
+
+	if len(args) == 0 {
+		return s.protocolError(client, msgID, "infolist: missing name")
+	}
+
+	// Forward to command handler
+	if s.onCommand != nil {
+		s.onCommand(client, msgID, "infolist", args)
+	}
+
+	return nil
+}
+
+// handleCompletion handles completion requests
+func (s *Server) handleCompletion(client *Client, msgID string, args []string) error {
+	if !client.authenticated {
+		return fmt.Errorf("not authenticated")
+	}
+
+	if len(args) == 0 || args[0] == "" {
+		return s.protocolError(client, msgID, "completion: missing buffer")
+	}
+
+	// Forward to command handler
+	if s.onCommand != nil {
+		s.onCommand(client, msgID, "completion", args)
 	}
 
 	return nil
 }
 
-// SendMessage sends a message to the client
+// handleTest answers the test command with one object of every type, see
+// weechatproto.CreateTestMessage
+func (s *Server) handleTest(client *Client, msgID string) error {
+	if !client.authenticated {
+		return fmt.Errorf("not authenticated")
+	}
+
+	return client.SendMessage(weechatproto.CreateTestMessage(msgID))
+}
+
+// handlePing answers ping with _pong and the same arguments, which clients
+// use to check the connection (and keep it from idling out)
+func (s *Server) handlePing(client *Client, arguments string) error {
+	if !client.authenticated {
+		return fmt.Errorf("not authenticated")
+	}
+
+	return client.SendMessage(weechatproto.CreatePongMessage(arguments))
+}
+
+// SendMessage sends a message to the client after those already queued and
+// waits until it is written, then for its bandwidth cap if it has one.
+// If encoding or writing fails the client is disconnected: a failed write may
+// have left a partial frame on the wire and every later message would be
+// decoded as garbage.
 func (c *Client) SendMessage(msg *weechatproto.Message) error {
+	wait, err := c.reply(msg)
+	if wait > 0 {
+		c.throttled.Add(int64(wait))
+		time.Sleep(wait)
+	}
+	return err
+}
+
+// setCompression compresses the messages sent from now on
+func (c *Client) setCompression(compression weechatproto.Compression) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	return c.encoder.EncodeMessage(msg)
+	c.encoder.SetCompression(compression)
 }
 
-// BroadcastMessage sends a message to all connected clients
+// send writes a message and returns how long the client's bandwidth cap
+// wants the sender to wait. Broadcasts don't wait, so a throttled client
+// never holds back the others. Only the writer goroutine calls it.
+func (c *Client) send(msg *weechatproto.Message) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.broken {
+		return 0, ErrClientClosed
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.server.writeTimeout))
+	before := c.bytesSent.Load()
+	err := c.encoder.EncodeMessage(msg)
+	if err == nil {
+		c.messagesSent.Add(1)
+		c.server.stats.messagesSent.Add(1)
+		c.countFrame(c.encoder.LastFrame())
+		if c.limiter == nil {
+			return 0, nil
+		}
+		return c.limiter.charge(int(c.bytesSent.Load()-before), time.Now()), nil
+	}
+
+	var writeErr *weechatproto.WriteError
+	if errors.As(err, &writeErr) {
+		c.server.stats.writeErrors.Add(1)
+	} else {
+		c.server.stats.encodeErrors.Add(1)
+	}
+
+	c.broken = true
+	c.server.stats.clientsClosedOnSend.Add(1)
+	c.log.Errorf("Send failed, closing client: %v", err)
+
+	// Closing the connection unblocks the read loop in handleClient,
+	// which removes the client and fires the disconnect handler
+	c.conn.Close()
+
+	return 0, err
+}
+
+// countFrame adds a sent frame to the compression counters
+func (c *Client) countFrame(frame weechatproto.FrameInfo) {
+	if frame.Compressed {
+		c.messagesCompressed.Add(1)
+		c.server.stats.messagesCompressed.Add(1)
+	}
+	c.messageBytes.Add(int64(frame.Size))
+	c.messageBytesSent.Add(int64(frame.Sent))
+	c.server.stats.messageBytes.Add(int64(frame.Size))
+	c.server.stats.messageBytesSent.Add(int64(frame.Sent))
+}
+
+// BroadcastMessage sends a message to all connected clients that synced it
 func (s *Server) BroadcastMessage(msg *weechatproto.Message) {
+	s.BroadcastMessageIf(msg, nil)
+}
+
+// BroadcastMessageIf queues a message for the connected clients that synced
+// it and accept returns true for (nil = all of them). It doesn't wait for the
+// writes, so a stalled client never holds back the others.
+func (s *Server) BroadcastMessageIf(msg *weechatproto.Message, accept func(*Client) bool) {
+	s.clientsMu.RLock()
+	sent := 0
+	for _, client := range s.clients {
+		if client.authenticated && client.receives(msg) && (accept == nil || accept(client)) {
+			if client.post(msg) {
+				sent++
+			}
+		}
+	}
+	s.clientsMu.RUnlock()
+
+	if s.onBroadcast != nil {
+		s.onBroadcast(msg, sent)
+	}
+}
+
+// AuthenticatedClients returns the number of clients past init
+func (s *Server) AuthenticatedClients() int {
 	s.clientsMu.RLock()
 	defer s.clientsMu.RUnlock()
 
+	n := 0
 	for _, client := range s.clients {
 		if client.authenticated {
-			if err := client.SendMessage(msg); err != nil {
-				client.log.Errorf("Failed to send message: %v", err)
-			}
+			n++
 		}
 	}
+	return n
 }
 
 // Close closes the server
 func (s *Server) Close() error {
 	close(s.done)
 
+	if s.wsServer != nil {
+		s.wsServer.Close()
+	}
+	s.closeACME()
+
 	if s.listener != nil {
 		return s.listener.Close()
 	}
@@ -0,0 +1,77 @@
+package weechat
+
+import "sync/atomic"
+
+// serverStats holds relay server counters (updated atomically)
+type serverStats struct {
+	encodeErrors        atomic.Int64
+	writeErrors         atomic.Int64
+	clientsClosedOnSend atomic.Int64
+	bytesSent           atomic.Int64
+	bytesReceived       atomic.Int64
+	messagesSent        atomic.Int64
+	messagesCompressed  atomic.Int64
+	messageBytes        atomic.Int64
+	messageBytesSent    atomic.Int64
+	messagesDropped     atomic.Int64
+	slowClientsClosed   atomic.Int64
+	connectionsRejected atomic.Int64
+	clientsTimedOut     atomic.Int64
+	authFailures        atomic.Int64
+	addressesBanned     atomic.Int64
+}
+
+// Stats is a point-in-time snapshot of relay server counters
+type Stats struct {
+	// EncodeErrors counts messages that could not be encoded
+	EncodeErrors int64
+	// WriteErrors counts failed or partial writes to client streams
+	WriteErrors int64
+	// ClientsClosedOnSend counts clients disconnected because a send failed
+	ClientsClosedOnSend int64
+	// BytesSent and BytesReceived count relay traffic of all clients
+	BytesSent     int64
+	BytesReceived int64
+	// MessagesSent counts messages sent to clients, MessagesCompressed
+	// those sent compressed; MessageBytes and MessageBytesSent are the size
+	// of all of them before and after compression
+	MessagesSent       int64
+	MessagesCompressed int64
+	MessageBytes       int64
+	MessageBytesSent   int64
+	// SlowClientsClosed counts clients disconnected because their write
+	// queue was full, MessagesDropped the broadcasts they missed
+	SlowClientsClosed int64
+	MessagesDropped   int64
+	// ConnectionsRejected counts connections refused by the client limit or
+	// the address access list
+	ConnectionsRejected int64
+	// ClientsTimedOut counts clients disconnected by the idle timeout or
+	// for missing websocket pongs
+	ClientsTimedOut int64
+	// AuthFailures counts failed inits, AddressesBanned the bans they
+	// led to, see LockoutConfig
+	AuthFailures    int64
+	AddressesBanned int64
+}
+
+// Stats returns a snapshot of the server counters
+func (s *Server) Stats() Stats {
+	return Stats{
+		EncodeErrors:        s.stats.encodeErrors.Load(),
+		WriteErrors:         s.stats.writeErrors.Load(),
+		ClientsClosedOnSend: s.stats.clientsClosedOnSend.Load(),
+		BytesSent:           s.stats.bytesSent.Load(),
+		BytesReceived:       s.stats.bytesReceived.Load(),
+		MessagesSent:        s.stats.messagesSent.Load(),
+		MessagesCompressed:  s.stats.messagesCompressed.Load(),
+		MessageBytes:        s.stats.messageBytes.Load(),
+		MessageBytesSent:    s.stats.messageBytesSent.Load(),
+		SlowClientsClosed:   s.stats.slowClientsClosed.Load(),
+		MessagesDropped:     s.stats.messagesDropped.Load(),
+		ConnectionsRejected: s.stats.connectionsRejected.Load(),
+		ClientsTimedOut:     s.stats.clientsTimedOut.Load(),
+		AuthFailures:        s.stats.authFailures.Load(),
+		AddressesBanned:     s.stats.addressesBanned.Load(),
+	}
+}
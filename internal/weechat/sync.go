@@ -0,0 +1,155 @@
+package weechat
+
+import (
+	"strings"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+// SyncFlags select what a client receives of a buffer it synced, as the
+// options of sync and desync
+type SyncFlags uint8
+
+const (
+	SyncBuffer   SyncFlags = 1 << iota // Lines and changes of the buffer ("buffer")
+	SyncNicklist                       // Nicklist ("nicklist")
+	SyncBuffers                        // Buffers opened, closed, moved... ("buffers", "*" only)
+	SyncUpgrade                        // _upgrade and _upgrade_ended ("upgrade", "*" only)
+
+	SyncAll = SyncBuffer | SyncNicklist | SyncBuffers | SyncUpgrade
+
+	// SyncBufferFlags are the flags a single buffer can be synced with
+	SyncBufferFlags = SyncBuffer | SyncNicklist
+)
+
+// syncOptions maps the options of sync and desync
+var syncOptions = map[string]SyncFlags{
+	"buffer":   SyncBuffer,
+	"nicklist": SyncNicklist,
+	"buffers":  SyncBuffers,
+	"upgrade":  SyncUpgrade,
+	"*":        SyncAll,
+}
+
+// ParseSyncArgs parses the arguments of sync and desync: the buffers, "*"
+// (every buffer) by default, and the flags, all of them by default.
+//
+//	sync
+//	sync * buffers,upgrade
+//	sync irc.libera.#go,0x123 buffer,nicklist
+func ParseSyncArgs(args []string) (buffers []string, flags SyncFlags) {
+	buffers = []string{"*"}
+	if len(args) > 0 {
+		buffers = strings.Split(args[0], ",")
+	}
+	if len(args) < 2 {
+		return buffers, SyncAll
+	}
+	for _, option := range strings.Split(args[1], ",") {
+		flags |= syncOptions[option]
+	}
+	return buffers, flags
+}
+
+// Sync subscribes the client to a buffer pointer ("*" = every buffer)
+func (c *Client) Sync(buffer string, flags SyncFlags) {
+	if buffer != "*" {
+		flags &= SyncBufferFlags
+	}
+	if flags == 0 {
+		return
+	}
+
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	if c.syncs == nil {
+		c.syncs = make(map[string]SyncFlags)
+	}
+	c.syncs[buffer] |= flags
+}
+
+// Desync removes flags from the subscription of a buffer pointer ("*" =
+// the one to every buffer, buffers synced by themselves stay synced)
+func (c *Client) Desync(buffer string, flags SyncFlags) {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	if _, ok := c.syncs[buffer]; !ok {
+		return
+	}
+	if c.syncs[buffer] &^= flags; c.syncs[buffer] == 0 {
+		delete(c.syncs, buffer)
+	}
+}
+
+// Synced reports whether the client receives a kind of event of a buffer.
+// A buffer synced by itself follows its own flags, others those of "*".
+func (c *Client) Synced(buffer string, flag SyncFlags) bool {
+	c.syncMu.Lock()
+	defer c.syncMu.Unlock()
+
+	if flags, ok := c.syncs[buffer]; ok && buffer != "" {
+		return flags&flag != 0
+	}
+	return c.syncs["*"]&flag != 0
+}
+
+// receives reports whether a broadcast is meant for the client, given what
+// it synced. Like WeeChat, clients get nothing before their first sync.
+func (c *Client) receives(msg *weechatproto.Message) bool {
+	buffer, flag := eventSync(msg)
+	switch flag {
+	case 0:
+		return true
+	case SyncBuffers:
+		// Changes of a buffer also reach clients syncing its content
+		return c.Synced(buffer, SyncBuffer) || c.Synced("*", SyncBuffers)
+	}
+	return c.Synced(buffer, flag)
+}
+
+// eventSync returns the buffer of an event and the flag a client needs to
+// receive it, no flag for events unrelated to sync. Live lines and
+// nicklists are sent without an event name, so they are told by hdata path.
+func eventSync(msg *weechatproto.Message) (buffer string, flag SyncFlags) {
+	path := ""
+	for _, obj := range msg.Data {
+		if hdata, ok := obj.(weechatproto.HData); ok {
+			path = hdata.Path
+			break
+		}
+	}
+
+	switch {
+	case msg.ID == "_upgrade" || msg.ID == "_upgrade_ended":
+		return "", SyncUpgrade
+	case path == "line_data":
+		return EventBuffer(msg), SyncBuffer
+	case strings.HasSuffix(path, "nicklist_item"):
+		return EventBuffer(msg), SyncNicklist
+	case strings.HasPrefix(msg.ID, "_buffer_"):
+		return EventBuffer(msg), SyncBuffers
+	}
+	return "", 0
+}
+
+// EventBuffer returns the buffer pointer of an hdata event, "" if it has
+// none
+func EventBuffer(msg *weechatproto.Message) string {
+	for _, obj := range msg.Data {
+		hdata, ok := obj.(weechatproto.HData)
+		if !ok || len(hdata.Items) == 0 {
+			continue
+		}
+		item := hdata.Items[0]
+		if ptr, ok := item.Objects["buffer"].(weechatproto.Pointer); ok {
+			return ptr.Value
+		}
+		// Buffer events point at the buffer itself
+		if strings.HasPrefix(hdata.Path, "buffer") && len(item.Pointers) > 0 {
+			return item.Pointers[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package weechat
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TOTP parameters of RFC 6238 as used by WeeChat and authenticator apps
+const (
+	totpPeriod = 30 * time.Second
+	totpDigits = 6
+
+	// totpWindow is how many periods before and after the current one are
+	// accepted, for clocks that drift a little
+	totpWindow = 1
+)
+
+// ParseTOTPSecret decodes a base32 TOTP secret as shown by authenticator
+// apps (case-insensitive, spaces and padding optional). Empty means no TOTP.
+func ParseTOTPSecret(s string) ([]byte, error) {
+	value := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(s), " ", ""))
+	if value == "" {
+		return nil, nil
+	}
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(value, "="))
+	if err != nil || len(key) == 0 {
+		return nil, fmt.Errorf("invalid TOTP secret (want base32)")
+	}
+	return key, nil
+}
+
+// totpCode returns the code of a TOTP key for a time step (RFC 4226 HOTP)
+func totpCode(key []byte, counter uint64) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, value%1000000)
+}
+
+// checkTOTP reports whether code is valid for the key at the given time
+func checkTOTP(key []byte, code string, now time.Time) bool {
+	if len(code) != totpDigits {
+		return false
+	}
+
+	counter := uint64(now.Unix() / int64(totpPeriod/time.Second))
+	for delta := -totpWindow; delta <= totpWindow; delta++ {
+		expected := totpCode(key, counter+uint64(delta))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return true
+		}
+	}
+	return false
+}
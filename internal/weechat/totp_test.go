@@ -0,0 +1,73 @@
+package weechat
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfc6238Key is the SHA-1 key of the RFC 6238 test vectors
+var rfc6238Key = []byte("12345678901234567890")
+
+func TestTOTPCode(t *testing.T) {
+	// The last six digits of the RFC 6238 SHA-1 vectors
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(rfc6238Key, uint64(tt.unix/30)); got != tt.want {
+			t.Errorf("at %d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTOTPWindow(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	code := func(offset time.Duration) string {
+		return totpCode(rfc6238Key, uint64(now.Add(offset).Unix()/30))
+	}
+
+	tests := []struct {
+		name string
+		code string
+		want bool
+	}{
+		{"current", code(0), true},
+		{"previous period", code(-30 * time.Second), true},
+		{"next period", code(30 * time.Second), true},
+		{"two periods old", code(-60 * time.Second), false},
+		{"two periods ahead", code(60 * time.Second), false},
+		{"empty", "", false},
+		{"too short", code(0)[:5], false},
+		{"too long", code(0) + "0", false},
+	}
+	for _, tt := range tests {
+		if got := checkTOTP(rfc6238Key, tt.code, now); got != tt.want {
+			t.Errorf("%s code %q: got %v, want %v", tt.name, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestParseTOTPSecret(t *testing.T) {
+	encoded := base32.StdEncoding.EncodeToString(rfc6238Key)
+
+	for _, s := range []string{encoded, "  " + encoded + "  ", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ"} {
+		key, err := ParseTOTPSecret(s)
+		if err != nil || string(key) != string(rfc6238Key) {
+			t.Errorf("%q: got %q, %v", s, key, err)
+		}
+	}
+	if key, err := ParseTOTPSecret(""); key != nil || err != nil {
+		t.Errorf("empty secret: got %q, %v, want no TOTP", key, err)
+	}
+	if _, err := ParseTOTPSecret("not base32!"); err == nil {
+		t.Errorf("invalid secret accepted")
+	}
+}
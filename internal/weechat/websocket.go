@@ -0,0 +1,267 @@
+package weechat
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// maxWSCommandSize bounds a single command message from a websocket client
+const maxWSCommandSize = 1 << 20
+
+// HTTPAuth is an optional first authentication factor for websocket clients,
+// checked against the Authorization header before the relay handshake. Useful
+// when a reverse proxy (nginx, Caddy) or the client can add the header.
+type HTTPAuth struct {
+	BasicUser     string // Accept "Authorization: Basic" with these credentials
+	BasicPassword string
+	BearerToken   string // Accept "Authorization: Bearer <token>"
+}
+
+// enabled reports whether any credential is configured
+func (a HTTPAuth) enabled() bool {
+	return a.BasicUser != "" || a.BearerToken != ""
+}
+
+// check validates an Authorization header value
+func (a HTTPAuth) check(header string) bool {
+	scheme, value, ok := strings.Cut(header, " ")
+	if !ok {
+		return false
+	}
+	value = strings.TrimSpace(value)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if a.BasicUser == "" {
+			return false
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return false
+		}
+		user, password, ok := strings.Cut(string(decoded), ":")
+		if !ok {
+			return false
+		}
+		userOK := subtle.ConstantTimeCompare([]byte(user), []byte(a.BasicUser)) == 1
+		passOK := subtle.ConstantTimeCompare([]byte(password), []byte(a.BasicPassword)) == 1
+		return userOK && passOK
+	case "bearer":
+		if a.BearerToken == "" {
+			return false
+		}
+		return subtle.ConstantTimeCompare([]byte(value), []byte(a.BearerToken)) == 1
+	default:
+		return false
+	}
+}
+
+// startWebSocket opens the websocket relay listener
+func (s *Server) startWebSocket() error {
+	addrs, wsPath, err := splitWebSocketPath(s.wsAddr)
+	if err != nil {
+		return err
+	}
+	if wsPath != "" {
+		s.wsPath = wsPath
+	}
+	if len(s.wsOrigins) > 0 {
+		s.upgrader.CheckOrigin = s.checkOrigin
+	}
+
+	listener, err := s.listenSpecs(addrs)
+	if err != nil {
+		return fmt.Errorf("failed to listen for websocket: %w", err)
+	}
+	addrs = formatAddrs(listenerAddrs(listener))
+	scheme := "ws"
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.wsTLSConfig())
+		scheme = "wss"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(s.wsPath, s.handleWebSocket)
+
+	s.wsListener = listener
+	s.wsServer = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.log.Infof("WeeChat websocket relay listening on %s (%s, path %s)", addrs, scheme, s.wsPath)
+
+	go func() {
+		if err := s.wsServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Errorf("Websocket server error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// splitWebSocketPath takes the URL path off websocket listen addresses
+// (":9001/weechat"). Every address with a path must use the same one.
+// Unix socket addresses can't carry one, their path is the socket's.
+func splitWebSocketPath(list string) (addrs, wsPath string, err error) {
+	items := strings.Split(list, ",")
+	for i, item := range items {
+		item = strings.TrimSpace(item)
+		if _, ok := unixSocketPath(item); ok {
+			items[i] = item
+			continue
+		}
+		addr, p, ok := strings.Cut(item, "/")
+		if ok {
+			p = "/" + p
+			if wsPath != "" && p != wsPath {
+				return "", "", fmt.Errorf("websocket listen addresses have different paths %s and %s", wsPath, p)
+			}
+			wsPath = p
+		}
+		items[i] = addr
+	}
+	return strings.Join(items, ","), wsPath, nil
+}
+
+// checkOrigin accepts requests without Origin and those from an allowed
+// origin. Patterns with a scheme match the whole origin, others its host.
+func (s *Server) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range s.wsOrigins {
+		pattern = strings.ToLower(pattern)
+		if pattern == "*" {
+			return true
+		}
+		if strings.Contains(pattern, "://") {
+			if ok, _ := path.Match(pattern, strings.ToLower(origin)); ok {
+				return true
+			}
+			continue
+		}
+		for _, host := range []string{u.Host, u.Hostname()} {
+			if ok, _ := path.Match(pattern, strings.ToLower(host)); ok {
+				return true
+			}
+		}
+	}
+
+	s.log.Warnf("Rejecting websocket client %s: origin %s not allowed", clientAddress(r), origin)
+	return false
+}
+
+// WebSocketAddr returns the address of the websocket listener (nil if disabled or not started)
+func (s *Server) WebSocketAddr() net.Addr {
+	if s.wsListener == nil {
+		return nil
+	}
+	return s.wsListener.Addr()
+}
+
+// handleWebSocket upgrades a request and serves the relay protocol over it
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	remote := clientAddress(r)
+
+	peer := r.RemoteAddr
+	if peer == "@" {
+		peer = "" // Unix socket
+	}
+	if reason := s.admit(peer, remote); reason != "" {
+		s.reject(remote, reason)
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if s.wsAuth.enabled() && !s.wsAuth.check(r.Header.Get("Authorization")) {
+		s.log.Warnf("Rejecting websocket client %s: missing or invalid Authorization header", remote)
+		if s.wsAuth.BasicUser != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="weechat relay"`)
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		s.log.Warnf("Websocket upgrade from %s failed: %v", remote, err)
+		return
+	}
+	ws.SetReadLimit(maxWSCommandSize)
+
+	s.log.Infof("New websocket client connected from %s", remote)
+	s.addClient(&wsConn{Conn: ws}, remote)
+}
+
+// clientAddress returns the client address of a request, preferring the
+// headers set by reverse proxies
+func clientAddress(r *http.Request) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		first, _, _ := strings.Cut(forwarded, ",")
+		return strings.TrimSpace(first)
+	}
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		return realIP
+	}
+	if r.RemoteAddr == "" || r.RemoteAddr == "@" {
+		return "unix socket"
+	}
+	return r.RemoteAddr
+}
+
+// wsConn adapts a websocket to net.Conn for the line-based relay reader and
+// the frame encoder. Each incoming ws message holds one or more commands;
+// each Write (one encoded relay message) becomes one binary ws message.
+type wsConn struct {
+	*websocket.Conn
+	pending []byte
+}
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		_, data, err := c.Conn.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		c.pending = data
+	}
+
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	if err := c.Conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.Conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.Conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,109 @@
+package weechat
+
+import (
+	"time"
+
+	"erssi-lith-bridge/pkg/weechatproto"
+)
+
+const (
+	// DefaultWriteQueueSize is how many messages may wait for a client's
+	// writer before the client counts as too slow and is disconnected
+	DefaultWriteQueueSize = 4096
+	// DefaultWriteTimeout is how long writing one message to a client may
+	// take before the client is disconnected
+	DefaultWriteTimeout = time.Minute
+
+	// maxQueueWait is how long a broadcast waits for room in a full write
+	// queue, holding back the other clients, before giving up on the client
+	maxQueueWait = 5 * time.Second
+)
+
+// outgoing is a message waiting for a client's writer. Replies carry a
+// channel for the result of the write; broadcasts don't wait for it.
+type outgoing struct {
+	msg  *weechatproto.Message
+	done chan sendResult
+}
+
+// sendResult is what writing a reply gave: how long the client's bandwidth
+// cap wants the sender to wait, or the error
+type sendResult struct {
+	wait time.Duration
+	err  error
+}
+
+// writeLoop writes the queued messages of a client, in order, until it
+// disconnects. Messages still queued then are dropped.
+func (c *Client) writeLoop() {
+	for {
+		select {
+		case o := <-c.queue:
+			wait, err := c.send(o.msg)
+			if o.done != nil {
+				o.done <- sendResult{wait: wait, err: err}
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// reply queues a message and waits until it is written. A full queue holds
+// the sender back, which only pauses the commands of this client.
+func (c *Client) reply(msg *weechatproto.Message) (time.Duration, error) {
+	done := make(chan sendResult, 1)
+	select {
+	case c.queue <- outgoing{msg: msg, done: done}:
+	case <-c.closed:
+		return 0, ErrClientClosed
+	}
+
+	select {
+	case result := <-done:
+		return result.wait, result.err
+	case <-c.closed:
+		return 0, ErrClientClosed
+	}
+}
+
+// post queues a broadcast without waiting for the write. A full queue
+// slows the broadcast down for at most maxQueueWait; a client whose queue
+// stays full can't keep up and is disconnected, since skipping a message
+// would leave its buffers inconsistent.
+func (c *Client) post(msg *weechatproto.Message) bool {
+	if c.slow.Load() {
+		c.dropped.Add(1)
+		c.server.stats.messagesDropped.Add(1)
+		return false
+	}
+
+	select {
+	case c.queue <- outgoing{msg: msg}:
+		return true
+	case <-c.closed:
+		return false
+	default:
+	}
+
+	timer := time.NewTimer(maxQueueWait)
+	defer timer.Stop()
+	select {
+	case c.queue <- outgoing{msg: msg}:
+		return true
+	case <-c.closed:
+		return false
+	case <-timer.C:
+	}
+
+	c.dropped.Add(1)
+	c.server.stats.messagesDropped.Add(1)
+	if c.slow.CompareAndSwap(false, true) {
+		c.server.stats.slowClientsClosed.Add(1)
+		c.log.Warnf("Client stayed %d messages behind for %s, disconnecting", cap(c.queue), maxQueueWait)
+
+		// Like a failed send, this ends the read loop in handleClient
+		c.conn.Close()
+	}
+	return false
+}
@@ -0,0 +1,204 @@
+package erssiproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Violation is a way an incoming message differs from what the bridge
+// expects of its type
+type Violation struct {
+	Type    MessageType
+	Field   string // Empty for problems with the message as a whole
+	Problem string
+}
+
+func (v Violation) Error() string {
+	name := string(v.Type)
+	if name == "" {
+		name = "message"
+	}
+	if v.Field == "" {
+		return fmt.Sprintf("%s: %s", name, v.Problem)
+	}
+	return fmt.Sprintf("%s.%s: %s", name, v.Field, v.Problem)
+}
+
+// fieldKind is the JSON kind of a WebMessage field
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindNumber
+	kindBool
+	kindObject
+	kindArray // Never expected
+)
+
+func (k fieldKind) String() string {
+	switch k {
+	case kindNumber:
+		return "number"
+	case kindBool:
+		return "boolean"
+	case kindObject:
+		return "object"
+	case kindArray:
+		return "array"
+	default:
+		return "string"
+	}
+}
+
+// knownFields are the fields fe-web may send, with "channel" and "server"
+// as the alternative names handled by UnmarshalJSON
+var knownFields = map[string]fieldKind{
+	"id":           kindString,
+	"type":         kindString,
+	"server":       kindString,
+	"server_tag":   kindString,
+	"target":       kindString,
+	"channel":      kindString,
+	"nick":         kindString,
+	"text":         kindString,
+	"level":        kindNumber,
+	"timestamp":    kindNumber,
+	"is_own":       kindBool,
+	"is_highlight": kindBool,
+	"extra_data":   kindObject,
+	"response_to":  kindString,
+	"seq":          kindNumber,
+}
+
+// fieldAliases lists the accepted names of a required field
+var fieldAliases = map[string][]string{
+	"server_tag": {"server_tag", "server"},
+	"target":     {"target", "channel"},
+}
+
+// requiredFields are the fields the bridge relies on, per message type.
+// Types not listed here are unknown to the bridge.
+var requiredFields = map[MessageType][]string{
+	AuthOK:              nil,
+	Message:             {"server_tag", "target", "text"},
+	ServerStatus:        {"server_tag"},
+	ChannelJoin:         {"server_tag", "target", "nick"},
+	ChannelPart:         {"server_tag", "target", "nick"},
+	ChannelKick:         {"server_tag", "target", "nick"},
+	UserQuit:            {"server_tag", "nick"},
+	Topic:               {"server_tag", "target"},
+	ChannelMode:         {"server_tag", "target"},
+	Nicklist:            {"server_tag", "target", "text"},
+	NicklistUpdate:      {"server_tag", "target"},
+	NickChange:          {"server_tag", "nick"},
+	UserMode:            {"server_tag"},
+	Away:                {"server_tag"},
+	Whois:               {"server_tag", "nick"},
+	ChannelList:         {"server_tag"},
+	StateDump:           {"server_tag"},
+	Error:               {"text"},
+	Pong:                nil,
+	QueryOpened:         {"server_tag", "target"},
+	QueryClosed:         {"server_tag", "target"},
+	ActivityUpdate:      {"server_tag", "target"},
+	MarkRead:            {"server_tag", "target"},
+	NetworkListResponse: nil,
+	ServerListResponse:  nil,
+	CommandResult:       nil,
+}
+
+// Validate checks a raw fe-web message against the fields the bridge
+// expects for its type: known type, required fields present and non-empty,
+// no unknown fields and every field of the right JSON kind. Data that is
+// not a JSON object is reported as a single violation.
+func Validate(data []byte) []Violation {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return []Violation{{Problem: fmt.Sprintf("not a JSON object: %v", err)}}
+	}
+
+	var msgType MessageType
+	if raw, ok := fields["type"]; ok {
+		json.Unmarshal(raw, &msgType)
+	}
+
+	var violations []Violation
+	add := func(field, problem string) {
+		violations = append(violations, Violation{Type: msgType, Field: field, Problem: problem})
+	}
+
+	required, known := requiredFields[msgType]
+	switch {
+	case msgType == "":
+		add("type", "missing")
+	case !known:
+		add("", "unknown message type")
+	}
+
+	for _, field := range required {
+		if !hasValue(fields, field) {
+			add(field, "missing")
+		}
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		kind, ok := knownFields[name]
+		if !ok {
+			add(name, "unknown field")
+			continue
+		}
+		if got, ok := jsonKind(fields[name]); ok && got != kind {
+			add(name, fmt.Sprintf("expected %s, got %s", kind, got))
+		}
+	}
+
+	return violations
+}
+
+// hasValue reports whether a field (or one of its aliases) is present and
+// not empty
+func hasValue(fields map[string]json.RawMessage, field string) bool {
+	names, ok := fieldAliases[field]
+	if !ok {
+		names = []string{field}
+	}
+	for _, name := range names {
+		raw, ok := fields[name]
+		if !ok {
+			continue
+		}
+		raw = bytes.TrimSpace(raw)
+		if !bytes.Equal(raw, []byte(`""`)) && !bytes.Equal(raw, []byte("null")) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonKind returns the kind of a raw JSON value; null matches any kind
+func jsonKind(raw json.RawMessage) (fieldKind, bool) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return 0, false
+	}
+	switch raw[0] {
+	case '"':
+		return kindString, true
+	case 't', 'f':
+		return kindBool, true
+	case '{':
+		return kindObject, true
+	case '[':
+		return kindArray, true
+	default:
+		return kindNumber, true
+	}
+}
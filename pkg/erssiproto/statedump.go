@@ -0,0 +1,152 @@
+package erssiproto
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// State is the payload of a state_dump message: every server with its
+// channels and queries. fe-web carries it in extra_data, older versions as
+// JSON in text.
+type State struct {
+	Servers []StateServer `json:"servers"`
+}
+
+// StateServer is one server of a state dump
+type StateServer struct {
+	Tag      string         `json:"tag"`
+	Nick     string         `json:"nick,omitempty"`
+	Channels []StateChannel `json:"channels,omitempty"`
+	Queries  []StateQuery   `json:"queries,omitempty"`
+}
+
+// StateChannel is one channel of a state dump server. Nicks may be sent
+// either as objects ({"nick":"x","prefix":"@"}) or as plain strings with
+// the mode prefix prepended ("@x").
+type StateChannel struct {
+	Name      string     `json:"name"`
+	Topic     string     `json:"topic,omitempty"`
+	Mode      string     `json:"mode,omitempty"`
+	UserCount int        `json:"user_count,omitempty"`
+	Nicks     []NickInfo `json:"nicks,omitempty"`
+}
+
+// StateQuery is one open query of a state dump server
+type StateQuery struct {
+	Nick string `json:"nick"`
+}
+
+// Info returns the channel as ChannelInfo
+func (ch StateChannel) Info() ChannelInfo {
+	return ChannelInfo(ch)
+}
+
+// UnmarshalJSON accepts both nick forms and a fractional user count
+func (ch *StateChannel) UnmarshalJSON(data []byte) error {
+	type plain StateChannel
+	aux := struct {
+		UserCount float64           `json:"user_count"`
+		Nicks     []json.RawMessage `json:"nicks"`
+		*plain
+	}{plain: (*plain)(ch)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	ch.UserCount = int(aux.UserCount)
+
+	ch.Nicks = nil
+	for i, raw := range aux.Nicks {
+		var nick NickInfo
+		if s := bytes.TrimSpace(raw); len(s) > 0 && s[0] == '"' {
+			var prefixed string
+			if err := json.Unmarshal(s, &prefixed); err != nil {
+				return fmt.Errorf("nicks[%d]: %w", i, err)
+			}
+			nick = ParsePrefixedNick(prefixed)
+		} else if err := json.Unmarshal(raw, &nick); err != nil {
+			return fmt.Errorf("nicks[%d]: %w", i, err)
+		}
+		if nick.Nick != "" {
+			ch.Nicks = append(ch.Nicks, nick)
+		}
+	}
+	return nil
+}
+
+// ParsePrefixedNick splits "@nick" into prefix and nick. Only the highest
+// prefix is kept when several are present ("@+nick").
+func ParsePrefixedNick(s string) NickInfo {
+	trimmed := strings.TrimLeft(s, "~&@%+")
+	info := NickInfo{Nick: trimmed}
+	if len(trimmed) < len(s) {
+		info.Prefix = s[:1]
+	}
+	return info
+}
+
+// ParseStateDump decodes the payload of a state_dump message. A dump of a
+// single server may carry its channels at the top level instead of in
+// "servers"; servers without a tag get the message's server tag. A message
+// without payload gives an empty dump.
+func ParseStateDump(msg *WebMessage) (*State, error) {
+	var data []byte
+	switch {
+	case len(msg.ExtraData) > 0:
+		var err error
+		if data, err = json.Marshal(msg.ExtraData); err != nil {
+			return nil, fmt.Errorf("state dump: %w", err)
+		}
+	case strings.HasPrefix(strings.TrimSpace(msg.Text), "{"):
+		data = []byte(msg.Text)
+	default:
+		return &State{}, nil
+	}
+
+	var payload struct {
+		State
+		StateServer
+	}
+	if err := json.Unmarshal(data, &payload); err != nil {
+		return nil, fmt.Errorf("state dump: %w", err)
+	}
+
+	dump := &payload.State
+	if dump.Servers == nil && payload.Channels != nil {
+		dump.Servers = []StateServer{payload.StateServer}
+	}
+	for i := range dump.Servers {
+		if dump.Servers[i].Tag == "" {
+			dump.Servers[i].Tag = msg.ServerTag
+		}
+	}
+	return dump, nil
+}
+
+// Validate reports entries the bridge can't use: servers without a tag,
+// channels without a name and queries without a nick
+func (d *State) Validate() []Violation {
+	var violations []Violation
+	add := func(field string) {
+		violations = append(violations, Violation{Type: StateDump, Field: field, Problem: "missing"})
+	}
+
+	for i, server := range d.Servers {
+		if server.Tag == "" {
+			add(fmt.Sprintf("servers[%d].tag", i))
+		}
+		for j, ch := range server.Channels {
+			if ch.Name == "" {
+				add(fmt.Sprintf("servers[%d].channels[%d].name", i, j))
+			}
+		}
+		for j, query := range server.Queries {
+			if query.Nick == "" {
+				add(fmt.Sprintf("servers[%d].queries[%d].nick", i, j))
+			}
+		}
+	}
+	return violations
+}
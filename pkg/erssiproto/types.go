@@ -1,6 +1,10 @@
 package erssiproto
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
 
 // MessageType represents erssi WebSocket message types
 // erssi sends these as strings, not integers
@@ -26,6 +30,7 @@ const (
 	StateDump           MessageType = "state_dump"
 	SyncServer          MessageType = "sync_server"
 	Error               MessageType = "error"
+	Ping                MessageType = "ping"
 	Pong                MessageType = "pong"
 	QueryOpened         MessageType = "query_opened"
 	QueryClosed         MessageType = "query_closed"
@@ -44,19 +49,20 @@ const (
 
 // WebMessage represents a message from/to erssi fe-web
 type WebMessage struct {
-	ID             string                 `json:"id,omitempty"`
-	Type           MessageType            `json:"type"`
-	Server         string                 `json:"server,omitempty"`      // For sync_server requests
-	ServerTag      string                 `json:"server_tag,omitempty"`  // For responses
-	Target         string                 `json:"target,omitempty"`
-	Nick           string                 `json:"nick,omitempty"`
-	Text           string                 `json:"text,omitempty"`
-	Level          int                    `json:"level,omitempty"`
-	Timestamp      int64                  `json:"timestamp,omitempty"`
-	IsOwn          bool                   `json:"is_own,omitempty"`
-	IsHighlight    bool                   `json:"is_highlight,omitempty"`
-	ExtraData      map[string]interface{} `json:"extra_data,omitempty"`
-	ResponseTo     string                 `json:"response_to,omitempty"`
+	ID          string                 `json:"id,omitempty"`
+	Type        MessageType            `json:"type"`
+	Server      string                 `json:"server,omitempty"`     // For sync_server requests
+	ServerTag   string                 `json:"server_tag,omitempty"` // For responses
+	Target      string                 `json:"target,omitempty"`
+	Nick        string                 `json:"nick,omitempty"`
+	Text        string                 `json:"text,omitempty"`
+	Level       int                    `json:"level,omitempty"`
+	Timestamp   int64                  `json:"timestamp,omitempty"`
+	IsOwn       bool                   `json:"is_own,omitempty"`
+	IsHighlight bool                   `json:"is_highlight,omitempty"`
+	ExtraData   map[string]interface{} `json:"extra_data,omitempty"`
+	ResponseTo  string                 `json:"response_to,omitempty"`
+	Seq         uint64                 `json:"seq,omitempty"` // Per-connection sequence number, if fe-web numbers its messages
 }
 
 // NickInfo represents a user in a channel nicklist
@@ -69,21 +75,21 @@ type NickInfo struct {
 
 // ChannelInfo represents channel metadata
 type ChannelInfo struct {
-	Name      string      `json:"name"`
-	Topic     string      `json:"topic,omitempty"`
-	Mode      string      `json:"mode,omitempty"`
-	UserCount int         `json:"user_count,omitempty"`
-	Nicks     []NickInfo  `json:"nicks,omitempty"`
+	Name      string     `json:"name"`
+	Topic     string     `json:"topic,omitempty"`
+	Mode      string     `json:"mode,omitempty"`
+	UserCount int        `json:"user_count,omitempty"`
+	Nicks     []NickInfo `json:"nicks,omitempty"`
 }
 
 // ServerInfo represents IRC server status
 type ServerInfo struct {
-	Tag       string    `json:"tag"`
-	Address   string    `json:"address"`
-	Port      int       `json:"port"`
-	Connected bool      `json:"connected"`
-	Nick      string    `json:"nick,omitempty"`
-	Channels  []string  `json:"channels,omitempty"`
+	Tag       string   `json:"tag"`
+	Address   string   `json:"address"`
+	Port      int      `json:"port"`
+	Connected bool     `json:"connected"`
+	Nick      string   `json:"nick,omitempty"`
+	Channels  []string `json:"channels,omitempty"`
 }
 
 // AuthRequest represents authentication to erssi
@@ -130,3 +136,100 @@ func (m *WebMessage) UnmarshalJSON(data []byte) error {
 
 	return nil
 }
+
+// IRCv3 tag keys fe-web may forward in extra_data (either at the top level or
+// nested under "tags")
+const (
+	tagServerTime = "time"
+	tagMsgID      = "msgid"
+)
+
+// ircTag looks up an IRCv3 tag value forwarded by fe-web
+func (m *WebMessage) ircTag(key string) string {
+	if m.ExtraData == nil {
+		return ""
+	}
+
+	if tags, ok := m.ExtraData["tags"].(map[string]interface{}); ok {
+		if v, ok := tags[key].(string); ok && v != "" {
+			return v
+		}
+	}
+
+	// Flattened form: server_time / msgid
+	flatKey := key
+	if key == tagServerTime {
+		flatKey = "server_time"
+	}
+	if v, ok := m.ExtraData[flatKey].(string); ok {
+		return v
+	}
+
+	return ""
+}
+
+// ServerTime returns the IRCv3 server-time of the message, if erssi forwarded it
+func (m *WebMessage) ServerTime() (time.Time, bool) {
+	v := m.ircTag(tagServerTime)
+	if v == "" {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// MsgID returns the IRCv3 msgid of the message, if erssi forwarded it
+func (m *WebMessage) MsgID() string {
+	return m.ircTag(tagMsgID)
+}
+
+// Host returns the user@host of the sender, if erssi forwarded it
+func (m *WebMessage) Host() string {
+	if m.ExtraData == nil {
+		return ""
+	}
+	host, _ := m.ExtraData["host"].(string)
+	return host
+}
+
+// ServerConnected returns the state reported by a server_status message:
+// extra_data "connected", or a text of "connected" or "disconnected"
+func (m *WebMessage) ServerConnected() (connected, ok bool) {
+	if m.ExtraData != nil {
+		if v, ok := m.ExtraData["connected"].(bool); ok {
+			return v, true
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(m.Text)) {
+	case "connected":
+		return true, true
+	case "disconnected":
+		return false, true
+	}
+	return false, false
+}
+
+// irssi message levels (MSGLEVEL_*) fe-web sends in WebMessage.Level
+const (
+	LevelNotices = 0x0008
+	LevelTopics  = 0x1000
+	LevelWallops = 0x2000
+
+	// LevelNoActivity marks lines irssi prints without window activity
+	LevelNoActivity = 0x2000000
+)
+
+// IsOperBroadcast reports whether the message is a wallops or a global
+// notice (a notice to a $server mask), the traffic IRC operators get
+// flooded with
+func (m *WebMessage) IsOperBroadcast() bool {
+	if m.Level&LevelWallops != 0 {
+		return true
+	}
+	return m.Level&LevelNotices != 0 && strings.HasPrefix(m.Target, "$")
+}
@@ -0,0 +1,38 @@
+package weechatproto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// WeeChat color codes embedded in strings (prefix, message, title)
+const (
+	colorCode  = "\x19" // Followed by a color spec
+	ColorReset = "\x1c" // Resets color and attributes
+)
+
+// colorNames are WeeChat's basic colors, by number
+var colorNames = []string{
+	"default", "black", "darkgray", "red", "lightred", "green", "lightgreen",
+	"brown", "yellow", "blue", "lightblue", "magenta", "lightmagenta", "cyan",
+	"lightcyan", "gray", "white",
+}
+
+// ColorCode returns the code setting the foreground color, for a WeeChat
+// color name ("lightgreen") or a 256-color number ("214")
+func ColorCode(color string) (string, error) {
+	color = strings.ToLower(strings.TrimSpace(color))
+
+	for i, name := range colorNames {
+		if name == color {
+			return fmt.Sprintf("%sF%02d", colorCode, i), nil
+		}
+	}
+
+	if n, err := strconv.Atoi(color); err == nil && n >= 0 && n <= 255 {
+		return fmt.Sprintf("%sF@%05d", colorCode, n), nil
+	}
+
+	return "", fmt.Errorf("unknown color %q", color)
+}
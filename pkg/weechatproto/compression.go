@@ -0,0 +1,92 @@
+package weechatproto
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"strings"
+	"sync"
+)
+
+// Compression is the compression of a frame body, as flagged in the frame
+// header
+type Compression byte
+
+const (
+	CompressionOff  Compression = 0
+	CompressionZlib Compression = 1
+	CompressionZstd Compression = 2
+)
+
+// compressionNames maps the handshake names of the compressions
+var compressionNames = map[string]Compression{
+	"off":       CompressionOff,
+	"zlib":      CompressionZlib,
+	"zstd":      CompressionZstd,
+	"zstandard": CompressionZstd,
+}
+
+// String returns the handshake name of a compression
+func (c Compression) String() string {
+	switch c {
+	case CompressionZlib:
+		return "zlib"
+	case CompressionZstd:
+		return "zstd"
+	}
+	return "off"
+}
+
+// NegotiateCompression picks the first of the colon-separated compressions
+// a client offers in handshake (most preferred first) that is supported,
+// off if there is none
+func NegotiateCompression(offered string) Compression {
+	for _, name := range strings.Split(offered, ":") {
+		if c, ok := compressionNames[strings.ToLower(strings.TrimSpace(name))]; ok {
+			return c
+		}
+	}
+	return CompressionOff
+}
+
+// zlibWriters reuses zlib writers, whose tables are costly to allocate for
+// every frame
+var zlibWriters = sync.Pool{
+	New: func() any { return zlib.NewWriter(nil) },
+}
+
+// compressFrame compresses the body of an encoded frame. The frame is
+// returned unchanged when compression doesn't make it smaller, which the
+// header tells clients.
+func compressFrame(frame []byte, c Compression) []byte {
+	body := frame[5:]
+
+	var compressed []byte
+	switch c {
+	case CompressionZlib:
+		var buf bytes.Buffer
+		zw := zlibWriters.Get().(*zlib.Writer)
+		zw.Reset(&buf)
+		_, err := zw.Write(body)
+		if err == nil {
+			err = zw.Close()
+		}
+		zlibWriters.Put(zw)
+		if err != nil {
+			return frame
+		}
+		compressed = buf.Bytes()
+	case CompressionZstd:
+		compressed = zstdCompress(body)
+	default:
+		return frame
+	}
+	if len(compressed) >= len(body) {
+		return frame
+	}
+
+	out := make([]byte, 0, 5+len(compressed))
+	out = binary.BigEndian.AppendUint32(out, uint32(5+len(compressed)))
+	out = append(out, byte(c))
+	return append(out, compressed...)
+}
@@ -0,0 +1,410 @@
+package weechatproto
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Decoder decodes WeeChat protocol messages (the client side of the relay)
+type Decoder struct {
+	reader io.Reader
+}
+
+// NewDecoder creates a new decoder
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{reader: r}
+}
+
+// DecodeMessage reads and decodes one complete message
+func (d *Decoder) DecodeMessage() (*Message, error) {
+	var totalLen uint32
+	if err := binary.Read(d.reader, binary.BigEndian, &totalLen); err != nil {
+		return nil, err
+	}
+	if totalLen < 5 {
+		return nil, fmt.Errorf("invalid message length: %d", totalLen)
+	}
+
+	var compression byte
+	if err := binary.Read(d.reader, binary.BigEndian, &compression); err != nil {
+		return nil, fmt.Errorf("failed to read compression: %w", err)
+	}
+
+	body := make([]byte, totalLen-5)
+	if _, err := io.ReadFull(d.reader, body); err != nil {
+		return nil, fmt.Errorf("failed to read body: %w", err)
+	}
+
+	switch Compression(compression) {
+	case CompressionOff:
+	case CompressionZlib:
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zlib body: %w", err)
+		}
+		body, err = io.ReadAll(zr)
+		zr.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to inflate body: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported compression: %d", compression)
+	}
+
+	return DecodeBody(body, compression)
+}
+
+// DecodeBody decodes an uncompressed message body (ID followed by objects)
+func DecodeBody(body []byte, compression byte) (*Message, error) {
+	r := bytes.NewReader(body)
+
+	id, err := readString(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode message ID: %w", err)
+	}
+
+	msg := &Message{Compression: compression}
+	if id.Value != nil {
+		msg.ID = *id.Value
+	}
+
+	for r.Len() > 0 {
+		typ, err := readType(r)
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := decodeObject(r, typ)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode object type %s: %w", typ, err)
+		}
+		msg.Data = append(msg.Data, obj)
+	}
+
+	return msg, nil
+}
+
+// readType reads a 3-byte object type
+func readType(r io.Reader) (ObjectType, error) {
+	buf := make([]byte, 3)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", fmt.Errorf("failed to read object type: %w", err)
+	}
+	return ObjectType(buf), nil
+}
+
+// decodeObject decodes a single object of the given type
+func decodeObject(r io.Reader, typ ObjectType) (Object, error) {
+	switch typ {
+	case TypeChar:
+		buf := make([]byte, 1)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return Char{Value: buf[0]}, nil
+
+	case TypeInteger:
+		var v int32
+		if err := binary.Read(r, binary.BigEndian, &v); err != nil {
+			return nil, err
+		}
+		return Integer{Value: v}, nil
+
+	case TypeLong:
+		v, err := readShortNumber(r)
+		if err != nil {
+			return nil, err
+		}
+		return Long{Value: v}, nil
+
+	case TypeString:
+		return readString(r)
+
+	case TypeBuffer:
+		var n int32
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return NullBuffer(), nil
+		}
+		if n == 0 {
+			return Buffer{Value: []byte{}}, nil
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return Buffer{Value: buf}, nil
+
+	case TypePointer:
+		s, err := readShortString(r)
+		if err != nil {
+			return nil, err
+		}
+		return Pointer{Value: s}, nil
+
+	case TypeTime:
+		v, err := readShortNumber(r)
+		if err != nil {
+			return nil, err
+		}
+		return Time{Value: v}, nil
+
+	case TypeHashTable:
+		return readHashTable(r)
+
+	case TypeHData:
+		return readHData(r)
+
+	case TypeInfo:
+		name, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readString(r)
+		if err != nil {
+			return nil, err
+		}
+		return Info{Name: stringValue(name), Value: stringValue(value)}, nil
+
+	case TypeInfoList:
+		return readInfoList(r)
+
+	case TypeArray:
+		return readArray(r)
+
+	default:
+		return nil, fmt.Errorf("unsupported object type: %s", typ)
+	}
+}
+
+// readString reads a length-prefixed string (-1 length = NULL)
+func readString(r io.Reader) (String, error) {
+	var n int32
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return String{}, err
+	}
+	if n < 0 {
+		return NullString(), nil
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return String{}, err
+	}
+	return NewString(string(buf)), nil
+}
+
+// readShortString reads a string prefixed by a 1-byte length (ptr, lon, tim)
+func readShortString(r io.Reader) (string, error) {
+	var n byte
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+// readShortNumber reads a number encoded as a short string
+func readShortNumber(r io.Reader) (int64, error) {
+	s, err := readShortString(r)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// readHashTable reads a hashtable, converting keys and values to strings
+func readHashTable(r io.Reader) (HashTable, error) {
+	keyType, err := readType(r)
+	if err != nil {
+		return HashTable{}, err
+	}
+	valueType, err := readType(r)
+	if err != nil {
+		return HashTable{}, err
+	}
+
+	var count int32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return HashTable{}, err
+	}
+
+	h := HashTable{KeyType: keyType, ValueType: valueType, Count: count}
+	for i := int32(0); i < count; i++ {
+		key, err := decodeObject(r, keyType)
+		if err != nil {
+			return HashTable{}, err
+		}
+		value, err := decodeObject(r, valueType)
+		if err != nil {
+			return HashTable{}, err
+		}
+		h.Keys = append(h.Keys, ObjectString(key))
+		h.Values = append(h.Values, ObjectString(value))
+	}
+
+	return h, nil
+}
+
+// readHData reads an hdata object
+func readHData(r io.Reader) (HData, error) {
+	path, err := readString(r)
+	if err != nil {
+		return HData{}, err
+	}
+	keys, err := readString(r)
+	if err != nil {
+		return HData{}, err
+	}
+
+	var count int32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return HData{}, err
+	}
+
+	h := HData{Path: stringValue(path), Keys: stringValue(keys), Count: count}
+
+	// One pointer per path element
+	pathLen := len(strings.Split(h.Path, "/"))
+
+	type keyDef struct {
+		name string
+		typ  ObjectType
+	}
+	var keyDefs []keyDef
+	if h.Keys != "" {
+		for _, field := range strings.Split(h.Keys, ",") {
+			parts := strings.SplitN(field, ":", 2)
+			if len(parts) != 2 {
+				return HData{}, fmt.Errorf("invalid hdata key: %s", field)
+			}
+			keyDefs = append(keyDefs, keyDef{name: parts[0], typ: ObjectType(parts[1])})
+		}
+	}
+
+	for i := int32(0); i < count; i++ {
+		item := HDataItem{Objects: make(map[string]Object, len(keyDefs))}
+		for p := 0; p < pathLen; p++ {
+			ptr, err := readShortString(r)
+			if err != nil {
+				return HData{}, err
+			}
+			item.Pointers = append(item.Pointers, ptr)
+		}
+		for _, kd := range keyDefs {
+			obj, err := decodeObject(r, kd.typ)
+			if err != nil {
+				return HData{}, fmt.Errorf("failed to decode field %s: %w", kd.name, err)
+			}
+			item.Objects[kd.name] = obj
+		}
+		h.Items = append(h.Items, item)
+	}
+
+	return h, nil
+}
+
+// readInfoList reads an infolist object
+func readInfoList(r io.Reader) (InfoList, error) {
+	name, err := readString(r)
+	if err != nil {
+		return InfoList{}, err
+	}
+
+	var count int32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return InfoList{}, err
+	}
+
+	l := InfoList{Name: stringValue(name)}
+	for i := int32(0); i < count; i++ {
+		var vars int32
+		if err := binary.Read(r, binary.BigEndian, &vars); err != nil {
+			return InfoList{}, err
+		}
+		var item InfoListItem
+		for v := int32(0); v < vars; v++ {
+			varName, err := readString(r)
+			if err != nil {
+				return InfoList{}, err
+			}
+			typ, err := readType(r)
+			if err != nil {
+				return InfoList{}, err
+			}
+			value, err := decodeObject(r, typ)
+			if err != nil {
+				return InfoList{}, fmt.Errorf("failed to decode variable %s: %w", stringValue(varName), err)
+			}
+			item.Vars = append(item.Vars, InfoListVar{Name: stringValue(varName), Value: value})
+		}
+		l.Items = append(l.Items, item)
+	}
+
+	return l, nil
+}
+
+// readArray reads an array object
+func readArray(r io.Reader) (Array, error) {
+	typ, err := readType(r)
+	if err != nil {
+		return Array{}, err
+	}
+
+	var count int32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return Array{}, err
+	}
+
+	a := Array{ElemType: typ}
+	for i := int32(0); i < count; i++ {
+		v, err := decodeObject(r, typ)
+		if err != nil {
+			return Array{}, err
+		}
+		a.Values = append(a.Values, v)
+	}
+
+	return a, nil
+}
+
+// stringValue returns the string value, or "" for NULL
+func stringValue(s String) string {
+	if s.Value == nil {
+		return ""
+	}
+	return *s.Value
+}
+
+// ObjectString renders a scalar object as a string (for logs and hashtables)
+func ObjectString(obj Object) string {
+	switch o := obj.(type) {
+	case Char:
+		return string(o.Value)
+	case Integer:
+		return strconv.Itoa(int(o.Value))
+	case Long:
+		return strconv.FormatInt(o.Value, 10)
+	case String:
+		return stringValue(o)
+	case Buffer:
+		return string(o.Value)
+	case Pointer:
+		return o.Value
+	case Time:
+		return strconv.FormatInt(o.Value, 10)
+	case Info:
+		return o.Value
+	default:
+		return fmt.Sprintf("<%s>", obj.Type())
+	}
+}
@@ -5,11 +5,23 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 )
 
 // Encoder encodes WeeChat protocol messages
 type Encoder struct {
-	writer io.Writer
+	writer      io.Writer
+	compression Compression
+	minCompress int // Smallest body compressed
+	last        FrameInfo
+}
+
+// FrameInfo describes a frame written by an encoder
+type FrameInfo struct {
+	Size       int  // Bytes uncompressed
+	Sent       int  // Bytes written
+	Compressed bool // The body was sent compressed
 }
 
 // NewEncoder creates a new encoder
@@ -17,14 +29,73 @@ func NewEncoder(w io.Writer) *Encoder {
 	return &Encoder{writer: w}
 }
 
-// EncodeMessage encodes a complete WeeChat message
+// SetCompression compresses the bodies of the following frames (off by
+// default)
+func (e *Encoder) SetCompression(c Compression) {
+	e.compression = c
+}
+
+// SetCompressionMinSize leaves frames whose body is smaller than n bytes
+// uncompressed: for tiny messages the compression header and the work
+// outweigh the savings
+func (e *Encoder) SetCompressionMinSize(n int) {
+	e.minCompress = n
+}
+
+// LastFrame describes the last frame written by EncodeMessage
+func (e *Encoder) LastFrame() FrameInfo {
+	return e.last
+}
+
+// WriteError is returned by EncodeMessage when the frame could not be fully
+// written to the underlying stream. Written bytes may already have reached the
+// peer, so the stream must be considered corrupted.
+type WriteError struct {
+	Written int
+	Err     error
+}
+
+func (e *WriteError) Error() string {
+	return fmt.Sprintf("failed to write message (%d bytes written): %v", e.Written, e.Err)
+}
+
+func (e *WriteError) Unwrap() error { return e.Err }
+
+// EncodeMessage encodes a complete WeeChat message.
+// The whole frame is built in memory and written with a single Write, so an
+// encoding failure never leaves a partial frame on the stream. Write failures
+// are reported as *WriteError.
 func (e *Encoder) EncodeMessage(msg *Message) error {
+	frame, err := EncodeFrame(msg)
+	if err != nil {
+		return err
+	}
+	size := len(frame)
+	if e.compression != CompressionOff && size-5 >= e.minCompress {
+		frame = compressFrame(frame, e.compression)
+	}
+	e.last = FrameInfo{Size: size, Sent: len(frame), Compressed: len(frame) != size}
+
+	n, err := e.writer.Write(frame)
+	if err == nil && n < len(frame) {
+		err = io.ErrShortWrite
+	}
+	if err != nil {
+		return &WriteError{Written: n, Err: err}
+	}
+
+	return nil
+}
+
+// EncodeFrame encodes a message into a complete uncompressed frame (length,
+// compression, body)
+func EncodeFrame(msg *Message) ([]byte, error) {
 	// Build message body first to calculate length
 	bodyBuf := &bytes.Buffer{}
 
 	// Write message ID (string)
 	if err := NewString(msg.ID).Encode(bodyBuf); err != nil {
-		return fmt.Errorf("failed to encode message ID: %w", err)
+		return nil, fmt.Errorf("failed to encode message ID: %w", err)
 	}
 
 	// Write objects
@@ -32,15 +103,15 @@ func (e *Encoder) EncodeMessage(msg *Message) error {
 		// Write type (3 bytes)
 		typeStr := string(obj.Type())
 		if len(typeStr) != 3 {
-			return fmt.Errorf("invalid object type: %s (must be 3 chars)", typeStr)
+			return nil, fmt.Errorf("invalid object type: %s (must be 3 chars)", typeStr)
 		}
 		if _, err := bodyBuf.Write([]byte(typeStr)); err != nil {
-			return err
+			return nil, err
 		}
 
 		// Write object data
 		if err := obj.Encode(bodyBuf); err != nil {
-			return fmt.Errorf("failed to encode object type %s: %w", typeStr, err)
+			return nil, fmt.Errorf("failed to encode object type %s: %w", typeStr, err)
 		}
 	}
 
@@ -49,26 +120,40 @@ func (e *Encoder) EncodeMessage(msg *Message) error {
 	// Calculate total length: 4 (length) + 1 (compression) + len(body)
 	totalLen := uint32(4 + 1 + len(body))
 
-	// Write length (4 bytes, big endian)
-	if err := binary.Write(e.writer, binary.BigEndian, totalLen); err != nil {
-		return fmt.Errorf("failed to write length: %w", err)
-	}
+	frame := make([]byte, 0, totalLen)
 
-	// Write compression (1 byte, 0 = none)
-	if err := binary.Write(e.writer, binary.BigEndian, msg.Compression); err != nil {
-		return fmt.Errorf("failed to write compression: %w", err)
-	}
+	// Length (4 bytes, big endian)
+	frame = binary.BigEndian.AppendUint32(frame, totalLen)
 
-	// Write body
-	if _, err := e.writer.Write(body); err != nil {
-		return fmt.Errorf("failed to write body: %w", err)
-	}
+	// Compression (1 byte, 0 = none)
+	frame = append(frame, byte(CompressionOff))
 
-	return nil
+	// Body
+	frame = append(frame, body...)
+
+	return frame, nil
+}
+
+// Handshake is what the relay chose in a handshake
+type Handshake struct {
+	PasswordHashAlgo       string
+	PasswordHashIterations int         // For the pbkdf2+ algorithms
+	TOTP                   bool        // init needs a one-time password
+	Nonce                  string      // Salt of hashed passwords
+	Compression            Compression // Of the following frames
+	EscapeCommands         bool        // Commands are backslash-escaped
+}
+
+// onOff formats a boolean handshake option
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
 }
 
 // CreateHandshakeResponse creates a handshake response message
-func CreateHandshakeResponse(id string, passwordHashAlgo string, nonce string) *Message {
+func CreateHandshakeResponse(id string, hs Handshake) *Message {
 	return &Message{
 		ID:          id,
 		Compression: 0,
@@ -86,18 +171,111 @@ func CreateHandshakeResponse(id string, passwordHashAlgo string, nonce string) *
 					"escape_commands",
 				},
 				Values: []string{
-					passwordHashAlgo,
-					"100000",
-					"off",
-					nonce,
-					"off",
-					"off",
+					hs.PasswordHashAlgo,
+					strconv.Itoa(hs.PasswordHashIterations),
+					onOff(hs.TOTP),
+					hs.Nonce,
+					hs.Compression.String(),
+					onOff(hs.EscapeCommands),
 				},
 			},
 		},
 	}
 }
 
+// CreateErrorMessage creates an error reply for a rejected command.
+// WeeChat has no error object, so this is an info named "error"; the message
+// ID is the one of the rejected command (or "_error" if it had none).
+func CreateErrorMessage(id string, text string) *Message {
+	if id == "" {
+		id = "_error"
+	}
+
+	return &Message{
+		ID:          id,
+		Compression: 0,
+		Data: []Object{
+			Info{Name: "error", Value: text},
+		},
+	}
+}
+
+// CreateEventMessage creates an event carrying no objects, like the
+// _upgrade and _upgrade_ended WeeChat sends around /upgrade
+func CreateEventMessage(id string) *Message {
+	return &Message{ID: id}
+}
+
+// CreatePongMessage creates the reply to ping: a _pong event carrying the
+// arguments of the ping, like WeeChat
+func CreatePongMessage(arguments string) *Message {
+	return &Message{ID: "_pong", Data: []Object{NewString(arguments)}}
+}
+
+// CreateInfoWithID creates the reply to an info request
+func CreateInfoWithID(name, value, id string) *Message {
+	return &Message{
+		ID:          id,
+		Compression: 0,
+		Data: []Object{
+			Info{Name: name, Value: value},
+		},
+	}
+}
+
+// CreateTestMessage creates the reply to the test command: one object of
+// every type with fixed values, the ones of WeeChat first (chr through
+// arr), so client decoders can be checked against a known message
+func CreateTestMessage(id string) *Message {
+	return &Message{
+		ID:          id,
+		Compression: 0,
+		Data: []Object{
+			Char{Value: 'A'},
+			Integer{Value: 123456},
+			Integer{Value: -123456},
+			Long{Value: 1234567890},
+			Long{Value: -1234567890},
+			NewString("a string"),
+			NewString(""),
+			NullString(),
+			Buffer{Value: []byte("buffer")},
+			NullBuffer(),
+			Pointer{Value: "0x1234abcd"},
+			Pointer{Value: "0x0"},
+			Time{Value: 1321993456},
+			Array{ElemType: TypeString, Values: []Object{NewString("abc"), NewString("de")}},
+			Array{ElemType: TypeInteger, Values: []Object{Integer{Value: 123}, Integer{Value: 456}, Integer{Value: 789}}},
+			HashTable{
+				KeyType:   TypeString,
+				ValueType: TypeString,
+				Count:     2,
+				Keys:      []string{"key1", "key2"},
+				Values:    []string{"value1", "value2"},
+			},
+			HData{
+				Path:  "test",
+				Keys:  "number:int,name:str",
+				Count: 2,
+				Items: []HDataItem{
+					{Pointers: []string{"0x1"}, Objects: map[string]Object{"number": Integer{Value: 1}, "name": NewString("one")}},
+					{Pointers: []string{"0x2"}, Objects: map[string]Object{"number": Integer{Value: 2}, "name": NewString("two")}},
+				},
+			},
+			Info{Name: "name", Value: "value"},
+			InfoList{
+				Name: "test",
+				Items: []InfoListItem{{Vars: []InfoListVar{
+					{"integer", Integer{Value: 123}},
+					{"string", NewString("abc")},
+					{"pointer", Pointer{Value: "0x1234abcd"}},
+					{"time", Time{Value: 1321993456}},
+				}}},
+			},
+		},
+	}
+}
+
 // CreateBuffersHData creates HData for buffer list
 // id can be empty for responses to hdata requests, or "_buffer_opened" for broadcasts
 func CreateBuffersHData(buffers []BufferData) *Message {
@@ -112,12 +290,13 @@ func CreateBuffersHDataWithID(buffers []BufferData, id string) *Message {
 		items[i] = HDataItem{
 			Pointers: []string{buf.Pointer},
 			Objects: map[string]Object{
-				"number":           Integer{Value: buf.Number},
-				"name":             NewString(buf.Name),
-				"short_name":       NewString(buf.ShortName),
-				"hidden":           Integer{Value: boolToInt(buf.Hidden)},
-				"title":            NewString(buf.Title),
-				"local_variables":  NewString(buf.LocalVariables),
+				"number":          Integer{Value: buf.Number},
+				"name":            NewString(buf.Name),
+				"short_name":      NewString(buf.ShortName),
+				"hidden":          Integer{Value: boolToInt(buf.Hidden)},
+				"title":           NewString(buf.Title),
+				"nicklist":        Integer{Value: boolToInt(buf.Nicklist)},
+				"local_variables": NewString(buf.LocalVariables),
 			},
 		}
 	}
@@ -128,7 +307,7 @@ func CreateBuffersHDataWithID(buffers []BufferData, id string) *Message {
 		Data: []Object{
 			HData{
 				Path:  "buffer",
-				Keys:  "number:int,name:str,short_name:str,hidden:int,title:str,local_variables:str",
+				Keys:  "number:int,name:str,short_name:str,hidden:int,title:str,nicklist:int,local_variables:str",
 				Count: int32(len(items)),
 				Items: items,
 			},
@@ -143,15 +322,169 @@ func CreateEmptyHotlist() *Message {
 
 // CreateEmptyHotlistWithID creates an empty hotlist HData response with custom message ID
 func CreateEmptyHotlistWithID(id string) *Message {
+	return CreateHotlistHDataWithID(nil, id)
+}
+
+// HotlistData is a hotlist entry: a buffer with unread lines
+type HotlistData struct {
+	Pointer   string
+	BufferPtr string
+	Priority  int32   // Highest priority of the lines (0 low .. 3 highlight)
+	Since     int64   // When the first unread line arrived
+	Count     []int32 // Unread lines per priority
+}
+
+// CreateHotlistHDataWithID creates HData for hotlist entries with custom
+// message ID, with the fields of WeeChat's hotlist hdata
+func CreateHotlistHDataWithID(entries []HotlistData, id string) *Message {
+	items := make([]HDataItem, len(entries))
+
+	for i, entry := range entries {
+		count := Array{ElemType: TypeInteger, Values: make([]Object, len(entry.Count))}
+		for j, n := range entry.Count {
+			count.Values[j] = Integer{Value: n}
+		}
+
+		items[i] = HDataItem{
+			Pointers: []string{entry.Pointer},
+			Objects: map[string]Object{
+				"priority":              Integer{Value: entry.Priority},
+				"creation_time.tv_sec":  Time{Value: entry.Since},
+				"creation_time.tv_usec": Long{Value: 0},
+				"buffer":                Pointer{Value: entry.BufferPtr},
+				"count":                 count,
+			},
+		}
+	}
+
 	return &Message{
 		ID:          id,
 		Compression: 0,
 		Data: []Object{
 			HData{
 				Path:  "hotlist",
-				Keys:  "priority:int,date:tim,date_printed:tim,buffer:ptr,count:int",
-				Count: 0,              // Empty hotlist
-				Items: []HDataItem{}, // No items
+				Keys:  "priority:int,creation_time.tv_sec:tim,creation_time.tv_usec:lon,buffer:ptr,count:arr",
+				Count: int32(len(items)),
+				Items: items,
+			},
+		},
+	}
+}
+
+// CreateInfoListWithID creates the reply to an infolist request
+func CreateInfoListWithID(name string, items []InfoListItem, id string) *Message {
+	return &Message{
+		ID:          id,
+		Compression: 0,
+		Data: []Object{
+			InfoList{Name: name, Items: items},
+		},
+	}
+}
+
+// CreateBuffersInfoListWithID creates the buffer infolist. Local variables
+// are numbered variables, as WeeChat sends them.
+func CreateBuffersInfoListWithID(buffers []BufferData, id string) *Message {
+	items := make([]InfoListItem, len(buffers))
+
+	for i, buf := range buffers {
+		vars := []InfoListVar{
+			{"pointer", Pointer{Value: buf.Pointer}},
+			{"number", Integer{Value: buf.Number}},
+			{"name", NewString(buf.Name)},
+			{"short_name", NewString(buf.ShortName)},
+			{"hidden", Integer{Value: boolToInt(buf.Hidden)}},
+			{"title", NewString(buf.Title)},
+			{"nicklist", Integer{Value: boolToInt(buf.Nicklist)}},
+		}
+		if buf.LocalVariables != "" {
+			for n, pair := range strings.Split(buf.LocalVariables, ",") {
+				name, value, _ := strings.Cut(pair, "=")
+				vars = append(vars,
+					InfoListVar{fmt.Sprintf("localvar_name_%05d", n), NewString(name)},
+					InfoListVar{fmt.Sprintf("localvar_value_%05d", n), NewString(value)})
+			}
+		}
+		items[i] = InfoListItem{Vars: vars}
+	}
+
+	return CreateInfoListWithID("buffer", items, id)
+}
+
+// CreateLinesInfoListWithID creates the buffer_lines infolist. Tags are
+// numbered variables, as WeeChat sends them.
+func CreateLinesInfoListWithID(lines []LineData, id string) *Message {
+	items := make([]InfoListItem, len(lines))
+
+	for i, line := range lines {
+		var tags []string
+		if line.Tags != "" {
+			tags = strings.Split(line.Tags, ",")
+		}
+		vars := []InfoListVar{
+			{"date", Time{Value: line.Date}},
+			{"date_printed", Time{Value: line.DatePrinted}},
+			{"displayed", Integer{Value: boolToInt(line.Displayed)}},
+			{"highlight", Integer{Value: boolToInt(line.Highlight)}},
+			{"tags_count", Integer{Value: int32(len(tags))}},
+		}
+		for n, tag := range tags {
+			vars = append(vars, InfoListVar{fmt.Sprintf("tag_%05d", n), NewString(tag)})
+		}
+		vars = append(vars,
+			InfoListVar{"prefix", NewString(line.Prefix)},
+			InfoListVar{"message", NewString(line.Message)})
+		items[i] = InfoListItem{Vars: vars}
+	}
+
+	return CreateInfoListWithID("buffer_lines", items, id)
+}
+
+// CompletionData is the result of completing a word of input
+type CompletionData struct {
+	Pointer  string
+	Context  string // "auto", "command" or "command_arg"
+	BaseWord string
+	PosStart int // First character of the word
+	PosEnd   int // Last character of the word (PosStart-1 for an empty word)
+	AddSpace bool
+	List     []string
+}
+
+// completionKeys are the fields of a completion
+const completionKeys = "context:str,base_word:str,pos_start:int,pos_end:int,add_space:int,list:arr"
+
+// CreateCompletionHDataWithID creates the reply to a completion command,
+// without items for a nil completion (no such buffer)
+func CreateCompletionHDataWithID(c *CompletionData, id string) *Message {
+	var items []HDataItem
+	if c != nil {
+		list := make([]Object, len(c.List))
+		for i, word := range c.List {
+			list[i] = NewString(word)
+		}
+		items = append(items, HDataItem{
+			Pointers: []string{c.Pointer},
+			Objects: map[string]Object{
+				"context":   NewString(c.Context),
+				"base_word": NewString(c.BaseWord),
+				"pos_start": Integer{Value: int32(c.PosStart)},
+				"pos_end":   Integer{Value: int32(c.PosEnd)},
+				"add_space": Integer{Value: boolToInt(c.AddSpace)},
+				"list":      Array{ElemType: TypeString, Values: list},
+			},
+		})
+	}
+
+	return &Message{
+		ID:          id,
+		Compression: 0,
+		Data: []Object{
+			HData{
+				Path:  "completion",
+				Keys:  completionKeys,
+				Count: int32(len(items)),
+				Items: items,
 			},
 		},
 	}
@@ -165,6 +498,7 @@ type BufferData struct {
 	ShortName      string
 	Hidden         bool
 	Title          string
+	Nicklist       bool // Buffer has a nicklist the client may request
 	LocalVariables string
 }
 
@@ -209,42 +543,74 @@ func CreateLinesHDataWithID(lines []LineData, id string) *Message {
 
 // LineData represents a buffer line
 type LineData struct {
-	Pointer      string
-	BufferPtr    string
-	Date         int64
-	DatePrinted  int64
-	Displayed    bool
-	Highlight    bool
-	Tags         string
-	Prefix       string
-	Message      string
+	Pointer     string
+	BufferPtr   string
+	Date        int64
+	DatePrinted int64
+	Displayed   bool
+	Highlight   bool
+	Tags        string
+	Prefix      string
+	Message     string
+}
+
+// BufferNicklist is the nicklist of one buffer
+type BufferNicklist struct {
+	BufferPtr string
+	Nicks     []NickData
+}
+
+// CreateBuffersNicklistHDataWithID creates HData for the nicklists of
+// several buffers (a nicklist request without buffer). Items carry the
+// buffer and nick pointers, like WeeChat's buffer/nicklist_item path.
+func CreateBuffersNicklistHDataWithID(nicklists []BufferNicklist, id string) *Message {
+	var items []HDataItem
+	for _, nicklist := range nicklists {
+		for _, nick := range nicklist.Nicks {
+			items = append(items, HDataItem{
+				Pointers: []string{nicklist.BufferPtr, nick.Pointer},
+				Objects:  nickObjects(nick),
+			})
+		}
+	}
+
+	return &Message{
+		ID:          id,
+		Compression: 0,
+		Data: []Object{
+			HData{
+				Path:  "buffer/nicklist_item",
+				Keys:  nicklistKeys,
+				Count: int32(len(items)),
+				Items: items,
+			},
+		},
+	}
 }
 
 // CreateNicklistHData creates HData for nicklist
 func CreateNicklistHData(nicks []NickData) *Message {
+	return CreateNicklistHDataWithID(nicks, "")
+}
+
+// CreateNicklistHDataWithID creates HData for nicklist with custom message ID
+func CreateNicklistHDataWithID(nicks []NickData, id string) *Message {
 	items := make([]HDataItem, len(nicks))
 
 	for i, nick := range nicks {
 		items[i] = HDataItem{
 			Pointers: []string{nick.Pointer},
-			Objects: map[string]Object{
-				"group":  Integer{Value: boolToInt(nick.IsGroup)},
-				"visible": Integer{Value: boolToInt(nick.Visible)},
-				"name":   NewString(nick.Name),
-				"color":  NewString(nick.Color),
-				"prefix": NewString(nick.Prefix),
-				"prefix_color": NewString(nick.PrefixColor),
-			},
+			Objects:  nickObjects(nick),
 		}
 	}
 
 	return &Message{
-		ID:          "",
+		ID:          id,
 		Compression: 0,
 		Data: []Object{
 			HData{
 				Path:  "nicklist_item",
-				Keys:  "group:int,visible:int,name:str,color:str,prefix:str,prefix_color:str",
+				Keys:  nicklistKeys,
 				Count: int32(len(items)),
 				Items: items,
 			},
@@ -254,13 +620,28 @@ func CreateNicklistHData(nicks []NickData) *Message {
 
 // NickData represents a nick in nicklist
 type NickData struct {
-	Pointer      string
-	IsGroup      bool
-	Visible      bool
-	Name         string
-	Color        string
-	Prefix       string
-	PrefixColor  string
+	Pointer     string
+	IsGroup     bool
+	Visible     bool
+	Name        string
+	Color       string
+	Prefix      string
+	PrefixColor string
+}
+
+// nicklistKeys are the fields of a nicklist item
+const nicklistKeys = "group:int,visible:int,name:str,color:str,prefix:str,prefix_color:str"
+
+// nickObjects returns the fields of a nicklist item
+func nickObjects(nick NickData) map[string]Object {
+	return map[string]Object{
+		"group":        Integer{Value: boolToInt(nick.IsGroup)},
+		"visible":      Integer{Value: boolToInt(nick.Visible)},
+		"name":         NewString(nick.Name),
+		"color":        NewString(nick.Color),
+		"prefix":       NewString(nick.Prefix),
+		"prefix_color": NewString(nick.PrefixColor),
+	}
 }
 
 // Helper function to convert bool to int
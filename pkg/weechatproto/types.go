@@ -106,11 +106,14 @@ func NullString() String {
 
 // Buffer represents binary data
 type Buffer struct {
-	Value []byte
+	Value []byte // nil for NULL buffers
 }
 
 func (b Buffer) Type() ObjectType { return TypeBuffer }
 func (b Buffer) Encode(w io.Writer) error {
+	if b.Value == nil {
+		return binary.Write(w, binary.BigEndian, int32(-1))
+	}
 	if err := binary.Write(w, binary.BigEndian, int32(len(b.Value))); err != nil {
 		return err
 	}
@@ -121,6 +124,11 @@ func (b Buffer) Encode(w io.Writer) error {
 	return nil
 }
 
+// NullBuffer creates a NULL buffer
+func NullBuffer() Buffer {
+	return Buffer{Value: nil}
+}
+
 // Pointer represents a pointer (hex string)
 type Pointer struct {
 	Value string
@@ -273,3 +281,74 @@ func (i Info) Encode(w io.Writer) error {
 	}
 	return NewString(i.Value).Encode(w)
 }
+
+// InfoListVar is one variable of an infolist item
+type InfoListVar struct {
+	Name  string
+	Value Object
+}
+
+// InfoListItem represents one item in an InfoList
+type InfoListItem struct {
+	Vars []InfoListVar
+}
+
+// InfoList represents a list of items with named, typed variables (the
+// older alternative to HData)
+type InfoList struct {
+	Name  string
+	Items []InfoListItem
+}
+
+func (l InfoList) Type() ObjectType { return TypeInfoList }
+func (l InfoList) Encode(w io.Writer) error {
+	if err := NewString(l.Name).Encode(w); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, int32(len(l.Items))); err != nil {
+		return err
+	}
+	for _, item := range l.Items {
+		if err := binary.Write(w, binary.BigEndian, int32(len(item.Vars))); err != nil {
+			return err
+		}
+		// Each variable: name, type, value
+		for _, v := range item.Vars {
+			if err := NewString(v.Name).Encode(w); err != nil {
+				return err
+			}
+			if _, err := w.Write([]byte(v.Value.Type())); err != nil {
+				return err
+			}
+			if err := v.Value.Encode(w); err != nil {
+				return fmt.Errorf("failed to encode variable %s: %w", v.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Array represents an array of objects of one type
+type Array struct {
+	ElemType ObjectType
+	Values   []Object
+}
+
+func (a Array) Type() ObjectType { return TypeArray }
+func (a Array) Encode(w io.Writer) error {
+	if _, err := w.Write([]byte(a.ElemType)); err != nil {
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, int32(len(a.Values))); err != nil {
+		return err
+	}
+	for i, v := range a.Values {
+		if v.Type() != a.ElemType {
+			return fmt.Errorf("array of %s has a %s at %d", a.ElemType, v.Type(), i)
+		}
+		if err := v.Encode(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package weechatproto
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// A small Zstandard (RFC 8878) compressor for relay frames. Matches are
+// found with a hash table and coded with the predefined FSE tables, and
+// literals are stored raw: most of zstd's gain on the repetitive relay
+// traffic (nicks, tags, pointers) without a dependency.
+
+const (
+	zstdMagic    = 0xFD2FB528
+	zstdMaxBlock = 128 << 10
+	zstdMinMatch = 4
+
+	zstdBlockRaw        = 0
+	zstdBlockCompressed = 2
+)
+
+// Literal length and match length codes: baseline and number of extra bits
+var (
+	zstdLLBase = [36]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLLBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	zstdMLBase = [53]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMLBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// Predefined FSE distributions of the sequence codes
+var (
+	zstdLLTable = newFSETable([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}, 6)
+	zstdMLTable = newFSETable([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}, 6)
+	zstdOFTable = newFSETable([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}, 5)
+)
+
+// fseTable is the encoding table of an FSE distribution
+type fseTable struct {
+	tableLog   uint
+	stateTable []uint16
+	symbols    []fseSymbol
+}
+
+type fseSymbol struct {
+	deltaFindState int
+	deltaNbBits    uint32
+}
+
+// newFSETable builds the encoding table of a normalized distribution
+// (-1 = "less than one"), spreading symbols like the decoder does
+func newFSETable(norm []int16, tableLog uint) *fseTable {
+	tableSize := 1 << tableLog
+	mask := tableSize - 1
+	step := tableSize>>1 + tableSize>>3 + 3
+
+	// Low probability symbols go to the end of the table, the others are
+	// spread over the rest
+	spread := make([]int, tableSize)
+	cumul := make([]int, len(norm)+1)
+	high := tableSize - 1
+	for s, n := range norm {
+		if n == -1 {
+			spread[high] = s
+			high--
+			cumul[s+1] = cumul[s] + 1
+		} else {
+			cumul[s+1] = cumul[s] + int(n)
+		}
+	}
+	pos := 0
+	for s, n := range norm {
+		for i := 0; i < int(n); i++ {
+			spread[pos] = s
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+
+	t := &fseTable{
+		tableLog:   tableLog,
+		stateTable: make([]uint16, tableSize),
+		symbols:    make([]fseSymbol, len(norm)),
+	}
+	for u, s := range spread {
+		t.stateTable[cumul[s]] = uint16(tableSize + u)
+		cumul[s]++
+	}
+
+	total := 0
+	for s, n := range norm {
+		switch n {
+		case 0:
+		case -1, 1:
+			t.symbols[s] = fseSymbol{deltaFindState: total - 1, deltaNbBits: uint32(int(tableLog<<16) - tableSize)}
+			total++
+		default:
+			maxBitsOut := tableLog - uint(bits.Len(uint(n-1))-1)
+			minStatePlus := int(n) << maxBitsOut
+			t.symbols[s] = fseSymbol{deltaFindState: total - int(n), deltaNbBits: uint32(int(maxBitsOut<<16) - minStatePlus)}
+			total += int(n)
+		}
+	}
+	return t
+}
+
+// fseState encodes symbols with an fseTable
+type fseState struct {
+	t     *fseTable
+	state uint32
+}
+
+// init starts with the last symbol, which costs no bits
+func (f *fseState) init(t *fseTable, symbol uint8) {
+	f.t = t
+	tt := t.symbols[symbol]
+	nbBitsOut := (tt.deltaNbBits + 1<<15) >> 16
+	value := nbBitsOut<<16 - tt.deltaNbBits
+	f.state = uint32(t.stateTable[int(value>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (f *fseState) encode(w *zstdBitWriter, symbol uint8) {
+	tt := f.t.symbols[symbol]
+	nbBitsOut := (f.state + tt.deltaNbBits) >> 16
+	w.addBits(uint64(f.state), uint(nbBitsOut))
+	f.state = uint32(f.t.stateTable[int(f.state>>nbBitsOut)+tt.deltaFindState])
+}
+
+func (f *fseState) flush(w *zstdBitWriter) {
+	w.addBits(uint64(f.state), f.t.tableLog)
+}
+
+// zstdBitWriter writes the little-endian bit stream that the decoder reads
+// backwards
+type zstdBitWriter struct {
+	out   []byte
+	bits  uint64
+	nbits uint
+}
+
+func (w *zstdBitWriter) addBits(value uint64, n uint) {
+	w.bits |= (value & (1<<n - 1)) << w.nbits
+	w.nbits += n
+	for w.nbits >= 8 {
+		w.out = append(w.out, byte(w.bits))
+		w.bits >>= 8
+		w.nbits -= 8
+	}
+}
+
+// close ends the stream with a 1 bit marking where it starts
+func (w *zstdBitWriter) close() []byte {
+	w.addBits(1, 1)
+	if w.nbits > 0 {
+		w.out = append(w.out, byte(w.bits))
+	}
+	return w.out
+}
+
+// zstdSequence is a run of literals followed by a match
+type zstdSequence struct {
+	litLen, matchLen, offset uint32
+}
+
+// zstdCompress compresses src into a single zstd frame
+func zstdCompress(src []byte) []byte {
+	dst := binary.LittleEndian.AppendUint32(nil, zstdMagic)
+
+	// Single segment frame: the window is the content, whose size follows
+	switch size := len(src); {
+	case size < 256:
+		dst = append(dst, 0<<6|1<<5, byte(size))
+	case size < 65536+256:
+		dst = append(dst, 1<<6|1<<5)
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(size-256))
+	default:
+		dst = append(dst, 2<<6|1<<5)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(size))
+	}
+
+	if len(src) == 0 {
+		return appendZstdBlockHeader(dst, true, zstdBlockRaw, 0)
+	}
+
+	m := newZstdMatcher(src)
+	for start := 0; start < len(src); start += zstdMaxBlock {
+		end := min(start+zstdMaxBlock, len(src))
+		dst = m.appendBlock(dst, start, end, end == len(src))
+	}
+	return dst
+}
+
+func appendZstdBlockHeader(dst []byte, last bool, blockType, size int) []byte {
+	header := blockType<<1 | size<<3
+	if last {
+		header |= 1
+	}
+	return append(dst, byte(header), byte(header>>8), byte(header>>16))
+}
+
+// zstdMatcher finds matches anywhere earlier in the frame
+type zstdMatcher struct {
+	src     []byte
+	table   []int32 // Last position+1 of each hash
+	hashLog uint
+}
+
+func newZstdMatcher(src []byte) *zstdMatcher {
+	hashLog := uint(min(max(bits.Len(uint(len(src))), 8), 16))
+	return &zstdMatcher{src: src, table: make([]int32, 1<<hashLog), hashLog: hashLog}
+}
+
+func (m *zstdMatcher) hash(pos int) uint32 {
+	return binary.LittleEndian.Uint32(m.src[pos:]) * 2654435761 >> (32 - m.hashLog)
+}
+
+// appendBlock compresses src[start:end] into a block, or stores it raw if
+// that is smaller
+func (m *zstdMatcher) appendBlock(dst []byte, start, end int, last bool) []byte {
+	var seqs []zstdSequence
+	var literals []byte
+
+	litStart := start
+	for pos := start; pos+zstdMinMatch <= end; {
+		h := m.hash(pos)
+		cand := int(m.table[h]) - 1
+		m.table[h] = int32(pos + 1)
+
+		if cand < 0 || binary.LittleEndian.Uint32(m.src[cand:]) != binary.LittleEndian.Uint32(m.src[pos:]) {
+			pos++
+			continue
+		}
+
+		length := zstdMinMatch
+		for pos+length < end && m.src[cand+length] == m.src[pos+length] {
+			length++
+		}
+		literals = append(literals, m.src[litStart:pos]...)
+		seqs = append(seqs, zstdSequence{litLen: uint32(pos - litStart), matchLen: uint32(length), offset: uint32(pos - cand)})
+
+		for i := pos + 1; i < pos+length && i+4 <= len(m.src); i++ {
+			m.table[m.hash(i)] = int32(i + 1)
+		}
+		pos += length
+		litStart = pos
+	}
+	literals = append(literals, m.src[litStart:end]...)
+
+	block := appendZstdLiterals(nil, literals)
+	block = appendZstdSequences(block, seqs)
+	if len(seqs) == 0 || len(block) >= end-start {
+		dst = appendZstdBlockHeader(dst, last, zstdBlockRaw, end-start)
+		return append(dst, m.src[start:end]...)
+	}
+	dst = appendZstdBlockHeader(dst, last, zstdBlockCompressed, len(block))
+	return append(dst, block...)
+}
+
+// appendZstdLiterals appends a raw literals section
+func appendZstdLiterals(dst, literals []byte) []byte {
+	switch n := len(literals); {
+	case n < 32:
+		dst = append(dst, byte(n<<3))
+	case n < 4096:
+		dst = append(dst, byte(1<<2|n<<4), byte(n>>4))
+	default:
+		dst = append(dst, byte(3<<2|n<<4), byte(n>>4), byte(n>>12))
+	}
+	return append(dst, literals...)
+}
+
+// appendZstdSequences appends a sequences section coded with the
+// predefined tables
+func appendZstdSequences(dst []byte, seqs []zstdSequence) []byte {
+	switch n := len(seqs); {
+	case n < 128:
+		dst = append(dst, byte(n))
+	case n < 0x7f00:
+		dst = append(dst, byte(n>>8+128), byte(n))
+	default:
+		dst = append(dst, 255, byte(n-0x7f00), byte((n-0x7f00)>>8))
+	}
+	if len(seqs) == 0 {
+		return dst
+	}
+	dst = append(dst, 0) // Predefined mode for all three codes
+
+	type coded struct {
+		ll, ml, of             uint8
+		llExtra, mlExtra, ofEx uint64
+	}
+	codes := make([]coded, len(seqs))
+	for i, s := range seqs {
+		ll := zstdCode(zstdLLBase[:], s.litLen)
+		ml := zstdCode(zstdMLBase[:], s.matchLen)
+		offValue := s.offset + 3 // Never a repeat offset
+		of := uint8(bits.Len32(offValue) - 1)
+		codes[i] = coded{
+			ll: ll, ml: ml, of: of,
+			llExtra: uint64(s.litLen - zstdLLBase[ll]),
+			mlExtra: uint64(s.matchLen - zstdMLBase[ml]),
+			ofEx:    uint64(offValue - 1<<of),
+		}
+	}
+
+	// The decoder reads backwards, so the last sequence goes first
+	w := &zstdBitWriter{out: dst}
+	var llState, mlState, ofState fseState
+	c := codes[len(codes)-1]
+	mlState.init(zstdMLTable, c.ml)
+	ofState.init(zstdOFTable, c.of)
+	llState.init(zstdLLTable, c.ll)
+	w.addBits(c.llExtra, uint(zstdLLBits[c.ll]))
+	w.addBits(c.mlExtra, uint(zstdMLBits[c.ml]))
+	w.addBits(c.ofEx, uint(c.of))
+	for i := len(codes) - 2; i >= 0; i-- {
+		c := codes[i]
+		ofState.encode(w, c.of)
+		mlState.encode(w, c.ml)
+		llState.encode(w, c.ll)
+		w.addBits(c.llExtra, uint(zstdLLBits[c.ll]))
+		w.addBits(c.mlExtra, uint(zstdMLBits[c.ml]))
+		w.addBits(c.ofEx, uint(c.of))
+	}
+	mlState.flush(w)
+	ofState.flush(w)
+	llState.flush(w)
+	return w.close()
+}
+
+// zstdCode returns the code of a value: the last baseline not above it
+func zstdCode(base []uint32, value uint32) uint8 {
+	code := len(base) - 1
+	for base[code] > value {
+		code--
+	}
+	return uint8(code)
+}
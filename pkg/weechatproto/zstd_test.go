@@ -0,0 +1,109 @@
+package weechatproto
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	mathrand "math/rand"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdDecode decompresses with a reference decoder
+func zstdDecode(t *testing.T, frame []byte) []byte {
+	t.Helper()
+	d, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+
+	out, err := d.DecodeAll(frame, nil)
+	if err != nil {
+		t.Fatalf("reference decoder: %v", err)
+	}
+	return out
+}
+
+// relayLines looks like relay traffic: lines sharing nicks, tags and pointers
+func relayLines(n int) []byte {
+	r := mathrand.New(mathrand.NewSource(1))
+	nicks := []string{"alice", "bob", "carol", "dave"}
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "0x%x irc_privmsg,notify_message,prefix_nick_white,nick_%s,log1 %s: message %d\n",
+			0x55d0c0de0000+r.Intn(64), nicks[r.Intn(len(nicks))], nicks[r.Intn(len(nicks))], r.Intn(1000))
+	}
+	return b.Bytes()
+}
+
+func TestZstdRoundTrip(t *testing.T) {
+	random := make([]byte, 300<<10)
+	rand.Read(random)
+
+	tests := []struct {
+		name string
+		src  []byte
+	}{
+		{"empty", nil},
+		{"one byte", []byte("x")},
+		{"shorter than a match", []byte("abc")},
+		{"tiny", []byte("abcdabcd")},
+		{"255 bytes", bytes.Repeat([]byte("y"), 255)},
+		{"256 bytes", bytes.Repeat([]byte("y"), 256)},
+		{"largest 2-byte size", bytes.Repeat([]byte("ab"), (65536+255)/2)},
+		{"smallest 4-byte size", bytes.Repeat([]byte("z"), 65536+256)},
+		{"repetitive", bytes.Repeat([]byte("nick_alice,"), 50000)},
+		{"one byte repeated over blocks", make([]byte, 1<<20)},
+		{"incompressible", random},
+		{"incompressible then repeated", append(append([]byte{}, random[:200<<10]...), random[:200<<10]...)},
+		{"relay lines", relayLines(20000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frame := zstdCompress(tt.src)
+			got := zstdDecode(t, frame)
+			if !bytes.Equal(got, tt.src) {
+				t.Fatalf("round trip of %d bytes gave %d different bytes", len(tt.src), len(got))
+			}
+			t.Logf("%d -> %d bytes", len(tt.src), len(frame))
+		})
+	}
+}
+
+func TestZstdCompresses(t *testing.T) {
+	src := relayLines(2000)
+	if frame := zstdCompress(src); len(frame) > len(src)/2 {
+		t.Errorf("relay lines compressed from %d to only %d bytes", len(src), len(frame))
+	}
+
+	// Incompressible data is stored raw, with only the headers added
+	random := make([]byte, 200<<10)
+	rand.Read(random)
+	if frame := zstdCompress(random); len(frame) > len(random)+32 {
+		t.Errorf("random data grew from %d to %d bytes", len(random), len(frame))
+	}
+}
+
+func TestZstdRoundTripMixed(t *testing.T) {
+	r := mathrand.New(mathrand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		// Random runs of new and repeated data at random distances
+		var src []byte
+		for size := r.Intn(64 << 10); len(src) < size; {
+			if len(src) > 0 && r.Intn(2) == 0 {
+				start := r.Intn(len(src))
+				end := min(start+r.Intn(300), len(src))
+				src = append(src, src[start:end]...)
+			} else {
+				chunk := make([]byte, r.Intn(40))
+				r.Read(chunk)
+				src = append(src, chunk...)
+			}
+		}
+		if got := zstdDecode(t, zstdCompress(src)); !bytes.Equal(got, src) {
+			t.Fatalf("round %d: round trip of %d bytes failed", i, len(src))
+		}
+	}
+}